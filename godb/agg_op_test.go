@@ -321,3 +321,60 @@ func TestRepeatedIteration(t *testing.T) {
 	}
 
 }
+
+func TestAggNulls(t *testing.T) {
+	c, _ := makeIndexTestCatalog(t, 4) // ages 0, 1, 2, 3
+	runTestQuery(t, c, "insert into t values ('joe', null)")
+	runTestQuery(t, c, "insert into t values (null, null)")
+
+	res := runTestQuery(t, c, "select sum(age), avg(age), min(age), max(age), count(age), count(*), count(name) from t")
+	expected := []int64{6, 1, 0, 3, 4, 6, 5}
+	if len(res) != 1 {
+		t.Fatalf("expected one result, got %d", len(res))
+	}
+	for i, exp := range expected {
+		if v, ok := res[0].Fields[i].(IntField); !ok || v.Value != exp {
+			t.Errorf("field %d: expected %d, got %v", i, exp, res[0].Fields[i])
+		}
+	}
+
+	// aggregates of only NULLs are NULL, except for counts
+	res = runTestQuery(t, c, "select sum(age), avg(age), min(age), max(age), count(age) from t where age is null")
+	for i := 0; i < 4; i++ {
+		if !isNull(res[0].Fields[i]) {
+			t.Errorf("field %d: expected NULL, got %v", i, res[0].Fields[i])
+		}
+	}
+	if v, ok := res[0].Fields[4].(IntField); !ok || v.Value != 0 {
+		t.Errorf("expected count 0, got %v", res[0].Fields[4])
+	}
+}
+
+func TestHaving(t *testing.T) {
+	c, _ := makeIndexTestCatalog(t, 25)
+	var queries = map[string]int{
+		// ages 0 to 4 have 3 tuples, 5 to 9 have 2
+		"select age, count(*) from t group by age having count(*) > 2":                          5,
+		"select age from t group by age having count(*) = 2":                                    5,
+		"select age, count(*) cnt from t group by age having cnt < 3 and age > 6":               3,
+		"select age, sum(age) from t group by age having sum(age) >= 10 or max(name) = 'sam20'": 7,
+		"select count(*) from t having count(*) > 100":                                          0,
+		"select age from t group by age":                                                        10,
+		"select count(*) from t having count(*) > 10":                                           1,
+	}
+	for q, cnt := range queries {
+		if res := runTestQuery(t, c, q); len(res) != cnt {
+			t.Errorf("expected %d results for %s, got %d", cnt, q, len(res))
+		}
+	}
+
+	if _, _, err := Parse(c, "select age from t group by age having name = 'sam1'"); err == nil {
+		t.Errorf("expected an error for a column that is not grouped")
+	}
+
+	// aggregates only in the HAVING clause are not returned
+	res := runTestQuery(t, c, "select age from t group by age having count(*) = 2")
+	if len(res) > 0 && len(res[0].Fields) != 1 {
+		t.Errorf("expected 1 field, got %d", len(res[0].Fields))
+	}
+}
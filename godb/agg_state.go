@@ -27,7 +27,8 @@ type AggState interface {
 	GetTupleDesc() *TupleDesc
 }
 
-// Implements the aggregation state for COUNT
+// Implements the aggregation state for COUNT.  NULLs are not counted, unless
+// expr is nil, which counts every tuple (COUNT(*)).
 type CountAggState struct {
 	alias string
 	expr  Expr
@@ -46,6 +47,12 @@ func (a *CountAggState) Init(alias string, expr Expr, getter func(DBValue) any)
 }
 
 func (a *CountAggState) AddTuple(t *Tuple) {
+	if a.expr != nil {
+		v, err := a.expr.EvalExpr(t)
+		if err == nil && isNull(v) {
+			return
+		}
+	}
 	a.count++
 }
 
@@ -71,6 +78,7 @@ type SumAggState[T Number] struct {
 	// TODO add fields that can help implement the aggregation state
 	CountInt   int64
 	CountFloat float64
+	nonNull    bool // whether a non-NULL value was added; the sum is NULL if not
 
 	alias  string
 	expr   Expr
@@ -81,6 +89,7 @@ func (a *SumAggState[T]) Copy() AggState {
 	newAggState := &SumAggState[T]{}
 	newAggState.CountInt = a.CountInt
 	newAggState.CountFloat = a.CountFloat
+	newAggState.nonNull = a.nonNull
 	newAggState.alias = a.alias
 	newAggState.expr = a.expr
 	newAggState.getter = a.getter
@@ -99,6 +108,7 @@ func stringAggGetter(v DBValue) any {
 func (a *SumAggState[T]) Init(alias string, expr Expr, getter func(DBValue) any) error {
 	a.CountInt = 0
 	a.CountFloat = 0
+	a.nonNull = false
 	a.expr = expr
 	a.alias = alias
 	a.getter = getter
@@ -110,9 +120,10 @@ func (a *SumAggState[T]) AddTuple(t *Tuple) {
 		panic("t is nil")
 	}
 	v, err := a.expr.EvalExpr(t)
-	if err != nil {
+	if err != nil || isNull(v) {
 		return
 	}
+	a.nonNull = true
 	val := a.getter(v)
 	switch typeOfVal := val.(type) {
 	case int64:
@@ -134,15 +145,17 @@ func (a *SumAggState[T]) GetTupleDesc() *TupleDesc {
 
 func (a *SumAggState[T]) Finalize() *Tuple {
 	td := a.GetTupleDesc()
-	f := IntField{a.CountInt}
+	var f DBValue = IntField{a.CountInt}
+	if !a.nonNull {
+		f = NullField{}
+	}
 	fs := []DBValue{f}
 	t := Tuple{*td, fs, nil}
 	return &t
 }
 
 // Implements the aggregation state for AVG
-// Note that we always AddTuple() at least once before Finalize()
-// so no worries for divide-by-zero
+// The average of no (non-NULL) values is NULL, so no worries for divide-by-zero
 type AvgAggState[T Number] struct {
 	// TODO: some code goes here
 	// TODO add fields that can help implement the aggregation state
@@ -186,6 +199,9 @@ func (a *AvgAggState[T]) AddTuple(t *Tuple) {
 	if err != nil {
 		panic(err)
 	}
+	if isNull(v) {
+		return
+	}
 	val := a.getter(v)
 	switch typeOfVal := val.(type) {
 	case int64:
@@ -208,6 +224,9 @@ func (a *AvgAggState[T]) GetTupleDesc() *TupleDesc {
 
 func (a *AvgAggState[T]) Finalize() *Tuple {
 	td := a.GetTupleDesc()
+	if a.count == 0 {
+		return &Tuple{*td, []DBValue{NullField{}}, nil}
+	}
 	a.avgResInt = int64(a.sumInt / float64(a.count))
 	var f IntField = IntField{a.avgResInt}
 	fs := []DBValue{f}
@@ -216,8 +235,7 @@ func (a *AvgAggState[T]) Finalize() *Tuple {
 }
 
 // Implements the aggregation state for MAX
-// Note that we always AddTuple() at least once before Finalize()
-// so no worries for NaN max
+// NULLs are skipped, and the max of no values is NULL
 type MaxAggState[T constraints.Ordered] struct {
 	alias  string
 	expr   Expr
@@ -234,12 +252,13 @@ func (a *MaxAggState[T]) Init(alias string, expr Expr, getter func(DBValue) any)
 	a.expr = expr
 	a.getter = getter
 	a.alias = alias
+	a.null = true
 	return nil
 }
 
 func (a *MaxAggState[T]) AddTuple(t *Tuple) {
 	v, err := a.expr.EvalExpr(t)
-	if err != nil {
+	if err != nil || isNull(v) {
 		return
 	}
 	val := a.getter(v).(T)
@@ -267,6 +286,9 @@ func (a *MaxAggState[T]) GetTupleDesc() *TupleDesc {
 
 func (a *MaxAggState[T]) Finalize() *Tuple {
 	td := a.GetTupleDesc()
+	if a.null {
+		return &Tuple{*td, []DBValue{NullField{}}, nil}
+	}
 	var f any
 	switch any(a.max).(type) {
 	case string:
@@ -280,8 +302,7 @@ func (a *MaxAggState[T]) Finalize() *Tuple {
 }
 
 // Implements the aggregation state for MIN
-// Note that we always AddTuple() at least once before Finalize()
-// so no worries for NaN min
+// NULLs are skipped, and the min of no values is NULL
 type MinAggState[T constraints.Ordered] struct {
 	alias  string
 	expr   Expr
@@ -304,7 +325,7 @@ func (a *MinAggState[T]) Init(alias string, expr Expr, getter func(DBValue) any)
 
 func (a *MinAggState[T]) AddTuple(t *Tuple) {
 	v, err := a.expr.EvalExpr(t)
-	if err != nil {
+	if err != nil || isNull(v) {
 		return
 	}
 	val := a.getter(v).(T)
@@ -332,6 +353,9 @@ func (a *MinAggState[T]) GetTupleDesc() *TupleDesc {
 
 func (a *MinAggState[T]) Finalize() *Tuple {
 	td := a.GetTupleDesc()
+	if a.null {
+		return &Tuple{*td, []DBValue{NullField{}}, nil}
+	}
 	var f any
 	switch any(a.min).(type) {
 	case string:
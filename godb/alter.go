@@ -0,0 +1,390 @@
+package godb
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// sqlparser parses ALTER TABLE but drops what it changes, so these statements
+// are matched before the query is handed to it.
+var (
+	alterTableRegexp   = regexp.MustCompile(`(?is)^\s*alter\s+table\s+(\w+)\s+(.*?)\s*;?\s*$`)
+	addColumnRegexp    = regexp.MustCompile(`(?is)^add\s+(?:column\s+)?(\w+)\s+(\w+)(?:\s*\(\s*\d+\s*\))?(\s+not\s+null)?(?:\s+default\s+(.*?))?(\s+not\s+null)?$`)
+	dropColumnRegexp   = regexp.MustCompile(`(?is)^drop\s+(?:column\s+)?(\w+)$`)
+	renameColumnRegexp = regexp.MustCompile(`(?is)^rename\s+column\s+(\w+)\s+to\s+(\w+)$`)
+	renameTableRegexp  = regexp.MustCompile(`(?is)^rename\s+(?:to\s+|as\s+)?(\w+)$`)
+)
+
+// The names of the types of columns added by ALTER TABLE, as in catalog files.
+var columnTypes = map[string]DBType{
+	"int":     IntType,
+	"integer": IntType,
+	"string":  StringType,
+	"varchar": StringType,
+	"text":    StringType,
+}
+
+// Process query if it is an ALTER TABLE statement, which adds, drops or
+// renames a column, or renames the table.  Returns false if it is not.
+func processAlterTable(c *Catalog, query string) (QueryType, bool, error) {
+	m := alterTableRegexp.FindStringSubmatch(query)
+	if m == nil {
+		return UnknownQueryType, false, nil
+	}
+	t := c.tableMap[strings.ToLower(m[1])]
+	if t == nil {
+		return UnknownQueryType, true, GoDBError{NoSuchTableError, fmt.Sprintf("no table '%s' found", strings.ToLower(m[1]))}
+	}
+	var err error
+	action := m[2]
+	if m := addColumnRegexp.FindStringSubmatch(action); m != nil {
+		err = c.addColumn(t, strings.ToLower(m[1]), strings.ToLower(m[2]), m[3] != "" || m[5] != "", m[4])
+	} else if m := dropColumnRegexp.FindStringSubmatch(action); m != nil {
+		err = c.dropColumn(t, strings.ToLower(m[1]))
+	} else if m := renameColumnRegexp.FindStringSubmatch(action); m != nil {
+		err = c.renameColumn(t, strings.ToLower(m[1]), strings.ToLower(m[2]))
+	} else if m := renameTableRegexp.FindStringSubmatch(action); m != nil {
+		err = c.renameTable(t, strings.ToLower(m[1]))
+	} else {
+		err = GoDBError{ParseError, fmt.Sprintf("unsupported ALTER TABLE %s", action)}
+	}
+	if err != nil {
+		return UnknownQueryType, true, err
+	}
+	return AlterTableQueryType, true, nil
+}
+
+// Return an error if there are views that read t, which a change to its name
+// or columns could break.
+func (c *Catalog) checkNoDependents(t *Table) error {
+	if deps := c.dependents(t.name); len(deps) > 0 {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("can't change table '%s', view '%s' depends on it", t.name, deps[0].name)}
+	}
+	return nil
+}
+
+// Add a column to t with the DEFAULT expression def, or none if it is "".  The
+// column of the tuples of t is set to the value of def, evaluated once, or
+// NULL, so it can only be NOT NULL without a DEFAULT if t is empty.
+func (c *Catalog) addColumn(t *Table, name string, typeName string, notNull bool, def string) error {
+	ftype, ok := columnTypes[typeName]
+	if !ok {
+		return GoDBError{ParseError, fmt.Sprintf("unsupported column type %s", typeName)}
+	}
+	if _, err := findFieldInTd(FieldType{name, "", UnknownType}, &t.desc); err == nil {
+		return GoDBError{DuplicateTableError, fmt.Sprintf("table '%s' already has a column '%s'", t.name, name)}
+	}
+	if err := c.checkNoDependents(t); err != nil {
+		return err
+	}
+	var value DBValue = NullField{}
+	if def != "" {
+		expr, err := parseDefault(c, def, ftype)
+		if err != nil {
+			return err
+		}
+		value, err = expr.EvalExpr(nil)
+		if err != nil {
+			return err
+		}
+	}
+	desc := TupleDesc{append(append([]FieldType{}, t.desc.Fields...), FieldType{name, "", ftype})}
+	var notNulls []bool
+	if t.notNull != nil || notNull {
+		notNulls = make([]bool, len(desc.Fields))
+		copy(notNulls, t.notNull)
+		notNulls[len(notNulls)-1] = notNull
+	}
+	err := c.rewriteTable(t, desc, notNulls, t.indexes, func(fields []DBValue) []DBValue {
+		return append(append([]DBValue{}, fields...), value)
+	})
+	if err != nil {
+		return err
+	}
+	if t.defaults != nil || def != "" {
+		defaults := make([]string, len(desc.Fields))
+		copy(defaults, t.defaults)
+		defaults[len(defaults)-1] = def
+		t.defaults = defaults
+	}
+	return nil
+}
+
+// Return true if columns contains column.
+func hasColumn(columns []string, column string) bool {
+	for _, col := range columns {
+		if col == column {
+			return true
+		}
+	}
+	return false
+}
+
+// Drop a column of t, the indexes on it, and the keys and foreign keys it is
+// part of.  A column referenced by the foreign key of another table can't be
+// dropped.
+func (c *Catalog) dropColumn(t *Table, name string) error {
+	i, err := findFieldInTd(FieldType{name, "", UnknownType}, &t.desc)
+	if err != nil {
+		return err
+	}
+	if len(t.desc.Fields) == 1 {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("can't drop the only column of table '%s'", t.name)}
+	}
+	if err := c.checkNoDependents(t); err != nil {
+		return err
+	}
+	desc := TupleDesc{append(append([]FieldType{}, t.desc.Fields[:i]...), t.desc.Fields[i+1:]...)}
+	var notNull []bool
+	if t.notNull != nil {
+		notNull = append(append([]bool{}, t.notNull[:i]...), t.notNull[i+1:]...)
+	}
+	var foreignKeys []*ForeignKey
+	dropped := make(map[string]bool) // the names of the dropped keys and foreign keys
+	for _, fk := range t.foreignKeys {
+		dropped[fk.name] = hasColumn(fk.columns, name)
+		if !dropped[fk.name] {
+			foreignKeys = append(foreignKeys, fk)
+		}
+	}
+	for _, fk := range c.referencing(t.name) {
+		if hasColumn(fk.refColumns, name) && !dropped[fk.name] {
+			return GoDBError{IllegalOperationError, fmt.Sprintf("can't drop column '%s' of table '%s', foreign key %s references it", name, t.name, fk.name)}
+		}
+	}
+	var keys []*Key
+	for _, key := range t.keys {
+		dropped[key.name] = hasColumn(key.columns, name)
+		if !dropped[key.name] {
+			keys = append(keys, key)
+		}
+	}
+	var indexes []*Index
+	for _, idx := range t.indexes {
+		if idx.column != name && !dropped[idx.name] {
+			indexes = append(indexes, idx)
+		}
+	}
+	err = c.rewriteTable(t, desc, notNull, indexes, func(fields []DBValue) []DBValue {
+		return append(append([]DBValue{}, fields[:i]...), fields[i+1:]...)
+	})
+	if err != nil {
+		return err
+	}
+	t.keys = keys
+	t.foreignKeys = foreignKeys
+	if t.defaults != nil {
+		t.defaults = append(append([]string{}, t.defaults[:i]...), t.defaults[i+1:]...)
+	}
+	return nil
+}
+
+// Rename a column of t.  Its tuples are unchanged, so only the catalog is.
+func (c *Catalog) renameColumn(t *Table, name string, newName string) error {
+	i, err := findFieldInTd(FieldType{name, "", UnknownType}, &t.desc)
+	if err != nil {
+		return err
+	}
+	if _, err := findFieldInTd(FieldType{newName, "", UnknownType}, &t.desc); err == nil {
+		return GoDBError{DuplicateTableError, fmt.Sprintf("table '%s' already has a column '%s'", t.name, newName)}
+	}
+	if err := c.checkNoDependents(t); err != nil {
+		return err
+	}
+	desc := t.desc.copy()
+	desc.Fields[i].Fname = newName
+	c.setDesc(t, *desc)
+	for _, idx := range t.indexes {
+		if idx.column == name {
+			idx.column = newName
+		}
+	}
+	for _, key := range t.keys {
+		renameColumns(key.columns, name, newName)
+	}
+	for _, fk := range t.foreignKeys {
+		renameColumns(fk.columns, name, newName)
+	}
+	for _, fk := range c.referencing(t.name) {
+		renameColumns(fk.refColumns, name, newName)
+	}
+	return nil
+}
+
+// Replace name in columns with newName.
+func renameColumns(columns []string, name string, newName string) {
+	for i, col := range columns {
+		if col == name {
+			columns[i] = newName
+		}
+	}
+}
+
+// Rename t, and its file.
+func (c *Catalog) renameTable(t *Table, newName string) error {
+	if c.tableMap[newName] != nil || c.findView(newName) != nil {
+		return GoDBError{DuplicateTableError, fmt.Sprintf("a table or view named '%s' already exists", newName)}
+	}
+	if err := c.checkNoDependents(t); err != nil {
+		return err
+	}
+	tid, hf, err := c.lockTable(t)
+	if err != nil {
+		return err
+	}
+	err = c.bp.replaceFile(tid, map[string]bool{hf.file.Name(): true}, c.tableNameToFile(t.name), c.tableNameToFile(newName))
+	if err != nil {
+		c.bp.AbortTransaction(tid)
+		return err
+	}
+	c.closeTable(t)
+	c.tablesChanged()
+	for _, fk := range c.referencing(t.name) {
+		fk.refTable = newName
+	}
+	delete(c.tableMap, t.name)
+	c.tableMap[newName] = t
+	t.name = newName
+	for _, idx := range t.indexes {
+		idx.table = newName
+	}
+	for _, key := range t.keys {
+		key.table = newName
+	}
+	for _, fk := range t.foreignKeys {
+		fk.table = newName
+	}
+	return nil
+}
+
+// Set the fields of t to those of desc, updating the columns it is found by.
+func (c *Catalog) setDesc(t *Table, desc TupleDesc) {
+	for _, f := range t.desc.Fields {
+		tables := c.columnMap[f.Fname]
+		for i, other := range tables {
+			if other == t {
+				c.columnMap[f.Fname] = append(tables[:i:i], tables[i+1:]...)
+				break
+			}
+		}
+	}
+	for _, f := range desc.Fields {
+		c.columnMap[f.Fname] = append(c.columnMap[f.Fname], t)
+	}
+	t.desc = desc
+	c.tablesChanged()
+}
+
+// Begin a transaction that locks every page of t for writing, which keeps
+// other transactions from reading or changing t until it ends.
+func (c *Catalog) lockTable(t *Table) (TransactionID, *HeapFile, error) {
+	file, err := c.GetTable(t.name)
+	if err != nil {
+		return nil, nil, err
+	}
+	hf := file.(*HeapFile)
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	for pageNo := 0; pageNo < hf.NumPages(); pageNo++ {
+		_, err := c.bp.GetPage(hf, pageNo, tid, WritePerm)
+		if err != nil {
+			c.bp.AbortTransaction(tid)
+			return nil, nil, err
+		}
+		c.bp.Unpin(hf.pageKey(pageNo))
+	}
+	return tid, hf, nil
+}
+
+// Rewrite the file of t with the fields described by desc, converting the
+// fields of each tuple with convert, and rebuild indexes, the indexes t has
+// afterwards.  The tuples are written to a new file while t is locked, which
+// then replaces its file.
+func (c *Catalog) rewriteTable(t *Table, desc TupleDesc, notNull []bool, indexes []*Index, convert func([]DBValue) []DBValue) error {
+	tid, hf, err := c.lockTable(t)
+	if err != nil {
+		return err
+	}
+	files := map[string]bool{hf.file.Name(): true}
+	for _, idx := range t.indexes {
+		files[c.indexNameToFile(idx.name)] = true
+	}
+	tmpName := c.tableNameToFile(t.name) + ".tmp"
+	err = c.writeTable(tid, hf, tmpName, &desc, notNull, convert)
+	if err == nil {
+		err = c.bp.replaceFile(tid, files, tmpName, hf.file.Name())
+	}
+	if err != nil {
+		c.bp.AbortTransaction(tid)
+		os.Remove(tmpName)
+		return err
+	}
+	c.closeTable(t)
+	c.setDesc(t, desc)
+	t.notNull = notNull
+	t.stats = nil
+	// the rids the indexes refer to have changed
+	for _, idx := range t.indexes {
+		os.Remove(c.indexNameToFile(idx.name))
+	}
+	t.indexes = indexes
+	for _, idx := range indexes {
+		err = c.buildIndex(idx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the tuples of hf, whose pages tid has locked, converted by convert, to
+// a new heap file named fileName with descriptor desc.  The pages are written
+// directly, rather than through the buffer pool, as the file replaces hf.
+func (c *Catalog) writeTable(tid TransactionID, hf *HeapFile, fileName string, desc *TupleDesc, notNull []bool, convert func([]DBValue) []DBValue) error {
+	os.Remove(fileName)
+	out, err := NewHeapFile(fileName, desc, nil)
+	if err != nil {
+		return err
+	}
+	defer out.file.Close()
+	out.notNull = notNull
+	var page Page = emptyHeapPage(desc, 0, out)
+	for pageNo := 0; pageNo < hf.NumPages(); pageNo++ {
+		pg, err := c.bp.GetPage(hf, pageNo, tid, WritePerm)
+		if err != nil {
+			return err
+		}
+		iter := (*pg).(*heapPage).tupleIter()
+		for {
+			old, _ := iter()
+			if old == nil {
+				break
+			}
+			t := &Tuple{Fields: convert(old.Fields)}
+			if err = out.checkTuple(t); err != nil {
+				break
+			}
+			hp := page.(*heapPage)
+			if !hp.hasRoomFor(t) {
+				if err = out.flushPage(&page); err != nil {
+					break
+				}
+				hp = emptyHeapPage(desc, hp.pageId+1, out)
+				page = hp
+			}
+			if _, err = hp.insertTuple(t); err != nil {
+				break
+			}
+		}
+		c.bp.Unpin(hf.pageKey(pageNo))
+		if err != nil {
+			return err
+		}
+	}
+	if page.(*heapPage).numUsedSlots == 0 {
+		return nil
+	}
+	return out.flushPage(&page)
+}
@@ -0,0 +1,107 @@
+package godb
+
+import (
+	"testing"
+)
+
+func TestAlterTable(t *testing.T) {
+	c := makeOuterJoinTestCatalog(t)
+	expect := func(c *Catalog, query string, expected []int64) {
+		res := firstColumn(t, c, query)
+		if len(res) != len(expected) {
+			t.Errorf("%s: expected %v, got %v", query, expected, res)
+			return
+		}
+		for i := range res {
+			if res[i] != expected[i] {
+				t.Errorf("%s: expected %v, got %v", query, expected, res)
+				return
+			}
+		}
+	}
+	for _, q := range []string{
+		"create index ax on a (x)",
+		"create index ay on a (y)",
+		"create view v as select x from b",
+		"alter table a add column z int",
+	} {
+		if _, _, err := Parse(c, q); err != nil {
+			t.Fatalf("%s: %s", q, err.Error())
+		}
+	}
+	runTestQuery(t, c, "insert into a values (9, 1, 3)")
+	expect(c, "select z from a", []int64{-1, -1, -1, -1, -1, 3})
+	expect(c, "select x from a where z = 3", []int64{9})
+	expect(c, "select x from a where x = 2", []int64{2, 2})
+
+	for _, q := range []string{
+		"alter table a drop column y",
+		"alter table a rename column z to w",
+		"alter table a rename to c2",
+	} {
+		if _, _, err := Parse(c, q); err != nil {
+			t.Fatalf("%s: %s", q, err.Error())
+		}
+	}
+	runTestQuery(t, c, "update c2 set w = 4 where x = 9")
+	if _, _, err := Parse(c, "select y from c2"); err == nil {
+		t.Errorf("expected y to be dropped")
+	}
+	if len(c.tableMap["c2"].indexes) != 1 || c.tableMap["c2"].indexes[0].table != "c2" {
+		t.Errorf("expected the index on y to be dropped, and that on x to be kept")
+	}
+	expect(c, "select x from c2", []int64{-1, 1, 2, 2, 3, 9})
+	expect(c, "select x from c2 where x = 2", []int64{2, 2})
+	expect(c, "select x from c2 where w = 4", []int64{9})
+
+	for _, q := range []string{
+		"alter table a add column q int",
+		"alter table c2 add column q int not null",
+		"alter table c2 add column w int",
+		"alter table c2 add column q float",
+		"alter table c2 drop column nosuch",
+		"alter table c2 rename column x to w",
+		"alter table c2 rename to b",
+		"alter table c2 frobnicate",
+		"alter table b drop column y",
+		"alter table b rename to b2",
+		"alter table b add column q int",
+		"alter table c2 add column q int default 'one'",
+		"alter table c2 add column q int default null not null",
+	} {
+		if _, _, err := Parse(c, q); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+	expect(c, "select x from c2", []int64{-1, 1, 2, 2, 3, 9})
+	expect(c, "select x from v", []int64{-1, 2, 3, 3, 4})
+	for _, q := range []string{
+		"drop view v",
+		"alter table b add column q varchar(10)",
+		// DEFAULT fills in the column of the tuples already in the table
+		"alter table c2 add column d int default 5 not null",
+		"alter table c2 add column e int not null default 2 + 3",
+	} {
+		if _, _, err := Parse(c, q); err != nil {
+			t.Errorf("%s: %s", q, err.Error())
+		}
+	}
+	expect(c, "select d + e from c2", []int64{10, 10, 10, 10, 10, 10})
+	runTestQuery(t, c, "insert into c2 (x) values (7)")
+	expect(c, "select d from c2 where x = 7", []int64{5})
+
+	// the changes are stored in the catalog file
+	err := c.SaveToFile("catalog.txt", c.rootPath)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	c, err = NewCatalogFromFile("catalog.txt", c.bp, c.rootPath)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expect(c, "select x from c2 where w = 4", []int64{9})
+	expect(c, "select x from c2 where x = 3", []int64{3})
+	expect(c, "select x from b where q is null", []int64{-1, 2, 3, 3, 4})
+	runTestQuery(t, c, "insert into c2 (x) values (8)")
+	expect(c, "select e from c2 where x = 8", []int64{5})
+}
@@ -0,0 +1,584 @@
+package godb
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+)
+
+// BTreeFile is a B+ tree index on one int or string column of a HeapFile.
+// The tree maps each key to the Rids of the tuples with that key, and its
+// pages are cached and locked through the BufferPool just like heap pages.
+//
+// Once created, the index is kept up to date by [HeapFile.insertTuple] and
+// [HeapFile.deleteTuple].  Deleting entries never merges pages, so pages may
+// become underfull (or empty, in the case of leaves) after many deletes.
+//
+// Iterating through a BTreeFile returns the tuples of the indexed table in key
+// order;  [BTreeFile.RangeIterator] returns just the tuples whose key satisfies
+// a predicate.  Tuples whose key is NULL are not in the index.
+type BTreeFile struct {
+	bufPool *BufferPool
+	sync.Mutex
+
+	table    *HeapFile
+	keyField int
+	keyType  DBType
+	file     *os.File
+
+	// maximum number of entries on leaf and internal pages
+	leafCapacity     int
+	internalCapacity int
+}
+
+// Create a BTreeFile indexing column keyField of table.
+// Parameters
+// - fromFile: backing file for the index.  May be empty or a previously created index of the same column.
+// - table: the HeapFile to index.  The index is registered with it, so that it is maintained on inserts and deletes.
+// - keyField: the index of the indexed column in the table's TupleDesc.
+// - bp: the BufferPool that is used to store pages read from the index
+// Tuples already in table are not added to a new index;  see [BTreeFile.build].
+func NewBTreeFile(fromFile string, table *HeapFile, keyField int, bp *BufferPool) (*BTreeFile, error) {
+	desc := table.Descriptor()
+	if keyField < 0 || keyField >= len(desc.Fields) {
+		return nil, GoDBError{IncompatibleTypesError, fmt.Sprintf("no field %d to index", keyField)}
+	}
+	var f = &BTreeFile{bufPool: bp, table: table, keyField: keyField, keyType: desc.Fields[keyField].Ftype}
+	var keySize = 8
+	if f.keyType == StringType {
+		keySize = StringLength
+	}
+	f.leafCapacity = (PageSize - btreePageHeaderSize) / (keySize + 8)
+	f.internalCapacity = (PageSize - btreePageHeaderSize - 4) / (keySize + 8 + 4)
+
+	var err error
+	f.file, err = os.OpenFile(fromFile, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if f.NumPages() == 0 {
+		// an empty tree is the meta page and an empty root leaf
+		meta := newBTreePage(btreeMetaPage, 0, f)
+		meta.next = 1
+		err = f.writePage(meta)
+		if err != nil {
+			return nil, err
+		}
+		err = f.writePage(newBTreePage(btreeLeafPage, 1, f))
+		if err != nil {
+			return nil, err
+		}
+	}
+	table.indexes = append(table.indexes, f)
+	return f, nil
+}
+
+// Add an entry for every tuple that is already in the indexed table.
+func (f *BTreeFile) build(tid TransactionID) error {
+	iter, err := f.table.Iterator(tid)
+	if err != nil {
+		return err
+	}
+	for {
+		t, err := iter()
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return nil
+		}
+		err = f.insertTuple(t, tid)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Return the number of pages in the index file, including the meta page.
+func (f *BTreeFile) NumPages() int {
+	stat, err := f.file.Stat()
+	if err != nil {
+		return -1
+	}
+	return int(stat.Size() / int64(PageSize))
+}
+
+// The column of the indexed table the index is on.
+func (f *BTreeFile) KeyField() FieldType {
+	return f.table.Descriptor().Fields[f.keyField]
+}
+
+func (f *BTreeFile) maxEntries(kind btreePageKind) int {
+	switch kind {
+	case btreeLeafPage:
+		return f.leafCapacity
+	case btreeInternalPage:
+		return f.internalCapacity
+	}
+	return 0
+}
+
+func (f *BTreeFile) writePage(p *btreePage) error {
+	buf, err := p.toBuffer()
+	if err != nil {
+		return err
+	}
+	_, err = f.file.WriteAt(buf.Bytes(), int64(p.pageNo*PageSize))
+	return err
+}
+
+// Read the specified page number from the BTreeFile on disk.
+func (f *BTreeFile) readPage(pageNo int) (*Page, error) {
+	if pageNo < 0 || pageNo >= f.NumPages() {
+		return nil, GoDBError{TupleNotFoundError, fmt.Sprintf("page %d not found", pageNo)}
+	}
+	var data = make([]byte, PageSize)
+	_, err := f.file.ReadAt(data, int64(pageNo*PageSize))
+	if err != nil {
+		return nil, err
+	}
+	var page = newBTreePage(btreeLeafPage, pageNo, f)
+	err = page.initFromBuffer(bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	var p Page = page
+	return &p, nil
+}
+
+// Write the page back to the index file.
+func (f *BTreeFile) flushPage(p *Page) error {
+	err := f.writePage((*p).(*btreePage))
+	if err != nil {
+		return err
+	}
+	f.file.Sync()
+	(*p).setDirty(false)
+	return nil
+}
+
+func (f *BTreeFile) pageKey(pgNo int) any {
+	return heapHash{f.file.Name(), pgNo}
+}
+
+// Return the page from the buffer pool, pinned.  Callers unpin it with
+// [BTreeFile.unpin] when they are done with it.
+func (f *BTreeFile) getPage(tid TransactionID, pageNo int, perm RWPerm) (*btreePage, error) {
+	pg, err := f.bufPool.GetPage(f, pageNo, tid, perm)
+	if err != nil {
+		return nil, err
+	}
+	return (*pg).(*btreePage), nil
+}
+
+func (f *BTreeFile) unpin(p *btreePage) {
+	f.bufPool.Unpin(f.pageKey(p.pageNo))
+}
+
+// Allocate a new, empty page at the end of the file and return it pinned and
+// write locked.  The empty page is written to disk immediately, so if tid
+// aborts the page is left unused.
+func (f *BTreeFile) newPage(tid TransactionID, kind btreePageKind) (*btreePage, error) {
+	f.Lock()
+	pageNo := f.NumPages()
+	err := f.writePage(newBTreePage(kind, pageNo, f))
+	f.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return f.getPage(tid, pageNo, WritePerm)
+}
+
+func (f *BTreeFile) tupleEntry(t *Tuple) (btreeEntry, error) {
+	rid, ok := t.Rid.(Rid)
+	if !ok {
+		return btreeEntry{}, GoDBError{IllegalOperationError, "tuple has no rid to index"}
+	}
+	if f.keyField >= len(t.Fields) {
+		return btreeEntry{}, GoDBError{IncompatibleTypesError, "tuple has no field to index"}
+	}
+	key := t.Fields[f.keyField]
+	err := f.checkKey(key)
+	return btreeEntry{indexKey(key), rid}, err
+}
+
+// Return the key stored in the index for v.  Strings are truncated to
+// StringLength bytes, the size of keys on [btreePage]s, so different strings
+// may have the same key.
+func indexKey(v DBValue) DBValue {
+	if s, ok := v.(StringField); ok && len(s.Value) > StringLength {
+		return StringField{s.Value[:StringLength]}
+	}
+	return v
+}
+
+func (f *BTreeFile) checkKey(key DBValue) error {
+	var ok bool
+	switch f.keyType {
+	case IntType:
+		_, ok = key.(IntField)
+	case StringType:
+		_, ok = key.(StringField)
+	}
+	if !ok {
+		return GoDBError{TypeMismatchError, fmt.Sprintf("key %v does not match the type of the index", key)}
+	}
+	return nil
+}
+
+// Add an entry for t, which must have its Rid set, to the index.
+//
+// Most inserts only change one leaf, so the leaf is found holding read locks
+// and only the leaf is write locked.  If the leaf is full, the entry is
+// inserted again from the root, write locking the path to the leaf, and the
+// meta page is only write locked if the root splits.
+func (f *BTreeFile) insertTuple(t *Tuple, tid TransactionID) error {
+	if f.keyField < len(t.Fields) && isNull(t.Fields[f.keyField]) {
+		return nil // NULLs are not indexed, no range scan returns them
+	}
+	e, err := f.tupleEntry(t)
+	if err != nil {
+		return err
+	}
+	leaf, err := f.findLeaf(tid, e)
+	if err != nil {
+		return err
+	}
+	p, err := f.getPage(tid, leaf, WritePerm)
+	if err != nil {
+		return err
+	}
+	if len(p.entries) < f.maxEntries(p.kind) {
+		defer f.unpin(p)
+		return f.insertIntoPage(tid, p, e)
+	}
+	f.unpin(p)
+
+	meta, err := f.getPage(tid, 0, ReadPerm)
+	if err != nil {
+		return err
+	}
+	root := meta.next
+	f.unpin(meta)
+	sep, right, err := f.insertEntry(tid, root, e)
+	if err != nil || sep == nil {
+		return err
+	}
+
+	// the root split, so the tree grows by one level
+	meta, err = f.getPage(tid, 0, WritePerm)
+	if err != nil {
+		return err
+	}
+	defer f.unpin(meta)
+	newRoot, err := f.newPage(tid, btreeInternalPage)
+	if err != nil {
+		return err
+	}
+	defer f.unpin(newRoot)
+	err = f.bufPool.logIndexPage(tid, newRoot)
+	if err != nil {
+		return err
+	}
+	err = f.bufPool.logIndexPage(tid, meta)
+	if err != nil {
+		return err
+	}
+	newRoot.entries = []btreeEntry{*sep}
+	newRoot.children = []int{root, right}
+	newRoot.setDirty(true)
+	meta.next = newRoot.pageNo
+	meta.setDirty(true)
+	return nil
+}
+
+// Return the page number of the leaf e belongs in, read locking the pages on
+// the path to it.
+func (f *BTreeFile) findLeaf(tid TransactionID, e btreeEntry) (int, error) {
+	meta, err := f.getPage(tid, 0, ReadPerm)
+	if err != nil {
+		return 0, err
+	}
+	pageNo := meta.next
+	f.unpin(meta)
+	for {
+		p, err := f.getPage(tid, pageNo, ReadPerm)
+		if err != nil {
+			return 0, err
+		}
+		f.unpin(p)
+		if p.kind == btreeLeafPage {
+			return pageNo, nil
+		}
+		pageNo = p.children[p.childIndex(e)]
+	}
+}
+
+// Insert e into the write locked leaf p, which must have room for it.
+func (f *BTreeFile) insertIntoPage(tid TransactionID, p *btreePage, e btreeEntry) error {
+	i := p.search(e)
+	if i < len(p.entries) && p.entries[i].compare(e) == 0 {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("tuple %v is already in the index", e.rid)}
+	}
+	err := f.bufPool.logIndexPage(tid, p)
+	if err != nil {
+		return err
+	}
+	p.entries = append(p.entries, btreeEntry{})
+	copy(p.entries[i+1:], p.entries[i:])
+	p.entries[i] = e
+	p.setDirty(true)
+	return nil
+}
+
+// Insert e into the subtree rooted at pageNo.  If the page had to be split,
+// returns the separator of the new page, which is to the right of the old one,
+// and the page number of the new page.
+func (f *BTreeFile) insertEntry(tid TransactionID, pageNo int, e btreeEntry) (*btreeEntry, int, error) {
+	p, err := f.getPage(tid, pageNo, WritePerm)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.unpin(p)
+
+	var i, child int
+	if p.kind == btreeLeafPage {
+		i = p.search(e)
+		if i < len(p.entries) && p.entries[i].compare(e) == 0 {
+			return nil, 0, GoDBError{IllegalOperationError, fmt.Sprintf("tuple %v is already in the index", e.rid)}
+		}
+	} else {
+		i = p.childIndex(e)
+		var sep *btreeEntry
+		sep, child, err = f.insertEntry(tid, p.children[i], e)
+		if err != nil || sep == nil {
+			return nil, 0, err
+		}
+		e = *sep
+	}
+	err = f.bufPool.logIndexPage(tid, p)
+	if err != nil {
+		return nil, 0, err
+	}
+	if p.kind == btreeInternalPage {
+		p.children = append(p.children, 0)
+		copy(p.children[i+2:], p.children[i+1:])
+		p.children[i+1] = child
+	}
+	p.entries = append(p.entries, btreeEntry{})
+	copy(p.entries[i+1:], p.entries[i:])
+	p.entries[i] = e
+	p.setDirty(true)
+	if len(p.entries) <= f.maxEntries(p.kind) {
+		return nil, 0, nil
+	}
+
+	right, err := f.newPage(tid, p.kind)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.unpin(right)
+	err = f.bufPool.logIndexPage(tid, right)
+	if err != nil {
+		return nil, 0, err
+	}
+	right.setDirty(true)
+	mid := len(p.entries) / 2
+	var sep btreeEntry
+	if p.kind == btreeLeafPage {
+		sep = p.entries[mid]
+		right.entries = append([]btreeEntry{}, p.entries[mid:]...)
+		p.entries = append([]btreeEntry{}, p.entries[:mid]...)
+		right.next = p.next
+		p.next = right.pageNo
+	} else {
+		// the middle separator moves up to the parent
+		sep = p.entries[mid]
+		right.entries = append([]btreeEntry{}, p.entries[mid+1:]...)
+		right.children = append([]int{}, p.children[mid+1:]...)
+		p.entries = append([]btreeEntry{}, p.entries[:mid]...)
+		p.children = append([]int{}, p.children[:mid+1]...)
+	}
+	return &sep, right.pageNo, nil
+}
+
+// Remove the entry for t from the index.
+func (f *BTreeFile) deleteTuple(t *Tuple, tid TransactionID) error {
+	if f.keyField < len(t.Fields) && isNull(t.Fields[f.keyField]) {
+		return nil // NULLs are not indexed, no range scan returns them
+	}
+	e, err := f.tupleEntry(t)
+	if err != nil {
+		return err
+	}
+	pageNo, err := f.findLeaf(tid, e)
+	if err != nil {
+		return err
+	}
+	p, err := f.getPage(tid, pageNo, WritePerm)
+	if err != nil {
+		return err
+	}
+	defer f.unpin(p)
+	i := p.search(e)
+	if i >= len(p.entries) || p.entries[i].compare(e) != 0 {
+		return GoDBError{TupleNotFoundError, fmt.Sprintf("tuple %v is not in the index", e.rid)}
+	}
+	err = f.bufPool.logIndexPage(tid, p)
+	if err != nil {
+		return err
+	}
+	p.entries = append(p.entries[:i], p.entries[i+1:]...)
+	p.setDirty(true)
+	return nil
+}
+
+// Return a function that iterates through the entries of the index in order,
+// starting with the first entry that is not smaller than from, or the first
+// entry of the index if from is nil.
+func (f *BTreeFile) entryIterator(tid TransactionID, from *btreeEntry) (func() (*btreeEntry, error), error) {
+	meta, err := f.getPage(tid, 0, ReadPerm)
+	if err != nil {
+		return nil, err
+	}
+	pageNo := meta.next
+	f.unpin(meta)
+	var p *btreePage
+	for {
+		p, err = f.getPage(tid, pageNo, ReadPerm)
+		if err != nil {
+			return nil, err
+		}
+		if p.kind == btreeLeafPage {
+			break
+		}
+		f.unpin(p)
+		if from == nil {
+			pageNo = p.children[0]
+		} else {
+			pageNo = p.children[p.childIndex(*from)]
+		}
+	}
+
+	var i = 0
+	if from != nil {
+		i = p.search(*from)
+	}
+	return func() (*btreeEntry, error) {
+		for p != nil && i >= len(p.entries) {
+			f.unpin(p)
+			if p.next == -1 {
+				p = nil
+				break
+			}
+			p, err = f.getPage(tid, p.next, ReadPerm)
+			if err != nil {
+				p = nil
+				return nil, err
+			}
+			i = 0
+		}
+		if p == nil {
+			return nil, nil
+		}
+		e := p.entries[i]
+		i++
+		return &e, nil
+	}, nil
+}
+
+// Return a function that iterates through the tuples of the indexed table
+// pointed to by the entries returned by iter, stopping at the first entry
+// for which stop returns true.  If match is not nil, tuples for which it
+// returns false are skipped.
+func (f *BTreeFile) tupleIterator(tid TransactionID, iter func() (*btreeEntry, error), stop func(*btreeEntry) bool, match func(*Tuple) bool) func() (*Tuple, error) {
+	var done = false
+	return func() (*Tuple, error) {
+		for !done {
+			e, err := iter()
+			if err != nil || e == nil || stop(e) {
+				done = true
+				return nil, err
+			}
+			t, err := f.table.fetchTuple(e.rid, tid)
+			if err != nil || match == nil || match(t) {
+				return t, err
+			}
+		}
+		return nil, nil
+	}
+}
+
+// Descriptor of the tuples returned by the index, which is the descriptor of
+// the indexed table.
+func (f *BTreeFile) Descriptor() *TupleDesc {
+	return f.table.Descriptor()
+}
+
+// [Operator] iterator method.  Returns the tuples of the indexed table in
+// key order.
+func (f *BTreeFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	iter, err := f.entryIterator(tid, nil)
+	if err != nil {
+		return nil, err
+	}
+	return f.tupleIterator(tid, iter, func(*btreeEntry) bool { return false }, nil), nil
+}
+
+// Return a function that iterates through the tuples of the indexed table
+// whose key k satisfies "k op key", in key order.  op may be any of OpEq,
+// OpLt, OpLe, OpGt and OpGe.
+//
+// Strings longer than StringLength are truncated in the index, so for keys of
+// StringLength bytes or more, which entries of longer strings may share, the
+// scan includes the truncated key and the fetched tuples are checked against
+// the full key.
+func (f *BTreeFile) RangeIterator(tid TransactionID, op BoolOp, key DBValue) (func() (*Tuple, error), error) {
+	err := f.checkKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var match func(*Tuple) bool
+	if s, ok := key.(StringField); ok && len(s.Value) >= StringLength {
+		var fullKey, fullOp = s.Value, op
+		match = func(t *Tuple) bool {
+			s, ok := t.Fields[f.keyField].(StringField)
+			return ok && evalPred(s.Value, fullKey, fullOp)
+		}
+		key = indexKey(key)
+		switch op {
+		case OpGt:
+			op = OpGe
+		case OpLt:
+			op = OpLe
+		}
+	}
+	var (
+		minEntry = btreeEntry{key, Rid{-1, -1}}
+		maxEntry = btreeEntry{key, Rid{math.MaxInt32, math.MaxInt32}}
+		from     *btreeEntry
+		stop     = func(e *btreeEntry) bool { return false }
+	)
+	switch op {
+	case OpEq:
+		from = &minEntry
+		stop = func(e *btreeEntry) bool { return compareDBValue(e.key, key) > 0 }
+	case OpGe:
+		from = &minEntry
+	case OpGt:
+		from = &maxEntry
+	case OpLt:
+		stop = func(e *btreeEntry) bool { return compareDBValue(e.key, key) >= 0 }
+	case OpLe:
+		stop = func(e *btreeEntry) bool { return compareDBValue(e.key, key) > 0 }
+	default:
+		return nil, GoDBError{IllegalOperationError, "unsupported operator for an index range scan"}
+	}
+	iter, err := f.entryIterator(tid, from)
+	if err != nil {
+		return nil, err
+	}
+	return f.tupleIterator(tid, iter, stop, match), nil
+}
@@ -0,0 +1,324 @@
+package godb
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Make a heap file with an index on age (or name if onName), using small
+// pages so that the tree has several levels.
+func makeBTreeTestVars(t *testing.T, dir string, onName bool) (*BufferPool, *HeapFile, *BTreeFile) {
+	var td = TupleDesc{Fields: []FieldType{
+		{Fname: "name", Ftype: StringType},
+		{Fname: "age", Ftype: IntType},
+	}}
+	bp := NewBufferPool(1000)
+	hf, err := NewHeapFile(dir+"/t.dat", &td, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	keyField := 1
+	if onName {
+		keyField = 0
+	}
+	idx, err := NewBTreeFile(dir+"/t_idx.dat", hf, keyField, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	idx.leafCapacity = 4
+	idx.internalCapacity = 3
+	return bp, hf, idx
+}
+
+// Insert n tuples with ages (i * 37) % 50 for i in [0, n)
+func insertBTreeTestTuples(t *testing.T, bp *BufferPool, hf *HeapFile, n int) {
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < n; i++ {
+		tup := Tuple{*hf.Descriptor(), []DBValue{StringField{fmt.Sprintf("sam%d", i)}, IntField{int64((i * 37) % 50)}}, nil}
+		err := hf.insertTuple(&tup, tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	bp.CommitTransaction(tid)
+}
+
+// Return the ages of the tuples returned by iter, checking they are in order
+func drainBTreeIter(t *testing.T, iter func() (*Tuple, error)) []int64 {
+	var ages []int64
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if tup == nil {
+			return ages
+		}
+		age := tup.Fields[1].(IntField).Value
+		if len(ages) > 0 && age < ages[len(ages)-1] {
+			t.Fatalf("index returned %d after %d", age, ages[len(ages)-1])
+		}
+		ages = append(ages, age)
+	}
+}
+
+func TestBTreeInsertAndScan(t *testing.T) {
+	bp, hf, idx := makeBTreeTestVars(t, t.TempDir(), false)
+	insertBTreeTestTuples(t, bp, hf, 200)
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	iter, err := idx.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if ages := drainBTreeIter(t, iter); len(ages) != 200 {
+		t.Errorf("expected 200 tuples, got %d", len(ages))
+	}
+	bp.CommitTransaction(tid)
+}
+
+func TestBTreeRangeIterator(t *testing.T) {
+	bp, hf, idx := makeBTreeTestVars(t, t.TempDir(), false)
+	insertBTreeTestTuples(t, bp, hf, 200)
+
+	// every age in [0, 50) appears 4 times
+	var expected = map[BoolOp]int{OpEq: 4, OpLt: 100, OpLe: 104, OpGt: 96, OpGe: 100}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for op, cnt := range expected {
+		iter, err := idx.RangeIterator(tid, op, IntField{25})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		ages := drainBTreeIter(t, iter)
+		if len(ages) != cnt {
+			t.Errorf("op %d: expected %d tuples, got %d", op, cnt, len(ages))
+		}
+		for _, age := range ages {
+			if !evalPred(age, 25, op) {
+				t.Errorf("op %d: age %d doesn't match", op, age)
+			}
+		}
+	}
+	_, err := idx.RangeIterator(tid, OpLike, IntField{25})
+	if err == nil {
+		t.Errorf("expected error for like")
+	}
+	_, err = idx.RangeIterator(tid, OpEq, StringField{"sam"})
+	if err == nil {
+		t.Errorf("expected error for a string key")
+	}
+	bp.CommitTransaction(tid)
+}
+
+func TestBTreeStringKey(t *testing.T) {
+	bp, hf, idx := makeBTreeTestVars(t, t.TempDir(), true)
+	insertBTreeTestTuples(t, bp, hf, 100)
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	iter, err := idx.RangeIterator(tid, OpGe, StringField{"sam9"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cnt := 0
+	for tup, err := iter(); tup != nil || err != nil; tup, err = iter() {
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if tup.Fields[0].(StringField).Value < "sam9" {
+			t.Errorf("unexpected name %s", tup.Fields[0].(StringField).Value)
+		}
+		cnt++
+	}
+	// sam9 and sam90 to sam99
+	if cnt != 11 {
+		t.Errorf("expected 11 tuples, got %d", cnt)
+	}
+	bp.CommitTransaction(tid)
+}
+
+func TestBTreeDelete(t *testing.T) {
+	bp, hf, idx := makeBTreeTestVars(t, t.TempDir(), false)
+	insertBTreeTestTuples(t, bp, hf, 200)
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	filt, err := NewIntFilter(&ConstExpr{IntField{10}, IntType}, OpLt, &FieldExpr{hf.Descriptor().Fields[1]}, hf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	iter, err := NewDeleteOp(hf, filt).Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err = iter(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	bp.CommitTransaction(tid)
+
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	idxIter, err := idx.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ages := drainBTreeIter(t, idxIter)
+	if len(ages) != 160 {
+		t.Errorf("expected 160 tuples, got %d", len(ages))
+	}
+	for _, age := range ages {
+		if age < 10 {
+			t.Errorf("deleted age %d still in index", age)
+		}
+	}
+	bp.CommitTransaction(tid)
+}
+
+func TestBTreeAbort(t *testing.T) {
+	bp, hf, idx := makeBTreeTestVars(t, t.TempDir(), false)
+	insertBTreeTestTuples(t, bp, hf, 50)
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	for i := 0; i < 100; i++ {
+		tup := Tuple{*hf.Descriptor(), []DBValue{StringField{"joe"}, IntField{int64(i)}}, nil}
+		err := hf.insertTuple(&tup, tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	bp.AbortTransaction(tid)
+
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	iter, err := idx.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if ages := drainBTreeIter(t, iter); len(ages) != 50 {
+		t.Errorf("expected 50 tuples after abort, got %d", len(ages))
+	}
+	bp.CommitTransaction(tid)
+}
+
+func TestBTreeReopen(t *testing.T) {
+	dir := t.TempDir()
+	bp, hf, _ := makeBTreeTestVars(t, dir, false)
+	insertBTreeTestTuples(t, bp, hf, 200)
+
+	bp = NewBufferPool(100)
+	hf, err := NewHeapFile(dir+"/t.dat", hf.Descriptor(), bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	idx, err := NewBTreeFile(dir+"/t_idx.dat", hf, 1, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	idx.leafCapacity = 4
+	idx.internalCapacity = 3
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	iter, err := idx.RangeIterator(tid, OpEq, IntField{7})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if ages := drainBTreeIter(t, iter); len(ages) != 4 {
+		t.Errorf("expected 4 tuples, got %d", len(ages))
+	}
+	bp.CommitTransaction(tid)
+}
+
+func TestBTreeBuild(t *testing.T) {
+	dir := t.TempDir()
+	bp, hf, idx := makeBTreeTestVars(t, dir, false)
+	insertBTreeTestTuples(t, bp, hf, 100)
+
+	idx2, err := NewBTreeFile(dir+"/t_idx2.dat", hf, 0, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	err = idx2.build(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, f := range []*BTreeFile{idx, idx2} {
+		iter, err := f.Iterator(tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		cnt := 0
+		for tup, _ := iter(); tup != nil; tup, _ = iter() {
+			cnt++
+		}
+		if cnt != 100 {
+			t.Errorf("expected 100 tuples, got %d", cnt)
+		}
+	}
+	bp.CommitTransaction(tid)
+}
+
+func TestBTreeAbortWithLog(t *testing.T) {
+	dir := makeRecoveryTestDir(t)
+	bp, hf := openRecoveryTestDb(t, dir, 20)
+	idx, err := NewBTreeFile(dir+"/t_idx.dat", hf, 1, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	insertBTreeTestTuples(t, bp, hf, 50)
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	insertTestTuples(t, hf, tid, 300)
+	bp.AbortTransaction(tid)
+
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	iter, err := idx.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if ages := drainBTreeIter(t, iter); len(ages) != 50 {
+		t.Errorf("expected 50 tuples after abort, got %d", len(ages))
+	}
+	bp.CommitTransaction(tid)
+}
+
+func TestBTreeLargeTransactionWithLog(t *testing.T) {
+	dir := makeRecoveryTestDir(t)
+	bp, hf := openRecoveryTestDb(t, dir, 6)
+	idx, err := NewBTreeFile(dir+"/t_idx.dat", hf, 1, bp)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	insertBTreeTestTuples(t, bp, hf, 50)
+
+	// the index and heap pages changed by one transaction don't fit in the
+	// buffer pool, so dirty index pages must be evicted
+	for _, commit := range []bool{false, true} {
+		tid := NewTID()
+		bp.BeginTransaction(tid)
+		insertTestTuples(t, hf, tid, 1500)
+		if commit {
+			bp.CommitTransaction(tid)
+		} else {
+			bp.AbortTransaction(tid)
+		}
+	}
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	iter, err := idx.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if ages := drainBTreeIter(t, iter); len(ages) != 1550 {
+		t.Errorf("expected 1550 tuples, got %d", len(ages))
+	}
+	bp.CommitTransaction(tid)
+}
@@ -0,0 +1,208 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+/* btreePage implements the Page interface for pages of BTreeFiles.  There are
+three kinds of pages:
+
+  - the meta page, which is always page 0 of the file and stores the page
+    number of the root
+  - internal pages, which store n separator entries and n+1 child page numbers
+  - leaf pages, which store the index entries in order, and the page number of
+    the next leaf (or -1 for the last leaf)
+
+An index entry is a key together with the Rid of the tuple it was taken from.
+Entries are ordered by key, and entries with equal keys by Rid, so every entry
+in the tree is distinct even if the key is not unique.  Separator i of an
+internal page is the smallest entry in the subtree of child i+1;  all entries
+in the subtree of child i are smaller than it.
+
+All pages are PageSize bytes.  They begin with a header of an int8 with the
+kind of page, an int32 with the number of entries and an int32 with the next
+leaf (leaf pages) or root (the meta page).  This is followed by the entries,
+each the key (an int64, or StringLength bytes for strings, see [indexKey])
+followed by the page and slot number of the Rid as int32s, and, for internal
+pages, the child page numbers as int32s.
+*/
+
+type btreePageKind int8
+
+const (
+	btreeMetaPage     btreePageKind = iota
+	btreeInternalPage btreePageKind = iota
+	btreeLeafPage     btreePageKind = iota
+)
+
+const btreePageHeaderSize int = 9
+
+type btreeEntry struct {
+	key DBValue
+	rid Rid
+}
+
+type btreePage struct {
+	kind     btreePageKind
+	entries  []btreeEntry
+	children []int // internal pages only
+	next     int   // next leaf for leaf pages, root for the meta page
+
+	pageNo int
+	dirty  bool
+	file   *BTreeFile
+	lsn    LSN // of the last image of the page in the log, not stored on disk
+}
+
+func newBTreePage(kind btreePageKind, pageNo int, f *BTreeFile) *btreePage {
+	return &btreePage{kind: kind, next: -1, pageNo: pageNo, file: f}
+}
+
+// Compare two entries, returning a negative number, zero or a positive number
+// if e is smaller than, equal to or greater than e2.
+func (e btreeEntry) compare(e2 btreeEntry) int {
+	if c := compareDBValue(e.key, e2.key); c != 0 {
+		return c
+	}
+	switch {
+	case e.rid.PageNo < e2.rid.PageNo:
+		return -1
+	case e.rid.PageNo > e2.rid.PageNo:
+		return 1
+	case e.rid.SlotNo < e2.rid.SlotNo:
+		return -1
+	case e.rid.SlotNo > e2.rid.SlotNo:
+		return 1
+	}
+	return 0
+}
+
+// Return the index of the first entry that is not smaller than e.
+func (p *btreePage) search(e btreeEntry) int {
+	lo, hi := 0, len(p.entries)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if p.entries[mid].compare(e) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// Return the index of the child of an internal page whose subtree contains e.
+func (p *btreePage) childIndex(e btreeEntry) int {
+	i := p.search(e)
+	if i < len(p.entries) && p.entries[i].compare(e) == 0 {
+		i++
+	}
+	return i
+}
+
+// Page method - return whether or not the page is dirty
+func (p *btreePage) isDirty() bool {
+	return p.dirty
+}
+
+// Page method - mark the page as dirty
+func (p *btreePage) setDirty(dirty bool) {
+	p.dirty = dirty
+}
+
+// Page method - return the corresponding BTreeFile for this page.
+func (p *btreePage) getFile() *DBFile {
+	var dbFile DBFile = p.file
+	return &dbFile
+}
+
+// Page method - return the page number of the page in its BTreeFile.
+func (p *btreePage) getPageNo() int {
+	return p.pageNo
+}
+
+func (p *btreePage) toBuffer() (*bytes.Buffer, error) {
+	var buf = new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, int8(p.kind))
+	binary.Write(buf, binary.LittleEndian, int32(len(p.entries)))
+	binary.Write(buf, binary.LittleEndian, int32(p.next))
+	for _, e := range p.entries {
+		var err error
+		switch k := e.key.(type) {
+		case IntField:
+			err = binary.Write(buf, binary.LittleEndian, k.Value)
+		case StringField:
+			_, err = buf.Write(makeFixedBytes(k.Value, StringLength))
+		}
+		if err != nil {
+			return nil, err
+		}
+		binary.Write(buf, binary.LittleEndian, int32(e.rid.PageNo))
+		binary.Write(buf, binary.LittleEndian, int32(e.rid.SlotNo))
+	}
+	for _, c := range p.children {
+		binary.Write(buf, binary.LittleEndian, int32(c))
+	}
+	if buf.Len() > PageSize {
+		return nil, GoDBError{PageFullError, fmt.Sprintf("btree page %d overflows", p.pageNo)}
+	}
+	buf.Write(make([]byte, PageSize-buf.Len()))
+	return buf, nil
+}
+
+func (p *btreePage) initFromBuffer(buf *bytes.Buffer) error {
+	var (
+		kind int8
+		n    int32
+		next int32
+	)
+	binary.Read(buf, binary.LittleEndian, &kind)
+	binary.Read(buf, binary.LittleEndian, &n)
+	err := binary.Read(buf, binary.LittleEndian, &next)
+	if err != nil {
+		return err
+	}
+	p.kind = btreePageKind(kind)
+	p.next = int(next)
+	if n < 0 || int(n) > p.file.maxEntries(p.kind) {
+		return GoDBError{MalformedDataError, fmt.Sprintf("btree page %d has %d entries", p.pageNo, n)}
+	}
+	p.entries = make([]btreeEntry, n)
+	for i := range p.entries {
+		switch p.file.keyType {
+		case IntType:
+			var v int64
+			err = binary.Read(buf, binary.LittleEndian, &v)
+			p.entries[i].key = IntField{v}
+		case StringType:
+			var s string
+			s, err = readString(buf, StringLength)
+			p.entries[i].key = StringField{s}
+		}
+		if err != nil {
+			return err
+		}
+		var pageNo, slotNo int32
+		binary.Read(buf, binary.LittleEndian, &pageNo)
+		err = binary.Read(buf, binary.LittleEndian, &slotNo)
+		if err != nil {
+			return err
+		}
+		p.entries[i].rid = Rid{int(pageNo), int(slotNo)}
+	}
+	p.children = nil
+	if p.kind == btreeInternalPage {
+		p.children = make([]int, n+1)
+		for i := range p.children {
+			var c int32
+			err = binary.Read(buf, binary.LittleEndian, &c)
+			if err != nil {
+				return err
+			}
+			p.children[i] = int(c)
+		}
+	}
+	return nil
+}
@@ -2,7 +2,10 @@ package godb
 
 import (
 	"container/list"
+	"fmt"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,6 +13,15 @@ import (
 //It has a fixed capacity to limit the total amount of memory used by GoDB.
 //It is also the primary way in which transactions are enforced, by using page
 //level locking (you will not need to worry about this until lab3).
+//
+//Until a [LogFile] is attached (see [NewCatalogFromFile]) the BufferPool is
+//FORCE/NO STEAL.  Once a log is attached, every page change is logged and the
+//BufferPool becomes STEAL/NO FORCE: dirty pages may be evicted at any time as
+//long as the log records describing them are on disk first, and commit only
+//forces the log.  This holds for the pages of [BTreeFile] indexes too:  heap
+//page changes are logged as redo/undo records, and index pages by their image
+//before a transaction first changes them, which is only used to roll it back
+//as indexes are rebuilt after a crash.
 
 // Permissions used to when reading / locking pages
 type RWPerm int
@@ -19,10 +31,14 @@ const (
 	WritePerm RWPerm = iota
 )
 
+// The size in bytes the log may grow to before a commit checkpoints it.
+var CheckpointLogSize int64 = 16 << 20
+
 // replacer interface
 type Replacer interface {
 	touch(pageNo int)
 	evict() (int, error)
+	remove(pageNo int)
 }
 
 type FifoReplacer struct {
@@ -45,6 +61,15 @@ func (fr *FifoReplacer) touch(fid int) {
 	fr.data.PushBack(fid)
 }
 
+func (fr *FifoReplacer) remove(fid int) {
+	for e := fr.data.Front(); e != nil; e = e.Next() {
+		if e.Value == fid {
+			fr.data.Remove(e)
+			return
+		}
+	}
+}
+
 func (fr *FifoReplacer) evict() (int, error) {
 	if fr.data.Len() == 0 {
 		return 0, GoDBError{BufferPoolFullError, "Can't evict from replacer which is empty"}
@@ -79,6 +104,22 @@ type BufferPool struct {
 	tranFetchedPid map[TransactionID]*[]FetchedPageType
 
 	g Graph
+
+	// write ahead log, nil if the buffer pool is FORCE/NO STEAL
+	log *LogFile
+	// LSN of the last log record written by each running transaction
+	lastLSN map[TransactionID]LSN
+	// pages that have a full page image in the log since it was last truncated
+	imaged map[any]bool
+	// heap files named by log records, so undo can find them
+	walFiles map[string]*HeapFile
+	// the transaction whose image of each index page is in the log, and the
+	// index files named by log records, so rollback can restore them
+	indexImaged map[any]TransactionID
+	indexFiles  map[string]*BTreeFile
+
+	// number of pages returned by GetPage, read atomically by PagesFetched
+	pagesFetched int64
 }
 
 // Create a new BufferPool with the specified number of pages
@@ -93,6 +134,11 @@ func NewBufferPool(numPages int) *BufferPool {
 
 	bp.tranFetchedPid = make(map[TransactionID]*[]FetchedPageType)
 	bp.g = NewGraph()
+	bp.lastLSN = make(map[TransactionID]LSN)
+	bp.imaged = make(map[any]bool)
+	bp.walFiles = make(map[string]*HeapFile)
+	bp.indexImaged = make(map[any]TransactionID)
+	bp.indexFiles = make(map[string]*BTreeFile)
 	for i := 0; i < numPages; i++ {
 		bp.freeList.PushBack(i)
 	}
@@ -133,7 +179,7 @@ func (bp *BufferPool) Unpin(pageKey any) {
 		if !ok {
 			return
 		}
-		if bp.pages[fid].isDirty() == false {
+		if bp.pages[fid].isDirty() == false || bp.isLogged(bp.pages[fid]) {
 			bp.replacer.touch(fid)
 		}
 	}
@@ -144,17 +190,99 @@ func (bp *BufferPool) Unpin(pageKey any) {
 func (bp *BufferPool) FlushAllPages() {
 	for i := 0; i < len(bp.pages); i++ {
 		if bp.pages[i] != nil && bp.pages[i].isDirty() {
-			file := bp.pages[i].getFile()
-			(*file).flushPage(&bp.pages[i])
-			bp.pages[i].setDirty(false)
+			bp.flushFrame(i)
+		}
+	}
+}
+
+// Write the page in the specified frame back to its file.  If a log is
+// attached, the log is forced up to the page's LSN first, so that no change
+// reaches disk before the log record describing it.
+func (bp *BufferPool) flushFrame(fid int) error {
+	page := bp.pages[fid]
+	if bp.isLogged(page) {
+		lsn := LSN(0)
+		switch p := page.(type) {
+		case *heapPage:
+			lsn = p.lsn
+		case *btreePage:
+			lsn = p.lsn
+		}
+		err := bp.log.force(lsn)
+		if err != nil {
+			return err
+		}
+	}
+	file := page.getFile()
+	err := (*file).flushPage(&bp.pages[fid])
+	if err != nil {
+		return err
+	}
+	bp.pages[fid].setDirty(false)
+	return nil
+}
+
+// Commit tid, which holds write locks on the pages of the named files but has
+// not changed them, discard the cached pages of the files, and rename file
+// from to to.  This replaces a file on disk with one written outside the
+// buffer pool, so none of its old pages may be read or written back.  As the
+// log records of the files do not apply to the new ones, the log is
+// checkpointed first, which fails if any other transaction is running.
+func (bp *BufferPool) replaceFile(tid TransactionID, files map[string]bool, from string, to string) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if bp.log != nil {
+		for other := range bp.tranFetchedPid {
+			if other != tid {
+				return GoDBError{IllegalTransactionError, "can't change a table while other transactions are running"}
+			}
+		}
+		err := bp.checkpoint()
+		if err != nil {
+			return err
 		}
 	}
+	bp.releasePageLock(tid, false)
+	delete(bp.tranFetchedPid, tid)
+	bp.removePages(files)
+	return os.Rename(from, to)
+}
+
+// Discard the cached pages of the named files without writing them back, as
+// the files are being removed.
+func (bp *BufferPool) discardFiles(files map[string]bool) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.removePages(files)
+}
+
+// Remove the pages of the named files from the buffer pool.  bp.mu must be
+// held.
+func (bp *BufferPool) removePages(files map[string]bool) {
+	for fid, page := range bp.pages {
+		if page == nil {
+			continue
+		}
+		key, ok := PageKey(page).(heapHash)
+		if !ok || !files[key.FileName] {
+			continue
+		}
+		delete(bp.corr, key)
+		delete(bp.pin, key)
+		delete(bp.imaged, key)
+		delete(bp.indexImaged, key)
+		bp.pages[fid] = nil
+		bp.replacer.remove(fid)
+		bp.freeList.PushBack(fid)
+	}
+	for name := range files {
+		delete(bp.walFiles, name)
+		delete(bp.indexFiles, name)
+	}
 }
 
 func (bp *BufferPool) RemoveFromLockMgr(tid TransactionID, p Page) {
-	pid := p.(*heapPage).pageId
-	key := (*p.getFile()).pageKey(pid)
-	bp.mgr.ReleaseLock(tid, key)
+	bp.mgr.ReleaseLock(tid, PageKey(p))
 }
 
 func (bp *BufferPool) releasePageLock(tid TransactionID, forceWrite bool) {
@@ -169,11 +297,8 @@ func (bp *BufferPool) releasePageLock(tid TransactionID, forceWrite bool) {
 		perm := val.Perm
 		key := file.pageKey(pid)
 		fid, ok := bp.corr[key]
-		if !ok {
-			continue
-		}
-		if perm == WritePerm {
-			if forceWrite {
+		if ok && perm == WritePerm {
+			if forceWrite && !bp.isLogged(bp.pages[fid]) {
 				// fetch page first
 				page := bp.pages[fid]
 				if page.isDirty() {
@@ -192,14 +317,23 @@ func (bp *BufferPool) releasePageLock(tid TransactionID, forceWrite bool) {
 
 }
 
-// Abort the transaction, releasing locks. Because GoDB is FORCE/NO STEAL, none
-// of the pages tid has dirtired will be on disk so it is sufficient to just
-// release locks to abort. You do not need to implement this for lab 1.
-func (bp *BufferPool) AbortTransaction(tid TransactionID) {
+// Abort the transaction, releasing locks. Without a log GoDB is FORCE/NO
+// STEAL, so none of the pages tid has dirtired will be on disk and it is
+// sufficient to reread them and release locks to abort.  With a log, the
+// changes of tid are rolled back using its log records before the locks are
+// released.  If the log can't be written, tid keeps its locks and a LogError
+// is returned;  its changes are rolled back by recovery when the database is
+// opened again.
+func (bp *BufferPool) AbortTransaction(tid TransactionID) error {
 	bp.mu.Lock()
 	defer bp.mu.Unlock()
 
-	bp.releasePageLock(tid, false)
+	if bp.log != nil {
+		err := bp.rollback(tid)
+		if err != nil {
+			return GoDBError{LogError, fmt.Sprintf("couldn't roll back transaction %d: %s", tidValue(tid), err.Error())}
+		}
+	}
 
 	// reread dirty page
 	pidList := bp.tranFetchedPid[tid]
@@ -208,13 +342,14 @@ func (bp *BufferPool) AbortTransaction(tid TransactionID) {
 		file := val.File
 		key := file.pageKey(pid)
 		fid, ok := bp.corr[key]
-		if !ok {
-			panic("fid should exist")
+		if !ok || bp.isLogged(bp.pages[fid]) {
+			// not cached, so not changed, or already rolled back
+			continue
 		}
 		page, err := file.readPage(pid)
-		hf := file.(*HeapFile)
 		if err != nil {
-			if pid < (*hf).NumPages() {
+			hf, ok := file.(*HeapFile)
+			if !ok || pid < (*hf).NumPages() {
 				panic("reading page shouldn't fail")
 			} else {
 				// this is new page
@@ -225,19 +360,65 @@ func (bp *BufferPool) AbortTransaction(tid TransactionID) {
 		bp.pages[fid] = *page
 	}
 
+	bp.releasePageLock(tid, false)
 	delete(bp.tranFetchedPid, tid)
+	closeTransactionSpillFiles(tid)
+	return nil
+}
+
+// Return true if changes to the page are logged, so it may be evicted while
+// dirty (STEAL) and need not be written at commit (NO FORCE).  Index pages are
+// only logged to be rolled back, as indexes are rebuilt after a crash.
+func (bp *BufferPool) isLogged(page Page) bool {
+	switch page.(type) {
+	case *heapPage, *btreePage:
+		return bp.log != nil
+	}
+	return false
 }
 
-// Commit the transaction, releasing locks. Because GoDB is FORCE/NO STEAL, none
-// of the pages tid has dirtied will be on disk, so prior to releasing locks you
-// should iterate through pages and write them to disk.  In GoDB lab3 we assume
-// that the system will not crash while doing this, allowing us to avoid using a
-// WAL. You do not need to implement this for lab 1.
-func (bp *BufferPool) CommitTransaction(tid TransactionID) {
+// Commit the transaction, releasing locks. Without a log GoDB is FORCE/NO
+// STEAL, none of the pages tid has dirtied will be on disk, so prior to
+// releasing locks we iterate through pages and write them to disk.  With a log,
+// the commit record is forced to the log instead and dirty heap and index
+// pages are written back whenever they are evicted.  If the commit record can't be
+// written, tid keeps its locks and a LogError is returned, so that it can be
+// aborted.  Once the log is larger than CheckpointLogSize, the last transaction
+// to commit checkpoints it.
+func (bp *BufferPool) CommitTransaction(tid TransactionID) error {
 	bp.mu.Lock()
 	defer bp.mu.Unlock()
+	if bp.log != nil {
+		err := bp.logCommit(tid)
+		if err != nil {
+			return GoDBError{LogError, fmt.Sprintf("couldn't commit transaction %d: %s", tidValue(tid), err.Error())}
+		}
+	}
 	bp.releasePageLock(tid, true)
 	delete(bp.tranFetchedPid, tid)
+	closeTransactionSpillFiles(tid)
+	if bp.log != nil && bp.log.size() > CheckpointLogSize && len(bp.tranFetchedPid) == 0 {
+		err := bp.checkpoint()
+		if err != nil {
+			return GoDBError{LogError, fmt.Sprintf("couldn't checkpoint the log: %s", err.Error())}
+		}
+	}
+	return nil
+}
+
+// Write every dirty page back to disk and truncate the log, so that no log
+// record refers to a file that is about to be removed, and the database is
+// opened again without recovery.  Fails if a transaction is running.
+func (bp *BufferPool) Checkpoint() error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if bp.log == nil {
+		return nil
+	}
+	if len(bp.tranFetchedPid) > 0 {
+		return GoDBError{IllegalTransactionError, "can't checkpoint while transactions are running"}
+	}
+	return bp.checkpoint()
 }
 
 func (bp *BufferPool) BeginTransaction(tid TransactionID) error {
@@ -253,10 +434,8 @@ func (bp *BufferPool) changeCorrespond(file DBFile, pageId int, frameNo int) {
 	oldPage := bp.pages[frameNo]
 	// defending codes
 	if oldPage != nil {
-		// old page id
-		oldPageId := oldPage.(*heapPage).pageId
 		// delete old
-		delete(bp.corr, (*oldPage.getFile()).pageKey(oldPageId))
+		delete(bp.corr, PageKey(oldPage))
 	}
 
 	bp.corr[file.pageKey(pageId)] = frameNo
@@ -296,6 +475,24 @@ func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm R
 		*currTidPageFetchedList = append(*currTidPageFetchedList, FetchedPageType{pageNo, perm, file})
 	}
 
+	pg, err := bp.loadPage(file, pageNo)
+	if err != nil {
+		bp.Unpin(key)
+		return nil, err
+	}
+	atomic.AddInt64(&bp.pagesFetched, 1)
+	return pg, nil
+}
+
+// Return the number of pages returned by GetPage so far.
+func (bp *BufferPool) PagesFetched() int64 {
+	return atomic.LoadInt64(&bp.pagesFetched)
+}
+
+// Return the frame holding the specified page, reading the page from its file
+// (and evicting another page) if it is not cached.  Takes no locks;  the
+// caller must hold bp.mu.
+func (bp *BufferPool) loadPage(file DBFile, pageNo int) (*Page, error) {
 	fid, ok := bp.corr[file.pageKey(pageNo)]
 	// not only pid , but also file is same
 	if ok {
@@ -304,46 +501,67 @@ func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm R
 
 	if bp.freeList.Len() > 0 {
 		backElement := bp.freeList.Back()
-		fid = backElement.Value.(int)
-		bp.freeList.Remove(backElement)
-
-		bp.changeCorrespond(file, pageNo, fid)
-
 		pg, err := file.readPage(pageNo)
 		if err != nil {
-			bp.Unpin(key)
 			return nil, err
 		}
-		(*pg).(*heapPage).pageId = pageNo
+		fid = backElement.Value.(int)
+		bp.freeList.Remove(backElement)
+
+		bp.changeCorrespond(file, pageNo, fid)
 		bp.pages[fid] = *pg
 
 		return &bp.pages[fid], nil
 	}
 
 	// read
-	fid, err := bp.replacer.evict()
+	fid, err := bp.evictFrame()
 	if err != nil {
-		bp.Unpin(key)
 		return nil, err
 	}
 
-	if bp.pages[fid].isDirty() {
-		panic("no steal")
-	}
-	// must be the first step
-	bp.changeCorrespond(file, pageNo, fid)
-
 	pg, err := file.readPage(pageNo)
 	if err != nil {
-		bp.Unpin(key)
+		bp.replacer.touch(fid)
 		return nil, err
 	}
+	// must be the first step
+	bp.changeCorrespond(file, pageNo, fid)
 	bp.pages[fid] = *pg
 
 	return &bp.pages[fid], nil
 
 }
 
+// Pick a frame to reuse.  Frames whose page is pinned are skipped;  they go
+// back to the replacer when they are unpinned.  A dirty page in the frame is
+// only allowed if it is logged (STEAL), in which case it is written back
+// first.
+func (bp *BufferPool) evictFrame() (int, error) {
+	for {
+		fid, err := bp.replacer.evict()
+		if err != nil {
+			return 0, err
+		}
+
+		page := bp.pages[fid]
+		if bp.pin[PageKey(page)] > 0 {
+			continue
+		}
+		if page.isDirty() {
+			if !bp.isLogged(page) {
+				continue
+			}
+			err = bp.flushFrame(fid)
+			if err != nil {
+				bp.replacer.touch(fid)
+				return 0, err
+			}
+		}
+		return fid, nil
+	}
+}
+
 // New a page
 func (bp *BufferPool) NewPage(file DBFile, pageNo int, tid TransactionID, perm RWPerm) (*Page, error) {
 	bp.mu.Lock()
@@ -394,16 +612,12 @@ func (bp *BufferPool) NewPage(file DBFile, pageNo int, tid TransactionID, perm R
 	}
 
 	// read
-	fid, err := bp.replacer.evict()
+	fid, err := bp.evictFrame()
 	if err != nil {
 		bp.Unpin(key)
 		return nil, err
 	}
 
-	if bp.pages[fid].isDirty() {
-		panic("no steal")
-	}
-
 	// must be first
 	bp.changeCorrespond(file, pageNo, fid)
 
@@ -126,16 +126,16 @@ func TestGetPage(t *testing.T) {
 		bp.CommitTransaction(tid)
 	}
 	bp.BeginTransaction(tid)
-	//expect 6 pages
-	for i := 0; i < 6; i++ {
+	//expect 4 pages, as the short strings take less than StringLength bytes
+	for i := 0; i < 4; i++ {
 		pg, err := bp.GetPage(hf, i, tid, ReadPerm)
 		if pg == nil || err != nil {
 			t.Fatalf("failed to get page %d (err = %v)", i, err)
 		}
 		bp.Unpin(PageKey(*pg))
 	}
-	_, err := bp.GetPage(hf, 7, tid, ReadPerm)
+	_, err := bp.GetPage(hf, 5, tid, ReadPerm)
 	if err == nil {
-		t.Fatalf("No error when getting page 7 from a file with 6 pages.")
+		t.Fatalf("No error when getting page 5 from a file with 4 pages.")
 	}
 }
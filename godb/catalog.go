@@ -4,12 +4,33 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
 type Table struct {
-	name string
-	desc TupleDesc
+	name        string
+	desc        TupleDesc
+	indexes     []*Index
+	keys        []*Key
+	foreignKeys []*ForeignKey
+	notNull     []bool      // notNull[i] is true if field i is NOT NULL, nil if no field is
+	defaults    []string    // defaults[i] is the DEFAULT expression of field i, or "", nil if no field has one
+	stats       *TableStats // nil if the table has not been analyzed
+
+	// the open file of the table, nil until [Catalog.openTable] opens it;
+	// changed is true if its indexes and keys must be set up again
+	file    *HeapFile
+	changed bool
+}
+
+// Index is a B+ tree index on one column of a table, stored in its own file
+// (see [BTreeFile]).
+type Index struct {
+	name   string
+	table  string
+	column string
+	file   *BTreeFile // nil until [Catalog.openIndex] opens it
 }
 
 type Catalog struct {
@@ -18,6 +39,10 @@ type Catalog struct {
 	columnMap map[string][]*Table
 	bp        *BufferPool
 	rootPath  string
+	ctes      map[string]*LogicalCTENode // of the query being parsed, see [parseWith]
+	params    paramExprs                 // of the statement being prepared, see [Prepare]
+	views     []*View
+	refs      map[string]bool // if not nil, the tables and views read by the query being parsed are added to it
 }
 
 func (c *Catalog) SaveToFile(catalogFile string, rootPath string) error {
@@ -31,13 +56,24 @@ func (c *Catalog) SaveToFile(catalogFile string, rootPath string) error {
 	return nil
 }
 
+// Remove a table, its indexes and their files.  The log is checkpointed
+// first, as redo finds the files of log records by name and a table created
+// later with the same name must not have the records of this one replayed.
 func (c *Catalog) dropTable(table string) error {
 	for i, t := range c.tables {
 		if t.name == table {
+			if err := c.bp.Checkpoint(); err != nil {
+				return err
+			}
 			c.tableMap[table] = nil
 			c.columnMap[table] = nil
 			c.tables = append(c.tables[:i], c.tables[i+1:]...)
+			c.closeTable(t)
+			c.tablesChanged()
 			os.Remove(c.tableNameToFile(table))
+			for _, idx := range t.indexes {
+				os.Remove(c.indexNameToFile(idx.name))
+			}
 			return nil
 		}
 	}
@@ -69,31 +105,152 @@ func ImportCatalogFromCSVs(catalogFile string, bp *BufferPool, rootPath string,
 	return nil
 }
 
-func parseCatalogFile(catalogFile string, rootPath string) ([]TupleDesc, []string, error) {
-	var tables []TupleDesc
-	var names []string
+// Parse a catalog file.  Each line of the file either describes a table, as
+//
+//	name (field type, field type not null, ...)
+//
+// or an index on a column of a table defined earlier in the file, as
+//
+//	index name on table (field)
+//
+// or the statistics of a table defined earlier in the file (see
+// [TableStats.String]), as
+//
+//	stats table (tuples, field distinct nulls min max buckets..., ...)
+//
+// or a key of a table defined earlier in the file (see [Key]), as
+//
+//	primary key name on table (field, ...)
+//	unique name on table (field, ...)
+//
+// or a foreign key of a table defined earlier in the file (see [ForeignKey]),
+// as
+//
+//	foreign key name on table (field, ...) references table (field, ...) on delete cascade|restrict
+//
+// or the DEFAULT expression of a column of a table defined earlier in the
+// file, as
+//
+//	default table field expression
+//
+// or a view (see [View]), as
+//
+//	view name [(column, ...)] as query
+func parseCatalogFile(catalogFile string, rootPath string) ([]*Table, []*Index, []*View, error) {
+	var tables []*Table
+	var indexes []*Index
+	var views []*View
 	f, err := os.Open(rootPath + "/" + catalogFile)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	scanner := bufio.NewScanner(f)
 
 	for scanner.Scan() {
+		// the query of a view may have any number of parens, and is case sensitive
+		if m := viewEntryRegexp.FindStringSubmatch(scanner.Text()); m != nil {
+			views = append(views, newView(m))
+			continue
+		}
+		if m := defaultEntryRegexp.FindStringSubmatch(scanner.Text()); m != nil {
+			var table *Table
+			field := -1
+			for _, t := range tables {
+				if t.name == strings.ToLower(m[1]) {
+					table = t
+					field, _ = findFieldInTd(FieldType{strings.ToLower(m[2]), "", UnknownType}, &t.desc)
+				}
+			}
+			if field < 0 {
+				return nil, nil, nil, GoDBError{ParseError, fmt.Sprintf("malformed default entry (line %s)", scanner.Text())}
+			}
+			if table.defaults == nil {
+				table.defaults = make([]string, len(table.desc.Fields))
+			}
+			table.defaults[field] = m[3]
+			continue
+		}
 		// code to read each line
 		line := strings.ToLower(scanner.Text())
+		if m := foreignKeyEntryRegexp.FindStringSubmatch(line); m != nil {
+			fk := newForeignKey(m)
+			var table *Table
+			for _, t := range tables {
+				if t.name == fk.table {
+					table = t
+				}
+			}
+			if table == nil {
+				return nil, nil, nil, GoDBError{ParseError, fmt.Sprintf("malformed foreign key entry (line %s)", line)}
+			}
+			table.foreignKeys = append(table.foreignKeys, fk)
+			continue
+		}
 		sep := strings.Split(line, "(")
 		if len(sep) != 2 {
-			return nil, nil, GoDBError{ParseError, fmt.Sprintf("expected one paren in catalog entry, got %d (%s)", len(sep), line)}
+			return nil, nil, nil, GoDBError{ParseError, fmt.Sprintf("expected one paren in catalog entry, got %d (%s)", len(sep), line)}
+		}
+		if strings.HasPrefix(line, "index ") {
+			words := strings.Fields(sep[0])
+			if len(words) != 4 || words[2] != "on" {
+				return nil, nil, nil, GoDBError{ParseError, fmt.Sprintf("malformed index entry (line %s)", line)}
+			}
+			indexes = append(indexes, &Index{words[1], words[3], strings.TrimSpace(strings.Trim(sep[1], "()")), nil})
+			continue
+		}
+		if strings.HasPrefix(line, "primary key ") || strings.HasPrefix(line, "unique ") {
+			words := strings.Fields(strings.TrimPrefix(sep[0], "primary ")) // "key" or "unique", name, "on", table
+			var table *Table
+			for _, t := range tables {
+				if len(words) == 4 && words[2] == "on" && t.name == words[3] {
+					table = t
+				}
+			}
+			if table == nil {
+				return nil, nil, nil, GoDBError{ParseError, fmt.Sprintf("malformed key entry (line %s)", line)}
+			}
+			var columns []string
+			for _, col := range strings.Split(strings.Trim(sep[1], "()"), ",") {
+				columns = append(columns, strings.TrimSpace(col))
+			}
+			table.keys = append(table.keys, &Key{words[1], table.name, columns, strings.HasPrefix(line, "primary key ")})
+			continue
+		}
+		if strings.HasPrefix(line, "stats ") {
+			words := strings.Fields(sep[0])
+			var table *Table
+			for _, t := range tables {
+				if len(words) == 2 && t.name == words[1] {
+					table = t
+				}
+			}
+			if table == nil {
+				return nil, nil, nil, GoDBError{ParseError, fmt.Sprintf("malformed stats entry (line %s)", line)}
+			}
+			table.stats, err = parseTableStats(strings.Trim(sep[1], "()"), &table.desc)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			continue
 		}
 		tableName := strings.TrimSpace(sep[0])
 		rest := strings.Trim(sep[1], "()")
 		fields := strings.Split(rest, ",")
 		var fieldArray []FieldType
+		var notNull []bool
 		for _, f := range fields {
 			f := strings.TrimSpace(f)
-			nameType := strings.Split(f, " ")
+			nameType := strings.Fields(f)
+			isNotNull := len(nameType) == 4 && nameType[2] == "not" && nameType[3] == "null"
+			if isNotNull {
+				nameType = nameType[:2]
+				if notNull == nil {
+					notNull = make([]bool, len(fields))
+				}
+				notNull[len(fieldArray)] = true
+			}
 			if len(nameType) != 2 {
-				return nil, nil, GoDBError{ParseError, fmt.Sprintf("malformed catalog entry %s (line %s)", nameType, line)}
+				return nil, nil, nil, GoDBError{ParseError, fmt.Sprintf("malformed catalog entry %s (line %s)", nameType, line)}
 			}
 			switch nameType[1] {
 			case "int":
@@ -107,34 +264,86 @@ func parseCatalogFile(catalogFile string, rootPath string) ([]TupleDesc, []strin
 			case "text":
 				fieldArray = append(fieldArray, FieldType{nameType[0], "", StringType})
 			default:
-				return nil, nil, GoDBError{ParseError, fmt.Sprintf("unknown type %s (line %s)", nameType[1], line)}
+				return nil, nil, nil, GoDBError{ParseError, fmt.Sprintf("unknown type %s (line %s)", nameType[1], line)}
 			}
 		}
-		tables = append(tables, TupleDesc{fieldArray})
-		names = append(names, tableName)
+		tables = append(tables, &Table{tableName, TupleDesc{fieldArray}, nil, nil, nil, notNull, nil, nil, nil, false})
 	}
-	return tables, names, nil
+	return tables, indexes, views, nil
 
 }
 
+// Load the catalog stored in catalogFile.  The write ahead log of the
+// database (catalogFile with its extension replaced by .log) is opened and
+// attached to bp, and any changes that were not written to the tables before
+// the last crash are recovered from it.  Index pages are only logged so that
+// aborted transactions can be rolled back, so if there was anything to recover
+// all indexes are rebuilt.
 func NewCatalogFromFile(catalogFile string, bp *BufferPool, rootPath string) (*Catalog, error) {
-	tabs, names, err := parseCatalogFile(catalogFile, rootPath)
+	tabs, indexes, views, err := parseCatalogFile(catalogFile, rootPath)
+	if err != nil {
+		return nil, err
+	}
+	c := &Catalog{make([]*Table, 0), make(map[string]*Table), make(map[string][]*Table), bp, rootPath, nil, nil, views, nil}
+	for _, t := range tabs {
+		c.addTable(t.name, t.desc, t.notNull)
+		c.tableMap[t.name].stats = t.stats
+		c.tableMap[t.name].keys = t.keys
+		c.tableMap[t.name].foreignKeys = t.foreignKeys
+		c.tableMap[t.name].defaults = t.defaults
+	}
+	for _, idx := range indexes {
+		err = c.addIndex(idx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log, err := NewLogFile(c.logFileName(catalogFile))
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*HeapFile)
+	for _, t := range c.tables {
+		hf, err := c.openTable(t)
+		if err != nil {
+			return nil, err
+		}
+		files[hf.file.Name()] = hf
+	}
+	crashed := !log.isEmpty()
+	err = bp.recoverFromLog(log, files)
 	if err != nil {
 		return nil, err
 	}
-	c := &Catalog{make([]*Table, 0), make(map[string]*Table), make(map[string][]*Table), bp, rootPath}
-	for i, t := range tabs {
-		c.addTable(names[i], t)
+	if crashed {
+		for _, t := range c.tables {
+			for _, idx := range t.indexes {
+				// bp may still cache pages of the index if it is reopened
+				bp.discardFiles(map[string]bool{c.indexNameToFile(idx.name): true})
+				os.Remove(c.indexNameToFile(idx.name))
+				err = c.buildIndex(idx)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
 	}
 
 	return c, nil
 
 }
 
-func (c *Catalog) addTable(named string, desc TupleDesc) error {
+func (c *Catalog) logFileName(catalogFile string) string {
+	return c.rootPath + "/" + strings.TrimSuffix(catalogFile, filepath.Ext(catalogFile)) + ".log"
+}
+
+// Add a table to the catalog.  notNull flags the fields that may not be NULL,
+// and may be nil.
+func (c *Catalog) addTable(named string, desc TupleDesc, notNull []bool) error {
 	_, err := c.GetTable(named)
 	if err != nil {
-		t := &Table{named, desc}
+		t := &Table{named, desc, nil, nil, nil, notNull, nil, nil, nil, false}
 		c.tables = append(c.tables, t)
 		c.tableMap[named] = t
 		for _, f := range desc.Fields {
@@ -155,13 +364,228 @@ func (c *Catalog) tableNameToFile(tableName string) string {
 	return c.rootPath + "/" + tableName + ".dat"
 
 }
+
+func (c *Catalog) indexNameToFile(indexName string) string {
+	return c.rootPath + "/" + indexName + ".idx"
+}
+
+// Return the table and index with the given name, or nil if there is none.
+func (c *Catalog) findIndex(named string) (*Table, int) {
+	for _, t := range c.tables {
+		for i, idx := range t.indexes {
+			if idx.name == named {
+				return t, i
+			}
+		}
+	}
+	return nil, -1
+}
+
+// Add an index to the catalog, without building it.
+func (c *Catalog) addIndex(idx *Index) error {
+	if t, _ := c.findIndex(idx.name); t != nil {
+		return GoDBError{DuplicateTableError, fmt.Sprintf("an index named '%s' already exists", idx.name)}
+	}
+	t := c.tableMap[idx.table]
+	if t == nil {
+		return GoDBError{NoSuchTableError, fmt.Sprintf("no table '%s' found", idx.table)}
+	}
+	_, err := findFieldInTd(FieldType{idx.column, "", UnknownType}, &t.desc)
+	if err != nil {
+		return err
+	}
+	t.indexes = append(t.indexes, idx)
+	c.tablesChanged()
+	return nil
+}
+
+// Fill the (new, empty) file of idx with an entry for every tuple of its
+// table.  The index is built in a transaction of its own.
+func (c *Catalog) buildIndex(idx *Index) error {
+	bt, err := c.openIndex(idx)
+	if err != nil {
+		return err
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	err = bt.build(tid)
+	if err != nil {
+		c.bp.AbortTransaction(tid)
+		return err
+	}
+	c.bp.CommitTransaction(tid)
+	return nil
+}
+
+// Return the open file of t, opening it the first time.  The file is kept
+// open, so that every query shares it and the pages cached for it, until t is
+// dropped or its file replaced.
+func (c *Catalog) openTable(t *Table) (*HeapFile, error) {
+	if t.file == nil {
+		hf, err := NewHeapFile(c.tableNameToFile(t.name), t.desc.copy(), c.bp)
+		if err != nil {
+			return nil, err
+		}
+		t.file = hf
+		t.changed = true
+	}
+	if t.changed {
+		t.file.desc = t.desc.copy()
+		t.file.notNull = t.notNull
+	}
+	return t.file, nil
+}
+
+// Return the open file of idx, opening it the first time, like
+// [Catalog.openTable].
+func (c *Catalog) openIndex(idx *Index) (*BTreeFile, error) {
+	if idx.file != nil {
+		return idx.file, nil
+	}
+	t := c.tableMap[idx.table]
+	keyField, err := findFieldInTd(FieldType{idx.column, "", UnknownType}, &t.desc)
+	if err != nil {
+		return nil, err
+	}
+	hf, err := c.openTable(t)
+	if err != nil {
+		return nil, err
+	}
+	idx.file, err = NewBTreeFile(c.indexNameToFile(idx.name), hf, keyField, c.bp)
+	return idx.file, err
+}
+
+// Close the file of idx, which is being removed or replaced, discarding its
+// cached pages.
+func (c *Catalog) closeIndex(idx *Index) {
+	if idx.file == nil {
+		return
+	}
+	c.bp.discardFiles(map[string]bool{idx.file.file.Name(): true})
+	idx.file.file.Close()
+	idx.file = nil
+}
+
+// Close the files of t and its indexes, like [Catalog.closeIndex].
+func (c *Catalog) closeTable(t *Table) {
+	for _, idx := range t.indexes {
+		c.closeIndex(idx)
+	}
+	if t.file == nil {
+		return
+	}
+	c.bp.discardFiles(map[string]bool{t.file.file.Name(): true})
+	t.file.file.Close()
+	t.file = nil
+}
+
+// Have [Catalog.GetTable] set up the indexes and keys of the open files again,
+// after the indexes, keys or columns of a table changed.  As foreign keys
+// link tables, all of them are set up again.
+func (c *Catalog) tablesChanged() {
+	for _, t := range c.tables {
+		t.changed = true
+	}
+}
+
+// Create an index named indexName on column of table, and index the tuples
+// already in the table.
+func (c *Catalog) createIndex(indexName string, table string, column string) error {
+	idx := &Index{indexName, table, column, nil}
+	err := c.addIndex(idx)
+	if err != nil {
+		return err
+	}
+	os.Remove(c.indexNameToFile(indexName))
+	err = c.buildIndex(idx)
+	if err != nil {
+		c.dropIndex(indexName)
+		return err
+	}
+	return nil
+}
+
+func (c *Catalog) dropIndex(indexName string) error {
+	t, i := c.findIndex(indexName)
+	if t == nil {
+		return GoDBError{NoSuchTableError, fmt.Sprintf("couldn't find index '%s' to drop", indexName)}
+	}
+	if t.findKey(indexName) != nil || t.findForeignKey(indexName) != nil {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("can't drop index '%s', it checks the key of the same name", indexName)}
+	}
+	c.closeIndex(t.indexes[i])
+	t.indexes = append(t.indexes[:i], t.indexes[i+1:]...)
+	c.tablesChanged()
+	os.Remove(c.indexNameToFile(indexName))
+	return nil
+}
+
+// Return the table with the specified name.  Its indexes are opened as well,
+// so that they are maintained when tuples are inserted or deleted through the
+// returned HeapFile, which is the same for every call.
 func (c *Catalog) GetTable(named string) (DBFile, error) {
 	t := c.tableMap[named]
 	if t == nil {
 		return nil, GoDBError{NoSuchTableError, fmt.Sprintf("no table '%s' found", named)}
 	}
-	return NewHeapFile(c.tableNameToFile(named), t.desc.copy(), c.bp)
+	hf, err := c.openTable(t)
+	if err != nil {
+		return nil, err
+	}
+	if !t.changed {
+		return hf, nil
+	}
+	var indexes []*BTreeFile
+	hf.keys, hf.references, hf.referencedBy = nil, nil, nil
+	for _, idx := range t.indexes {
+		bt, err := c.openIndex(idx)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, bt)
+		if key := t.findKey(idx.name); key != nil {
+			fields := make([]int, len(key.columns))
+			for i, col := range key.columns {
+				fields[i], err = findFieldInTd(FieldType{col, "", UnknownType}, hf.Descriptor())
+				if err != nil {
+					return nil, err
+				}
+			}
+			hf.keys = append(hf.keys, &heapKey{key.name, fields, bt})
+		}
+	}
+	hf.indexes = indexes
+	err = c.openForeignKeys(t, hf)
+	if err != nil {
+		return nil, err
+	}
+	t.changed = false
+	return hf, nil
+}
 
+// Collect the statistics of a table, replacing any it had.  The table is read
+// in a transaction of its own.
+func (c *Catalog) analyze(table string) error {
+	t := c.tableMap[table]
+	if t == nil {
+		return GoDBError{NoSuchTableError, fmt.Sprintf("no table '%s' found", table)}
+	}
+	hf, err := c.GetTable(table)
+	if err != nil {
+		return err
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	stats, err := collectStats(hf.Descriptor(), func() (func() (*Tuple, error), error) {
+		return hf.Iterator(tid)
+	})
+	if err != nil {
+		c.bp.AbortTransaction(tid)
+		return err
+	}
+	c.bp.CommitTransaction(tid)
+	t.stats = stats
+	return nil
 }
 
 func (c *Catalog) findTablesWithColumn(named string) []*Table {
@@ -188,8 +612,37 @@ func (c *Catalog) CatalogString() string {
 				fieldStr = fieldStr + ", "
 			}
 			fieldStr = fieldStr + f.Fname + " " + typeNames[f.Ftype]
+			if t.notNull != nil && t.notNull[i] {
+				fieldStr = fieldStr + " not null"
+			}
 		}
 		outStr = outStr + t.name + " " + fieldStr + ")\n"
 	}
+	for _, t := range c.tables {
+		for _, idx := range t.indexes {
+			outStr = outStr + "index " + idx.name + " on " + idx.table + " (" + idx.column + ")\n"
+		}
+	}
+	for _, t := range c.tables {
+		for _, key := range t.keys {
+			outStr = outStr + key.String() + "\n"
+		}
+		for _, fk := range t.foreignKeys {
+			outStr = outStr + fk.String() + "\n"
+		}
+		for i, expr := range t.defaults {
+			if expr != "" {
+				outStr = outStr + "default " + t.name + " " + t.desc.Fields[i].Fname + " " + expr + "\n"
+			}
+		}
+	}
+	for _, t := range c.tables {
+		if t.stats != nil {
+			outStr = outStr + "stats " + t.name + " (" + t.stats.String(&t.desc) + ")\n"
+		}
+	}
+	for _, v := range c.views {
+		outStr = outStr + v.String() + "\n"
+	}
 	return outStr
 }
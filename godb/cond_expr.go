@@ -0,0 +1,192 @@
+package godb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Return the type of the values of exprs, ignoring nil expressions and NULLs,
+// or an error if they are of different types.
+func commonType(exprs []Expr) (DBType, error) {
+	var ftype = UnknownType
+	for _, e := range exprs {
+		if e == nil {
+			continue
+		}
+		switch t := e.GetExprType().Ftype; {
+		case t == UnknownType:
+		case ftype == UnknownType:
+			ftype = t
+		case t != ftype:
+			return UnknownType, GoDBError{TypeMismatchError, "expressions of a CASE or COALESCE must be of the same type"}
+		}
+	}
+	return ftype, nil
+}
+
+// Return the field type of type ftype of an expression of exprs:  that of the
+// first column they refer to, as filters look up their table by it, or else
+// one named name.
+func exprsFieldType(name string, ftype DBType, exprs []Expr) FieldType {
+	for _, e := range exprs {
+		if _, ok := e.(*ConstExpr); ok || e == nil {
+			continue
+		}
+		if ft := e.GetExprType(); ft.TableQualifier != "" {
+			return FieldType{ft.Fname, ft.TableQualifier, ftype}
+		}
+	}
+	return FieldType{name, "", ftype}
+}
+
+// Return the operands of the comparisons of the predicate.
+func (p *PredExpr) operands() []Expr {
+	if p.predType == PredCompare {
+		return []Expr{p.left, p.right}
+	}
+	var ops []Expr
+	for _, arg := range p.args {
+		ops = append(ops, arg.operands()...)
+	}
+	return ops
+}
+
+// CaseExpr is the value of the then expression of its first when predicate
+// that is true, or else of elseExpr, NULL if it is nil.
+type CaseExpr struct {
+	whens    []*PredExpr
+	thens    []Expr
+	elseExpr Expr
+	ftype    DBType
+}
+
+// Constructor for a CASE expression.  Returns an error if its values are of
+// different types.
+func NewCaseExpr(whens []*PredExpr, thens []Expr, elseExpr Expr) (*CaseExpr, error) {
+	ftype, err := commonType(append(append([]Expr{}, thens...), elseExpr))
+	if err != nil {
+		return nil, err
+	}
+	return &CaseExpr{whens, thens, elseExpr, ftype}, nil
+}
+
+func (e *CaseExpr) GetExprType() FieldType {
+	exprs := append(append([]Expr{}, e.thens...), e.elseExpr)
+	for _, when := range e.whens {
+		exprs = append(exprs, when.operands()...)
+	}
+	return exprsFieldType("case", e.ftype, exprs)
+}
+
+func (e *CaseExpr) EvalExpr(t *Tuple) (DBValue, error) {
+	for i, when := range e.whens {
+		v, err := when.eval(t)
+		if err != nil {
+			return nil, err
+		}
+		if v == triTrue {
+			return e.thens[i].EvalExpr(t)
+		}
+	}
+	if e.elseExpr == nil {
+		return NullField{}, nil
+	}
+	return e.elseExpr.EvalExpr(t)
+}
+
+// CoalesceExpr is the value of the first of its arguments that is not NULL.
+type CoalesceExpr struct {
+	args  []Expr
+	ftype DBType
+}
+
+// Constructor for a COALESCE expression.  Returns an error if its arguments
+// are of different types.
+func NewCoalesceExpr(args []Expr) (*CoalesceExpr, error) {
+	ftype, err := commonType(args)
+	if err != nil {
+		return nil, err
+	}
+	return &CoalesceExpr{args, ftype}, nil
+}
+
+func (e *CoalesceExpr) GetExprType() FieldType {
+	return exprsFieldType("coalesce", e.ftype, e.args)
+}
+
+func (e *CoalesceExpr) EvalExpr(t *Tuple) (DBValue, error) {
+	for _, arg := range e.args {
+		v, err := arg.EvalExpr(t)
+		if err != nil || !isNull(v) {
+			return v, err
+		}
+	}
+	return NullField{}, nil
+}
+
+// CastExpr converts the value of expr to ftype.  Strings that are not integers
+// can't be cast to integers.
+type CastExpr struct {
+	expr  Expr
+	ftype DBType
+}
+
+func NewCastExpr(expr Expr, ftype DBType) *CastExpr {
+	return &CastExpr{expr, ftype}
+}
+
+func (e *CastExpr) GetExprType() FieldType {
+	ft := e.expr.GetExprType()
+	return FieldType{ft.Fname, ft.TableQualifier, e.ftype}
+}
+
+func (e *CastExpr) EvalExpr(t *Tuple) (DBValue, error) {
+	v, err := e.expr.EvalExpr(t)
+	if err != nil || isNull(v) {
+		return v, err
+	}
+	switch v := v.(type) {
+	case IntField:
+		if e.ftype == StringType {
+			return StringField{strconv.FormatInt(v.Value, 10)}, nil
+		}
+	case StringField:
+		if e.ftype == IntType {
+			n, err := strconv.ParseInt(strings.TrimSpace(v.Value), 10, 64)
+			if err != nil {
+				return nil, GoDBError{TypeMismatchError, fmt.Sprintf("can't cast '%s' to an integer", v.Value)}
+			}
+			return IntField{n}, nil
+		}
+	}
+	return v, nil
+}
+
+// BoolExpr is the value of a predicate:  1 if it is true, 0 if it is false,
+// and NULL if it is unknown.
+type BoolExpr struct {
+	pred *PredExpr
+}
+
+func NewBoolExpr(pred *PredExpr) *BoolExpr {
+	return &BoolExpr{pred}
+}
+
+func (e *BoolExpr) GetExprType() FieldType {
+	return exprsFieldType("bool", IntType, e.pred.operands())
+}
+
+func (e *BoolExpr) EvalExpr(t *Tuple) (DBValue, error) {
+	v, err := e.pred.eval(t)
+	if err != nil {
+		return nil, err
+	}
+	switch v {
+	case triTrue:
+		return IntField{1}, nil
+	case triFalse:
+		return IntField{0}, nil
+	}
+	return NullField{}, nil
+}
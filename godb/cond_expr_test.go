@@ -0,0 +1,83 @@
+package godb
+
+import (
+	"testing"
+)
+
+func TestCondExprQuery(t *testing.T) {
+	c := makeOuterJoinTestCatalog(t)
+	// a.x is NULL, 1, 2, 2, 3;  NULLs sort first
+	var queries = []struct {
+		query    string
+		ftype    DBType
+		expected []any // the second column of each row, nil for NULL
+	}{
+		{"select x, case when x > 1 then 'big' when x = 1 then 'one' end from a order by x", StringType, []any{nil, "one", "big", "big", "big"}},
+		{"select x, case x when 2 then 'two' else 'other' end from a order by x", StringType, []any{"other", "other", "two", "two", "other"}},
+		{"select x, case when x is null then null else x * 2 end from a order by x", IntType, []any{nil, 2, 4, 4, 6}},
+		{"select x, coalesce(x, 0) from a order by x", IntType, []any{0, 1, 2, 2, 3}},
+		{"select x, coalesce(null, x, 5) from a order by x", IntType, []any{5, 1, 2, 2, 3}},
+		{"select x, nullif(x, 2) from a order by x", IntType, []any{nil, 1, nil, nil, 3}},
+		{"select x, cast(x as string) from a order by x", StringType, []any{nil, "1", "2", "2", "3"}},
+		{"select x, cast(x as char) from a order by x", StringType, []any{nil, "1", "2", "2", "3"}},
+		{"select x, cast(cast(x as varchar) as int) + 1 from a order by x", IntType, []any{nil, 2, 3, 3, 4}},
+		{"select x, x > 1 from a order by x", IntType, []any{nil, 0, 1, 1, 1}},
+		{"select x, x is null or x = 3 from a order by x", IntType, []any{1, 0, 0, 0, 1}},
+		{"select x, x between 2 and 3 from a order by x", IntType, []any{nil, 0, 1, 1, 1}},
+	}
+	for _, q := range queries {
+		res := runTestQuery(t, c, q.query)
+		if len(res) != len(q.expected) {
+			t.Errorf("%s: expected %d results, got %d", q.query, len(q.expected), len(res))
+			continue
+		}
+		if ftype := res[0].Desc.Fields[1].Ftype; ftype != q.ftype {
+			t.Errorf("%s: expected a column of type %v, got %v", q.query, q.ftype, ftype)
+		}
+		for i, tup := range res {
+			var v any
+			switch f := tup.Fields[1].(type) {
+			case IntField:
+				v = int(f.Value)
+			case StringField:
+				v = f.Value
+			}
+			if v != q.expected[i] {
+				t.Errorf("%s: expected %v in row %d, got %v", q.query, q.expected[i], i, tup.Fields[1])
+			}
+		}
+	}
+
+	if res := runTestQuery(t, c, "select x from a where coalesce(x, 0) < 2"); len(res) != 2 {
+		t.Errorf("expected 2 results, got %d", len(res))
+	}
+	res := runTestQuery(t, c, "select sum(case when x > 1 then 1 else 0 end) as big from a")
+	if len(res) != 1 || res[0].Fields[0].(IntField).Value != 3 {
+		t.Errorf("expected 3 rows with x > 1, got %v", res)
+	}
+
+	for _, q := range []string{
+		"select case when x > 1 then 'big' else 0 end from a",
+		"select coalesce(x, 'none') from a",
+		"select cast(x as date) from a",
+		"select nullif(x) from a",
+	} {
+		if _, _, err := Parse(c, q); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+	_, plan, err := Parse(c, "select cast('one' as int) from a")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	defer c.bp.CommitTransaction(tid)
+	iter, err := plan.Iterator(tid)
+	if err == nil {
+		_, err = iter()
+	}
+	if err == nil {
+		t.Errorf("expected an error casting a string that is not an integer")
+	}
+}
@@ -0,0 +1,175 @@
+package godb
+
+import (
+	"fmt"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// A common table expression of a WITH clause.  Each reference to it in a FROM
+// clause is planned as a subquery of its query, which is parsed in scope, the
+// catalog with the expressions before it.  In the recursive term of a
+// recursive expression, the references are to op, its work table.
+type LogicalCTENode struct {
+	name      string
+	columns   []string // if nil, the columns are named by its query
+	query     string
+	recursive bool
+	scope     *Catalog
+	op        Operator
+}
+
+// Return a catalog with the tables of c and the common table expressions of c
+// and cte, which hides the tables and expressions of c of the same name.
+func (c *Catalog) withCTE(cte *LogicalCTENode) *Catalog {
+	scoped := *c
+	scoped.ctes = map[string]*LogicalCTENode{cte.name: cte}
+	for name, other := range c.ctes {
+		if name != cte.name {
+			scoped.ctes[name] = other
+		}
+	}
+	return &scoped
+}
+
+// sqlparser does not parse WITH clauses, so split query into its WITH clause,
+// if it has one, and the query that follows it.  Return the catalog with the
+// common table expressions of the clause, and that query.
+func parseWith(c *Catalog, query string) (*Catalog, string, error) {
+	tokens := scanTokens(query)
+	if len(tokens) == 0 || tokens[0].word(query) != "with" {
+		return c, query, nil
+	}
+	var errWith = GoDBError{ParseError, "invalid WITH clause"}
+	i, recursive := 1, false
+	if i < len(tokens) && tokens[i].word(query) == "recursive" {
+		i, recursive = i+1, true
+	}
+	scope := c
+	for {
+		//name [(columns)] AS (query)
+		if i >= len(tokens) || tokens[i].typ != sqlparser.ID {
+			return nil, "", errWith
+		}
+		cte := &LogicalCTENode{name: tokens[i].word(query), scope: scope}
+		i++
+		if i < len(tokens) && tokens[i].typ == '(' {
+			close := matchingParen(tokens, i, 1)
+			if close < 0 {
+				return nil, "", errWith
+			}
+			for _, t := range tokens[i+1 : close] {
+				if t.typ != ',' {
+					cte.columns = append(cte.columns, t.word(query))
+				}
+			}
+			i = close + 1
+		}
+		if i+1 >= len(tokens) || tokens[i].word(query) != "as" || tokens[i+1].typ != '(' {
+			return nil, "", errWith
+		}
+		close := matchingParen(tokens, i+1, 1)
+		if close < 0 {
+			return nil, "", errWith
+		}
+		cte.query = query[tokens[i+1].end:tokens[close].start]
+		// only expressions that refer to themselves are evaluated recursively
+		for _, t := range tokens[i+2 : close] {
+			cte.recursive = cte.recursive || (recursive && t.word(query) == cte.name)
+		}
+		scope = scope.withCTE(cte)
+		i = close + 1
+		if i >= len(tokens) || tokens[i].typ != ',' {
+			break
+		}
+		i++
+	}
+	if i >= len(tokens) {
+		return nil, "", GoDBError{ParseError, "WITH must be followed by a query"}
+	}
+	return scope, query[tokens[i].start:], nil
+}
+
+// Return the subquery a reference to the expression, aliased alias, is
+// planned as.
+func (cte *LogicalCTENode) subplan(alias string) (*LogicalPlan, error) {
+	op := cte.op
+	if op == nil {
+		// the query may have a WITH clause of its own, as that of a view may
+		scope, query, err := parseWith(cte.scope, cte.query)
+		if err != nil {
+			return nil, err
+		}
+		query, setOps := rewriteQuery(query)
+		stmt, err := sqlparser.Parse(query)
+		if err != nil {
+			return nil, err
+		}
+		union, ok := stmt.(*sqlparser.Union)
+		switch {
+		case ok && cte.recursive:
+			op, err = cte.planRecursive(scope, union, setOps)
+		case ok:
+			op, err = parseUnion(scope, union, &setOps)
+		default:
+			sel, ok := stmt.(*sqlparser.Select)
+			if !ok {
+				return nil, GoDBError{ParseError, fmt.Sprintf("%s must be a query", cte.name)}
+			}
+			op, err = parseUnion(scope, sel, &setOps)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if cte.columns != nil && !cte.recursive {
+			op, err = renameFields(op, cte.columns)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	plan := &LogicalPlan{alias: alias, physical: op}
+	for _, f := range op.Descriptor().Fields {
+		field := NewFieldSelectNode("", f.Fname, "")
+		plan.selects = append(plan.selects, &field)
+	}
+	return plan, nil
+}
+
+// Return the operator of a recursive expression, the union of a non
+// recursive term and a recursive one, parsed in scope.
+func (cte *LogicalCTENode) planRecursive(scope *Catalog, stmt *sqlparser.Union, setOps []SetOpType) (Operator, error) {
+	anchor, err := parseUnion(scope, stmt.Left, &setOps)
+	if err != nil {
+		return nil, err
+	}
+	if len(setOps) == 0 || setOps[0] != UnionOp {
+		return nil, GoDBError{ParseError, fmt.Sprintf("the recursive term of %s must follow UNION", cte.name)}
+	}
+	setOps = setOps[1:]
+	if cte.columns != nil {
+		anchor, err = renameFields(anchor, cte.columns)
+		if err != nil {
+			return nil, err
+		}
+	}
+	work := NewWorkTable(anchor.Descriptor())
+	recursive, err := parseUnion(scope.withCTE(&LogicalCTENode{name: cte.name, op: work}), stmt.Right, &setOps)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecursiveUnion(anchor, recursive, work, stmt.Type == sqlparser.UnionAllStr)
+}
+
+// Return op with its fields named names.
+func renameFields(op Operator, names []string) (Operator, error) {
+	fields := op.Descriptor().Fields
+	if len(names) != len(fields) {
+		return nil, GoDBError{ParseError, fmt.Sprintf("expected %d columns, got %d", len(names), len(fields))}
+	}
+	var exprs []Expr
+	for _, f := range fields {
+		exprs = append(exprs, &FieldExpr{f})
+	}
+	return NewProjectOp(exprs, names, false, op)
+}
@@ -0,0 +1,115 @@
+package godb
+
+import (
+	"os"
+	"sort"
+	"testing"
+)
+
+// Return a catalog with the outer join test tables and emp (id, manager), a
+// hierarchy where 1 manages 2 and 3, 2 manages 4, 4 manages 5, and 6 manages
+// 7 and is managed by 7.
+func makeCTETestCatalog(t *testing.T) *Catalog {
+	c := makeOuterJoinTestCatalog(t)
+	err := os.WriteFile(c.rootPath+"/catalog.txt", []byte("a (x int, y int)\nb (x int, y int)\nemp (id int, manager int)\n"), 0644)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	c, err = NewCatalogFromFile("catalog.txt", c.bp, c.rootPath)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, v := range []string{"1, null", "2, 1", "3, 1", "4, 2", "5, 4", "6, 7", "7, 6"} {
+		runTestQuery(t, c, "insert into emp values ("+v+")")
+	}
+	return c
+}
+
+// Return the sorted integers of the first column of the results of query.
+func firstColumn(t *testing.T, c *Catalog, query string) []int64 {
+	var values []int64
+	for _, tup := range runTestQuery(t, c, query) {
+		if isNull(tup.Fields[0]) {
+			values = append(values, -1)
+			continue
+		}
+		values = append(values, tup.Fields[0].(IntField).Value)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values
+}
+
+func TestCTEQuery(t *testing.T) {
+	c := makeCTETestCatalog(t)
+	var queries = []struct {
+		query    string
+		expected []int64 // the sorted first column of the results, -1 for NULL
+	}{
+		{"with big as (select x from a where x > 1) select x from big", []int64{2, 2, 3}},
+		{"with big as (select x from a where x > 1), small as (select x from a where x < 3) select big.x from big join small on big.x = small.x", []int64{2, 2, 2, 2}},
+		{"with t as (select x from a where x is not null) select t1.x from t t1, t t2 where t1.x = t2.x and t2.x < 3", []int64{1, 2, 2, 2, 2}},
+		{"with t(v) as (select x from a) select v from t where v = 2", []int64{2, 2}},
+		{"with t1 as (select * from a), t2 as (select x from t1 where x > 1) select * from t2", []int64{2, 2, 3}},
+		{"with a as (select x from b) select x from a where x > 3", []int64{4}},
+		{"with u as (select x from a union select x from b) select x from u", []int64{-1, 1, 2, 3, 4}},
+		{"with t as (select x, y from b) select a.x from a, t where a.x = t.x and t.y = 2", []int64{2, 2, 3, 3}},
+		{"with recursive n(i) as (select 1 from a where x = 1 union all select i + 1 from n where i < 5) select i from n", []int64{1, 2, 3, 4, 5}},
+		{"with recursive sub(id) as (select id from emp where id = 2 union all select emp.id from emp join sub on emp.manager = sub.id) select id from sub", []int64{2, 4, 5}},
+		{"with recursive sub as (select id from emp where manager is null union all select emp.id from emp, sub where emp.manager = sub.id) select id from sub", []int64{1, 2, 3, 4, 5}},
+		// the cycle of 6 and 7 ends once no new rows are found
+		{"with recursive sub(id) as (select id from emp where id = 6 union select emp.id from emp join sub on emp.manager = sub.id) select id from sub", []int64{6, 7}},
+		{"with recursive t as (select x from a where x = 3) select x from t", []int64{3}},
+		// an anchor without a FROM clause returns a single row
+		{"with recursive r(n) as (select 1 union all select n + 1 from r where n < 5) select n from r", []int64{1, 2, 3, 4, 5}},
+		{"with r(n) as (select 1 + 2) select n from r", []int64{3}},
+		{"select 1", []int64{1}},
+		{"select 1 where 1 = 2", []int64{}},
+	}
+	for _, q := range queries {
+		res := firstColumn(t, c, q.query)
+		if len(res) != len(q.expected) {
+			t.Errorf("%s: expected %v, got %v", q.query, q.expected, res)
+			continue
+		}
+		for i := range res {
+			if res[i] != q.expected[i] {
+				t.Errorf("%s: expected %v, got %v", q.query, q.expected, res)
+				break
+			}
+		}
+	}
+
+	res := runTestQuery(t, c, "with recursive chain(id, depth) as (select id, 0 from emp where id = 1 union all select emp.id, depth + 1 from emp join chain on emp.manager = chain.id) select id, depth from chain where id = 5")
+	if len(res) != 1 || res[0].Fields[1].(IntField).Value != 3 || res[0].Desc.Fields[1].Fname != "depth" {
+		t.Errorf("expected 5 at depth 3, got %v", res)
+	}
+
+	for _, q := range []string{
+		"with t(v, w) as (select x from a) select v from t",
+		"with t as select x from a select x from t",
+		"with t as (select x from a)",
+		"with t as (select x from t) select x from t",
+		"with recursive t as (select x from a union all select x, y from t) select x from t",
+	} {
+		if _, _, err := Parse(c, q); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+	_, plan, err := Parse(c, "with recursive t(x) as (select x from a where x = 1 union all select x from t) select x from t")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	defer c.bp.CommitTransaction(tid)
+	iter, err := plan.Iterator(tid)
+	for err == nil {
+		var tup *Tuple
+		if tup, err = iter(); tup == nil {
+			break
+		}
+	}
+	if err == nil {
+		t.Errorf("expected an error for a recursive query that does not end")
+	}
+}
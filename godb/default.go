@@ -0,0 +1,155 @@
+package godb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// The DEFAULT expressions of columns are stored in the catalog file as
+//
+//	default table column expression
+var defaultEntryRegexp = regexp.MustCompile(`(?i)^default\s+(\w+)\s+(\w+)\s+(.*?)\s*$`)
+
+// INSERT INTO t DEFAULT VALUES inserts a tuple of the defaults of t.
+var defaultValuesRegexp = regexp.MustCompile(`(?is)^(\s*insert\s+into\s+(\w+))\s+default\s+values(\s*;?\s*)$`)
+
+// The keywords that end the DEFAULT expression of a column.
+var columnOptionKeywords = map[string]bool{
+	"not":            true,
+	"null":           true,
+	"primary":        true,
+	"unique":         true,
+	"key":            true,
+	"references":     true,
+	"comment":        true,
+	"auto_increment": true,
+}
+
+// sqlparser only parses DEFAULT values that are literals, so remove the
+// DEFAULT expressions of the columns from query if it is a CREATE TABLE
+// statement, and return them, by column.  An expression extends up to the
+// next option of the column, such as NOT NULL.
+func rewriteDefaults(query string) (string, map[string]string) {
+	tokens, defs := tableDefinitions(query)
+	var (
+		defaults  map[string]string
+		rewritten strings.Builder
+		last      = 0
+	)
+	for _, def := range defs {
+		start, next := def[0], def[1]
+		for j := start + 1; j+1 < next; j++ {
+			if tokens[j].word(query) != "default" {
+				continue
+			}
+			end := j + 2
+			for end < next && !columnOptionKeywords[tokens[end].word(query)] {
+				if tokens[end].typ == '(' {
+					end = matchingParen(tokens, end, 1)
+				}
+				end++
+			}
+			// the positions of literals are not exact, so cut at those of the
+			// keywords and punctuation around the expression
+			if defaults == nil {
+				defaults = make(map[string]string)
+			}
+			defaults[tokens[start].word(query)] = strings.TrimSpace(query[tokens[j].end:tokens[end].start])
+			rewritten.WriteString(query[last:tokens[j].start])
+			last = tokens[end].start
+			break
+		}
+	}
+	rewritten.WriteString(query[last:])
+	return rewritten.String(), defaults
+}
+
+// sqlparser doesn't parse INSERT ... DEFAULT VALUES, so rewrite it to insert
+// DEFAULT into the first column of the table, which inserts the same tuple.
+func (c *Catalog) rewriteDefaultValues(query string) string {
+	m := defaultValuesRegexp.FindStringSubmatch(query)
+	if m == nil {
+		return query
+	}
+	t := c.tableMap[strings.ToLower(m[2])]
+	if t == nil {
+		// the INSERT fails as the table is not found
+		return m[1] + " values (default)" + m[3]
+	}
+	return fmt.Sprintf("%s (%s) values (default)%s", m[1], t.desc.Fields[0].Fname, m[3])
+}
+
+// Return the expression a DEFAULT expression of a column of type ftype is
+// evaluated by.  It may not refer to any columns.
+func parseDefault(c *Catalog, text string, ftype DBType) (Expr, error) {
+	stmt, err := sqlparser.Parse("select " + rewriteCasts(text))
+	if err != nil {
+		return nil, GoDBError{ParseError, fmt.Sprintf("invalid DEFAULT expression %s: %s", text, err.Error())}
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	var aliased *sqlparser.AliasedExpr
+	if ok && len(sel.SelectExprs) == 1 && sel.Where == nil && sel.GroupBy == nil {
+		aliased, ok = sel.SelectExprs[0].(*sqlparser.AliasedExpr)
+	}
+	if !ok || aliased == nil {
+		return nil, GoDBError{ParseError, fmt.Sprintf("invalid DEFAULT expression %s", text)}
+	}
+	node, err := parseExpr(c, aliased.Expr, "")
+	if err != nil {
+		return nil, err
+	}
+	expr, _, err := node.generateExpr(c, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !isNullConst(expr) && expr.GetExprType().Ftype != ftype {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("DEFAULT expression %s is not of type %s", text, typeNames[ftype])}
+	}
+	return expr, nil
+}
+
+// Return the expressions the fields of t are filled with when an INSERT
+// gives no value for them:  their DEFAULT expressions, or NULL.
+func (c *Catalog) columnDefaults(t *Table) ([]Expr, error) {
+	var exprs []Expr
+	for i, f := range t.desc.Fields {
+		if t.defaults == nil || t.defaults[i] == "" {
+			exprs = append(exprs, &ConstExpr{NullField{}, UnknownType})
+			continue
+		}
+		expr, err := parseDefault(c, t.defaults[i], f.Ftype)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+	return exprs, nil
+}
+
+// Return the numbers of the fields of desc that the columns of an INSERT
+// statement name, in order, or of all its fields if it names none.
+func insertColumns(columns sqlparser.Columns, desc *TupleDesc) ([]int, error) {
+	var fields []int
+	if len(columns) == 0 {
+		for i := range desc.Fields {
+			fields = append(fields, i)
+		}
+		return fields, nil
+	}
+	seen := make(map[int]bool)
+	for _, col := range columns {
+		i, err := findFieldInTd(FieldType{sqlparser.String(col), "", UnknownType}, desc)
+		if err != nil {
+			return nil, err
+		}
+		if seen[i] {
+			return nil, GoDBError{ParseError, fmt.Sprintf("column %s is inserted more than once", sqlparser.String(col))}
+		}
+		seen[i] = true
+		fields = append(fields, i)
+	}
+	return fields, nil
+}
@@ -0,0 +1,86 @@
+package godb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInsertDefaults(t *testing.T) {
+	c := makeOuterJoinTestCatalog(t)
+	q := "create table t (a int, b int default 7 not null, c varchar(20) default 'none', d int default epoch(), e int)"
+	if _, _, err := Parse(c, q); err != nil {
+		t.Fatalf("%s: %s", q, err.Error())
+	}
+	start := time.Now().Unix()
+	runTestQuery(t, c, "insert into t (a, c) values (1, 'x'), (2, default)")
+	runTestQuery(t, c, "insert into t values (3, default, 'y', 0, 5)")
+	runTestQuery(t, c, "insert into t (e, a) select y, x + 10 from b where x > 2")
+	runTestQuery(t, c, "insert into t select x, y, 'z', 0, y from a where x = 1")
+	runTestQuery(t, c, "insert into t default values")
+
+	res := runTestQuery(t, c, "select a, b, c, d, e from t order by a, b")
+	expected := []struct {
+		a, b  int64
+		c     string
+		epoch bool // d is the time of the insert, rather than 0
+		e     int64
+	}{
+		{-1, 7, "none", true, -1},
+		{1, 1, "z", false, 1},
+		{1, 7, "x", true, -1},
+		{2, 7, "none", true, -1},
+		{3, 7, "y", false, 5},
+		{13, 7, "none", true, 2},
+		{13, 7, "none", true, 2},
+		{14, 7, "none", true, 2},
+	}
+	if len(res) != len(expected) {
+		t.Fatalf("expected %d tuples, got %d", len(expected), len(res))
+	}
+	for i, exp := range expected {
+		f := res[i].Fields
+		var a, e int64 = -1, -1
+		if !isNull(f[0]) {
+			a = f[0].(IntField).Value
+		}
+		if !isNull(f[4]) {
+			e = f[4].(IntField).Value
+		}
+		d := f[3].(IntField).Value
+		if a != exp.a || f[1].(IntField).Value != exp.b || f[2].(StringField).Value != exp.c || e != exp.e || (d >= start) != exp.epoch {
+			t.Errorf("tuple %d: expected %v, got %v", i, exp, f)
+		}
+	}
+
+	for _, q := range []string{
+		"insert into t (a, c) values (1)",
+		"insert into t (a, a) values (1, 2)",
+		"insert into t (nosuch) values (1)",
+		"insert into nosuch default values",
+		"insert into t (a, c) select x from a",
+		"create table bad (a int default 'x')",
+		"create table bad (a int default a + 1)",
+	} {
+		if _, _, err := Parse(c, q); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+
+	// defaults are stored in the catalog file
+	err := c.SaveToFile("catalog.txt", c.rootPath)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	c, err = NewCatalogFromFile("catalog.txt", c.bp, c.rootPath)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, _, err := Parse(c, "alter table t drop column a"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	runTestQuery(t, c, "insert into t (e) values (99)")
+	res = runTestQuery(t, c, "select b, c from t where e = 99")
+	if len(res) != 1 || res[0].Fields[0].(IntField).Value != 7 || res[0].Fields[1].(StringField).Value != "none" {
+		t.Errorf("expected the defaults to be kept, got %v", res)
+	}
+}
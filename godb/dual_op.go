@@ -0,0 +1,27 @@
+package godb
+
+// DualOp returns a single tuple without fields.  It is the input of a SELECT
+// without a FROM clause, which sqlparser parses as reading the table dual, so
+// "select 1" returns one tuple.
+type DualOp struct{}
+
+func NewDualOp() *DualOp {
+	return &DualOp{}
+}
+
+// Return a TupleDescriptor for this operator, which has no fields.
+func (d *DualOp) Descriptor() *TupleDesc {
+	return &TupleDesc{}
+}
+
+// Dual operator implementation.  Returns one empty tuple.
+func (d *DualOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	done := false
+	return func() (*Tuple, error) {
+		if done {
+			return nil, nil
+		}
+		done = true
+		return &Tuple{Desc: TupleDesc{}}, nil
+	}, nil
+}
@@ -0,0 +1,167 @@
+package godb
+
+import "time"
+
+// ExplainOp returns the plan of a query, one tuple per operator (see
+// [planLines]).  If analyze is set, the query is run first and every operator
+// of the plan reports the tuples it returned, the time spent in it and its
+// inputs, and the pages it fetched from the buffer pool.
+type ExplainOp struct {
+	plan    Operator
+	analyze bool
+	bp      *BufferPool
+}
+
+// Construct an ExplainOp for plan.  If analyze is set, every operator of plan
+// is instrumented, so plan should not be run other than by the ExplainOp.
+func NewExplainOp(plan Operator, analyze bool, bp *BufferPool) *ExplainOp {
+	if analyze {
+		plan = instrumentPlan(plan, bp)
+	}
+	return &ExplainOp{plan, analyze, bp}
+}
+
+// Return a TupleDescriptor for the plan, a single string field
+func (e *ExplainOp) Descriptor() *TupleDesc {
+	return &TupleDesc{[]FieldType{{"plan", "", StringType}}}
+}
+
+// Return an iterator over the lines of the plan.  For EXPLAIN ANALYZE, the
+// query is run to completion in transaction tid first, discarding its results.
+func (e *ExplainOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	if e.analyze {
+		resetPlanStats(e.plan)
+		iter, err := e.plan.Iterator(tid)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			t, err := iter()
+			if err != nil {
+				return nil, err
+			}
+			if t == nil {
+				break
+			}
+		}
+	}
+	var lines = planLines(e.plan, "")
+	var next = 0
+	return func() (*Tuple, error) {
+		if next >= len(lines) {
+			return nil, nil
+		}
+		next++
+		return &Tuple{*e.Descriptor(), []DBValue{StringField{lines[next-1]}}, nil}, nil
+	}, nil
+}
+
+// analyzedOp wraps an operator of an EXPLAIN ANALYZE plan, counting the tuples
+// it returns and the time and buffer pool page fetches spent getting them,
+// including those of its inputs.
+type analyzedOp struct {
+	child   Operator
+	bp      *BufferPool
+	rows    int
+	elapsed time.Duration
+	pages   int64
+}
+
+func (a *analyzedOp) Descriptor() *TupleDesc {
+	return a.child.Descriptor()
+}
+
+func (a *analyzedOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	var iter func() (*Tuple, error)
+	var err error
+	a.measure(func() {
+		iter, err = a.child.Iterator(tid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() (*Tuple, error) {
+		var t *Tuple
+		var err error
+		a.measure(func() {
+			t, err = iter()
+		})
+		if t != nil {
+			a.rows++
+		}
+		return t, err
+	}, nil
+}
+
+func (a *analyzedOp) measure(f func()) {
+	var start = time.Now()
+	var pages = a.bp.PagesFetched()
+	f()
+	a.elapsed += time.Since(start)
+	a.pages += a.bp.PagesFetched() - pages
+}
+
+// Wrap every operator of the plan rooted at op in an analyzedOp.
+func instrumentPlan(op Operator, bp *BufferPool) Operator {
+	for _, child := range planChildren(op) {
+		*child = instrumentPlan(*child, bp)
+	}
+	return &analyzedOp{child: op, bp: bp}
+}
+
+func resetPlanStats(op Operator) {
+	if a, ok := op.(*analyzedOp); ok {
+		a.rows, a.elapsed, a.pages = 0, 0, 0
+		op = a.child
+	}
+	for _, child := range planChildren(op) {
+		resetPlanStats(*child)
+	}
+}
+
+// Return pointers to the inputs of op, so they can be read or replaced.
+func planChildren(o Operator) []*Operator {
+	switch op := o.(type) {
+	case *HashJoin:
+		return []*Operator{&op.left, &op.right}
+	case *NestedLoopJoin:
+		return []*Operator{&op.left, &op.right}
+	case *SortMergeJoin:
+		return []*Operator{&op.left, &op.right}
+	case *SetOp:
+		return []*Operator{&op.left, &op.right}
+	case *RecursiveUnion:
+		return []*Operator{&op.anchor, &op.recursive}
+	case *EqualityJoin[int64]:
+		return []*Operator{op.left, op.right}
+	case *EqualityJoin[string]:
+		return []*Operator{op.left, op.right}
+	case *Project:
+		return []*Operator{&op.child}
+	case *Filter[int64]:
+		return []*Operator{&op.child}
+	case *Filter[string]:
+		return []*Operator{&op.child}
+	case *PredicateOp:
+		return []*Operator{&op.child}
+	case *OrderBy:
+		return []*Operator{&op.child}
+	case *LimitOp:
+		return []*Operator{&op.child}
+	case *ScalarSubquery:
+		return []*Operator{&op.child}
+	case *Window:
+		return []*Operator{&op.child}
+	case *Aggregator:
+		return []*Operator{&op.child}
+	case *InsertOp:
+		return []*Operator{&op.child}
+	case *DeleteOp:
+		return []*Operator{&op.child}
+	case *UpdateOp:
+		return []*Operator{&op.child}
+	case *ExplainOp:
+		return []*Operator{&op.plan}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package godb
+
+import (
+	"strings"
+	"testing"
+)
+
+// Return the lines of the plan returned by an EXPLAIN query.
+func explainLines(t *testing.T, c *Catalog, query string) []string {
+	var lines []string
+	for _, tup := range runTestQuery(t, c, query) {
+		lines = append(lines, tup.Fields[0].(StringField).Value)
+	}
+	return lines
+}
+
+func TestExplain(t *testing.T) {
+	c, _ := makeIndexTestCatalog(t, 20)
+	lines := explainLines(t, c, "explain select name from t where age = 3")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 plan lines, got %v", lines)
+	}
+	var prefixes = []string{"Project", "\tFilter", "\t\tHeap Scan t.dat"}
+	for i, prefix := range prefixes {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("expected line %d of the plan to start with %q, got %q", i, prefix, lines[i])
+		}
+	}
+	if strings.Contains(lines[0], "rows=") {
+		t.Errorf("EXPLAIN should not run the query")
+	}
+
+	if _, _, err := Parse(c, "create index t_age on t (age)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	lines = explainLines(t, c, "explain select name from t where age = 3")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "\tIndex Scan t_age.idx, age =") {
+		t.Errorf("expected an index scan, got %v", lines)
+	}
+
+	if _, _, err := Parse(c, "explain create table u (a int)"); err == nil {
+		t.Errorf("expected an error explaining a statement that returns no tuples")
+	}
+}
+
+func TestExplainAnalyze(t *testing.T) {
+	c, _ := makeIndexTestCatalog(t, 20)
+	lines := explainLines(t, c, "EXPLAIN ANALYZE select t1.name from t t1, t t2 where t1.age = t2.age and t1.age = 3")
+	if len(lines) != 7 {
+		t.Fatalf("expected 7 plan lines, got %v", lines)
+	}
+	var rows = []string{"rows=4 ", "rows=4 ", "rows=2 ", "rows=2 ", "rows=20 ", "rows=20 ", "rows=20 "}
+	for i, r := range rows {
+		if !strings.Contains(lines[i], r) || !strings.Contains(lines[i], "pages=") {
+			t.Errorf("expected %s in line %d of the plan, got %q", r, i, lines[i])
+		}
+	}
+	if !strings.HasPrefix(lines[4], "\t\t\t\tHeap Scan") || strings.Contains(lines[4], "pages=0)") {
+		t.Errorf("expected the scan to fetch pages, got %q", lines[4])
+	}
+}
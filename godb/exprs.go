@@ -13,7 +13,7 @@ import (
 //other values from tuples.
 
 type Expr interface {
-	EvalExpr(t *Tuple) (DBValue, error) //DBValue is either IntField or StringField
+	EvalExpr(t *Tuple) (DBValue, error) //DBValue is an IntField, a StringField or a NullField
 	GetExprType() FieldType             //Return the type of the Expression
 }
 
@@ -48,6 +48,13 @@ func (c *ConstExpr) EvalExpr(_ *Tuple) (DBValue, error) {
 	return c.val, nil
 }
 
+// Return true if e is the constant NULL, which may be used in place of a
+// value of any type.
+func isNullConst(e Expr) bool {
+	c, ok := e.(*ConstExpr)
+	return ok && isNull(c.val)
+}
+
 type FuncExpr struct {
 	op   string
 	args []*Expr
@@ -221,7 +228,7 @@ func (f *FuncExpr) EvalExpr(t *Tuple) (DBValue, error) {
 	argvals := make([]any, len(fType.argTypes))
 	for i, argType := range fType.argTypes {
 		arg := *f.args[i]
-		if arg.GetExprType().Ftype != argType {
+		if arg.GetExprType().Ftype != argType && !isNullConst(arg) {
 			typeName := "string"
 			switch argType {
 			case IntType:
@@ -233,6 +240,10 @@ func (f *FuncExpr) EvalExpr(t *Tuple) (DBValue, error) {
 		if err != nil {
 			return nil, err
 		}
+		// functions of NULL are NULL
+		if isNull(val) {
+			return NullField{}, nil
+		}
 		switch argType {
 		case IntType:
 			argvals[i] = val.(IntField).Value
@@ -0,0 +1,180 @@
+package godb
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Maximum number of runs merged at once by externalSort
+const sortMergeFanIn int = 16
+
+// Return an iterator over the tuples returned by iter, sorted so that no tuple
+// is returned after a tuple it is less than.  At most maxBufferSize tuples are
+// kept in memory:  if iter returns more, the input is split into sorted runs of
+// maxBufferSize tuples that are written to spill files (see [spillFile]) and
+// merged, up to sortMergeFanIn runs at a time.
+//
+// Like the partitions of [HashJoin], runs bypass the buffer pool, which cannot
+// evict dirty pages without a log and would log the runs if it has one.  So the
+// memory of a sort is bounded by maxBufferSize, not by the capacity of the
+// buffer pool, and the pages of runs are not counted by EXPLAIN ANALYZE.
+func externalSort(tid TransactionID, iter func() (*Tuple, error), less func(a, b *Tuple) bool, maxBufferSize int) (func() (*Tuple, error), error) {
+	if maxBufferSize < 1 {
+		return nil, GoDBError{IllegalOperationError, "sort buffer must hold at least one tuple"}
+	}
+	var runs []*spillFile
+	for {
+		var buf []*Tuple
+		for len(buf) < maxBufferSize {
+			t, err := iter()
+			if err != nil {
+				closeSpillFiles(runs)
+				return nil, err
+			}
+			if t == nil {
+				break
+			}
+			buf = append(buf, t)
+		}
+		sort.Sort(tupleSorter{buf, less})
+		if len(runs) == 0 && len(buf) < maxBufferSize {
+			// everything fits in memory
+			return sliceIter(buf), nil
+		}
+		if len(buf) > 0 {
+			run, err := writeRun(tid, sliceIter(buf))
+			if err != nil {
+				closeSpillFiles(runs)
+				return nil, err
+			}
+			runs = append(runs, run)
+		}
+		if len(buf) < maxBufferSize {
+			break
+		}
+	}
+
+	for len(runs) > sortMergeFanIn {
+		var merged []*spillFile
+		for i := 0; i < len(runs); i += sortMergeFanIn {
+			end := i + sortMergeFanIn
+			if end > len(runs) {
+				end = len(runs)
+			}
+			group := runs[i:end]
+			run, err := writeRun(tid, mergeRuns(group, less))
+			closeSpillFiles(group)
+			if err != nil {
+				closeSpillFiles(merged)
+				closeSpillFiles(runs[i+len(group):])
+				return nil, err
+			}
+			merged = append(merged, run)
+		}
+		runs = merged
+	}
+	var mergeIter = mergeRuns(runs, less)
+	return func() (*Tuple, error) {
+		t, err := mergeIter()
+		if err != nil || t == nil {
+			closeSpillFiles(runs)
+		}
+		return t, err
+	}, nil
+}
+
+// Write the tuples returned by iter to a new spill file of tid.
+func writeRun(tid TransactionID, iter func() (*Tuple, error)) (*spillFile, error) {
+	run, err := newSpillFile(tid, nil)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, err := iter()
+		if err == nil && t == nil {
+			err = run.finish()
+		}
+		if err != nil {
+			closeSpillFiles([]*spillFile{run})
+			return nil, err
+		}
+		if t == nil {
+			return run, nil
+		}
+		err = run.append(t)
+		if err != nil {
+			closeSpillFiles([]*spillFile{run})
+			return nil, err
+		}
+	}
+}
+
+// Return an iterator over the tuples of the sorted runs, in sorted order.
+func mergeRuns(runs []*spillFile, less func(a, b *Tuple) bool) func() (*Tuple, error) {
+	var h = &runHeap{less: less}
+	var started = false
+	return func() (*Tuple, error) {
+		if !started {
+			started = true
+			for _, run := range runs {
+				iter := run.iterator()
+				t, err := iter()
+				if err != nil {
+					return nil, err
+				}
+				if t != nil {
+					h.heads = append(h.heads, runHead{t, iter})
+				}
+			}
+			heap.Init(h)
+		}
+		if h.Len() == 0 {
+			return nil, nil
+		}
+		var head = &h.heads[0]
+		var res = head.t
+		t, err := head.iter()
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			heap.Pop(h)
+		} else {
+			head.t = t
+			heap.Fix(h, 0)
+		}
+		return res, nil
+	}
+}
+
+// The next tuple of a run being merged
+type runHead struct {
+	t    *Tuple
+	iter func() (*Tuple, error)
+}
+
+// A [heap.Interface] of the next tuples of the runs being merged
+type runHeap struct {
+	heads []runHead
+	less  func(a, b *Tuple) bool
+}
+
+func (h *runHeap) Len() int           { return len(h.heads) }
+func (h *runHeap) Less(i, j int) bool { return h.less(h.heads[i].t, h.heads[j].t) }
+func (h *runHeap) Swap(i, j int)      { h.heads[i], h.heads[j] = h.heads[j], h.heads[i] }
+func (h *runHeap) Push(x any)         { h.heads = append(h.heads, x.(runHead)) }
+func (h *runHeap) Pop() any {
+	var last = h.heads[len(h.heads)-1]
+	h.heads = h.heads[:len(h.heads)-1]
+	return last
+}
+
+// A [sort.Interface] that sorts tuples with a less function
+type tupleSorter struct {
+	tuples []*Tuple
+	less   func(a, b *Tuple) bool
+}
+
+func (s tupleSorter) Len() int           { return len(s.tuples) }
+func (s tupleSorter) Less(i, j int) bool { return s.less(s.tuples[i], s.tuples[j]) }
+func (s tupleSorter) Swap(i, j int)      { s.tuples[i], s.tuples[j] = s.tuples[j], s.tuples[i] }
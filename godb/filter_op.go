@@ -22,7 +22,7 @@ func stringFilterGetter(v DBValue) string {
 
 // Constructor for a filter operator on ints
 func NewIntFilter(constExpr Expr, op BoolOp, field Expr, child Operator) (*Filter[int64], error) {
-	if constExpr.GetExprType().Ftype != IntType || field.GetExprType().Ftype != IntType {
+	if !isNullConst(constExpr) && constExpr.GetExprType().Ftype != IntType || field.GetExprType().Ftype != IntType {
 		return nil, GoDBError{IncompatibleTypesError, "cannot apply int filter to non int-types"}
 	}
 	f, err := newFilter[int64](constExpr, op, field, child, intFilterGetter)
@@ -31,7 +31,7 @@ func NewIntFilter(constExpr Expr, op BoolOp, field Expr, child Operator) (*Filte
 
 // Constructor for a filter operator on strings
 func NewStringFilter(constExpr Expr, op BoolOp, field Expr, child Operator) (*Filter[string], error) {
-	if constExpr.GetExprType().Ftype != StringType || field.GetExprType().Ftype != StringType {
+	if !isNullConst(constExpr) && constExpr.GetExprType().Ftype != StringType || field.GetExprType().Ftype != StringType {
 		return nil, GoDBError{IncompatibleTypesError, "cannot apply string filter to non string-types"}
 	}
 	f, err := newFilter[string](constExpr, op, field, child, stringFilterGetter)
@@ -80,8 +80,8 @@ func (f *Filter[T]) Iterator(tid TransactionID) (func() (*Tuple, error), error)
 				return nil, err
 			}
 
-			// compare
-			if evalPred[T](f.getter(leftVal), f.getter(rightVal), f.op) {
+			// compare; NULLs make the predicate unknown, which fails the filter
+			if evalNullablePred(leftVal, rightVal, f.op, f.getter) == triTrue {
 				return tup, nil
 			}
 		}
@@ -103,3 +103,25 @@ func TestString2Filter(t *testing.T) {
 		t.Errorf("unexpected number of results %d", cnt)
 	}
 }
+
+func TestFilterNulls(t *testing.T) {
+	c, _ := makeIndexTestCatalog(t, 10)
+	runTestQuery(t, c, "insert into t values ('joe', null)")
+	var queries = map[string]int{
+		"select name from t where age = 3":          1,
+		"select name from t where age <> 3":         9, // NULL <> 3 is unknown
+		"select name from t where age = null":       0,
+		"select name from t where age is null":      1,
+		"select name from t where age is not null":  10,
+		"select name from t where name is not null": 11,
+	}
+	for q, cnt := range queries {
+		if res := runTestQuery(t, c, q); len(res) != cnt {
+			t.Errorf("expected %d results for %s, got %d", cnt, q, len(res))
+		}
+	}
+	res := runTestQuery(t, c, "select age + 1 from t where name = 'joe'")
+	if len(res) != 1 || !isNull(res[0].Fields[0]) {
+		t.Errorf("expected NULL + 1 to be NULL")
+	}
+}
@@ -0,0 +1,489 @@
+package godb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ForeignKey is a FOREIGN KEY constraint of a table:  the values of columns of
+// each of its tuples, unless one of them is NULL, are those of refColumns,
+// a [Key] of refTable, in some tuple of refTable.  Deleting a tuple of
+// refTable that is referenced fails, or if cascade is set, deletes the tuples
+// referencing it too.  The referencing tuples are found with the index of the
+// same name, on the first of columns.
+type ForeignKey struct {
+	name       string
+	table      string
+	columns    []string
+	refTable   string
+	refColumns []string
+	cascade    bool // ON DELETE CASCADE, rather than RESTRICT
+}
+
+// Foreign keys are stored in the catalog file as
+//
+//	foreign key name on table (field, ...) references table (field, ...) on delete cascade|restrict
+var foreignKeyEntryRegexp = regexp.MustCompile(`^foreign key (\w+) on (\w+) \(([^)]*)\) references (\w+) \(([^)]*)\) on delete (cascade|restrict)$`)
+
+// Return the foreign key described by m, a match of [foreignKeyEntryRegexp].
+func newForeignKey(m []string) *ForeignKey {
+	return &ForeignKey{m[1], m[2], splitColumns(m[3]), m[4], splitColumns(m[5]), m[6] == "cascade"}
+}
+
+// Return the names in the comma separated list s.
+func splitColumns(s string) []string {
+	var columns []string
+	for _, col := range strings.Split(s, ",") {
+		columns = append(columns, strings.TrimSpace(col))
+	}
+	return columns
+}
+
+// Return the catalog file entry of fk.
+func (fk *ForeignKey) String() string {
+	action := "restrict"
+	if fk.cascade {
+		action = "cascade"
+	}
+	return "foreign key " + fk.name + " on " + fk.table + " (" + strings.Join(fk.columns, ", ") + ") references " +
+		fk.refTable + " (" + strings.Join(fk.refColumns, ", ") + ") on delete " + action
+}
+
+// Return the tokens of query, if it is a CREATE TABLE statement, and the
+// ranges of the tokens of its column and constraint definitions:  the first
+// token of each, and the comma or paren after it.
+func tableDefinitions(query string) ([]sqlToken, [][2]int) {
+	tokens := scanTokens(query)
+	if len(tokens) < 4 || tokens[0].word(query) != "create" || tokens[1].word(query) != "table" || tokens[3].typ != '(' {
+		return nil, nil
+	}
+	end := matchingParen(tokens, 3, 1)
+	if end < 0 {
+		return nil, nil
+	}
+	var defs [][2]int
+	for start := 4; start < end; {
+		next := start
+		for next < end && tokens[next].typ != ',' {
+			if tokens[next].typ == '(' {
+				next = matchingParen(tokens, next, 1)
+			}
+			next++
+		}
+		if next > start {
+			defs = append(defs, [2]int{start, next})
+		}
+		start = next + 1
+	}
+	return tokens, defs
+}
+
+// sqlparser does not parse foreign keys, so remove them from query if it is a
+// CREATE TABLE statement, and return them.  They are declared either with
+// the column that references another table, or on their own, as
+//
+//	column type ... REFERENCES table [(column)] [ON DELETE action] [ON UPDATE action]
+//	[CONSTRAINT name] FOREIGN KEY [name] (column, ...) REFERENCES table [(column, ...)] [...]
+//
+// where action is RESTRICT, NO ACTION or CASCADE (ON UPDATE only RESTRICT or
+// NO ACTION).  The table of the keys is not set.
+func rewriteForeignKeys(query string) (string, []*ForeignKey, error) {
+	tokens, defs := tableDefinitions(query)
+	var (
+		foreignKeys []*ForeignKey
+		rewritten   strings.Builder
+		last        = 0
+	)
+	// remove the text from tokens[from] up to tokens[to]
+	remove := func(from int, to int) {
+		rewritten.WriteString(query[last:tokens[from].start])
+		last = tokens[to-1].end
+	}
+	for d, def := range defs {
+		start, next := def[0], def[1]
+		i, name := start, ""
+		if tokens[i].word(query) == "constraint" && i+2 < next {
+			name, i = tokens[i+1].word(query), i+2
+		}
+		if tokens[i].word(query) == "foreign" && i+1 < next && tokens[i+1].word(query) == "key" {
+			i += 2
+			if i < next && tokens[i].typ != '(' {
+				name, i = tokens[i].word(query), i+1
+			}
+			fk := &ForeignKey{name: name}
+			var err error
+			fk.columns, i, err = parseColumnList(query, tokens, i, next)
+			if err != nil {
+				return "", nil, err
+			}
+			i, err = parseReferences(query, tokens, i, next, fk)
+			if err != nil {
+				return "", nil, err
+			}
+			if i != next {
+				return "", nil, GoDBError{ParseError, fmt.Sprintf("unexpected %s in foreign key", query[tokens[i].start:tokens[next-1].end])}
+			}
+			foreignKeys = append(foreignKeys, fk)
+			// remove the definition, and the comma before or after it
+			if d > 0 {
+				remove(start-1, next)
+			} else if tokens[next].typ == ',' {
+				remove(start, next+1)
+			} else {
+				remove(start, next)
+			}
+			continue
+		}
+		for j := start + 1; j < next; j++ {
+			if tokens[j].word(query) != "references" {
+				continue
+			}
+			fk := &ForeignKey{name: name, columns: []string{tokens[start].word(query)}}
+			to, err := parseReferences(query, tokens, j, next, fk)
+			if err != nil {
+				return "", nil, err
+			}
+			foreignKeys = append(foreignKeys, fk)
+			remove(j, to)
+			break
+		}
+	}
+	rewritten.WriteString(query[last:])
+	return rewritten.String(), foreignKeys, nil
+}
+
+// Parse the list of columns (column, ...) at tokens[i], which ends before
+// tokens[end].  Returns the columns, and the position of the token after it.
+func parseColumnList(query string, tokens []sqlToken, i int, end int) ([]string, int, error) {
+	if i >= end || tokens[i].typ != '(' {
+		return nil, i, GoDBError{ParseError, "expected a list of columns in foreign key"}
+	}
+	close := matchingParen(tokens, i, 1)
+	if close < 0 || close >= end {
+		return nil, i, GoDBError{ParseError, "unterminated list of columns in foreign key"}
+	}
+	var columns []string
+	for _, t := range tokens[i+1 : close] {
+		if t.typ != ',' {
+			columns = append(columns, t.word(query))
+		}
+	}
+	return columns, close + 1, nil
+}
+
+// Parse the REFERENCES clause at tokens[i], which ends before tokens[end],
+// into fk.  Returns the position of the token after it.
+func parseReferences(query string, tokens []sqlToken, i int, end int, fk *ForeignKey) (int, error) {
+	if i+1 >= end || tokens[i].word(query) != "references" {
+		return i, GoDBError{ParseError, "expected REFERENCES table in foreign key"}
+	}
+	fk.refTable = tokens[i+1].word(query)
+	i += 2
+	if i < end && tokens[i].typ == '(' {
+		var err error
+		fk.refColumns, i, err = parseColumnList(query, tokens, i, end)
+		if err != nil {
+			return i, err
+		}
+	}
+	for i+2 < end && tokens[i].word(query) == "on" {
+		event, action := tokens[i+1].word(query), tokens[i+2].word(query)
+		i += 3
+		if action == "no" && i < end && tokens[i].word(query) == "action" {
+			action, i = "restrict", i+1
+		}
+		switch {
+		case action == "restrict":
+		case action == "cascade" && event == "delete":
+			fk.cascade = true
+		default:
+			return i, GoDBError{ParseError, fmt.Sprintf("unsupported foreign key action ON %s %s", strings.ToUpper(event), strings.ToUpper(action))}
+		}
+	}
+	return i, nil
+}
+
+// Check that fk, a foreign key of a new table with descriptor desc and keys,
+// is valid, and fill in its name and the columns it references if they are not
+// given.
+func (c *Catalog) checkForeignKey(fk *ForeignKey, desc *TupleDesc, keys []*Key) error {
+	refDesc := desc
+	if fk.refTable != fk.table {
+		ref := c.tableMap[fk.refTable]
+		if ref == nil {
+			return GoDBError{NoSuchTableError, fmt.Sprintf("no table '%s' found", fk.refTable)}
+		}
+		refDesc, keys = &ref.desc, ref.keys
+	}
+	if fk.name == "" {
+		fk.name = fk.table + "_" + strings.Join(fk.columns, "_") + "_fkey"
+	}
+	if fk.refColumns == nil {
+		for _, key := range keys {
+			if key.primary {
+				fk.refColumns = key.columns
+			}
+		}
+		if fk.refColumns == nil {
+			return GoDBError{ParseError, fmt.Sprintf("table '%s' has no primary key for foreign key %s to reference", fk.refTable, fk.name)}
+		}
+	}
+	if len(fk.columns) != len(fk.refColumns) {
+		return GoDBError{ParseError, fmt.Sprintf("foreign key %s has %d columns, but references %d", fk.name, len(fk.columns), len(fk.refColumns))}
+	}
+	if findKeyOn(keys, fk.refColumns) == nil {
+		return GoDBError{ParseError, fmt.Sprintf("columns (%s) of table '%s' are not a primary key or unique", strings.Join(fk.refColumns, ", "), fk.refTable)}
+	}
+	for i, col := range fk.columns {
+		field, err := findFieldInTd(FieldType{col, "", UnknownType}, desc)
+		if err != nil {
+			return err
+		}
+		refField, err := findFieldInTd(FieldType{fk.refColumns[i], "", UnknownType}, refDesc)
+		if err != nil {
+			return err
+		}
+		if desc.Fields[field].Ftype != refDesc.Fields[refField].Ftype {
+			return GoDBError{TypeMismatchError, fmt.Sprintf("column %s of foreign key %s has a different type than %s.%s", col, fk.name, fk.refTable, fk.refColumns[i])}
+		}
+	}
+	return nil
+}
+
+// Return the key of keys on columns, in the same order, or nil if there is
+// none.
+func findKeyOn(keys []*Key, columns []string) *Key {
+	for _, key := range keys {
+		if strings.Join(key.columns, ",") == strings.Join(columns, ",") {
+			return key
+		}
+	}
+	return nil
+}
+
+// Return the foreign key of t named, or nil if there is none.
+func (t *Table) findForeignKey(named string) *ForeignKey {
+	for _, fk := range t.foreignKeys {
+		if fk.name == named {
+			return fk
+		}
+	}
+	return nil
+}
+
+// Return the foreign keys of the tables of c that reference the table named.
+func (c *Catalog) referencing(named string) []*ForeignKey {
+	var fks []*ForeignKey
+	for _, t := range c.tables {
+		for _, fk := range t.foreignKeys {
+			if fk.refTable == named {
+				fks = append(fks, fk)
+			}
+		}
+	}
+	return fks
+}
+
+// Drop fk, and its index.
+func (c *Catalog) dropForeignKey(fk *ForeignKey) error {
+	t := c.tableMap[fk.table]
+	for i, other := range t.foreignKeys {
+		if other == fk {
+			t.foreignKeys = append(t.foreignKeys[:i:i], t.foreignKeys[i+1:]...)
+		}
+	}
+	return c.dropIndex(fk.name)
+}
+
+// A foreign key of a heap file, or of another heap file that references it,
+// as it is checked:  fields are the numbers of the fields of the key in this
+// file, and otherFields those in the other file, which open opens along with
+// its index on the first of otherFields.
+type heapForeignKey struct {
+	name        string
+	fields      []int
+	otherFields []int
+	cascade     bool
+	open        func() (*HeapFile, *BTreeFile, error)
+}
+
+// Return a function that opens table, and its index named index, the first
+// time it is called.
+func (c *Catalog) openOnce(table string, index string) func() (*HeapFile, *BTreeFile, error) {
+	var (
+		hf *HeapFile
+		bt *BTreeFile
+	)
+	return func() (*HeapFile, *BTreeFile, error) {
+		if hf != nil {
+			return hf, bt, nil
+		}
+		file, err := c.GetTable(table)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, idx := range file.(*HeapFile).indexes {
+			if idx.file.Name() == c.indexNameToFile(index) {
+				hf, bt = file.(*HeapFile), idx
+			}
+		}
+		if hf == nil {
+			return nil, nil, GoDBError{NoSuchTableError, fmt.Sprintf("no index '%s' found", index)}
+		}
+		return hf, bt, nil
+	}
+}
+
+// Return a foreign key of a file with descriptor desc, whose other file is the
+// table with descriptor otherDesc.
+func (c *Catalog) newHeapForeignKey(fk *ForeignKey, columns []string, desc *TupleDesc, otherColumns []string, otherDesc *TupleDesc, open func() (*HeapFile, *BTreeFile, error)) (*heapForeignKey, error) {
+	hfk := &heapForeignKey{name: fk.name, cascade: fk.cascade, open: open}
+	for i := range columns {
+		field, err := findFieldInTd(FieldType{columns[i], "", UnknownType}, desc)
+		if err != nil {
+			return nil, err
+		}
+		otherField, err := findFieldInTd(FieldType{otherColumns[i], "", UnknownType}, otherDesc)
+		if err != nil {
+			return nil, err
+		}
+		hfk.fields = append(hfk.fields, field)
+		hfk.otherFields = append(hfk.otherFields, otherField)
+	}
+	return hfk, nil
+}
+
+// Add the foreign keys of t, and of the tables referencing it, to hf, the
+// file of t.
+func (c *Catalog) openForeignKeys(t *Table, hf *HeapFile) error {
+	for _, fk := range t.foreignKeys {
+		ref := c.tableMap[fk.refTable]
+		key := findKeyOn(ref.keys, fk.refColumns)
+		if key == nil {
+			return GoDBError{NoSuchTableError, fmt.Sprintf("no key of '%s' on (%s) found", fk.refTable, strings.Join(fk.refColumns, ", "))}
+		}
+		hfk, err := c.newHeapForeignKey(fk, fk.columns, hf.Descriptor(), fk.refColumns, &ref.desc, c.openOnce(ref.name, key.name))
+		if err != nil {
+			return err
+		}
+		hf.references = append(hf.references, hfk)
+	}
+	for _, fk := range c.referencing(t.name) {
+		other := c.tableMap[fk.table]
+		hfk, err := c.newHeapForeignKey(fk, fk.refColumns, hf.Descriptor(), fk.columns, &other.desc, c.openOnce(other.name, fk.name))
+		if err != nil {
+			return err
+		}
+		hf.referencedBy = append(hf.referencedBy, hfk)
+	}
+	return nil
+}
+
+// Return the tuples of the other file of fk whose other fields are equal to
+// the fields of t.
+func (fk *heapForeignKey) matching(t *Tuple, tid TransactionID) ([]*Tuple, error) {
+	_, index, err := fk.open()
+	if err != nil {
+		return nil, err
+	}
+	iter, err := index.RangeIterator(tid, OpEq, t.Fields[fk.fields[0]])
+	if err != nil {
+		return nil, err
+	}
+	var res []*Tuple
+	for {
+		other, err := iter()
+		if err != nil {
+			return nil, err
+		}
+		if other == nil {
+			return res, nil
+		}
+		same := true
+		for i, field := range fk.fields[1:] {
+			same = same && compareDBValue(t.Fields[field], other.Fields[fk.otherFields[i+1]]) == 0
+		}
+		if same {
+			res = append(res, other)
+		}
+	}
+}
+
+// Return the values of the fields of fk in t, and whether any is NULL, or
+// (if old is not nil) they differ from those in old.
+func (fk *heapForeignKey) values(t *Tuple, old *Tuple) (string, bool, bool) {
+	var values []string
+	null, changed := false, old == nil
+	for _, field := range fk.fields {
+		values = append(values, fmt.Sprintf("%v", t.Fields[field]))
+		null = null || isNull(t.Fields[field])
+		changed = changed || compareDBValue(t.Fields[field], old.Fields[field]) != 0
+	}
+	return strings.Join(values, ", "), null, changed
+}
+
+// Return an error if t, which replaces old, or is new if old is nil, references
+// a tuple that does not exist, or if old is referenced and t changes its key.
+func (f *HeapFile) checkForeignKeys(t *Tuple, old *Tuple, tid TransactionID) error {
+	for _, fk := range f.references {
+		values, null, changed := fk.values(t, old)
+		if null || !changed {
+			continue
+		}
+		refs, err := fk.matching(t, tid)
+		if err != nil {
+			return err
+		}
+		if len(refs) == 0 {
+			return GoDBError{ForeignKeyError, fmt.Sprintf("key (%s) of foreign key %s is not present in the referenced table", values, fk.name)}
+		}
+	}
+	if old == nil {
+		return nil
+	}
+	for _, fk := range f.referencedBy {
+		values, null, _ := fk.values(old, nil)
+		if _, _, changed := fk.values(t, old); null || !changed {
+			continue
+		}
+		refs, err := fk.matching(old, tid)
+		if err != nil {
+			return err
+		}
+		if len(refs) > 0 {
+			return GoDBError{ForeignKeyError, fmt.Sprintf("key (%s) is still referenced by foreign key %s", values, fk.name)}
+		}
+	}
+	return nil
+}
+
+// Apply the ON DELETE actions of the foreign keys that reference old, which
+// was deleted:  delete the tuples that reference it if the key cascades, or
+// else return an error if there are any.
+func (f *HeapFile) deleteReferences(old *Tuple, tid TransactionID) error {
+	for _, fk := range f.referencedBy {
+		values, null, _ := fk.values(old, nil)
+		if null {
+			continue
+		}
+		refs, err := fk.matching(old, tid)
+		if err != nil {
+			return err
+		}
+		if len(refs) > 0 && !fk.cascade {
+			return GoDBError{ForeignKeyError, fmt.Sprintf("key (%s) is still referenced by foreign key %s", values, fk.name)}
+		}
+		other, _, err := fk.open()
+		if err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			err = other.deleteTuple(ref, tid)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
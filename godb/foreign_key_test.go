@@ -0,0 +1,116 @@
+package godb
+
+import (
+	"testing"
+)
+
+func TestForeignKeys(t *testing.T) {
+	c := makeOuterJoinTestCatalog(t)
+	for _, q := range []string{
+		"create table cust (id int primary key, name varchar(20))",
+		"create table orders (id int primary key, cust int references cust (id) on delete cascade)",
+		"create table items (id int, ord int, constraint items_ord foreign key (ord) references orders on delete restrict on update no action)",
+		"create table emp (id int primary key, boss int, foreign key (boss) references emp (id) on delete cascade)",
+	} {
+		if _, _, err := Parse(c, q); err != nil {
+			t.Fatalf("%s: %s", q, err.Error())
+		}
+	}
+	run := func(c *Catalog, queries ...string) {
+		for _, q := range queries {
+			if err := runUpdateQuery(c, q); err != nil {
+				t.Errorf("%s: %s", q, err.Error())
+			}
+		}
+	}
+	expectError := func(c *Catalog, query string) {
+		err := runUpdateQuery(c, query)
+		if err == nil || err.(GoDBError).code != ForeignKeyError {
+			t.Errorf("%s: expected a foreign key error, got %v", query, err)
+		}
+	}
+	expect := func(c *Catalog, query string, expected []int64) {
+		res := firstColumn(t, c, query)
+		if len(res) != len(expected) {
+			t.Errorf("%s: expected %v, got %v", query, expected, res)
+			return
+		}
+		for i := range res {
+			if res[i] != expected[i] {
+				t.Errorf("%s: expected %v, got %v", query, expected, res)
+				return
+			}
+		}
+	}
+	run(c,
+		"insert into cust values (1, 'sam')",
+		"insert into cust values (2, 'kim')",
+		"insert into cust values (3, 'alex')",
+		"insert into orders values (10, 1)",
+		"insert into orders values (11, 1)",
+		"insert into orders values (20, 2)",
+		"insert into orders values (30, null)",
+		"insert into items values (1, 20)",
+	)
+	expectError(c, "insert into orders values (40, 9)")
+	expectError(c, "insert into items values (2, 12)")
+	expectError(c, "update orders set cust = 9 where id = 30")
+	expectError(c, "update cust set id = 5 where id = 2")
+	expectError(c, "delete from cust where id = 2")
+	run(c, "update cust set id = 4 where id = 3", "update orders set cust = 4 where id = 30")
+
+	// deleting a customer deletes its orders, in the same transaction
+	run(c, "delete from cust where id = 1")
+	expect(c, "select id from orders", []int64{20, 30})
+	expect(c, "select id from cust", []int64{2, 4})
+
+	for _, q := range []string{
+		"create table bad (x int references nosuch (id))",
+		"create table bad (x int references cust (name))",
+		"create table bad (x varchar(20) references cust (id))",
+		"create table bad (x int references cust (id) on delete set null)",
+		"create table bad (x int, foreign key (x) references items)",
+		"drop index orders_cust_fkey",
+		"drop table cust",
+		"alter table cust drop column id",
+	} {
+		if _, _, err := Parse(c, q); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+
+	// foreign keys are stored in the catalog file, and changed with their tables
+	err := c.SaveToFile("catalog.txt", c.rootPath)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	c, err = NewCatalogFromFile("catalog.txt", NewBufferPool(50), c.rootPath)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, q := range []string{"alter table cust rename to customers", "alter table orders rename column cust to customer"} {
+		if _, _, err := Parse(c, q); err != nil {
+			t.Fatalf("%s: %s", q, err.Error())
+		}
+	}
+	expectError(c, "insert into orders values (40, 9)")
+	expectError(c, "delete from customers where id = 2")
+	run(c, "delete from items where id = 1", "delete from customers where id = 2")
+	expect(c, "select id from orders", []int64{30})
+
+	// tuples that reference tuples of the same table
+	run(c,
+		"insert into emp values (1, null)",
+		"insert into emp values (2, 1)",
+		"insert into emp values (3, 2)",
+		"insert into emp values (4, null)",
+	)
+	expectError(c, "insert into emp values (5, 6)")
+	run(c, "delete from emp where id = 2")
+	expect(c, "select id from emp", []int64{1, 4})
+
+	if _, _, err := Parse(c, "drop table customers cascade"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	run(c, "insert into orders values (40, 9)")
+}
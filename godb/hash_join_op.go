@@ -0,0 +1,621 @@
+package godb
+
+import (
+	"bytes"
+	"hash/fnv"
+	"os"
+	"sync"
+)
+
+// HashJoin is an equality join that builds a hash table on the join values of
+// its smaller input and probes it with the tuples of the other input.  It
+// buffers at most maxBufferSize tuples;  if both inputs are larger than that,
+// both are partitioned on the hash of their join values into temporary heap
+// files, and the partitions are joined pairwise.
+//
+// Outer joins also return the unmatched tuples of the inputs they preserve,
+// padded with NULLs, and semi and anti joins return the tuples of the left
+// input that match or do not match.
+type HashJoin struct {
+	// Expressions that when applied to tuples from the left or right operators,
+	// respectively, return the value of the left or right side of the join
+	leftField, rightField Expr
+
+	left, right Operator
+
+	joinType JoinType
+
+	// Condition that joined tuples must also satisfy to match, or nil;  the
+	// rest of the ON clause of an outer join
+	cond *PredExpr
+
+	// Tuple that unmatched tuples of the left input are joined with instead
+	// of NULLs, or nil
+	rightPad *Tuple
+
+	// The maximum number of tuples the join buffers in memory
+	maxBufferSize int
+}
+
+// Number of partitions each input is split into when the join spills
+const hashJoinPartitions int = 32
+
+// Constructor for a hash join of int or string expressions.  Returns an error
+// if the left and right expressions have different types.
+func NewHashJoin(left Operator, leftField Expr, right Operator, rightField Expr, maxBufferSize int) (*HashJoin, error) {
+	return NewOuterHashJoin(left, leftField, right, rightField, InnerJoin, nil, maxBufferSize)
+}
+
+// Constructor for a hash join of type joinType.  Joined tuples match only if
+// cond, which may be nil, is true for them.
+func NewOuterHashJoin(left Operator, leftField Expr, right Operator, rightField Expr, joinType JoinType, cond *PredExpr, maxBufferSize int) (*HashJoin, error) {
+	if leftField.GetExprType().Ftype != rightField.GetExprType().Ftype {
+		return nil, GoDBError{TypeMismatchError, "can't join fields of different types"}
+	}
+	switch leftField.GetExprType().Ftype {
+	case IntType, StringType:
+	default:
+		return nil, GoDBError{TypeMismatchError, "unknown type"}
+	}
+	if maxBufferSize < 1 {
+		return nil, GoDBError{IllegalOperationError, "join buffer must hold at least one tuple"}
+	}
+	return &HashJoin{leftField, rightField, left, right, joinType, cond, nil, maxBufferSize}, nil
+}
+
+// Return a TupleDescriptor for this join, the fields of the left input
+// followed by those of the right input, or only those of the left input for
+// semi and anti joins.
+func (hj *HashJoin) Descriptor() *TupleDesc {
+	if hj.joinType.filtersLeft() {
+		return hj.left.Descriptor().copy()
+	}
+	return hj.left.Descriptor().merge(hj.right.Descriptor())
+}
+
+// One input of the join while it is being read
+type hashJoinInput struct {
+	iter     func() (*Tuple, error)
+	field    Expr
+	buffered []*Tuple
+	isLeft   bool
+}
+
+// Join operator implementation.  The inputs are read in turn until one of
+// them ends, which makes it the build input, or maxBufferSize tuples are
+// buffered, in which case the join spills.  NULLs never match.
+func (hj *HashJoin) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	leftIter, err := hj.left.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	rightIter, err := hj.right.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	var inputs = [2]*hashJoinInput{
+		{iter: leftIter, field: hj.leftField, isLeft: true},
+		{iter: rightIter, field: hj.rightField},
+	}
+	for n := 0; n < hj.maxBufferSize; n++ {
+		in := inputs[n%2]
+		t, err := in.iter()
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			build, probe := in, inputs[1-n%2]
+			table, err := hj.buildTable(build.buffered, build.field, hj.tracks(build.isLeft))
+			if err != nil {
+				return nil, err
+			}
+			return hj.probe(table, build.isLeft, probe.field, concatIters(sliceIter(probe.buffered), probe.iter), hj.returnsUnmatched(probe.isLeft), nil), nil
+		}
+		in.buffered = append(in.buffered, t)
+	}
+
+	var parts [2][]*spillFile
+	for i, in := range inputs {
+		parts[i], err = hj.partition(tid, concatIters(sliceIter(in.buffered), in.iter), in.field, hj.returnsUnmatched(in.isLeft))
+		in.buffered = nil
+		if err != nil {
+			closeSpillFiles(parts[0])
+			closeSpillFiles(parts[1])
+			return nil, err
+		}
+	}
+	return hj.joinPartitions(parts[0], parts[1]), nil
+}
+
+// Return whether the join returns the unmatched tuples of its left input, if
+// isLeft is set, or of its right input otherwise.
+func (hj *HashJoin) returnsUnmatched(isLeft bool) bool {
+	left, right := hj.joinType.preserves()
+	if isLeft {
+		return left || hj.joinType == AntiJoin
+	}
+	return right
+}
+
+// Return whether the join needs to know which tuples of its left input, if
+// isLeft is set, or of its right input otherwise, matched.
+func (hj *HashJoin) tracks(isLeft bool) bool {
+	return hj.returnsUnmatched(isLeft) || isLeft && hj.joinType == SemiJoin
+}
+
+// Return the tuple returned for an unmatched tuple t of the left input, if
+// isLeft is set, or of the right input otherwise.
+func (hj *HashJoin) unmatched(t *Tuple, isLeft bool) *Tuple {
+	if hj.joinType.filtersLeft() {
+		return t
+	}
+	if isLeft {
+		return hj.joinPair(nil, t, false)
+	}
+	return hj.joinPair(nil, t, true)
+}
+
+// The build tuples of a join, indexed by their join values
+type hashTable struct {
+	tuples []*Tuple
+	index  map[DBValue][]int // positions in tuples by join value
+	// Which tuples matched, if the unmatched ones are returned, or nil
+	matched []bool
+}
+
+// Return a hash table from the join values of tuples to the tuples.  Tuples
+// whose join value is NULL are left out of the index.  If track is set, the
+// table records which tuples matched.
+func (hj *HashJoin) buildTable(tuples []*Tuple, field Expr, track bool) (*hashTable, error) {
+	var table = &hashTable{tuples: tuples, index: make(map[DBValue][]int)}
+	for i, t := range tuples {
+		v, err := field.EvalExpr(t)
+		if err != nil {
+			return nil, err
+		}
+		if isNull(v) {
+			continue
+		}
+		table.index[v] = append(table.index[v], i)
+	}
+	if track {
+		table.matched = make([]bool, len(tuples))
+	}
+	return table, nil
+}
+
+// Return the tuple joining a build and a probe tuple, either of which may be
+// nil for an unmatched tuple of the other input.
+func (hj *HashJoin) joinPair(build *Tuple, probe *Tuple, buildIsLeft bool) *Tuple {
+	left, right := probe, build
+	if buildIsLeft {
+		left, right = build, probe
+	}
+	if left == nil {
+		left = nullTuple(hj.left.Descriptor())
+	}
+	if right == nil {
+		right = hj.rightPad
+		if right == nil {
+			right = nullTuple(hj.right.Descriptor())
+		}
+	}
+	return joinTuples(left, right)
+}
+
+// Return the tuple joining build and probe, and whether it satisfies the
+// condition of the join.
+func (hj *HashJoin) match(build *Tuple, probe *Tuple, buildIsLeft bool) (*Tuple, bool, error) {
+	var t = hj.joinPair(build, probe, buildIsLeft)
+	if hj.cond == nil {
+		return t, true, nil
+	}
+	v, err := hj.cond.eval(t)
+	return t, v == triTrue, err
+}
+
+// Return an iterator over the join of the tuples of table with those returned
+// by probeIter.  buildIsLeft is true if the tuples of table are from the left
+// input.  Unmatched probe tuples are returned if returnProbes is set, and
+// unmatched build tuples, or matched ones for semi joins, after the probe
+// tuples if table tracks them.  If probeMatched is not nil, the probe tuples
+// that matched are recorded in it by their position in probeIter.
+func (hj *HashJoin) probe(table *hashTable, buildIsLeft bool, field Expr, probeIter func() (*Tuple, error), returnProbes bool, probeMatched []bool) func() (*Tuple, error) {
+	var (
+		candidates []int
+		probeTuple *Tuple
+		probeNo    = -1
+		found      bool
+		probeDone  = false
+		next       = 0 // position of the next build tuple to return if unmatched
+	)
+	return func() (*Tuple, error) {
+		for !probeDone {
+			for len(candidates) > 0 {
+				pos := candidates[0]
+				candidates = candidates[1:]
+				if hj.joinType.filtersLeft() && buildIsLeft && table.matched[pos] {
+					continue
+				}
+				t, ok, err := hj.match(table.tuples[pos], probeTuple, buildIsLeft)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					continue
+				}
+				found = true
+				if table.matched != nil {
+					table.matched[pos] = true
+				}
+				var seen = false
+				if probeMatched != nil {
+					seen = probeMatched[probeNo]
+					probeMatched[probeNo] = true
+				}
+				if !hj.joinType.filtersLeft() {
+					return t, nil
+				}
+				if !buildIsLeft {
+					// the probe tuple is from the left input, and one match is enough
+					candidates = nil
+					if hj.joinType == SemiJoin && !seen {
+						return probeTuple, nil
+					}
+				}
+			}
+			if probeTuple != nil {
+				t := probeTuple
+				probeTuple = nil
+				if returnProbes && !found {
+					return hj.unmatched(t, !buildIsLeft), nil
+				}
+			}
+			t, err := probeIter()
+			if err != nil {
+				return nil, err
+			}
+			if t == nil {
+				probeDone = true
+				break
+			}
+			v, err := field.EvalExpr(t)
+			if err != nil {
+				return nil, err
+			}
+			probeTuple, found = t, false
+			probeNo++
+			if !isNull(v) {
+				candidates = table.index[v]
+			}
+		}
+		for table.matched != nil && next < len(table.tuples) {
+			next++
+			if table.matched[next-1] == (hj.joinType == SemiJoin) {
+				if hj.joinType == SemiJoin {
+					return table.tuples[next-1], nil
+				}
+				return hj.unmatched(table.tuples[next-1], buildIsLeft), nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// Return an iterator over the tuples returned by probeIter that did not
+// match, padded with NULLs.
+func (hj *HashJoin) unmatchedProbes(probeIter func() (*Tuple, error), matched []bool, buildIsLeft bool) func() (*Tuple, error) {
+	var probeNo = 0
+	return func() (*Tuple, error) {
+		for {
+			t, err := probeIter()
+			if err != nil || t == nil {
+				return nil, err
+			}
+			probeNo++
+			if !matched[probeNo-1] {
+				return hj.unmatched(t, !buildIsLeft), nil
+			}
+		}
+	}
+}
+
+// Write the tuples returned by iter into hashJoinPartitions spill files,
+// chosen by the hash of their join value.  Tuples whose join value is NULL
+// never match, so they are dropped unless keepNulls is set, in which case
+// they are written to the first partition.
+func (hj *HashJoin) partition(tid TransactionID, iter func() (*Tuple, error), field Expr, keepNulls bool) ([]*spillFile, error) {
+	var parts []*spillFile
+	for i := 0; i < hashJoinPartitions; i++ {
+		sf, err := newSpillFile(tid, field)
+		if err != nil {
+			return parts, err
+		}
+		parts = append(parts, sf)
+	}
+	for {
+		t, err := iter()
+		if err != nil {
+			return parts, err
+		}
+		if t == nil {
+			break
+		}
+		v, err := field.EvalExpr(t)
+		if err != nil {
+			return parts, err
+		}
+		var part = 0
+		if isNull(v) {
+			if !keepNulls {
+				continue
+			}
+		} else {
+			part = int(hashDBValue(v) % uint32(hashJoinPartitions))
+		}
+		err = parts[part].append(t)
+		if err != nil {
+			return parts, err
+		}
+	}
+	for _, sf := range parts {
+		err := sf.finish()
+		if err != nil {
+			return parts, err
+		}
+	}
+	return parts, nil
+}
+
+// Return an iterator over the join of each pair of partitions.  The smaller
+// partition of each pair is loaded into hash tables of at most maxBufferSize
+// tuples, and the other one is scanned once per table.  If the join preserves
+// the input of the scanned partition, it is scanned once more for the tuples
+// that matched none of the tables.  The spill files are closed when the
+// iterator is exhausted or fails, or else when the transaction ends.
+func (hj *HashJoin) joinPartitions(leftParts, rightParts []*spillFile) func() (*Tuple, error) {
+	var (
+		p            = 0
+		build        *spillFile
+		buildIter    func() (*Tuple, error)
+		buildIsLeft  bool
+		probe        *spillFile
+		probeMatched []bool
+		result       func() (*Tuple, error)
+		done         = false
+	)
+	var cleanup = func() {
+		done = true
+		closeSpillFiles(leftParts)
+		closeSpillFiles(rightParts)
+	}
+	return func() (*Tuple, error) {
+		for !done {
+			if result != nil {
+				t, err := result()
+				if err != nil {
+					cleanup()
+					return nil, err
+				}
+				if t != nil {
+					return t, nil
+				}
+				result = nil
+			}
+			if buildIter == nil {
+				if probeMatched != nil && hj.returnsUnmatched(!buildIsLeft) {
+					result = hj.unmatchedProbes(probe.iterator(), probeMatched, buildIsLeft)
+					probeMatched = nil
+					continue
+				}
+				if p >= len(leftParts) {
+					cleanup()
+					return nil, nil
+				}
+				build, probe, buildIsLeft = leftParts[p], rightParts[p], true
+				if probe.count < build.count {
+					build, probe, buildIsLeft = probe, build, false
+				}
+				probeMatched = nil
+				if hj.tracks(!buildIsLeft) {
+					probeMatched = make([]bool, probe.count)
+				}
+				buildIter = build.iterator()
+				p++
+			}
+			var chunk []*Tuple
+			for len(chunk) < hj.maxBufferSize {
+				t, err := buildIter()
+				if err != nil {
+					cleanup()
+					return nil, err
+				}
+				if t == nil {
+					buildIter = nil
+					break
+				}
+				chunk = append(chunk, t)
+			}
+			if len(chunk) == 0 {
+				continue
+			}
+			table, err := hj.buildTable(chunk, build.field, hj.tracks(buildIsLeft))
+			if err != nil {
+				cleanup()
+				return nil, err
+			}
+			result = hj.probe(table, buildIsLeft, probe.field, probe.iterator(), false, probeMatched)
+		}
+		return nil, nil
+	}
+}
+
+// A temporary heap file that holds spilled tuples.  Its pages are written and
+// read directly rather than through the buffer pool, so spilling is not
+// limited by the size of the buffer pool and spilled pages are never locked or
+// logged.  The file is removed as soon as it is created, so it disappears when
+// it is closed or GoDB exits.
+type spillFile struct {
+	file  *HeapFile
+	tid   TransactionID
+	field Expr
+	page  *heapPage
+	count int
+}
+
+// The spill files of each transaction that are still open.  An iterator that
+// is abandoned before its end, by a LIMIT or a rescan, never closes its spill
+// files, so they are closed when the transaction ends instead.
+var (
+	openSpillFilesMu sync.Mutex
+	openSpillFiles   = make(map[TransactionID]map[*spillFile]bool)
+)
+
+func newSpillFile(tid TransactionID, field Expr) (*spillFile, error) {
+	f, err := os.CreateTemp("", "godb-spill-*.dat")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+	var hf = &HeapFile{file: f}
+	var sf = &spillFile{file: hf, tid: tid, field: field}
+	openSpillFilesMu.Lock()
+	defer openSpillFilesMu.Unlock()
+	if openSpillFiles[tid] == nil {
+		openSpillFiles[tid] = make(map[*spillFile]bool)
+	}
+	openSpillFiles[tid][sf] = true
+	return sf, nil
+}
+
+// Add t to the spill file, writing out the current page if t does not fit.
+func (sf *spillFile) append(t *Tuple) error {
+	if sf.page == nil {
+		sf.file.desc = t.Desc.copy()
+		sf.page = emptyHeapPage(sf.file.desc, 0, sf.file)
+	}
+	if !sf.page.hasRoomFor(t) {
+		err := sf.writePage()
+		if err != nil {
+			return err
+		}
+		sf.page = emptyHeapPage(sf.file.desc, sf.page.pageId+1, sf.file)
+	}
+	_, err := sf.page.insertTuple(t)
+	if err != nil {
+		return err
+	}
+	sf.count++
+	return nil
+}
+
+func (sf *spillFile) writePage() error {
+	buf, err := sf.page.toBuffer()
+	if err != nil {
+		return err
+	}
+	_, err = sf.file.file.WriteAt(buf.Bytes(), int64(sf.page.pageId*PageSize))
+	return err
+}
+
+// Write out the last page;  called after the last tuple is appended.
+func (sf *spillFile) finish() error {
+	if sf.page == nil || sf.page.numUsedSlots == 0 {
+		return nil
+	}
+	return sf.writePage()
+}
+
+// Return an iterator over the tuples of the spill file.
+func (sf *spillFile) iterator() func() (*Tuple, error) {
+	var pageNo = 0
+	var tupleIter = func() (*Tuple, error) { return nil, nil }
+	return func() (*Tuple, error) {
+		for {
+			t, err := tupleIter()
+			if err != nil || t != nil {
+				return t, err
+			}
+			if sf.page == nil || pageNo >= sf.file.NumPages() {
+				return nil, nil
+			}
+			var data = make([]byte, PageSize)
+			_, err = sf.file.file.ReadAt(data, int64(pageNo*PageSize))
+			if err != nil {
+				return nil, err
+			}
+			var hp = emptyHeapPage(sf.file.desc, pageNo, sf.file)
+			err = hp.initFromBuffer(bytes.NewBuffer(data))
+			if err != nil {
+				return nil, err
+			}
+			tupleIter = hp.tupleIter()
+			pageNo++
+		}
+	}
+}
+
+func closeSpillFiles(files []*spillFile) {
+	openSpillFilesMu.Lock()
+	defer openSpillFilesMu.Unlock()
+	for _, sf := range files {
+		sf.file.file.Close()
+		delete(openSpillFiles[sf.tid], sf)
+		if len(openSpillFiles[sf.tid]) == 0 {
+			delete(openSpillFiles, sf.tid)
+		}
+	}
+}
+
+// Close the spill files tid left open;  called when tid commits or aborts.
+func closeTransactionSpillFiles(tid TransactionID) {
+	openSpillFilesMu.Lock()
+	defer openSpillFilesMu.Unlock()
+	for sf := range openSpillFiles[tid] {
+		sf.file.file.Close()
+	}
+	delete(openSpillFiles, tid)
+}
+
+// Return a hash of an int or string value.
+func hashDBValue(v DBValue) uint32 {
+	var h = fnv.New32a()
+	switch v := v.(type) {
+	case IntField:
+		var b [8]byte
+		for i := range b {
+			b[i] = byte(v.Value >> (8 * i))
+		}
+		h.Write(b[:])
+	case StringField:
+		h.Write([]byte(v.Value))
+	}
+	return h.Sum32()
+}
+
+// Return an iterator over the tuples of a slice.
+func sliceIter(tuples []*Tuple) func() (*Tuple, error) {
+	var i = 0
+	return func() (*Tuple, error) {
+		if i >= len(tuples) {
+			return nil, nil
+		}
+		i++
+		return tuples[i-1], nil
+	}
+}
+
+// Return an iterator over the tuples of first followed by those of second.
+func concatIters(first, second func() (*Tuple, error)) func() (*Tuple, error) {
+	var firstDone = false
+	return func() (*Tuple, error) {
+		if !firstDone {
+			t, err := first()
+			if err != nil || t != nil {
+				return t, err
+			}
+			firstDone = true
+		}
+		return second()
+	}
+}
@@ -0,0 +1,218 @@
+package godb
+
+import (
+	"os"
+	"testing"
+)
+
+const HashJoinTestFile string = "HashJoinTestFile.dat"
+
+func TestHashJoin(t *testing.T) {
+	td, t1, t2, hf, bp, tid := makeTestVars()
+	hf.insertTuple(&t1, tid)
+	hf.insertTuple(&t2, tid)
+	hf.insertTuple(&t2, tid)
+
+	os.Remove(HashJoinTestFile)
+	hf2, _ := NewHeapFile(HashJoinTestFile, &td, bp)
+	hf2.insertTuple(&t1, tid)
+	hf2.insertTuple(&t2, tid)
+	hf2.insertTuple(&t2, tid)
+	hf2.insertTuple(&Tuple{td, []DBValue{StringField{"nobody"}, NullField{}}, nil}, tid)
+
+	outT1 := joinTuples(&t1, &t1)
+	outT2 := joinTuples(&t2, &t2)
+
+	leftField := FieldExpr{td.Fields[1]}
+	// a buffer of 1 tuple makes the join spill
+	for _, bufSize := range []int{100, 1} {
+		join, err := NewHashJoin(hf, &leftField, hf2, &leftField, bufSize)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		iter, err := join.Iterator(tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		cnt, cntOut1, cntOut2 := 0, 0, 0
+		for {
+			tup, err := iter()
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if tup == nil {
+				break
+			}
+			if tup.equals(outT1) {
+				cntOut1++
+			} else if tup.equals(outT2) {
+				cntOut2++
+			}
+			cnt++
+		}
+		if cnt != 5 || cntOut1 != 1 || cntOut2 != 4 {
+			t.Errorf("buffer size %d: unexpected join results (%d, %d t1, %d t2; expected 5, 1, 4)", bufSize, cnt, cntOut1, cntOut2)
+		}
+	}
+
+	_, err := NewHashJoin(hf, &leftField, hf2, &FieldExpr{td.Fields[0]}, 100)
+	if err == nil {
+		t.Errorf("expected error joining fields of different types")
+	}
+
+	// the spill files of an iterator that is abandoned are closed at commit
+	join, err := NewHashJoin(hf, &leftField, hf2, &leftField, 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	iter, err := join.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if tup, err := iter(); tup == nil || err != nil {
+		t.Fatalf("expected a result, got %v", err)
+	}
+	if len(openSpillFiles[tid]) != 2*hashJoinPartitions {
+		t.Errorf("expected %d open spill files, got %d", 2*hashJoinPartitions, len(openSpillFiles[tid]))
+	}
+	bp.CommitTransaction(tid)
+	if len(openSpillFiles[tid]) != 0 {
+		t.Errorf("expected the spill files to be closed at commit, got %d open", len(openSpillFiles[tid]))
+	}
+}
+
+func TestHashJoinQuery(t *testing.T) {
+	c, _ := makeIndexTestCatalog(t, 20)
+	_, plan, err := Parse(c, "select t1.name, t2.name from t t1, t t2 where t1.age = t2.age")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := planInput(plan).(*HashJoin); !ok {
+		t.Errorf("expected a hash join")
+	}
+	if res := runTestQuery(t, c, "select t1.name, t2.name from t t1, t t2 where t1.age = t2.age"); len(res) != 40 {
+		t.Errorf("expected 40 results, got %d", len(res))
+	}
+}
+
+func makeOuterJoinTestCatalog(t *testing.T) *Catalog {
+	dir := t.TempDir()
+	err := os.WriteFile(dir+"/catalog.txt", []byte("a (x int, y int)\nb (x int, y int)\n"), 0644)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	c, err := NewCatalogFromFile("catalog.txt", NewBufferPool(50), dir)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, v := range []string{"1", "2", "2", "3", "null"} {
+		runTestQuery(t, c, "insert into a values ("+v+", 1)")
+	}
+	for _, v := range []string{"2", "3", "3", "4", "null"} {
+		runTestQuery(t, c, "insert into b values ("+v+", 2)")
+	}
+	return c
+}
+
+func TestOuterHashJoin(t *testing.T) {
+	c := makeOuterJoinTestCatalog(t)
+	a, _ := c.GetTable("a")
+	b, _ := c.GetTable("b")
+	a.Descriptor().setTableAlias("a")
+	b.Descriptor().setTableAlias("b")
+	aField := &FieldExpr{a.Descriptor().Fields[0]}
+	bField := &FieldExpr{b.Descriptor().Fields[0]}
+	var tests = []struct {
+		joinType JoinType
+		cond     *PredExpr
+		// numbers of results, and of those padded on the left and on the right
+		rows, leftPadded, rightPadded int
+	}{
+		{InnerJoin, nil, 4, 0, 0},
+		{LeftOuterJoin, nil, 6, 0, 2},
+		{RightOuterJoin, nil, 6, 2, 0},
+		{FullOuterJoin, nil, 8, 2, 2},
+		{LeftOuterJoin, NewComparePred(aField, OpLt, &ConstExpr{IntField{3}, IntType}), 5, 0, 3},
+		{FullOuterJoin, NewComparePred(aField, OpLt, &ConstExpr{IntField{3}, IntType}), 9, 4, 3},
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	defer c.bp.CommitTransaction(tid)
+	// a buffer of 2 tuples makes the join spill, and 1 also splits partitions
+	for _, bufSize := range []int{100, 2, 1} {
+		for _, test := range tests {
+			join, err := NewOuterHashJoin(a, aField, b, bField, test.joinType, test.cond, bufSize)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			iter, err := join.Iterator(tid)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			rows, leftPadded, rightPadded := 0, 0, 0
+			for {
+				tup, err := iter()
+				if err != nil {
+					t.Fatalf(err.Error())
+				}
+				if tup == nil {
+					break
+				}
+				rows++
+				if isNull(tup.Fields[1]) {
+					leftPadded++
+				}
+				if isNull(tup.Fields[3]) {
+					rightPadded++
+				}
+			}
+			if rows != test.rows || leftPadded != test.leftPadded || rightPadded != test.rightPadded {
+				t.Errorf("%s join, buffer size %d: got %d results, %d/%d padded; expected %d, %d/%d",
+					test.joinType, bufSize, rows, leftPadded, rightPadded, test.rows, test.leftPadded, test.rightPadded)
+			}
+		}
+	}
+}
+
+func TestOuterJoinQuery(t *testing.T) {
+	c := makeOuterJoinTestCatalog(t)
+	var queries = []struct {
+		query string
+		rows  int
+	}{
+		{"select * from a left join b on a.x = b.x", 6},
+		{"select * from a left outer join b on b.x = a.x", 6},
+		{"select * from a right join b on a.x = b.x", 6},
+		{"select * from a full outer join b on a.x = b.x", 8},
+		{"select * from a full join b on a.x = b.x and b.y = 3", 10},
+		// filters of the WHERE clause apply to the result of the join
+		{"select * from a left join b on a.x = b.x where b.x is null", 2},
+		{"select * from a left join b on a.x = b.x where b.y = 2", 4},
+		{"select * from a left join b on a.x = b.x where a.x >= 2", 4},
+		{"select * from a left join b on a.x = b.x where b.x = 3 or a.x = 1", 3},
+		// but filters of the ON clause only decide which tuples match
+		{"select * from a left join b on a.x = b.x and b.x = 3", 6},
+		{"select * from a t1 left join b on t1.x = b.x left join a t2 on b.x = t2.x", 8},
+	}
+	for _, q := range queries {
+		if res := runTestQuery(t, c, q.query); len(res) != q.rows {
+			t.Errorf("%s: expected %d results, got %d", q.query, q.rows, len(res))
+		}
+	}
+	res := runTestQuery(t, c, "select a.x, b.x from a right join b on a.x = b.x where a.x is null")
+	if len(res) != 2 {
+		t.Fatalf("expected the 2 unmatched tuples of b, got %d", len(res))
+	}
+	for _, tup := range res {
+		if !isNull(tup.Fields[0]) {
+			t.Errorf("expected an unmatched tuple of b, got %v", tup.Fields)
+		}
+	}
+	_, plan, err := Parse(c, "select * from a left join b on a.x = b.x where b.y = 2")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := planInput(plan).(*PredicateOp); !ok {
+		t.Errorf("expected the filter on b to be applied after the join")
+	}
+}
@@ -23,6 +23,19 @@ type HeapFile struct {
 	desc *TupleDesc
 	file *os.File
 
+	// indexes on the file, updated on every insert and delete
+	indexes []*BTreeFile
+
+	// notNull[i] is true if field i may not be NULL;  nil if all fields may be
+	notNull []bool
+
+	// the keys of the file, checked on every insert and update
+	keys []*heapKey
+
+	// the foreign keys of the file, and of the files that reference it
+	references   []*heapForeignKey
+	referencedBy []*heapForeignKey
+
 	// tmp test
 	insertCnt int
 }
@@ -89,6 +102,11 @@ func (f *HeapFile) LoadFromCSV(file *os.File, hasHeader bool, sep string, skipLa
 		}
 		var newFields []DBValue
 		for fno, field := range fields {
+			// empty fields are NULL
+			if strings.TrimSpace(field) == "" {
+				newFields = append(newFields, NullField{})
+				continue
+			}
 			switch f.Descriptor().Fields[fno].Ftype {
 			case IntType:
 				field = strings.TrimSpace(field)
@@ -99,9 +117,6 @@ func (f *HeapFile) LoadFromCSV(file *os.File, hasHeader bool, sep string, skipLa
 				intValue := int(floatVal)
 				newFields = append(newFields, IntField{int64(intValue)})
 			case StringType:
-				if len(field) > StringLength {
-					field = field[0:StringLength]
-				}
 				newFields = append(newFields, StringField{field})
 			}
 		}
@@ -109,7 +124,16 @@ func (f *HeapFile) LoadFromCSV(file *os.File, hasHeader bool, sep string, skipLa
 		tid := NewTID()
 		bp := f.bufPool
 		bp.BeginTransaction(tid)
-		f.insertTuple(&newT, tid)
+		err := f.insertTuple(&newT, tid)
+		if err != nil {
+			bp.AbortTransaction(tid)
+			// a duplicate key keeps its code, so it can be told from bad data
+			code := MalformedDataError
+			if e, ok := err.(GoDBError); ok && e.code == DuplicateKeyError {
+				code = e.code
+			}
+			return GoDBError{code, fmt.Sprintf("LoadFromCSV: line %d (%s): %s", cnt, line, err.Error())}
+		}
 
 		// hack to force dirty pages to disk
 		// because CommitTransaction may not be implemented
@@ -173,6 +197,21 @@ func (f *HeapFile) AllocPage(pageNo int) *Page {
 // worry about concurrent transactions modifying the Page or HeapFile.  We will
 // add support for concurrent modifications in lab 3.
 func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
+	err := f.checkTuple(t)
+	if err != nil {
+		return err
+	}
+	err = f.checkKeys(t, nil, tid)
+	if err != nil {
+		return err
+	}
+	err = f.checkForeignKeys(t, nil, tid)
+	if err != nil {
+		return err
+	}
+	if !emptyHeapPage(f.desc, 0, f).hasRoomFor(t) {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("tuple of %d bytes does not fit in a page", t.recordSize())}
+	}
 	var i = 0
 	var bp = f.bufPool
 	var maxPageNo = f.NumPages()
@@ -186,14 +225,8 @@ func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
 
 		var hp = (*pg).(*heapPage)
 
-		if hp.numUsedSlots < hp.numSlots {
-			rid, err := hp.insertTuple(t)
-			if err != nil {
-				return err
-			}
-			t.Rid = rid
-			(*pg).setDirty(true)
-			return nil
+		if hp.hasRoomFor(t) {
+			return f.insertIntoPage(hp, t, tid)
 		}
 		bp.Unpin(PageKey(*pg))
 		i++
@@ -205,13 +238,64 @@ func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
 		return err
 	}
 
-	var hp = (*newPage).(*heapPage)
+	return f.insertIntoPage((*newPage).(*heapPage), t, tid)
+}
+
+// Check that t can be stored in the file:  it must have a value of the right
+// type (or NULL) for every field, and no NULLs in NOT NULL fields.  Sets the
+// TupleDesc of t to that of the file, which is used to serialize NULLs.
+func (f *HeapFile) checkTuple(t *Tuple) error {
+	if len(t.Fields) != len(f.desc.Fields) {
+		return GoDBError{TypeMismatchError, fmt.Sprintf("expected %d fields, got %d", len(f.desc.Fields), len(t.Fields))}
+	}
+	for i, v := range t.Fields {
+		field := f.desc.Fields[i]
+		var ok bool
+		switch v.(type) {
+		case NullField:
+			if f.notNull != nil && f.notNull[i] {
+				return GoDBError{IllegalOperationError, fmt.Sprintf("field %s may not be NULL", field.Fname)}
+			}
+			ok = true
+		case IntField:
+			ok = field.Ftype == IntType
+		case StringField:
+			ok = field.Ftype == StringType
+		}
+		if !ok {
+			return GoDBError{TypeMismatchError, fmt.Sprintf("value %v does not match the type of field %s", v, field.Fname)}
+		}
+	}
+	t.Desc = *f.desc
+	return nil
+}
+
+// Insert t into a page with a free slot, logging the change if the buffer
+// pool has a log.
+func (f *HeapFile) insertIntoPage(hp *heapPage, t *Tuple, tid TransactionID) error {
+	var bp = f.bufPool
+	err := bp.logPageImage(tid, hp)
+	if err != nil {
+		return err
+	}
 	rid, err := hp.insertTuple(t)
 	if err != nil {
 		return err
 	}
 	t.Rid = rid
 	hp.setDirty(true)
+	err = bp.logTupleChange(tid, logInsertRecord, hp, rid.(Rid), t)
+	// the page stays locked until tid ends, but may be stolen if there is a log
+	bp.Unpin(f.pageKey(hp.pageId))
+	if err != nil {
+		return err
+	}
+	for _, idx := range f.indexes {
+		err = idx.insertTuple(t, tid)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -222,22 +306,159 @@ func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
 // for tuples as they are read via [Iterator].  Note that Rid is an empty interface,
 // so you can supply any object you wish.  You will likely want to identify the
 // heap page and slot within the page that the tuple came from.
+// The tuples of other files that reference t are then deleted, or the delete
+// fails, as their foreign keys specify (see [ForeignKey]).
 func (f *HeapFile) deleteTuple(t *Tuple, tid TransactionID) error {
+	old, err := f.removeTuple(t, tid)
+	if err != nil {
+		return err
+	}
+	return f.deleteReferences(old, tid)
+}
+
+// Remove the tuple t from the file and its indexes, without applying the
+// actions of the foreign keys referencing it.  Returns the removed tuple.
+func (f *HeapFile) removeTuple(t *Tuple, tid TransactionID) (*Tuple, error) {
 	rid := t.Rid.(Rid)
 	var pageNo = rid.PageNo
 	var bp = f.bufPool
 
-	var pg, err = bp.GetPage(f, pageNo, tid, ReadPerm)
+	var pg, err = bp.GetPage(f, pageNo, tid, WritePerm)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var hp = (*pg).(*heapPage)
-	hp.deleteTuple(rid)
+	old, err := hp.fetchTuple(rid.SlotNo)
+	if err != nil {
+		return nil, err
+	}
+	err = bp.logPageImage(tid, hp)
+	if err != nil {
+		return nil, err
+	}
+	err = hp.deleteTuple(rid)
+	if err != nil {
+		return nil, err
+	}
 	(*pg).setDirty(true)
+	err = bp.logTupleChange(tid, logDeleteRecord, hp, rid, old)
+	bp.Unpin(f.pageKey(pageNo))
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range f.indexes {
+		err = idx.deleteTuple(old, tid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return old, nil
+}
+
+// Replace the tuple old with t, in the same slot.  The change is logged as a
+// delete of old followed by an insert of t, so recovery needs nothing new.
+// Only the indexes whose key changed are updated.  If t is longer than old and
+// does not fit on the page, old is deleted and t inserted into another page,
+// so the Rid of t changes.
+func (f *HeapFile) updateTuple(old *Tuple, t *Tuple, tid TransactionID) error {
+	rid := old.Rid.(Rid)
+	var bp = f.bufPool
+
+	err := f.checkTuple(t)
+	if err != nil {
+		return err
+	}
+	err = f.checkKeys(t, old, tid)
+	if err != nil {
+		return err
+	}
+	err = f.checkForeignKeys(t, old, tid)
+	if err != nil {
+		return err
+	}
+	pg, err := bp.GetPage(f, rid.PageNo, tid, WritePerm)
+	if err != nil {
+		return err
+	}
+	defer bp.Unpin(f.pageKey(rid.PageNo))
+
+	var hp = (*pg).(*heapPage)
+	cur, err := hp.fetchTuple(rid.SlotNo)
+	if err != nil {
+		return GoDBError{TupleNotFoundError, fmt.Sprintf("no tuple at %v: %s", rid, err.Error())}
+	}
+	if hp.spaceUsed()-cur.recordSize()+t.recordSize() > PageSize {
+		_, err = f.removeTuple(cur, tid)
+		if err != nil {
+			return err
+		}
+		t.Rid = nil
+		return f.insertTuple(t, tid)
+	}
+	err = bp.logPageImage(tid, hp)
+	if err != nil {
+		return err
+	}
+	err = hp.deleteTuple(rid)
+	if err != nil {
+		return err
+	}
+	_, err = hp.insertTupleAt(rid.SlotNo, t)
+	if err != nil {
+		return err
+	}
+	t.Rid = rid
+	hp.setDirty(true)
+	err = bp.logTupleChange(tid, logDeleteRecord, hp, rid, cur)
+	if err != nil {
+		return err
+	}
+	err = bp.logTupleChange(tid, logInsertRecord, hp, rid, t)
+	if err != nil {
+		return err
+	}
+	for _, idx := range f.indexes {
+		if compareDBValue(cur.Fields[idx.keyField], t.Fields[idx.keyField]) == 0 {
+			continue
+		}
+		err = idx.deleteTuple(cur, tid)
+		if err != nil {
+			return err
+		}
+		err = idx.insertTuple(t, tid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the index on the field with the specified name, or nil if there is
+// none.
+func (f *HeapFile) indexOn(fieldName string) *BTreeFile {
+	for _, idx := range f.indexes {
+		if idx.KeyField().Fname == fieldName {
+			return idx
+		}
+	}
 	return nil
 }
 
+// Return the tuple with the specified rid, e.g. one found through an index.
+func (f *HeapFile) fetchTuple(rid Rid, tid TransactionID) (*Tuple, error) {
+	pg, err := f.bufPool.GetPage(f, rid.PageNo, tid, ReadPerm)
+	if err != nil {
+		return nil, err
+	}
+	defer f.bufPool.Unpin(f.pageKey(rid.PageNo))
+	t, err := (*pg).(*heapPage).fetchTuple(rid.SlotNo)
+	if err != nil {
+		return nil, GoDBError{TupleNotFoundError, fmt.Sprintf("no tuple at %v: %s", rid, err.Error())}
+	}
+	return t, nil
+}
+
 // Method to force the specified page back to the backing file at the appropriate
 // location.  This will be called by BufferPool when it wants to evict a page.
 // The Page object should store information about its offset on disk (e.g.,
@@ -248,7 +469,6 @@ func (f *HeapFile) flushPage(p *Page) error {
 	// get heap page
 	var hp = (*p).(*heapPage)
 
-	f.file.Seek(int64(hp.pageId*PageSize), 0)
 	var bf, err = hp.toBuffer()
 	if err != nil {
 		return err
@@ -256,7 +476,7 @@ func (f *HeapFile) flushPage(p *Page) error {
 	if hp.pageId >= f.NumPages() {
 		f.file.Truncate(int64((hp.pageId + 1) * PageSize))
 	}
-	var _, err2 = f.file.Write(bf.Bytes())
+	var _, err2 = f.file.WriteAt(bf.Bytes(), int64(hp.pageId*PageSize))
 	if err2 != nil {
 		return err2
 	}
@@ -300,19 +520,31 @@ func (f *HeapFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 
 	var tupleIter = hp.tupleIter()
 	return func() (*Tuple, error) {
-		var tuple, err = tupleIter()
-		if err != nil {
-			return nil, err
-		}
-		key := PageKey(*pg)
-		if tuple == nil {
-			bp.Unpin(key) // unpin previous page
+		for {
+			var tuple, err = tupleIter()
+			if err != nil {
+				return nil, err
+			}
+			if tuple != nil {
+				// cached pages hold tuples with the descriptor they were
+				// inserted with, which the planner may since have qualified
+				var res = *tuple
+				res.Desc = *f.desc
+				return &res, nil
+			}
+			if pg == nil {
+				return nil, nil
+			}
+
+			// pages may have free slots (or be empty) after deletes, so keep
+			// going until the last page
+			bp.Unpin(PageKey(*pg)) // unpin previous page
 			pageNo++
 			if pageNo >= f.NumPages() {
+				pg = nil
 				return nil, nil
 			}
 			pg, err = bp.GetPage(f, pageNo, tid, ReadPerm)
-			newKey := PageKey(*pg)
 			if err != nil {
 				return nil, err
 			}
@@ -322,27 +554,8 @@ func (f *HeapFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 			if hp.pageId != pageNo {
 				panic("page id not equal to page no")
 			}
-
-			// defending codes
-			if pageNo+1 < f.NumPages() {
-				if hp.numUsedSlots < hp.numSlots {
-					panic("page not full , which should not happen")
-				}
-			}
-
 			tupleIter = hp.tupleIter()
-			tuple, err = tupleIter()
-			if err != nil {
-				return nil, err
-			}
-
-			// maybe no tuple in this page
-			if tuple == nil {
-				bp.Unpin(newKey)
-				return nil, nil
-			}
 		}
-		return tuple, nil
 	}, nil
 
 }
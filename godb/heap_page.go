@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 )
 
 /* HeapPage implements the Page interface for pages of HeapFiles. We have
@@ -13,86 +14,86 @@ interface requires.  You will want to use an interface like what we provide to
 implement the methods of [HeapFile] that insert, delete, and iterate through
 tuples.
 
-In GoDB all tuples are fixed length, which means that given a TupleDesc it is
-possible to figure out how many tuple "slots" fit on a given page.
+Tuples are stored as variable length records (see [Tuple.writeTo]), so
+strings take only as many bytes as they are long.  Pages use a slotted layout:
 
-In addition, all pages are PageSize bytes.  They begin with a header with a 32
-bit integer with the number of slots (tuples), and a second 32 bit integer with
-the number of used slots.
+All pages are PageSize bytes.  They begin with a header with a 32 bit integer
+with the number of slots, a second 32 bit integer with the number of used
+slots, and a 64 bit integer with the LSN of the last log record that modified
+the page.  The header is followed by the slot directory, which has a 16 bit
+offset per slot of the record stored in the slot, or 0 if the slot is free.
+The records follow the slot directory, and the rest of the page is zero filled.
 
-Each tuple occupies the same number of bytes.  You can use the go function
-unsafe.Sizeof() to determine the size in bytes of an object.  So, a GoDB integer
-(represented as an int64) requires unsafe.Sizeof(int64(0)) bytes.  For strings,
-we encode them as byte arrays of StringLength, so they are size
-((int)(unsafe.Sizeof(byte('a')))) * StringLength bytes.  The size in bytes  of a
-tuple is just the sum of the size in bytes of its fields.
-
-Once you have figured out how big a record is, you can determine the number of
-slots on on the page as:
-
-remPageSize = PageSize - 8 // bytes after header
-numSlots = remPageSize / bytesPerTuple //integer division will round down
+The slot directory grows with the tuples of the page, so the number of tuples
+a page holds depends only on the size of their records.  A new page has no
+slots.  A tuple is inserted into a free slot if there is one, and otherwise a
+slot is added to the end of the directory, as long as the record, and the 2
+bytes of a new slot, fit in the bytes not used by the header, the slot
+directory and the other records.
 
 To serialize a page to a buffer, you can then:
 
 write the number of slots as an int32
 write the number of used slots as an int32
-write the tuples themselves to the buffer
+write the page LSN as an int64
+write the slot directory
+write the record of every used slot
 
 You will follow the inverse process to read pages from a buffer.
 
-Note that to process deletions you will likely delete tuples at a specific
-position (slot) in the heap page.  This means that after a page is read from
-disk, tuples should retain the same slot number. Because GoDB will never evict a
-dirty page, it's OK if tuples are renumbered when they are written back to disk.
+Tuples keep their slot number when they are deleted or the page is written back
+to disk.  GoDB may steal dirty pages of running transactions, and the log
+records those transactions wrote (see log_file.go) identify tuples by page and
+slot, so slots must never be renumbered.
 
 */
 
+const heapPageFixedHeaderSize int = 16
+
 type heapPage struct {
 	desc         *TupleDesc
-	tuples       []Tuple
-	deleted      []bool // mark deleted tuples
+	tuples       []*Tuple // one entry per slot, nil if the slot is free
 	numSlots     int
 	numUsedSlots int
+	recordBytes  int // total size of the records of the used slots
 
-	singleTupleSize int
-	pageId          int
+	pageId int
+
+	// LSN of the last log record that modified this page
+	lsn LSN
 
 	dirty bool
 	file  *HeapFile
 }
 
-func (h *heapPage) UpdateSlotNumAndSingleTupleSize() int {
-	var remPageSize int = PageSize - 8 // bytes after header
-	var bytesPerTuple int = h.desc.Size()
-	var res int = remPageSize / (bytesPerTuple)
-	h.numSlots = res
-	h.singleTupleSize = bytesPerTuple
-	return res
-}
-
-// Construct a new heap page
-// read from file if pageNo is valid else is create a new page
-func newHeapPage(desc *TupleDesc, pageNo int, f *HeapFile) *heapPage {
+// Construct an empty heap page without touching the file
+func emptyHeapPage(desc *TupleDesc, pageNo int, f *HeapFile) *heapPage {
 	var res = new(heapPage)
 	res.desc = desc
-	res.UpdateSlotNumAndSingleTupleSize()
+	res.numSlots = 0
 	res.numUsedSlots = 0
 	res.pageId = pageNo
 
 	res.dirty = false
 	res.file = f
+	return res
+}
+
+// Construct a new heap page
+// read from file if pageNo is valid else is create a new page
+func newHeapPage(desc *TupleDesc, pageNo int, f *HeapFile) *heapPage {
+	var res = emptyHeapPage(desc, pageNo, f)
 
 	// read from file if pageNo is valid else is create a new page
 	if pageNo < f.NumPages() {
-		var buf = bytes.Buffer{}
-		buf.Grow(PageSize)
-		f.file.Seek(int64(pageNo*PageSize), 0)
-		var _, err = buf.ReadFrom(f.file)
+		var data = make([]byte, PageSize)
+		var _, err = f.file.ReadAt(data, int64(pageNo*PageSize))
 		if err != nil {
 			return nil
 		}
-		res.initFromBuffer(&buf)
+		if res.initFromBuffer(bytes.NewBuffer(data)) != nil {
+			return nil
+		}
 	} else {
 		f.file.Truncate(int64((pageNo + 1) * PageSize))
 	}
@@ -104,62 +105,100 @@ func (h *heapPage) getNumSlots() int {
 	return h.numSlots
 }
 
+// Size of the header, including the slot directory
 func (h *heapPage) headerSize() int {
-	return 8
+	return heapPageFixedHeaderSize + 2*h.numSlots
 }
 
 func (h *heapPage) spaceUsed() int {
-	return h.numUsedSlots*h.singleTupleSize + h.headerSize()
+	return h.headerSize() + h.recordBytes
+}
+
+// Return true if the page has room for the record of t, and for a new slot if
+// none is free.
+func (h *heapPage) hasRoomFor(t *Tuple) bool {
+	var slotBytes = 0
+	if h.numUsedSlots == h.numSlots {
+		slotBytes = 2
+	}
+	return h.spaceUsed()+slotBytes+t.recordSize() <= PageSize
 }
 
-func (h *heapPage) tupleStartPos(slot int) int {
-	return h.headerSize() + slot*h.singleTupleSize
+// Return true if the slot holds a tuple.
+func (h *heapPage) slotUsed(slot int) bool {
+	return slot >= 0 && slot < h.numSlots && h.tuples[slot] != nil
 }
 
-// TODO test
 func (h *heapPage) fetchTuple(slotIdx int) (*Tuple, error) {
-	if slotIdx >= h.numUsedSlots {
+	if slotIdx < 0 || slotIdx >= h.numSlots {
 		return nil, errors.New("invalid slot idx")
 	}
-	if h.deleted[slotIdx] {
+	if h.tuples[slotIdx] == nil {
 		return nil, errors.New("tuple deleted")
 	}
-	return &h.tuples[slotIdx], nil
+	return h.tuples[slotIdx], nil
 }
 
-// TODO test
-// Insert the tuple into a free slot on the page, or return an error if there are
-// no free slots.  Set the tuples rid and return it.
+// Insert the tuple into a free slot on the page, or a new slot if none is
+// free, or return an error if the page is too full for the tuple.  Set the
+// tuples rid and return it.
 func (h *heapPage) insertTuple(t *Tuple) (recordID, error) {
-	if h.numUsedSlots >= h.numSlots {
-		return Rid{}, errors.New("no free slots")
+	for slot, tup := range h.tuples {
+		if tup == nil {
+			return h.insertTupleAt(slot, t)
+		}
 	}
+	return h.insertTupleAt(h.numSlots, t)
+}
 
-	var rid Rid
-
-	rid.PageNo = h.pageId
-	rid.SlotNo = h.numUsedSlots
+// Insert the tuple into the specified slot, which must be free.  The slot
+// directory is extended up to the slot if it is past its end.  Used by
+// insertTuple and when replaying or undoing log records, which have to put
+// tuples back into the exact slot they were logged with.
+func (h *heapPage) insertTupleAt(slot int, t *Tuple) (recordID, error) {
+	if slot < 0 {
+		return Rid{}, errors.New("invalid slot no")
+	}
+	if h.slotUsed(slot) {
+		return Rid{}, errors.New("slot already used")
+	}
+	var newSlots = 0
+	if slot >= h.numSlots {
+		newSlots = slot + 1 - h.numSlots
+	}
+	if h.spaceUsed()+2*newSlots+t.recordSize() > PageSize {
+		return Rid{}, errors.New("not enough free space on page")
+	}
+	for ; newSlots > 0; newSlots-- {
+		h.tuples = append(h.tuples, nil)
+		h.numSlots++
+	}
+	var rid = Rid{h.pageId, slot}
+	var tup = *t
+	tup.Desc = *h.desc
+	tup.Rid = rid
+	h.tuples[slot] = &tup
 	h.numUsedSlots++
-	t.Desc = *h.desc
-	h.tuples = append(h.tuples, *t)
-	h.deleted = append(h.deleted, false)
+	h.recordBytes += tup.recordSize()
 	return rid, nil
 }
 
 // Delete the tuple in the specified slot number, or return an error if
-// the slot is invalid
+// the slot is invalid.  The slot is left free, so the other tuples on the
+// page keep their rids.
 func (h *heapPage) deleteTuple(rid recordID) error {
 	var r = rid.(Rid)
 	if r.PageNo != h.pageId {
 		return errors.New("invalid page no")
 	}
-	if r.SlotNo >= len(h.tuples) {
+	if r.SlotNo < 0 || r.SlotNo >= h.numSlots {
 		return errors.New("invalid slot no")
 	}
-	if h.deleted[r.SlotNo] {
+	if h.tuples[r.SlotNo] == nil {
 		return errors.New("tuple already deleted")
 	}
-	h.deleted[rid.(Rid).SlotNo] = true
+	h.recordBytes -= h.tuples[r.SlotNo].recordSize()
+	h.tuples[r.SlotNo] = nil
 	h.numUsedSlots--
 	return nil
 }
@@ -181,38 +220,86 @@ func (p *heapPage) getFile() *DBFile {
 	return &dbFile
 }
 
+// Page method - return the page number of the page in its HeapFile.
+func (p *heapPage) getPageNo() int {
+	return p.pageId
+}
+
 // Allocate a new bytes.Buffer and write the heap page to it. Returns an error
 // if the write to the the buffer fails. You will likely want to call this from
 // your [HeapFile.flushPage] method.  You should write the page header, using
-// the binary.Write method in LittleEndian order, followed by the tuples of the
-// page, written using the Tuple.writeTo method.
+// the binary.Write method in LittleEndian order, followed by the slot directory
+// and the records of the page, written using the Tuple.writeTo method.
 func (h *heapPage) toBuffer() (*bytes.Buffer, error) {
 	var buf = new(bytes.Buffer)
 	binary.Write(buf, binary.LittleEndian, int32(h.numSlots))
 	binary.Write(buf, binary.LittleEndian, int32(h.numUsedSlots))
+	binary.Write(buf, binary.LittleEndian, int64(h.lsn))
+	var records = new(bytes.Buffer)
+	var offsets = make([]uint16, h.numSlots)
 	for i, tuple := range h.tuples {
-		if !h.deleted[i] {
-			tuple.writeTo(buf)
+		if tuple == nil {
+			continue
+		}
+		offsets[i] = uint16(h.headerSize() + records.Len())
+		err := tuple.writeTo(records)
+		if err != nil {
+			return nil, err
 		}
 	}
+	if h.headerSize()+records.Len() > PageSize {
+		return nil, GoDBError{MalformedDataError, "page records do not fit in a page"}
+	}
+	binary.Write(buf, binary.LittleEndian, offsets)
+	buf.Write(records.Bytes())
+	buf.Write(make([]byte, PageSize-buf.Len()))
 	return buf, nil
 }
 
 // Read the contents of the HeapPage from the supplied buffer.
 func (h *heapPage) initFromBuffer(buf *bytes.Buffer) error {
+	var data = buf.Bytes()
 	var numSlots int32
 	var numUsedSlots int32
+	var lsn int64
 	binary.Read(buf, binary.LittleEndian, &numSlots)
 	binary.Read(buf, binary.LittleEndian, &numUsedSlots)
+	binary.Read(buf, binary.LittleEndian, &lsn)
+	// a zero filled page was allocated but never written, keep it empty
+	if numSlots == 0 {
+		return nil
+	}
+	if numSlots < 0 || heapPageFixedHeaderSize+2*int(numSlots) > PageSize {
+		return GoDBError{MalformedDataError, fmt.Sprintf("page has invalid number of slots %d", numSlots)}
+	}
 	h.numSlots = int(numSlots)
-	h.numUsedSlots = int(numUsedSlots)
-	for i := 0; i < h.numUsedSlots; i++ {
-		t, err := readTupleFrom(buf, h.desc)
+	h.lsn = LSN(lsn)
+	var offsets = make([]uint16, h.numSlots)
+	err := binary.Read(buf, binary.LittleEndian, offsets)
+	if err != nil {
+		return err
+	}
+	h.tuples = make([]*Tuple, h.numSlots)
+	h.numUsedSlots = 0
+	h.recordBytes = 0
+	for i, off := range offsets {
+		if off == 0 {
+			continue
+		}
+		if int(off) < h.headerSize() || int(off) >= len(data) {
+			return GoDBError{MalformedDataError, fmt.Sprintf("slot %d has invalid offset %d", i, off)}
+		}
+		t, err := readTupleFrom(bytes.NewBuffer(data[off:]), h.desc)
 		if err != nil {
 			return err
 		}
-		h.tuples = append(h.tuples, *t)
-		h.deleted = append(h.deleted, false)
+		t.Rid = Rid{h.pageId, i}
+		h.tuples[i] = t
+		h.numUsedSlots++
+		h.recordBytes += t.recordSize()
+	}
+	if h.numUsedSlots != int(numUsedSlots) {
+		return GoDBError{MalformedDataError, "page slot directory does not match used slot count"}
 	}
 	return nil
 }
@@ -224,13 +311,13 @@ func (p *heapPage) tupleIter() func() (*Tuple, error) {
 	var i int = 0
 
 	return func() (*Tuple, error) {
-		for i < len(p.tuples) && p.deleted[i] {
+		for i < len(p.tuples) && p.tuples[i] == nil {
 			i++
 		}
 		if i >= len(p.tuples) {
 			return nil, nil
 		}
-		var res = &p.tuples[i]
+		var res = p.tuples[i]
 		res.Rid = Rid{p.pageId, i}
 		i++
 		return res, nil
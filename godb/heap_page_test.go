@@ -2,8 +2,8 @@ package godb
 
 import (
 	"fmt"
+	"strings"
 	"testing"
-	"unsafe"
 )
 
 // my test
@@ -16,6 +16,12 @@ func newIter() func() int {
 	}
 }
 
+// Return the number of tuples with records of the size of the record of t
+// that fit on an empty page.
+func tuplesPerPage(t *Tuple) int {
+	return (PageSize - heapPageFixedHeaderSize) / (2 + t.recordSize())
+}
+
 // my test
 func TestIteratorOp(t *testing.T) {
 	iter := newIter()
@@ -28,9 +34,8 @@ func TestIteratorOp(t *testing.T) {
 func TestHeapPageInsertAndFetch(t *testing.T) {
 	td, t1, t2, hf, _, _ := makeTestVars()
 	pg := newHeapPage(&td, 0, hf)
-	var expectedSlots = (PageSize - 8) / (StringLength + int(unsafe.Sizeof(int64(0))))
-	if pg.getNumSlots() != expectedSlots {
-		t.Fatalf("Incorrect number of slots, expected %d, got %d", expectedSlots, pg.getNumSlots())
+	if pg.getNumSlots() != 0 {
+		t.Fatalf("Incorrect number of slots, expected 0, got %d", pg.getNumSlots())
 	}
 
 	pg.insertTuple(&t1)
@@ -57,9 +62,8 @@ func TestHeapPageInsertAndFetch(t *testing.T) {
 func TestInsertHeapPage(t *testing.T) {
 	td, t1, t2, hf, _, _ := makeTestVars()
 	pg := newHeapPage(&td, 0, hf)
-	var expectedSlots = (PageSize - 8) / (StringLength + int(unsafe.Sizeof(int64(0))))
-	if pg.getNumSlots() != expectedSlots {
-		t.Fatalf("Incorrect number of slots, expected %d, got %d", expectedSlots, pg.getNumSlots())
+	if pg.getNumSlots() != 0 {
+		t.Fatalf("Incorrect number of slots, expected 0, got %d", pg.getNumSlots())
 	}
 
 	pg.insertTuple(&t1)
@@ -112,7 +116,7 @@ func TestDeleteHeapPage(t *testing.T) {
 func TestHeapPageInsertTuple(t *testing.T) {
 	td, t1, _, hf, _, _ := makeTestVars()
 	page := newHeapPage(&td, 0, hf)
-	free := page.getNumSlots()
+	free := tuplesPerPage(&Tuple{Desc: td, Fields: []DBValue{StringField{"sam"}, IntField{0}}})
 
 	for i := 0; i < free; i++ {
 		var addition = Tuple{
@@ -158,7 +162,7 @@ func TestHeapPageInsertTuple(t *testing.T) {
 func TestHeapPageDeleteTuple(t *testing.T) {
 	td, _, _, hf, _, _ := makeTestVars()
 	page := newHeapPage(&td, 0, hf)
-	free := page.getNumSlots()
+	free := tuplesPerPage(&Tuple{Desc: td, Fields: []DBValue{StringField{"sam"}, IntField{0}}})
 
 	list := make([]recordID, free)
 	for i := 0; i < free; i++ {
@@ -219,7 +223,7 @@ func TestHeapPageSerialization(t *testing.T) {
 
 	td, _, _, hf, _, _ := makeTestVars()
 	page := newHeapPage(&td, 0, hf)
-	free := page.getNumSlots()
+	free := tuplesPerPage(&Tuple{Desc: td, Fields: []DBValue{StringField{"sam"}, IntField{0}}})
 
 	for i := 0; i < free-1; i++ {
 		var addition = Tuple{
@@ -267,3 +271,122 @@ func TestHeapPageSerialization(t *testing.T) {
 		}
 	}
 }
+
+func TestHeapPageNulls(t *testing.T) {
+	td, t1, t2, hf, _, _ := makeTestVars()
+	pg := newHeapPage(&td, 0, hf)
+	t1.Fields[1] = NullField{}
+	t2.Fields[0] = NullField{}
+	pg.insertTuple(&t1)
+	pg.insertTuple(&t2)
+
+	buf, err := pg.toBuffer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	pg2 := newHeapPage(&td, 0, hf)
+	err = pg2.initFromBuffer(buf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i, exp := range []*Tuple{&t1, &t2} {
+		tup, err := pg2.fetchTuple(i)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if !tup.equals(exp) {
+			t.Errorf("tuple %d changed by serialization: %s", i, tup.ToString())
+		}
+	}
+	if isNull(t1.Fields[0]) || !isNull(t1.Fields[1]) {
+		t.Errorf("wrong fields are NULL")
+	}
+}
+
+func TestHeapPageLongStrings(t *testing.T) {
+	td, t1, t2, hf, _, _ := makeTestVars()
+	pg := newHeapPage(&td, 0, hf)
+	t1.Fields[0] = StringField{strings.Repeat("a long string ", 10)}
+	rid1, err := pg.insertTuple(&t1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	t2.Rid, err = pg.insertTuple(&t2)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// deleting a tuple leaves the rids of the others unchanged
+	err = pg.deleteTuple(rid1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	t1.Fields[0] = StringField{strings.Repeat("x", 200)}
+	t1.Rid, err = pg.insertTuple(&t1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if t1.Rid != rid1 {
+		t.Errorf("expected the free slot %v to be reused, got %v", rid1, t1.Rid)
+	}
+
+	buf, err := pg.toBuffer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	pg2 := newHeapPage(&td, 0, hf)
+	err = pg2.initFromBuffer(buf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, exp := range []*Tuple{&t1, &t2} {
+		tup, err := pg2.fetchTuple(exp.Rid.(Rid).SlotNo)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if !tup.equals(exp) {
+			t.Errorf("tuple changed by serialization: %s", tup.ToString())
+		}
+	}
+
+	// a page is full when its records fill it
+	big := Tuple{Desc: td, Fields: []DBValue{StringField{strings.Repeat("y", 1000)}, IntField{1}}}
+	n := 0
+	for ; n < 10; n++ {
+		if _, err := pg.insertTuple(&big); err != nil {
+			break
+		}
+	}
+	if n != 3 {
+		t.Errorf("expected 3 more 1000 byte tuples to fit, got %d", n)
+	}
+}
+
+func TestHeapPageShortStrings(t *testing.T) {
+	td, t1, _, hf, _, _ := makeTestVars()
+	pg := newHeapPage(&td, 0, hf)
+	n := 0
+	for ; ; n++ {
+		if _, err := pg.insertTuple(&t1); err != nil {
+			break
+		}
+	}
+	// short strings take less room than StringLength bytes
+	if n != tuplesPerPage(&t1) || n <= (PageSize-heapPageFixedHeaderSize)/(2+td.Size()) {
+		t.Errorf("expected %d tuples to fit, got %d", tuplesPerPage(&t1), n)
+	}
+	if pg.getNumSlots() != n {
+		t.Errorf("expected a slot per tuple, got %d slots", pg.getNumSlots())
+	}
+
+	buf, err := pg.toBuffer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	pg2 := newHeapPage(&td, 0, hf)
+	if err := pg2.initFromBuffer(buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if pg2.getNumSlots() != n || pg2.numUsedSlots != n {
+		t.Errorf("expected %d slots after serialization, got %d", n, pg2.getNumSlots())
+	}
+}
@@ -0,0 +1,46 @@
+package godb
+
+// IndexScan returns the tuples of a table whose indexed column satisfies
+// "column op key", using a [BTreeFile] range scan instead of filtering every
+// tuple of the table.
+type IndexScan struct {
+	index *BTreeFile
+	op    BoolOp
+	key   Expr
+}
+
+// Return true if an IndexScan can evaluate op.
+func indexScanSupports(op BoolOp) bool {
+	switch op {
+	case OpEq, OpLt, OpLe, OpGt, OpGe:
+		return true
+	}
+	return false
+}
+
+// Construct an index scan.  key must be a constant expression of the same type
+// as the indexed column, and op one of OpEq, OpLt, OpLe, OpGt and OpGe.
+func NewIndexScan(index *BTreeFile, op BoolOp, key Expr) (*IndexScan, error) {
+	if !indexScanSupports(op) {
+		return nil, GoDBError{IllegalOperationError, "unsupported operator for an index scan"}
+	}
+	if key.GetExprType().Ftype != index.KeyField().Ftype {
+		return nil, GoDBError{IncompatibleTypesError, "index key and constant have different types"}
+	}
+	return &IndexScan{index, op, key}, nil
+}
+
+// Return a TupleDescriptor for this index scan, which is the descriptor of
+// the indexed table.
+func (s *IndexScan) Descriptor() *TupleDesc {
+	return s.index.Descriptor()
+}
+
+// Index scan operator implementation.
+func (s *IndexScan) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	key, err := s.key.EvalExpr(nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.index.RangeIterator(tid, s.op, key)
+}
@@ -0,0 +1,272 @@
+package godb
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Run a query that returns tuples in its own transaction, returning the
+// tuples.
+func runTestQuery(t *testing.T, c *Catalog, query string) []*Tuple {
+	qType, plan, err := Parse(c, query)
+	if err != nil {
+		t.Fatalf("failed to parse %s, %s", query, err.Error())
+	}
+	if qType != IteratorType {
+		t.Fatalf("expected %s to return tuples", query)
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	iter, err := plan.Iterator(tid)
+	if err != nil {
+		t.Fatalf("failed to run %s, %s", query, err.Error())
+	}
+	var res []*Tuple
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf("failed to run %s, %s", query, err.Error())
+		}
+		if tup == nil {
+			break
+		}
+		res = append(res, tup)
+	}
+	c.bp.CommitTransaction(tid)
+	return res
+}
+
+// Return a catalog with a table t (name string, age int) with n tuples with
+// ages i % 10 for i in [0, n), in a new directory.
+func makeIndexTestCatalog(t *testing.T, n int) (*Catalog, string) {
+	dir := t.TempDir()
+	err := os.WriteFile(dir+"/catalog.txt", []byte("t (name string, age int)\n"), 0644)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	c, err := NewCatalogFromFile("catalog.txt", NewBufferPool(50), dir)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i := 0; i < n; i++ {
+		runTestQuery(t, c, fmt.Sprintf("insert into t values ('sam%d', %d)", i, i%10))
+	}
+	return c, dir
+}
+
+// Return the first operator of the plan that isn't a project.
+func planInput(op Operator) Operator {
+	if p, ok := op.(*Project); ok {
+		return p.child
+	}
+	return op
+}
+
+func TestCreateIndexAndIndexScan(t *testing.T) {
+	c, _ := makeIndexTestCatalog(t, 100)
+
+	qType, _, err := Parse(c, "CREATE INDEX t_age ON t(age)")
+	if err != nil || qType != CreateIndexQueryType {
+		t.Fatalf("failed to create index, %v", err)
+	}
+	var queries = map[string]int{
+		"select name from t where age = 3":  10,
+		"select name from t where age < 3":  30,
+		"select name from t where age >= 7": 30,
+	}
+	for q, cnt := range queries {
+		_, plan, err := Parse(c, q)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if _, ok := planInput(plan).(*IndexScan); !ok {
+			t.Errorf("expected an index scan for %s", q)
+		}
+		if res := runTestQuery(t, c, q); len(res) != cnt {
+			t.Errorf("expected %d results for %s, got %d", cnt, q, len(res))
+		}
+	}
+
+	// not indexable
+	_, plan, err := Parse(c, "select name from t where age <> 3")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := planInput(plan).(*IndexScan); ok {
+		t.Errorf("expected no index scan for <>")
+	}
+
+	// the index is maintained by inserts and deletes
+	runTestQuery(t, c, "insert into t values ('joe', 3)")
+	runTestQuery(t, c, "delete from t where name = 'sam3'")
+	res := runTestQuery(t, c, "select name from t where age = 3")
+	if len(res) != 10 {
+		t.Errorf("expected 10 results, got %d", len(res))
+	}
+	for _, tup := range res {
+		if tup.Fields[0].(StringField).Value == "sam3" {
+			t.Errorf("deleted tuple returned by the index")
+		}
+	}
+}
+
+func TestIndexScanPrefersEquality(t *testing.T) {
+	c, _ := makeIndexTestCatalog(t, 20)
+	for _, q := range []string{"create index t_age on t (age)", "create index t_name on t (name)"} {
+		if _, _, err := Parse(c, q); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	_, plan, err := Parse(c, "select name from t where age > 3 and name = 'sam5'")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	filt, ok := planInput(plan).(*Filter[int64])
+	if !ok {
+		t.Fatalf("expected a filter on age")
+	}
+	scan, ok := filt.child.(*IndexScan)
+	if !ok || scan.index.KeyField().Fname != "name" {
+		t.Errorf("expected an index scan on name")
+	}
+	if res := runTestQuery(t, c, "select name from t where age > 3 and name = 'sam5'"); len(res) != 1 {
+		t.Errorf("expected 1 result, got %d", len(res))
+	}
+}
+
+func TestIndexCatalog(t *testing.T) {
+	c, dir := makeIndexTestCatalog(t, 20)
+	if _, _, err := Parse(c, "create index t_age on t (age)"); err == nil {
+		if _, _, err := Parse(c, "create index t_age on t (name)"); err == nil {
+			t.Errorf("expected error for duplicate index name")
+		}
+	} else {
+		t.Fatalf(err.Error())
+	}
+	if _, _, err := Parse(c, "create index t_x on t (x)"); err == nil {
+		t.Errorf("expected error for unknown column")
+	}
+	if _, _, err := Parse(c, "create index t_x on nosuchtable (x)"); err == nil {
+		t.Errorf("expected error for unknown table")
+	}
+	err := c.SaveToFile("catalog.txt", dir)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	c2, err := NewCatalogFromFile("catalog.txt", NewBufferPool(50), dir)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if c2.CatalogString() != c.CatalogString() {
+		t.Errorf("catalog changed by reloading, %s", c2.CatalogString())
+	}
+	_, plan, err := Parse(c2, "select name from t where age = 1")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := planInput(plan).(*IndexScan); !ok {
+		t.Errorf("expected an index scan after reloading the catalog")
+	}
+	if res := runTestQuery(t, c2, "select name from t where age = 1"); len(res) != 2 {
+		t.Errorf("expected 2 results, got %d", len(res))
+	}
+
+	qType, _, err := Parse(c2, "drop index t_age")
+	if err != nil || qType != DropIndexQueryType {
+		t.Fatalf("failed to drop index, %v", err)
+	}
+	_, plan, err = Parse(c2, "select name from t where age = 1")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := planInput(plan).(*IndexScan); ok {
+		t.Errorf("expected no index scan after dropping the index")
+	}
+	if _, err := os.Stat(dir + "/t_age.idx"); err == nil {
+		t.Errorf("expected index file to be removed")
+	}
+}
+
+func TestIndexFilesOpenedOnce(t *testing.T) {
+	c, _ := makeIndexTestCatalog(t, 20)
+	if _, _, err := Parse(c, "create index t_age on t (age)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	hf1, _ := c.GetTable("t")
+	hf2, _ := c.GetTable("t")
+	if hf1 != hf2 || len(hf1.(*HeapFile).indexes) != 1 {
+		t.Fatalf("expected the table and its index to be opened once")
+	}
+	idx := hf1.(*HeapFile).indexes[0]
+	if _, _, err := Parse(c, "drop index t_age"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if hf, _ := c.GetTable("t"); hf != hf1 || len(hf.(*HeapFile).indexes) != 0 {
+		t.Errorf("expected the dropped index to be removed from the table")
+	}
+	if _, err := idx.file.Stat(); err == nil {
+		t.Errorf("expected the file of the dropped index to be closed")
+	}
+}
+
+func TestIndexRebuiltAfterCrash(t *testing.T) {
+	c, dir := makeIndexTestCatalog(t, 20)
+	if _, _, err := Parse(c, "create index t_age on t (age)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	c.SaveToFile("catalog.txt", dir)
+	runTestQuery(t, c, "insert into t values ('joe', 1)")
+	// lose the index, as if its pages were never written
+	os.Remove(dir + "/t_age.idx")
+
+	c2, err := NewCatalogFromFile("catalog.txt", NewBufferPool(50), dir)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if res := runTestQuery(t, c2, "select name from t where age = 1"); len(res) != 3 {
+		t.Errorf("expected 3 results, got %d", len(res))
+	}
+}
+
+func TestIndexLongStrings(t *testing.T) {
+	c, _ := makeIndexTestCatalog(t, 0)
+	var prefix = strings.Repeat("p", StringLength)
+	for i := 0; i < 5; i++ {
+		runTestQuery(t, c, fmt.Sprintf("insert into t values ('%s%d', %d)", prefix, i, i))
+	}
+	if _, _, err := Parse(c, "CREATE INDEX t_name ON t(name)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// strings are stored at their real length, but share a key in the index
+	res := runTestQuery(t, c, "select name from t")
+	for _, tup := range res {
+		if len(tup.Fields[0].(StringField).Value) != StringLength+1 {
+			t.Errorf("string truncated to %s", tup.Fields[0].(StringField).Value)
+		}
+	}
+	var queries = map[string]int{
+		"select age from t where name = '" + prefix + "3'":  1,
+		"select age from t where name > '" + prefix + "3'":  1,
+		"select age from t where name <= '" + prefix + "3'": 4,
+		"select age from t where name = '" + prefix + "'":   0,
+		"select age from t where name > '" + prefix + "'":   5,
+		"select age from t where name <= '" + prefix + "'":  0,
+		"select age from t where name < '" + prefix + "'":   0,
+	}
+	for q, cnt := range queries {
+		_, plan, err := Parse(c, q)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if _, ok := planInput(plan).(*IndexScan); !ok {
+			t.Errorf("expected an index scan for %s", q)
+		}
+		if res := runTestQuery(t, c, q); len(res) != cnt {
+			t.Errorf("expected %d results for %s, got %d", cnt, q, len(res))
+		}
+	}
+}
@@ -59,3 +59,51 @@ func TestInsert(t *testing.T) {
 		t.Errorf("insert failed, expected 2 tuples, got %d", cnt)
 	}
 }
+
+func TestInsertNotNull(t *testing.T) {
+	c, dir := makeIndexTestCatalog(t, 0)
+	if _, _, err := Parse(c, "create table u (a int not null, b varchar(20))"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	runTestQuery(t, c, "insert into u values (1, null)")
+
+	_, plan, err := Parse(c, "insert into u values (null, 'x')")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	iter, err := plan.Iterator(tid)
+	if err == nil {
+		_, err = iter()
+	}
+	if err == nil {
+		t.Errorf("expected error inserting NULL into a NOT NULL field")
+	}
+	c.bp.AbortTransaction(tid)
+
+	// the constraint is saved in the catalog
+	c.SaveToFile("catalog.txt", dir)
+	c2, err := NewCatalogFromFile("catalog.txt", NewBufferPool(50), dir)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if c2.CatalogString() != c.CatalogString() {
+		t.Errorf("catalog changed by reloading, %s", c2.CatalogString())
+	}
+	res := runTestQuery(t, c2, "select a, b from u")
+	if len(res) != 1 || !isNull(res[0].Fields[1]) {
+		t.Errorf("expected one tuple with a NULL b")
+	}
+	_, plan, _ = Parse(c2, "update u set a = null")
+	tid = NewTID()
+	c2.bp.BeginTransaction(tid)
+	iter, err = plan.Iterator(tid)
+	if err == nil {
+		_, err = iter()
+	}
+	if err == nil {
+		t.Errorf("expected error setting a NOT NULL field to NULL")
+	}
+	c2.bp.AbortTransaction(tid)
+}
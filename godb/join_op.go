@@ -130,7 +130,8 @@ func (joinOp *EqualityJoin[T]) Iterator(tid TransactionID) (func() (*Tuple, erro
 
 			// println("[ ", left.ToString(), "] [", right.ToString(), "]")
 
-			if joinOp.getter(leftVal) == joinOp.getter(rightVal) {
+			// NULL is not equal to anything, including NULL
+			if !isNull(leftVal) && !isNull(rightVal) && joinOp.getter(leftVal) == joinOp.getter(rightVal) {
 				return joinTuples(left, right), nil
 			}
 
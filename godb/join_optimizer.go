@@ -0,0 +1,249 @@
+package godb
+
+import "strconv"
+
+// Maximum number of joins orderJoins orders;  the search takes time
+// exponential in the number of joins.
+const maxOrderedJoins int = 12
+
+// A left-deep plan joining a set of tables, with its estimated cardinality and
+// cost
+type joinPlan struct {
+	joins  []*LogicalJoinNode // in the order they are applied
+	tables map[string]bool    // the tables joined, by name or alias
+	card   float64
+	cost   float64
+}
+
+// A table of the FROM clause of a query, with its statistics and the estimated
+// number of its tuples that pass the filters of the query.
+type joinTable struct {
+	table *Table
+	card  float64
+}
+
+// Return the joins of plan in the order of the cheapest left-deep plan, found
+// by dynamic programming over the subsets of the joins (Selinger et al.).
+// Every join after the first adds one table to the tables joined by the joins
+// before it.  The cost of a plan is the number of tuples its joins read and
+// produce, estimated from the statistics of the tables (see [TableStats]).
+//
+// The joins are returned in query order if there are too many of them, if
+// one of the joined tables is a subquery or has not been analyzed, if the
+// joins form a cycle, if one of them is an outer join, since outer joins do
+// not commute with other joins, or if one of them is not an equality.
+func orderJoins(c *Catalog, plan *LogicalPlan) ([]*LogicalJoinNode, error) {
+	n := len(plan.joins)
+	if n < 2 || n > maxOrderedJoins || len(plan.subqueries) > 0 {
+		return plan.joins, nil
+	}
+	for _, j := range plan.joins {
+		if j.joinType != InnerJoin || j.left == nil {
+			return plan.joins, nil
+		}
+	}
+	tables := make(map[string]*joinTable)
+	for _, t := range plan.tables {
+		name := t.tableName
+		if t.alias != "" {
+			name = t.alias
+		}
+		table := c.tableMap[t.tableName]
+		if table == nil || table.stats == nil {
+			return plan.joins, nil
+		}
+		tables[name] = &joinTable{table, float64(table.stats.numTuples)}
+	}
+	for _, f := range plan.filters {
+		tabName, fieldName, err := f.fieldExpr.getTableField(c, plan.subqueries, plan.tables)
+		if err != nil {
+			return nil, err
+		}
+		t := findJoinTable(tables, plan, tabName)
+		if t == nil {
+			continue
+		}
+		t.card *= filterSelectivity(t.table, fieldName, f)
+	}
+	for _, p := range plan.preds {
+		var predTables = make(map[*joinTable]bool)
+		for _, col := range p.columns() {
+			tabName, _, err := col.getTableField(c, plan.subqueries, plan.tables)
+			if err != nil {
+				return nil, err
+			}
+			if t := findJoinTable(tables, plan, tabName); t != nil {
+				predTables[t] = true
+			}
+		}
+		if len(predTables) == 1 {
+			for t := range predTables {
+				t.card *= defaultSelectivity
+			}
+		}
+	}
+
+	// the tables and distinct values of the join columns of each join
+	type joinSide struct {
+		table    string
+		distinct float64
+	}
+	sides := make([][2]joinSide, n)
+	for i, j := range plan.joins {
+		for k, lsn := range []*LogicalSelectNode{j.left, j.right} {
+			tabName, fieldName, err := lsn.getTableField(c, plan.subqueries, plan.tables)
+			if err != nil {
+				return nil, err
+			}
+			t := findJoinTable(tables, plan, tabName)
+			if t == nil {
+				return plan.joins, nil
+			}
+			for name, t2 := range tables {
+				if t2 == t {
+					sides[i][k].table = name
+				}
+			}
+			sides[i][k].distinct = t.card
+			if field, err := findFieldInTd(FieldType{fieldName, "", UnknownType}, &t.table.desc); err == nil {
+				sides[i][k].distinct = float64(t.table.stats.columns[field].distinct)
+			}
+		}
+		if sides[i][0].table == sides[i][1].table {
+			return plan.joins, nil
+		}
+	}
+
+	best := make(map[int]*joinPlan)
+	for mask := 1; mask < 1<<n; mask++ {
+		for i := 0; i < n; i++ {
+			if mask&(1<<i) == 0 {
+				continue
+			}
+			l, r := sides[i][0], sides[i][1]
+			var p *joinPlan
+			if mask == 1<<i {
+				lCard, rCard := tables[l.table].card, tables[r.table].card
+				card := joinCardinality(lCard, rCard, l.distinct, r.distinct)
+				p = &joinPlan{[]*LogicalJoinNode{plan.joins[i]}, map[string]bool{l.table: true, r.table: true}, card, lCard + rCard + card}
+			} else {
+				sub := best[mask&^(1<<i)]
+				if sub == nil || sub.tables[l.table] == sub.tables[r.table] {
+					continue
+				}
+				if sub.tables[r.table] {
+					l, r = r, l
+				}
+				rCard := tables[r.table].card
+				card := joinCardinality(sub.card, rCard, l.distinct, r.distinct)
+				p = &joinPlan{append(append([]*LogicalJoinNode{}, sub.joins...), plan.joins[i]), map[string]bool{r.table: true}, card, sub.cost + sub.card + rCard + card}
+				for t := range sub.tables {
+					p.tables[t] = true
+				}
+			}
+			if best[mask] == nil || p.cost < best[mask].cost {
+				best[mask] = p
+			}
+		}
+	}
+	if p := best[1<<n-1]; p != nil {
+		return p.joins, nil
+	}
+	return plan.joins, nil
+}
+
+// Return the table of the FROM clause that tabName, as returned by
+// [LogicalSelectNode.getTableField], refers to, or nil if there is none.
+func findJoinTable(tables map[string]*joinTable, plan *LogicalPlan, tabName string) *joinTable {
+	if t, ok := tables[tabName]; ok {
+		return t
+	}
+	// unqualified fields are resolved to table names rather than aliases
+	for _, t := range plan.tables {
+		if t.tableName == tabName && t.alias != "" {
+			return tables[t.alias]
+		}
+	}
+	return nil
+}
+
+// Return the estimated number of tuples of an equality join of inputs with
+// the specified numbers of tuples and distinct join values.
+func joinCardinality(card1 float64, card2 float64, distinct1 float64, distinct2 float64) float64 {
+	if distinct1 > card1 {
+		distinct1 = card1
+	}
+	if distinct2 > card2 {
+		distinct2 = card2
+	}
+	maxDistinct := distinct1
+	if distinct2 > maxDistinct {
+		maxDistinct = distinct2
+	}
+	if maxDistinct < 1 {
+		maxDistinct = 1
+	}
+	return card1 * card2 / maxDistinct
+}
+
+// Return the estimated fraction of the tuples of table that pass filter f on
+// its field fieldName.
+func filterSelectivity(table *Table, fieldName string, f *LogicalFilterNode) float64 {
+	field, err := findFieldInTd(FieldType{fieldName, "", UnknownType}, &table.desc)
+	if err != nil || f.fieldExpr.exprType != ExprField || f.constExpr.exprType != ExprConst || f.constExpr.param != 0 {
+		return defaultSelectivity
+	}
+	var v DBValue = NullField{}
+	if !f.constExpr.null {
+		switch table.desc.Fields[field].Ftype {
+		case IntType:
+			i, err := strconv.ParseInt(f.constExpr.value, 10, 64)
+			if err != nil {
+				return defaultSelectivity
+			}
+			v = IntField{i}
+		case StringType:
+			v = StringField{f.constExpr.value}
+		}
+	}
+	return table.stats.selectivity(field, f.predOp, v)
+}
+
+// Return the descriptor of the tuples returned by applying joins in order to
+// the inputs in tableMap, as [makePhysicalPlan] does.
+func joinedDesc(c *Catalog, plan *LogicalPlan, joins []*LogicalJoinNode, tableMap map[string]*PlanNode) (*TupleDesc, error) {
+	var nodes = make(map[string]*PlanNode)
+	for key, node := range tableMap {
+		nodes[key] = node
+	}
+	var desc *TupleDesc
+	for _, j := range joins {
+		lTabName, lFieldName, err := j.left.getTableField(c, plan.subqueries, plan.tables)
+		if err != nil {
+			return nil, err
+		}
+		node1, err := fieldToOp(lTabName, lFieldName, nodes)
+		if err != nil {
+			return nil, err
+		}
+		rTabName, rFieldName, err := j.right.getTableField(c, plan.subqueries, plan.tables)
+		if err != nil {
+			return nil, err
+		}
+		node2, err := fieldToOp(rTabName, rFieldName, nodes)
+		if err != nil {
+			return nil, err
+		}
+		if node1 == node2 {
+			continue
+		}
+		desc = node1.desc.merge(node2.desc)
+		newNode := &PlanNode{nil, desc}
+		for key, node := range nodes {
+			if node == node1 || node == node2 {
+				nodes[key] = newNode
+			}
+		}
+	}
+	return desc, nil
+}
@@ -0,0 +1,195 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// Key is a PRIMARY KEY or UNIQUE constraint of a table:  no two of its tuples
+// may have the same values of columns, unless one of them is NULL.  It is
+// checked with the index of the same name, on the first of columns.
+type Key struct {
+	name    string
+	table   string
+	columns []string
+	primary bool
+}
+
+// Return the catalog file entry of k, see [parseCatalogFile].
+func (k *Key) String() string {
+	kind := "unique"
+	if k.primary {
+		kind = "primary key"
+	}
+	return kind + " " + k.name + " on " + k.table + " (" + strings.Join(k.columns, ", ") + ")"
+}
+
+// A key of a heap file, as it is checked:  fields are the numbers of the
+// fields of the key, and index is its index.
+type heapKey struct {
+	name   string
+	fields []int
+	index  *BTreeFile
+}
+
+// Return an error if t, which replaces old, or is new if old is nil, has the
+// same values of the fields of a key of f as another tuple of f.
+func (f *HeapFile) checkKeys(t *Tuple, old *Tuple, tid TransactionID) error {
+	for _, key := range f.keys {
+		changed, null := old == nil, false
+		for _, field := range key.fields {
+			null = null || isNull(t.Fields[field])
+			changed = changed || compareDBValue(t.Fields[field], old.Fields[field]) != 0
+		}
+		if null || !changed {
+			continue
+		}
+		iter, err := key.index.RangeIterator(tid, OpEq, t.Fields[key.fields[0]])
+		if err != nil {
+			return err
+		}
+		for {
+			other, err := iter()
+			if err != nil {
+				return err
+			}
+			if other == nil {
+				break
+			}
+			same := old == nil || other.Rid != old.Rid
+			for _, field := range key.fields[1:] {
+				same = same && compareDBValue(t.Fields[field], other.Fields[field]) == 0
+			}
+			if same {
+				var values []string
+				for _, field := range key.fields {
+					values = append(values, fmt.Sprintf("%v", t.Fields[field]))
+				}
+				return GoDBError{DuplicateKeyError, fmt.Sprintf("duplicate value (%s) of key %s", strings.Join(values, ", "), key.name)}
+			}
+		}
+	}
+	return nil
+}
+
+// The key options of the columns sqlparser parses, which it does not export.
+const (
+	columnKeyPrimary   sqlparser.ColumnKeyOption = 1
+	columnKeyUnique    sqlparser.ColumnKeyOption = 3
+	columnKeyUniqueKey sqlparser.ColumnKeyOption = 4
+)
+
+// The prefix of the names [rewriteUniqueKeys] gives keys that have none.
+const unnamedKeyPrefix = "__key"
+
+// sqlparser only parses UNIQUE constraints of a table that are named, so name
+// those that are not, and have a name made up for them by [tableKeys].
+func rewriteUniqueKeys(query string) string {
+	tokens := scanTokens(query)
+	var rewritten strings.Builder
+	last := 0
+	for i, t := range tokens {
+		if t.word(query) != "unique" {
+			continue
+		}
+		next := i + 1
+		if next < len(tokens) && (tokens[next].word(query) == "key" || tokens[next].word(query) == "index") {
+			next++
+		}
+		if next < len(tokens) && tokens[next].typ == '(' {
+			rewritten.WriteString(query[last:tokens[next].start])
+			fmt.Fprintf(&rewritten, "%s%d ", unnamedKeyPrefix, i)
+			last = tokens[next].start
+		}
+	}
+	rewritten.WriteString(query[last:])
+	return rewritten.String()
+}
+
+// Return the keys of the table named tabName that spec declares, and the
+// (non unique) indexes it declares.  Columns of the primary key are not null.
+func tableKeys(tabName string, spec *sqlparser.TableSpec, desc *TupleDesc, notNull []bool) ([]*Key, []*Index, error) {
+	var (
+		keys    []*Key
+		indexes []*Index
+		primary bool
+	)
+	addKey := func(name string, columns []string, isPrimary bool) error {
+		for _, col := range columns {
+			i, err := findFieldInTd(FieldType{col, "", UnknownType}, desc)
+			if err != nil {
+				return err
+			}
+			notNull[i] = notNull[i] || isPrimary
+		}
+		switch {
+		case isPrimary && primary:
+			return GoDBError{ParseError, fmt.Sprintf("table %s has more than one primary key", tabName)}
+		case isPrimary:
+			name, primary = tabName+"_pkey", true
+		case name == "" || strings.HasPrefix(name, unnamedKeyPrefix):
+			name = tabName + "_" + strings.Join(columns, "_") + "_key"
+		}
+		keys = append(keys, &Key{name, tabName, columns, isPrimary})
+		return nil
+	}
+	for _, col := range spec.Columns {
+		name := sqlparser.String(col.Name)
+		var err error
+		switch col.Type.KeyOpt {
+		case columnKeyPrimary:
+			err = addKey("", []string{name}, true)
+		case columnKeyUnique, columnKeyUniqueKey:
+			err = addKey("", []string{name}, false)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, def := range spec.Indexes {
+		var columns []string
+		for _, col := range def.Columns {
+			columns = append(columns, sqlparser.String(col.Column))
+		}
+		name := strings.ToLower(def.Info.Name.String())
+		var err error
+		switch {
+		case def.Info.Primary || def.Info.Unique:
+			err = addKey(name, columns, def.Info.Primary)
+		case len(columns) == 1 && name != "":
+			indexes = append(indexes, &Index{name, tabName, columns[0], nil})
+		default:
+			err = GoDBError{ParseError, fmt.Sprintf("unsupported index %s, indexes must be on one column", sqlparser.String(def))}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return keys, indexes, nil
+}
+
+// Create the indexes of the keys of table, which is empty, and add the keys to
+// it.
+func (c *Catalog) addKeys(table string, keys []*Key) error {
+	t := c.tableMap[table]
+	for _, key := range keys {
+		err := c.createIndex(key.name, table, key.columns[0])
+		if err != nil {
+			return err
+		}
+		t.keys = append(t.keys, key)
+	}
+	return nil
+}
+
+// Return the key of t named, or nil if there is none.
+func (t *Table) findKey(named string) *Key {
+	for _, key := range t.keys {
+		if key.name == named {
+			return key
+		}
+	}
+	return nil
+}
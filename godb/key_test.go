@@ -0,0 +1,149 @@
+package godb
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// Run the insert or update query in a transaction of its own, which is
+// aborted if the query fails.
+func runUpdateQuery(c *Catalog, query string) error {
+	_, plan, err := Parse(c, query)
+	if err != nil {
+		return err
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	iter, err := plan.Iterator(tid)
+	if err == nil {
+		_, err = iter()
+	}
+	if err != nil {
+		c.bp.AbortTransaction(tid)
+		return err
+	}
+	c.bp.CommitTransaction(tid)
+	return nil
+}
+
+func TestKeys(t *testing.T) {
+	c := makeOuterJoinTestCatalog(t)
+	for _, q := range []string{
+		"create table people (id int primary key, name varchar(20) unique, team int, num int, unique (team, num))",
+		"create table pets (id int, owner int, primary key (id), key pets_owner (owner))",
+	} {
+		if _, _, err := Parse(c, q); err != nil {
+			t.Fatalf("%s: %s", q, err.Error())
+		}
+	}
+	expectDuplicate := func(c *Catalog, query string) {
+		err := runUpdateQuery(c, query)
+		if err == nil || err.(GoDBError).code != DuplicateKeyError {
+			t.Errorf("%s: expected a duplicate key error, got %v", query, err)
+		}
+	}
+	for _, q := range []string{
+		"insert into people values (1, 'sam', 1, 1)",
+		"insert into people values (2, 'alex', 1, 2)",
+		"insert into people values (3, null, null, 2)",
+		"insert into people values (4, null, null, 2)",
+		"insert into pets values (1, 1)",
+		"insert into pets values (2, 1)",
+	} {
+		if err := runUpdateQuery(c, q); err != nil {
+			t.Fatalf("%s: %s", q, err.Error())
+		}
+	}
+	expectDuplicate(c, "insert into people values (1, 'kim', 2, 1)")
+	expectDuplicate(c, "insert into people values (5, 'sam', 2, 1)")
+	expectDuplicate(c, "insert into people values (5, 'kim', 1, 2)")
+	expectDuplicate(c, "update people set name = 'sam' where id = 2")
+	expectDuplicate(c, "update people set num = 1 where id = 2")
+	expectDuplicate(c, "insert into pets values (2, 3)")
+	if err := runUpdateQuery(c, "insert into people values (null, 'kim', 2, 1)"); err == nil {
+		t.Errorf("expected the primary key to be not null")
+	}
+	for _, q := range []string{
+		"update people set name = 'sam' where id = 1",
+		"update people set num = 3 where id = 2",
+		"insert into people values (5, 'kim', 1, 2)",
+	} {
+		if err := runUpdateQuery(c, q); err != nil {
+			t.Errorf("%s: %s", q, err.Error())
+		}
+	}
+	if res := firstColumn(t, c, "select id from people"); len(res) != 5 {
+		t.Errorf("expected failed statements to change nothing, got ids %v", res)
+	}
+
+	// keys are also checked when loading CSV files
+	hf, err := c.GetTable("pets")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	csv := c.rootPath + "/pets.csv"
+	if err = os.WriteFile(csv, []byte("3,2\n1,2\n"), 0644); err != nil {
+		t.Fatalf(err.Error())
+	}
+	f, err := os.Open(csv)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer f.Close()
+	err = hf.(*HeapFile).LoadFromCSV(f, false, ",", false)
+	if err == nil || err.(GoDBError).code != DuplicateKeyError {
+		t.Errorf("expected loading a duplicate key to fail, got %v", err)
+	}
+
+	for _, q := range []string{
+		"create table bad (id int primary key, x int, primary key (x))",
+		"create table bad (id int, unique (nosuch))",
+		"create table bad (id int unique, x int, unique key people_pkey (x))",
+		"drop index people_pkey",
+	} {
+		if _, _, err := Parse(c, q); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+	if c.tableMap["bad"] != nil {
+		t.Errorf("expected failed CREATE TABLE statements to create no table")
+	}
+
+	// keys are stored in the catalog file, and changed with their columns
+	err = c.SaveToFile("catalog.txt", c.rootPath)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	c, err = NewCatalogFromFile("catalog.txt", NewBufferPool(50), c.rootPath)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expectDuplicate(c, "insert into people values (6, 'sam', 3, 3)")
+
+	// names of StringLength bytes share their key in the index with longer names
+	long := strings.Repeat("n", StringLength)
+	for _, q := range []string{
+		"insert into people values (10, '" + long + "x', 10, 1)",
+		"insert into people values (11, '" + long + "', 10, 2)",
+	} {
+		if err := runUpdateQuery(c, q); err != nil {
+			t.Errorf("%s: %s", q, err.Error())
+		}
+	}
+	expectDuplicate(c, "insert into people values (12, '"+long+"', 10, 3)")
+	for _, q := range []string{
+		"alter table people rename column team to squad",
+		"alter table people drop column name",
+		"alter table people rename to folks",
+	} {
+		if _, _, err := Parse(c, q); err != nil {
+			t.Fatalf("%s: %s", q, err.Error())
+		}
+	}
+	if err := runUpdateQuery(c, "insert into folks values (6, 3, 3)"); err != nil {
+		t.Errorf("expected the key on the dropped column to be dropped, got %s", err.Error())
+	}
+	expectDuplicate(c, "insert into folks values (6, 4, 4)")
+	expectDuplicate(c, "insert into folks values (7, 3, 3)")
+}
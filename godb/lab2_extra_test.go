@@ -82,9 +82,10 @@ func TestSetDirty(t *testing.T) {
 	_, t1, _, hf, bp, _ := makeTestVars()
 	tid := NewTID()
 	bp.BeginTransaction(tid)
-	for i := 0; i < 308; i++ {
+	slots := 3 * tuplesPerPage(&t1)
+	for i := 0; i < slots+2; i++ {
 		err := hf.insertTuple(&t1, tid)
-		if err != nil && (i == 306 || i == 307) {
+		if err != nil && (i == slots || i == slots+1) {
 			return
 		} else if err != nil {
 			t.Fatalf("%v", err)
@@ -330,7 +331,7 @@ func TestBufferLen(t *testing.T) {
 
 	td, _, _, hf, _, _ := makeTestVars()
 	page := newHeapPage(&td, 0, hf)
-	free := page.getNumSlots()
+	free := tuplesPerPage(&Tuple{Desc: td, Fields: []DBValue{StringField{"sam"}, IntField{0}}})
 
 	for i := 0; i < free-1; i++ {
 		var addition = Tuple{
@@ -0,0 +1,319 @@
+package godb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// LogFile is the write-ahead log used by the BufferPool.  Every change to a
+// heap page is described by a log record that is appended to the log before
+// the page itself may be written back to disk, so the BufferPool is free to
+// evict dirty pages of running transactions (STEAL) and does not need to write
+// pages at commit (NO FORCE).  After a crash, [BufferPool] replays the log in
+// three passes (analysis, redo, undo) to bring the heap files back to a state
+// that contains exactly the changes of committed transactions.
+//
+// The log is a sequence of records following a short file header.  Each record
+// is identified by its LSN, which is the byte offset of the record in the log
+// file.  Records are laid out as:
+//
+//	int32  size of the record in bytes, including this field and the checksum
+//	int8   record type
+//	int64  transaction id
+//	int64  LSN of the previous record of the same transaction (0 if none)
+//	...    type specific payload
+//	uint32 crc32 of all the preceding bytes of the record
+//
+// Records for page changes carry the name of the heap file, the page and slot
+// number and either the serialized tuple or a full image of the page.  Index
+// records carry the image of an index page before a transaction changed it,
+// which is only used to roll the transaction back.  A record that is cut off by
+// a crash fails its checksum and ends the log.
+
+// LSN is the log sequence number of a log record.  LSN 0 is never used by a
+// record, so a page or transaction with LSN 0 has not been logged.
+type LSN int64
+
+type logRecordType int8
+
+const (
+	logInsertRecord    logRecordType = iota
+	logDeleteRecord    logRecordType = iota
+	logPageImageRecord logRecordType = iota
+	logCLRRecord       logRecordType = iota
+	logCommitRecord    logRecordType = iota
+	logAbortRecord     logRecordType = iota
+	logEndRecord       logRecordType = iota
+	logIndexPageRecord logRecordType = iota
+)
+
+var logFileMagic = []byte("GODBLOG1")
+
+type logRecord struct {
+	lsn     LSN
+	size    int // size of the serialized record in bytes
+	kind    logRecordType
+	tid     int64
+	prevLSN LSN
+
+	// set for records that change a page (insert, delete, page image, CLR)
+	file   string
+	pageNo int
+	slot   int
+	data   []byte // the serialized tuple, or the whole page for page images
+
+	// set for CLRs, which redo the change in action and continue the undo of
+	// their transaction at undoNext
+	action   logRecordType
+	undoNext LSN
+}
+
+func (r *logRecord) isPageRecord() bool {
+	switch r.kind {
+	case logInsertRecord, logDeleteRecord, logPageImageRecord, logCLRRecord, logIndexPageRecord:
+		return true
+	}
+	return false
+}
+
+func (r *logRecord) toBuffer() *bytes.Buffer {
+	var body = new(bytes.Buffer)
+	binary.Write(body, binary.LittleEndian, int8(r.kind))
+	binary.Write(body, binary.LittleEndian, r.tid)
+	binary.Write(body, binary.LittleEndian, int64(r.prevLSN))
+	if r.isPageRecord() {
+		binary.Write(body, binary.LittleEndian, int8(r.action))
+		binary.Write(body, binary.LittleEndian, int64(r.undoNext))
+		binary.Write(body, binary.LittleEndian, int16(len(r.file)))
+		body.WriteString(r.file)
+		binary.Write(body, binary.LittleEndian, int32(r.pageNo))
+		binary.Write(body, binary.LittleEndian, int32(r.slot))
+		binary.Write(body, binary.LittleEndian, int32(len(r.data)))
+		body.Write(r.data)
+	}
+
+	var buf = new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, int32(4+body.Len()+4))
+	buf.Write(body.Bytes())
+	binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	return buf
+}
+
+func readLogRecord(data []byte, lsn LSN) (*logRecord, error) {
+	var n = len(data) - 4
+	if crc32.ChecksumIEEE(data[:n]) != binary.LittleEndian.Uint32(data[n:]) {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("bad checksum in log record %d", lsn)}
+	}
+	var buf = bytes.NewBuffer(data[4:n])
+	var (
+		kind    int8
+		prevLSN int64
+	)
+	var rec = logRecord{lsn: lsn, size: len(data)}
+	binary.Read(buf, binary.LittleEndian, &kind)
+	binary.Read(buf, binary.LittleEndian, &rec.tid)
+	err := binary.Read(buf, binary.LittleEndian, &prevLSN)
+	if err != nil {
+		return nil, err
+	}
+	rec.kind = logRecordType(kind)
+	rec.prevLSN = LSN(prevLSN)
+	if !rec.isPageRecord() {
+		return &rec, nil
+	}
+
+	var (
+		action   int8
+		undoNext int64
+		fileLen  int16
+		pageNo   int32
+		slot     int32
+		dataLen  int32
+	)
+	binary.Read(buf, binary.LittleEndian, &action)
+	binary.Read(buf, binary.LittleEndian, &undoNext)
+	binary.Read(buf, binary.LittleEndian, &fileLen)
+	rec.file = string(buf.Next(int(fileLen)))
+	binary.Read(buf, binary.LittleEndian, &pageNo)
+	binary.Read(buf, binary.LittleEndian, &slot)
+	err = binary.Read(buf, binary.LittleEndian, &dataLen)
+	if err != nil {
+		return nil, err
+	}
+	if int(dataLen) != buf.Len() {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("log record %d has a truncated payload", lsn)}
+	}
+	rec.action = logRecordType(action)
+	rec.undoNext = LSN(undoNext)
+	rec.pageNo = int(pageNo)
+	rec.slot = int(slot)
+	rec.data = buf.Bytes()
+	return &rec, nil
+}
+
+type LogFile struct {
+	file *os.File
+	mu   sync.Mutex
+
+	end        LSN // offset at which the next record is appended
+	flushedLSN LSN // records starting before this offset are on disk
+}
+
+// Open the log stored in fileName, creating it if it doesn't exist.  A record
+// at the end of the log that was only partially written before a crash is
+// discarded.
+func NewLogFile(fileName string) (*LogFile, error) {
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	lf := &LogFile{file: file}
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() == 0 {
+		err = lf.truncate()
+		if err != nil {
+			return nil, err
+		}
+		return lf, nil
+	}
+
+	var magic = make([]byte, len(logFileMagic))
+	_, err = file.ReadAt(magic, 0)
+	if err != nil || !bytes.Equal(magic, logFileMagic) {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("%s is not a GoDB log file", fileName)}
+	}
+	lf.end = LSN(len(logFileMagic))
+	iter := lf.iterator()
+	for {
+		rec, err := iter()
+		if err != nil {
+			return nil, err
+		}
+		if rec == nil {
+			break
+		}
+	}
+	// drop anything after the last complete record
+	err = file.Truncate(int64(lf.end))
+	if err != nil {
+		return nil, err
+	}
+	lf.flushedLSN = lf.end
+	return lf, nil
+}
+
+// Append a record to the log, returning its LSN.  The record is not
+// guaranteed to be on disk until [LogFile.force] is called.
+func (lf *LogFile) append(rec *logRecord) (LSN, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	buf := rec.toBuffer()
+	_, err := lf.file.WriteAt(buf.Bytes(), int64(lf.end))
+	if err != nil {
+		return 0, err
+	}
+	rec.lsn = lf.end
+	rec.size = buf.Len()
+	lf.end += LSN(rec.size)
+	return rec.lsn, nil
+}
+
+// Make sure that the record with the given LSN, and all records before it,
+// are on disk.
+func (lf *LogFile) force(lsn LSN) error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lsn < lf.flushedLSN {
+		return nil
+	}
+	err := lf.file.Sync()
+	if err != nil {
+		return err
+	}
+	lf.flushedLSN = lf.end
+	return nil
+}
+
+// Read the record with the given LSN.
+func (lf *LogFile) readRecord(lsn LSN) (*logRecord, error) {
+	var sizeBuf = make([]byte, 4)
+	_, err := lf.file.ReadAt(sizeBuf, int64(lsn))
+	if err != nil {
+		return nil, err
+	}
+	size := int(binary.LittleEndian.Uint32(sizeBuf))
+	if size < 4+17+4 {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("log record %d has invalid size %d", lsn, size)}
+	}
+	var data = make([]byte, size)
+	_, err = lf.file.ReadAt(data, int64(lsn))
+	if err != nil {
+		return nil, err
+	}
+	return readLogRecord(data, lsn)
+}
+
+// Return a function that iterates through the records of the log in LSN
+// order, starting at the first record.  Returns nil, nil after the last
+// complete record.  As a side effect lf.end is left just past that record.
+func (lf *LogFile) iterator() func() (*logRecord, error) {
+	var next = LSN(len(logFileMagic))
+	return func() (*logRecord, error) {
+		rec, err := lf.readRecord(next)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil
+		}
+		if _, ok := err.(GoDBError); ok {
+			// a torn record at the tail of the log
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		next += LSN(rec.size)
+		if next > lf.end {
+			lf.end = next
+		}
+		return rec, nil
+	}
+}
+
+// Return the size of the log in bytes.
+func (lf *LogFile) size() int64 {
+	return int64(lf.end)
+}
+
+// Return true if the log has no records.
+func (lf *LogFile) isEmpty() bool {
+	return lf.end == LSN(len(logFileMagic))
+}
+
+// Discard all records in the log.  Only safe to call once every page changed
+// by a logged record has been written to disk and no transaction is running.
+func (lf *LogFile) truncate() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	err := lf.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = lf.file.WriteAt(logFileMagic, 0)
+	if err != nil {
+		return err
+	}
+	err = lf.file.Sync()
+	if err != nil {
+		return err
+	}
+	lf.end = LSN(len(logFileMagic))
+	lf.flushedLSN = lf.end
+	return nil
+}
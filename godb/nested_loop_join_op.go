@@ -0,0 +1,230 @@
+package godb
+
+// NestedLoopJoin joins its inputs on an arbitrary condition, or returns their
+// cross product if it has none.  It reads its left input in blocks of at most
+// maxBufferSize tuples and scans its right input once per block, so it
+// handles the joins [HashJoin] can't, such as those on < or <>.
+//
+// Outer joins also return the unmatched tuples of the inputs they preserve,
+// padded with NULLs;  the unmatched tuples of the right input are found by
+// scanning it once more after the last block.  Semi and anti joins return the
+// tuples of the left input that match or do not match, and stop scanning the
+// right input for a block once all of its tuples matched, as do mark joins.
+type NestedLoopJoin struct {
+	left, right Operator
+
+	joinType JoinType
+
+	// Condition that joined tuples must satisfy, or nil for a cross product
+	cond *PredExpr
+
+	// For mark joins, the predicate on the joined tuples the mark is the OR
+	// of, or nil if it is true, and the field of the mark
+	mark      *PredExpr
+	markField FieldType
+
+	// The maximum number of tuples of the left input buffered in memory
+	maxBufferSize int
+}
+
+func NewNestedLoopJoin(left Operator, right Operator, joinType JoinType, cond *PredExpr, maxBufferSize int) (*NestedLoopJoin, error) {
+	if maxBufferSize < 1 {
+		return nil, GoDBError{IllegalOperationError, "join buffer must hold at least one tuple"}
+	}
+	return &NestedLoopJoin{left: left, right: right, joinType: joinType, cond: cond, maxBufferSize: maxBufferSize}, nil
+}
+
+// Return a mark join, which returns every tuple of left followed by field,
+// the mark:  the OR of mark over the tuples of right that join with it on
+// cond, in SQL's three valued logic.  The mark is 1 for true, 0 for false and
+// NULL for unknown, so x IN (subquery) is the mark of the subquery joined on
+// x = y, and EXISTS (subquery) the mark of the subquery on a nil predicate.
+func NewMarkJoin(left Operator, right Operator, cond *PredExpr, mark *PredExpr, field FieldType, maxBufferSize int) (*NestedLoopJoin, error) {
+	nl, err := NewNestedLoopJoin(left, right, MarkJoin, cond, maxBufferSize)
+	if err != nil {
+		return nil, err
+	}
+	nl.mark, nl.markField = mark, field
+	return nl, nil
+}
+
+// Return a TupleDescriptor for this join, the fields of the left input
+// followed by those of the right input, or only those of the left input for
+// semi and anti joins, and followed by the mark for mark joins.
+func (nl *NestedLoopJoin) Descriptor() *TupleDesc {
+	if nl.joinType.filtersLeft() {
+		return nl.left.Descriptor().copy()
+	}
+	if nl.joinType == MarkJoin {
+		return nl.left.Descriptor().merge(&TupleDesc{Fields: []FieldType{nl.markField}})
+	}
+	return nl.left.Descriptor().merge(nl.right.Descriptor())
+}
+
+type nestedLoopState int
+
+const (
+	nestedLoopReadBlock      nestedLoopState = iota // read the next block of the left input
+	nestedLoopScan           nestedLoopState = iota // join the block with the right input
+	nestedLoopUnmatchedLeft  nestedLoopState = iota // return the unmatched tuples of the block
+	nestedLoopUnmatchedRight nestedLoopState = iota // return the unmatched tuples of the right input
+	nestedLoopDone           nestedLoopState = iota
+)
+
+// Join operator implementation.  The right input is iterated once per block
+// of the left input, plus once more for right and full outer joins.
+func (nl *NestedLoopJoin) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	leftIter, err := nl.left.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	preservesLeft, preservesRight := nl.joinType.preserves()
+	var (
+		state        = nestedLoopReadBlock
+		leftDone     = false
+		block        []*Tuple
+		blockMatched []bool
+		blockUnknown []bool // the mark of the tuple is unknown, unless it matched
+		numMatched   int    // of the tuples of block
+		rightIter    func() (*Tuple, error)
+		rightTuple   *Tuple
+		rightNo      int
+		rightMatched []bool // by position in the right input, if it is preserved
+		next         int    // position in block of the next tuple to check
+	)
+	var nextRight = func() error {
+		rightTuple, err = rightIter()
+		if err != nil || rightTuple == nil {
+			return err
+		}
+		rightNo++
+		if preservesRight && rightNo >= len(rightMatched) {
+			rightMatched = append(rightMatched, false)
+		}
+		return nil
+	}
+	return func() (*Tuple, error) {
+		for {
+			switch state {
+			case nestedLoopReadBlock:
+				block, blockMatched = nil, nil
+				for !leftDone && len(block) < nl.maxBufferSize {
+					t, err := leftIter()
+					if err != nil {
+						return nil, err
+					}
+					if t == nil {
+						leftDone = true
+						break
+					}
+					block = append(block, t)
+				}
+				if len(block) == 0 {
+					state = nestedLoopDone
+					if preservesRight {
+						state = nestedLoopUnmatchedRight
+						rightIter, err = nl.right.Iterator(tid)
+						if err != nil {
+							return nil, err
+						}
+						rightNo = -1
+					}
+					continue
+				}
+				blockMatched, blockUnknown, numMatched = make([]bool, len(block)), make([]bool, len(block)), 0
+				rightIter, err = nl.right.Iterator(tid)
+				if err != nil {
+					return nil, err
+				}
+				rightNo, rightTuple, next = -1, nil, len(block)
+				state = nestedLoopScan
+			case nestedLoopScan:
+				if next >= len(block) {
+					if nl.joinType.returnsLeftOnce() && numMatched == len(block) {
+						state, next = nestedLoopUnmatchedLeft, 0
+						continue
+					}
+					if err := nextRight(); err != nil {
+						return nil, err
+					}
+					if rightTuple == nil {
+						state, next = nestedLoopUnmatchedLeft, 0
+						continue
+					}
+					next = 0
+				}
+				if nl.joinType.returnsLeftOnce() && blockMatched[next] {
+					next++
+					continue
+				}
+				t := joinTuples(block[next], rightTuple)
+				next++
+				if nl.cond != nil {
+					v, err := nl.cond.eval(t)
+					if err != nil {
+						return nil, err
+					}
+					if v != triTrue {
+						continue
+					}
+				}
+				if nl.mark != nil {
+					v, err := nl.mark.eval(t)
+					if err != nil {
+						return nil, err
+					}
+					if v != triTrue {
+						blockUnknown[next-1] = blockUnknown[next-1] || v == triUnknown
+						continue
+					}
+				}
+				blockMatched[next-1] = true
+				numMatched++
+				if preservesRight {
+					rightMatched[rightNo] = true
+				}
+				switch nl.joinType {
+				case SemiJoin:
+					return block[next-1], nil
+				case AntiJoin, MarkJoin:
+					continue
+				}
+				return t, nil
+			case nestedLoopUnmatchedLeft:
+				for (preservesLeft || nl.joinType == AntiJoin || nl.joinType == MarkJoin) && next < len(block) {
+					next++
+					if nl.joinType == MarkJoin {
+						var mark DBValue = IntField{0}
+						if blockMatched[next-1] {
+							mark = IntField{1}
+						} else if blockUnknown[next-1] {
+							mark = NullField{}
+						}
+						return joinTuples(block[next-1], &Tuple{TupleDesc{Fields: []FieldType{nl.markField}}, []DBValue{mark}, nil}), nil
+					}
+					if blockMatched[next-1] {
+						continue
+					}
+					if nl.joinType == AntiJoin {
+						return block[next-1], nil
+					}
+					return joinTuples(block[next-1], nullTuple(nl.right.Descriptor())), nil
+				}
+				state = nestedLoopReadBlock
+			case nestedLoopUnmatchedRight:
+				if err := nextRight(); err != nil {
+					return nil, err
+				}
+				if rightTuple == nil {
+					state = nestedLoopDone
+					continue
+				}
+				if !rightMatched[rightNo] {
+					return joinTuples(nullTuple(nl.left.Descriptor()), rightTuple), nil
+				}
+			case nestedLoopDone:
+				return nil, nil
+			}
+		}
+	}, nil
+}
@@ -0,0 +1,178 @@
+package godb
+
+import "testing"
+
+func TestNestedLoopJoin(t *testing.T) {
+	c := makeOuterJoinTestCatalog(t)
+	a, _ := c.GetTable("a")
+	b, _ := c.GetTable("b")
+	a.Descriptor().setTableAlias("a")
+	b.Descriptor().setTableAlias("b")
+	aField := &FieldExpr{a.Descriptor().Fields[0]}
+	bField := &FieldExpr{b.Descriptor().Fields[0]}
+	var tests = []struct {
+		joinType JoinType
+		cond     *PredExpr
+		// numbers of results, and of those padded on the left and on the right
+		rows, leftPadded, rightPadded int
+	}{
+		{InnerJoin, nil, 25, 0, 0},
+		{InnerJoin, NewComparePred(aField, OpLt, bField), 11, 0, 0},
+		{InnerJoin, NewComparePred(aField, OpNeq, bField), 12, 0, 0},
+		{LeftOuterJoin, NewComparePred(aField, OpGt, bField), 5, 0, 4},
+		{RightOuterJoin, NewComparePred(aField, OpGt, bField), 5, 4, 0},
+		{FullOuterJoin, NewComparePred(aField, OpGt, bField), 9, 4, 4},
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	defer c.bp.CommitTransaction(tid)
+	for _, bufSize := range []int{100, 2, 1} {
+		for _, test := range tests {
+			join, err := NewNestedLoopJoin(a, b, test.joinType, test.cond, bufSize)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			iter, err := join.Iterator(tid)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			rows, leftPadded, rightPadded := 0, 0, 0
+			for {
+				tup, err := iter()
+				if err != nil {
+					t.Fatalf(err.Error())
+				}
+				if tup == nil {
+					break
+				}
+				rows++
+				if isNull(tup.Fields[1]) {
+					leftPadded++
+				}
+				if isNull(tup.Fields[3]) {
+					rightPadded++
+				}
+			}
+			if rows != test.rows || leftPadded != test.leftPadded || rightPadded != test.rightPadded {
+				t.Errorf("%s join on %v, buffer size %d: got %d results, %d/%d padded; expected %d, %d/%d",
+					test.joinType, test.cond, bufSize, rows, leftPadded, rightPadded, test.rows, test.leftPadded, test.rightPadded)
+			}
+		}
+	}
+	if _, err := NewNestedLoopJoin(a, b, InnerJoin, nil, 0); err == nil {
+		t.Errorf("expected error for an empty join buffer")
+	}
+}
+
+func TestMarkJoin(t *testing.T) {
+	c := makeOuterJoinTestCatalog(t)
+	a, _ := c.GetTable("a")
+	b, _ := c.GetTable("b")
+	a.Descriptor().setTableAlias("a")
+	b.Descriptor().setTableAlias("b")
+	aField := &FieldExpr{a.Descriptor().Fields[0]}
+	bField := &FieldExpr{b.Descriptor().Fields[0]}
+	notNull := NewComparePred(bField, OpIsNotNull, &ConstExpr{NullField{}, UnknownType})
+	var tests = []struct {
+		cond *PredExpr
+		mark *PredExpr
+		// numbers of marks that are 1, 0 and NULL
+		ones, zeros, nulls int
+	}{
+		// b.x has a NULL, so the mark of a.x = 1 is unknown, as is that of
+		// a.x NULL unless b is empty
+		{nil, NewComparePred(aField, OpEq, bField), 3, 0, 2},
+		{notNull, NewComparePred(aField, OpEq, bField), 3, 1, 1},
+		{NewComparePred(aField, OpLt, bField), nil, 4, 1, 0},
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	defer c.bp.CommitTransaction(tid)
+	for _, bufSize := range []int{100, 2, 1} {
+		for _, test := range tests {
+			join, err := NewMarkJoin(a, b, test.cond, test.mark, FieldType{"mark", "b", IntType}, bufSize)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if len(join.Descriptor().Fields) != 3 {
+				t.Fatalf("expected the fields of a and the mark, got %v", join.Descriptor())
+			}
+			iter, err := join.Iterator(tid)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			ones, zeros, nulls := 0, 0, 0
+			for {
+				tup, err := iter()
+				if err != nil {
+					t.Fatalf(err.Error())
+				}
+				if tup == nil {
+					break
+				}
+				switch {
+				case isNull(tup.Fields[2]):
+					nulls++
+				case tup.Fields[2].(IntField).Value == 1:
+					ones++
+				default:
+					zeros++
+				}
+			}
+			if ones != test.ones || zeros != test.zeros || nulls != test.nulls {
+				t.Errorf("mark %v on %v, buffer size %d: got %d/%d/%d marks that are 1/0/NULL; expected %d/%d/%d",
+					test.mark, test.cond, bufSize, ones, zeros, nulls, test.ones, test.zeros, test.nulls)
+			}
+		}
+	}
+}
+
+func TestThetaJoinQuery(t *testing.T) {
+	c := makeOuterJoinTestCatalog(t)
+	var queries = []struct {
+		query string
+		rows  int
+	}{
+		{"select * from a, b", 25},
+		{"select * from a cross join b", 25},
+		{"select * from a, b where a.x < b.x", 11},
+		{"select * from a join b on a.x <> b.x and a.y < b.y", 12},
+		{"select * from a, b where b.x between a.x and a.x + 1", 10},
+		{"select * from a, b where b.x not between a.x and a.x + 1", 6},
+		{"select * from a left join b on a.x > b.x", 5},
+		{"select * from a, b, a a2 where a.x = b.x and a2.x < b.x", 8},
+		{"select * from a where a.x between 2 and 3", 3},
+	}
+	for _, q := range queries {
+		if res := runTestQuery(t, c, q.query); len(res) != q.rows {
+			t.Errorf("%s: expected %d results, got %d", q.query, q.rows, len(res))
+		}
+	}
+	_, plan, err := Parse(c, "select * from a, b where a.x < b.x")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	join, ok := plan.(*NestedLoopJoin)
+	if !ok || join.cond == nil {
+		t.Errorf("expected a nested loop join on a.x < b.x")
+	}
+	if !plan.Descriptor().equals(&TupleDesc{[]FieldType{{"x", "a", IntType}, {"y", "a", IntType}, {"x", "b", IntType}, {"y", "b", IntType}}}) {
+		t.Errorf("wrong columns %v", plan.Descriptor())
+	}
+
+	// the left input is read in blocks of JoinBufferSize tuples
+	defer func(n int) { JoinBufferSize = n }(JoinBufferSize)
+	JoinBufferSize = 2
+	_, plan, err = Parse(c, "select * from a, b where a.x < b.x")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if join, ok := plan.(*NestedLoopJoin); !ok || join.maxBufferSize != 2 {
+		t.Errorf("expected a nested loop join with blocks of 2 tuples")
+	}
+	for _, q := range queries {
+		if res := runTestQuery(t, c, q.query); len(res) != q.rows {
+			t.Errorf("%s with blocks of 2 tuples: expected %d results, got %d", q.query, q.rows, len(res))
+		}
+	}
+}
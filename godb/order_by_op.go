@@ -1,14 +1,20 @@
 package godb
 
-import "sort"
-
 type OrderBy struct {
 	orderBy []Expr // OrderBy should include these two fields (used by parser)
 	child   Operator
 	//add additional fields here
 	ascending []bool
+
+	// The maximum number of tuples the sort keeps in memory
+	maxBufferSize int
 }
 
+// Maximum number of tuples an [OrderBy] sorts in memory before it writes
+// sorted runs to temporary files (see [externalSort]).  Used by the OrderBy
+// operators created after it is set.
+var SortBufferSize int = 100000
+
 // Order by constructor -- should save the list of field, child, and ascending
 // values for use in the Iterator() method. Here, orderByFields is a list of
 // expressions that can be extacted from the child operator's tuples, and the
@@ -19,6 +25,7 @@ func NewOrderBy(orderByFields []Expr, child Operator, ascending []bool) (*OrderB
 	ob.child = child
 	ob.orderBy = orderByFields
 	ob.ascending = ascending
+	ob.maxBufferSize = SortBufferSize
 	return &ob, nil
 }
 
@@ -26,24 +33,14 @@ func (o *OrderBy) Descriptor() *TupleDesc {
 	return o.child.Descriptor()
 }
 
-// Return a function that iterators through the results of the child iterator in
-// ascending/descending order, as specified in the construtor.  This sort is
-// "blocking" -- it should first construct an in-memory sorted list of results
-// to return, and then iterate through them one by one on each subsequent
-// invocation of the iterator function.
-//
-// Although you are free to implement your own sorting logic, you may wish to
-// leverage the go sort pacakge and the [sort.Sort] method for this purpose.  To
-// use this you will need to implement three methods:  Len, Swap, and Less that
-// the sort algorithm will invoke to preduce a sorted list. See the first
-// example, example of SortMultiKeys, and documentation at: https://pkg.go.dev/sort
-
 type TupleSorted struct {
 	Tup           *Tuple
 	Asc           *[]bool
 	CmpFieldsExpr *[]Expr
 }
 
+// TupleSortedSlice sorts tuples with [sort.Sort] by the values of a list of
+// expressions, ascending or descending as specified for each expression.
 type TupleSortedSlice []TupleSorted
 
 func (t TupleSortedSlice) Len() int {
@@ -55,62 +52,56 @@ func (t TupleSortedSlice) Swap(i, j int) {
 }
 
 func (t TupleSortedSlice) Less(a, b int) bool {
-	tupI := t[a].Tup
-	tupJ := t[b].Tup
-	asc := t[a].Asc
-	cmpFieldsExpr := t[a].CmpFieldsExpr
-	for i, cmpExpr := range *cmpFieldsExpr {
+	return tupleLess(t[a].Tup, t[b].Tup, *t[a].CmpFieldsExpr, *t[a].Asc)
+}
+
+// Return true if tupI sorts before tupJ when ordered by the values of
+// cmpFieldsExpr, ascending or descending as specified by asc.
+func tupleLess(tupI *Tuple, tupJ *Tuple, cmpFieldsExpr []Expr, asc []bool) bool {
+	for i, cmpExpr := range cmpFieldsExpr {
 		left, _ := cmpExpr.EvalExpr(tupI)
 		right, _ := cmpExpr.EvalExpr(tupJ)
 		var leftGreater bool
 		var equal bool
-		switch curType := left.(type) {
-		case IntField:
-			leftGreater = evalPred(curType.Value, right.(IntField).Value, OpGt)
-			equal = evalPred(curType.Value, right.(IntField).Value, OpEq)
-		case StringField:
-			leftGreater = evalPred(curType.Value, right.(StringField).Value, OpGt)
-			equal = evalPred(curType.Value, right.(StringField).Value, OpEq)
+		if isNull(left) || isNull(right) {
+			// NULLs sort first
+			leftGreater = compareDBValue(left, right) > 0
+			equal = compareDBValue(left, right) == 0
+		} else {
+			switch curType := left.(type) {
+			case IntField:
+				leftGreater = evalPred(curType.Value, right.(IntField).Value, OpGt)
+				equal = evalPred(curType.Value, right.(IntField).Value, OpEq)
+			case StringField:
+				leftGreater = evalPred(curType.Value, right.(StringField).Value, OpGt)
+				equal = evalPred(curType.Value, right.(StringField).Value, OpEq)
+			}
 		}
 		// If the two values are equal, then we need to compare the next field
 		if equal {
 			continue
 		}
 		if leftGreater {
-			return !(*asc)[i]
+			return !asc[i]
 		} else if !leftGreater {
-			return (*asc)[i]
+			return asc[i]
 		}
 	}
 	// if we get here, then the tuples are equal
 	return false
 }
 
+// Return a function that iterators through the results of the child iterator in
+// ascending/descending order, as specified in the construtor.  This sort is
+// "blocking" -- the child is sorted with [externalSort] before the first tuple
+// is returned, in memory if it has at most maxBufferSize tuples, and in sorted
+// runs that are merged otherwise.
 func (o *OrderBy) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 	iter, err := o.child.Iterator(tid)
 	if err != nil {
 		return nil, err
 	}
-	var sortedTuples TupleSortedSlice
-	for {
-		tup, err := iter()
-		if err != nil {
-			return nil, err
-		}
-		if tup == nil {
-			break
-		}
-		sortedTuples = append(sortedTuples, TupleSorted{tup, &o.ascending, &o.orderBy})
-	}
-
-	sort.Sort(sortedTuples)
-	index := 0
-	return func() (*Tuple, error) {
-		if index >= len(sortedTuples) {
-			return nil, nil
-		}
-		tup := sortedTuples[index].Tup
-		index++
-		return tup, nil
-	}, nil
+	return externalSort(tid, iter, func(a, b *Tuple) bool {
+		return tupleLess(a, b, o.orderBy, o.ascending)
+	}, o.maxBufferSize)
 }
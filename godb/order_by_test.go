@@ -1,6 +1,7 @@
 package godb
 
 import (
+	"fmt"
 	"os"
 	"testing"
 )
@@ -160,3 +161,48 @@ func TestMultiFieldOrderBy(t *testing.T) {
 	bp.CommitTransaction(tid)
 
 }
+
+// With a small buffer, the sort spills runs that take more than one pass to merge.
+func TestExternalOrderBy(t *testing.T) {
+	td, _, _, hf, _, tid := makeTestVars()
+	const n = 300
+	for i := 0; i < n; i++ {
+		tup := Tuple{td, []DBValue{StringField{fmt.Sprintf("sam%d", i)}, IntField{int64(i * 7919 % n)}}, nil}
+		if i%50 == 0 {
+			tup.Fields[1] = NullField{}
+		}
+		err := hf.insertTuple(&tup, tid)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	defer func(n int) { SortBufferSize = n }(SortBufferSize)
+	SortBufferSize = 10
+	oby, err := NewOrderBy([]Expr{&FieldExpr{td.Fields[1]}}, hf, []bool{true})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	iter, err := oby.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var last DBValue = NullField{}
+	cnt := 0
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if tup == nil {
+			break
+		}
+		if compareDBValue(tup.Fields[1], last) < 0 {
+			t.Fatalf("%v returned after %v", tup.Fields[1], last)
+		}
+		last = tup.Fields[1]
+		cnt++
+	}
+	if cnt != n {
+		t.Errorf("expected %d tuples, got %d", n, cnt)
+	}
+}
@@ -2,10 +2,11 @@ package godb
 
 import (
 	"fmt"
+	"path/filepath"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
-	"unsafe"
 
 	"github.com/xwb1989/sqlparser"
 )
@@ -16,19 +17,37 @@ type LogicalFilterNode struct {
 	predOp    BoolOp
 }
 
+// An equality of columns of two tables, or, if left and right are nil, a join
+// of the tables of a FROM clause on a condition without one.
 type LogicalJoinNode struct {
 	left, right *LogicalSelectNode
 	predOp      BoolOp
+	joinType    JoinType
+	cond        []*LogicalPredNode // the other terms of the ON clause
+	nullable    []string           // the tables outer joins pad with NULLs
+	sides       [2]string          // a table of each side of the FROM clause join
+	pad         []DBValue          // if set, what outer joins pad the right side with instead
+}
+
+// A term of a WHERE clause that is neither a filter nor a join:  a comparison
+// of two arbitrary expressions, or the AND, OR or NOT of other predicates.
+type LogicalPredNode struct {
+	predType    PredType
+	left, right *LogicalSelectNode // operands of comparisons
+	predOp      BoolOp
+	args        []*LogicalPredNode   // operands of AND, OR and NOT
+	subquery    *LogicalSubqueryNode // of EXISTS if left is nil, else of left IN
 }
 
 type SelectExprType int
 
 const (
-	ExprField SelectExprType = iota
-	ExprConst SelectExprType = iota
-	ExprFunc  SelectExprType = iota
-	ExprStar  SelectExprType = iota
-	ExprAggr  SelectExprType = iota
+	ExprField  SelectExprType = iota
+	ExprConst  SelectExprType = iota
+	ExprFunc   SelectExprType = iota
+	ExprStar   SelectExprType = iota
+	ExprAggr   SelectExprType = iota
+	ExprWindow SelectExprType = iota
 )
 
 type LogicalSelectNode struct {
@@ -38,8 +57,13 @@ type LogicalSelectNode struct {
 	funcOp      *string //may be nil, if no aggregate
 	alias       string
 	value       string
+	null        bool                 //for constants, true if the constant is NULL
 	args        []*LogicalSelectNode //for functions other than aggregates
 	cachedField *FieldType
+	subquery    *LogicalSubqueryNode //for fields that are the value of a subquery
+	window      *LogicalWindowNode   //for window functions
+	preds       []*LogicalPredNode   //for CASE, its WHEN conditions, and for predicates used as values, the predicate
+	param       int                  //for placeholders of prepared statements, their number
 }
 
 func NewFieldSelectNode(table string, field string, alias string) LogicalSelectNode {
@@ -57,6 +81,16 @@ func NewConstSelectNode(value string, alias string) LogicalSelectNode {
 	lsn.alias = alias
 	return lsn
 }
+func NewParamSelectNode(n int, alias string) LogicalSelectNode {
+	lsn := NewConstSelectNode(fmt.Sprintf("$%d", n), alias)
+	lsn.param = n
+	return lsn
+}
+func NewNullSelectNode(alias string) LogicalSelectNode {
+	lsn := NewConstSelectNode("null", alias)
+	lsn.null = true
+	return lsn
+}
 func NewStarSelectNode(table string) LogicalSelectNode {
 	lsn := LogicalSelectNode{}
 	lsn.exprType = ExprStar
@@ -113,6 +147,52 @@ func checkNameInTablesOrSubqueries(table string, field string, c *Catalog, subqu
 	return table, nil
 }
 
+// The functions that are not in the funcs table, but generated by
+// [LogicalSelectNode.generateCondExpr].
+var condFuncs = map[string]bool{
+	"coalesce": true,
+	"nullif":   true,
+	"cast":     true,
+}
+
+// Return the expression for a CASE, COALESCE, NULLIF, CAST or predicate used
+// as a value, whose arguments are args.
+func (s *LogicalSelectNode) generateCondExpr(c *Catalog, args []Expr, inputDesc *TupleDesc, tableMap map[string]*PlanNode) (Expr, error) {
+	var preds []*PredExpr
+	for _, p := range s.preds {
+		pred, err := p.generatePred(c, inputDesc, tableMap)
+		if err != nil {
+			return nil, err
+		}
+		preds = append(preds, pred)
+	}
+	switch *s.funcOp {
+	case "case":
+		var elseExpr Expr
+		if len(args) > len(preds) {
+			elseExpr = args[len(preds)]
+		}
+		return NewCaseExpr(preds, args[:len(preds)], elseExpr)
+	case "coalesce":
+		if len(args) == 0 {
+			return nil, GoDBError{ParseError, "COALESCE expects at least one argument"}
+		}
+		return NewCoalesceExpr(args)
+	case "nullif":
+		if len(args) != 2 {
+			return nil, GoDBError{ParseError, "NULLIF expects two arguments"}
+		}
+		// NULLIF(a, b) is CASE WHEN a = b THEN NULL ELSE a END
+		return NewCaseExpr([]*PredExpr{NewComparePred(args[0], OpEq, args[1])}, []Expr{&ConstExpr{NullField{}, UnknownType}}, args[0])
+	case "cast":
+		if s.value == "string" {
+			return NewCastExpr(args[0], StringType), nil
+		}
+		return NewCastExpr(args[0], IntType), nil
+	}
+	return NewBoolExpr(preds[0]), nil
+}
+
 // need to figure out which table & field this expression references, if any
 // if catalog is non null, will try to resolve table name from catalog
 // otherwise, will not
@@ -120,10 +200,10 @@ func (lsn *LogicalSelectNode) getTableField(c *Catalog, subqueries []*LogicalPla
 	if lsn.exprType == ExprConst {
 		return "", "", nil
 	}
-	if lsn.exprType == ExprFunc || lsn.exprType == ExprAggr {
+	if lsn.exprType == ExprFunc || lsn.exprType == ExprAggr || lsn.exprType == ExprWindow {
 		tabName := ""
 		fieldName := ""
-		for _, subLsn := range lsn.args {
+		for _, subLsn := range lsn.operands() {
 			newTabName, newFieldName, err := subLsn.getTableField(c, subqueries, ts)
 			if err != nil {
 				return "", "", err
@@ -183,66 +263,346 @@ type LogicalPlan struct {
 	limit         *LogicalSelectNode
 	distinct      bool
 	alias         string
+	preds         []*LogicalPredNode
+	having        *LogicalPredNode
+	semiJoins     []*LogicalSemiJoinNode
+	markPreds     []*LogicalPredNode // predicates on the marks of mark joins
+	hidden        bool               // a subquery used as a value, left out of SELECT *
+	scalar        bool               // a subquery used as a value that returns one tuple
+	tableUses     map[string]int     // of each table in the whole statement
+	windows       []*LogicalSelectNode
+	physical      Operator // if set, the plan of the subquery, which is planned already
 }
 
 func (p *LogicalPlan) getSubplanFields(c *Catalog) []*FieldType {
 	var nodes []*FieldType
 	for _, s := range p.selects {
 		_, field, _ := s.getTableField(c, p.subqueries, p.tables)
+		if s.alias != "" { // getTableField names aggregates by their argument
+			field = s.alias
+		}
 		nodes = append(nodes, &FieldType{field, p.alias, UnknownType})
 	}
 	return nodes
 }
 
-func parseWhere(c *Catalog, subqueries []*LogicalPlan, ts []*LogicalTableNode, expr sqlparser.Expr) ([]*LogicalFilterNode, []*LogicalJoinNode, error) {
+// Parse a WHERE clause into the filters, joins and other predicates that must
+// all be true.  Conjunctions are split into their terms, so that comparisons
+// of a column with a constant become filters and equalities of columns of two
+// tables become joins, whatever the rest of the clause is.
+func parseWhere(c *Catalog, subqueries []*LogicalPlan, ts []*LogicalTableNode, expr sqlparser.Expr) ([]*LogicalFilterNode, []*LogicalJoinNode, []*LogicalPredNode, error) {
 	switch expr := expr.(type) {
 	case *sqlparser.AndExpr:
-		//print("got and")
-		filterListLeft, joinListLeft, _ := parseWhere(c, subqueries, ts, expr.Left)
-		filterListRight, joinListRight, _ := parseWhere(c, subqueries, ts, expr.Right)
+		filterListLeft, joinListLeft, predListLeft, err := parseWhere(c, subqueries, ts, expr.Left)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		filterListRight, joinListRight, predListRight, err := parseWhere(c, subqueries, ts, expr.Right)
+		if err != nil {
+			return nil, nil, nil, err
+		}
 		filterExprs := append(filterListLeft, filterListRight...)
 		joinExprs := append(joinListLeft, joinListRight...)
-		return filterExprs, joinExprs, nil
+		predExprs := append(predListLeft, predListRight...)
+		return filterExprs, joinExprs, predExprs, nil
+	case *sqlparser.ParenExpr:
+		return parseWhere(c, subqueries, ts, expr.Expr)
+	case *sqlparser.RangeCond:
+		if expr.Operator == sqlparser.BetweenStr {
+			return parseWhere(c, subqueries, ts, rangeToExpr(expr))
+		}
 	case *sqlparser.ComparisonExpr:
-		op := BoolOpMap[expr.Operator]
-		//print(op)
-		//print("got compare")
-
+		if expr.Operator == sqlparser.InStr || expr.Operator == sqlparser.NotInStr {
+			if list, ok := expr.Right.(sqlparser.ValTuple); ok {
+				return parseWhere(c, subqueries, ts, inListToExpr(expr, list))
+			}
+			break // a subquery
+		}
+		op, ok := BoolOpMap[expr.Operator]
+		if !ok {
+			return nil, nil, nil, GoDBError{ParseError, fmt.Sprintf("unsupported predicate %s", expr.Operator)}
+		}
 		left, err := parseExpr(c, expr.Left, "")
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		right, err := parseExpr(c, expr.Right, "")
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		//here we want to search the catalog for the table id, if it's not specified
-		lTable, _, err := left.getTableField(c, subqueries, ts)
+		lTable, lField, err := left.getTableField(c, subqueries, ts)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
-		rTable, _, err := right.getTableField(c, subqueries, ts)
+		rTable, rField, err := right.getTableField(c, subqueries, ts)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
+		}
+		lColumn := lTable != "" || lField != ""
+		rColumn := rTable != "" || rField != ""
+		if flipped, ok := flipOp(op); ok && !lColumn && rColumn {
+			left, right, op = right, left, flipped
+			lTable, rTable = rTable, lTable
+			lColumn, rColumn = rColumn, lColumn
+		}
+		switch {
+		case lTable != "" && rTable != "" && lTable != rTable && op == OpEq: //join
+			join := LogicalJoinNode{left: left, right: right, predOp: op}
+			return nil, []*LogicalJoinNode{&join}, nil, nil
+		case lColumn && !rColumn: //filter
+			filter := LogicalFilterNode{*left, *right, op}
+			return []*LogicalFilterNode{&filter}, nil, nil, nil
+		}
+	case *sqlparser.IsExpr:
+		op, ok := BoolOpMap[expr.Operator]
+		if !ok {
+			return nil, nil, nil, GoDBError{ParseError, fmt.Sprintf("unsupported predicate %s", expr.Operator)}
 		}
-		if lTable != "" && rTable != "" && lTable != rTable { //join
+		left, err := parseExpr(c, expr.Expr, "")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		filter := LogicalFilterNode{*left, NewNullSelectNode(""), op}
+		return []*LogicalFilterNode{&filter}, nil, nil, nil
+	}
+	pred, err := parsePred(c, expr)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return nil, nil, []*LogicalPredNode{pred}, nil
+}
 
-			if op != OpEq {
-				return nil, nil, GoDBError{IllegalOperationError, "only equality joins are supported"}
-			}
-			join := LogicalJoinNode{left, right, op}
-			lj := make([]*LogicalJoinNode, 1)
-			lj[0] = &join
-			return nil, lj, nil
+// Parse a boolean expression of a WHERE clause into a predicate.
+func parsePred(c *Catalog, expr sqlparser.Expr) (*LogicalPredNode, error) {
+	switch expr := expr.(type) {
+	case *sqlparser.AndExpr, *sqlparser.OrExpr:
+		var predType = PredAnd
+		var l, r sqlparser.Expr
+		if and, ok := expr.(*sqlparser.AndExpr); ok {
+			l, r = and.Left, and.Right
 		} else {
-			filter := LogicalFilterNode{*left, *right, op}
-			lf := make([]*LogicalFilterNode, 1)
-			lf[0] = &filter
-			return lf, nil, nil
+			or := expr.(*sqlparser.OrExpr)
+			predType, l, r = PredOr, or.Left, or.Right
 		}
-	default:
-		return nil, nil, GoDBError{ParseError, "where expression with non value or column on RHS (disjunctions and nested where expressions are not supported)"}
+		left, err := parsePred(c, l)
+		if err != nil {
+			return nil, err
+		}
+		right, err := parsePred(c, r)
+		if err != nil {
+			return nil, err
+		}
+		return &LogicalPredNode{predType: predType, args: []*LogicalPredNode{left, right}}, nil
+	case *sqlparser.NotExpr:
+		arg, err := parsePred(c, expr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return &LogicalPredNode{predType: PredNot, args: []*LogicalPredNode{arg}}, nil
+	case *sqlparser.ParenExpr:
+		return parsePred(c, expr.Expr)
+	case *sqlparser.RangeCond:
+		return parsePred(c, rangeToExpr(expr))
+	case *sqlparser.ExistsExpr:
+		sq, err := newSubqueryNode(expr.Subquery)
+		if err != nil {
+			return nil, err
+		}
+		return &LogicalPredNode{predType: PredCompare, subquery: sq}, nil
+	case *sqlparser.ComparisonExpr:
+		if expr.Operator == sqlparser.InStr || expr.Operator == sqlparser.NotInStr {
+			return parseIn(c, expr)
+		}
+		op, ok := BoolOpMap[expr.Operator]
+		if !ok {
+			return nil, GoDBError{ParseError, fmt.Sprintf("unsupported predicate %s", expr.Operator)}
+		}
+		left, err := parseExpr(c, expr.Left, "")
+		if err != nil {
+			return nil, err
+		}
+		right, err := parseExpr(c, expr.Right, "")
+		if err != nil {
+			return nil, err
+		}
+		return &LogicalPredNode{predType: PredCompare, left: left, right: right, predOp: op}, nil
+	case *sqlparser.IsExpr:
+		op, ok := BoolOpMap[expr.Operator]
+		if !ok {
+			return nil, GoDBError{ParseError, fmt.Sprintf("unsupported predicate %s", expr.Operator)}
+		}
+		left, err := parseExpr(c, expr.Expr, "")
+		if err != nil {
+			return nil, err
+		}
+		right := NewNullSelectNode("")
+		return &LogicalPredNode{predType: PredCompare, left: left, right: &right, predOp: op}, nil
+	}
+	return nil, GoDBError{ParseError, fmt.Sprintf("unsupported where expression %s", sqlparser.String(expr))}
+}
+
+// Return the comparisons x BETWEEN a AND b stands for, x >= a AND x <= b, or
+// their negation for NOT BETWEEN.
+func rangeToExpr(expr *sqlparser.RangeCond) sqlparser.Expr {
+	var and sqlparser.Expr = &sqlparser.AndExpr{
+		Left:  &sqlparser.ComparisonExpr{Operator: sqlparser.GreaterEqualStr, Left: expr.Left, Right: expr.From},
+		Right: &sqlparser.ComparisonExpr{Operator: sqlparser.LessEqualStr, Left: expr.Left, Right: expr.To},
+	}
+	if expr.Operator == sqlparser.NotBetweenStr {
+		return &sqlparser.NotExpr{Expr: and}
+	}
+	return and
+}
+
+// Return the operator that compares b with a as op compares a with b, if
+// there is one.
+func flipOp(op BoolOp) (BoolOp, bool) {
+	switch op {
+	case OpGt:
+		return OpLt, true
+	case OpLt:
+		return OpGt, true
+	case OpGe:
+		return OpLe, true
+	case OpLe:
+		return OpGe, true
+	case OpEq, OpNeq:
+		return op, true
+	}
+	return op, false
+}
+
+// Return the columns the comparisons of the predicate refer to, other than the
+// arguments of aggregates.
+func (p *LogicalPredNode) columns() []*LogicalSelectNode {
+	var cols []*LogicalSelectNode
+	for _, lsn := range []*LogicalSelectNode{p.left, p.right} {
+		if lsn != nil {
+			cols = append(cols, lsn.columns()...)
+		}
+	}
+	for _, arg := range p.args {
+		cols = append(cols, arg.columns()...)
+	}
+	return cols
+}
+
+// Return the columns the expression refers to, other than the arguments of
+// aggregates.
+func (lsn *LogicalSelectNode) columns() []*LogicalSelectNode {
+	switch lsn.exprType {
+	case ExprField:
+		return []*LogicalSelectNode{lsn}
+	case ExprFunc:
+		var cols []*LogicalSelectNode
+		for _, arg := range lsn.operands() {
+			cols = append(cols, arg.columns()...)
+		}
+		return cols
+	}
+	return nil
+}
+
+// Return the arguments of the expression, and the operands of the
+// comparisons of its predicates.
+func (lsn *LogicalSelectNode) operands() []*LogicalSelectNode {
+	if lsn.preds == nil {
+		return lsn.args
+	}
+	ops := append([]*LogicalSelectNode{}, lsn.args...)
+	for _, p := range lsn.preds {
+		ops = append(ops, p.operands()...)
+	}
+	return ops
+}
+
+// Return the operands of the comparisons of the predicate.
+func (p *LogicalPredNode) operands() []*LogicalSelectNode {
+	var ops []*LogicalSelectNode
+	for _, lsn := range []*LogicalSelectNode{p.left, p.right} {
+		if lsn != nil {
+			ops = append(ops, lsn)
+		}
+	}
+	for _, arg := range p.args {
+		ops = append(ops, arg.operands()...)
+	}
+	return ops
+}
+
+// Return the aggregates of the predicate.
+func (p *LogicalPredNode) aggs() []*LogicalSelectNode {
+	var aggs []*LogicalSelectNode
+	for _, lsn := range []*LogicalSelectNode{p.left, p.right} {
+		if lsn != nil {
+			aggs = append(aggs, extractAggs(lsn)...)
+		}
+	}
+	for _, arg := range p.args {
+		aggs = append(aggs, arg.aggs()...)
+	}
+	return aggs
+}
+
+// Return the inputs in tableMap that the columns of the predicate are in.
+func (p *LogicalPredNode) inputs(c *Catalog, plan *LogicalPlan, tableMap map[string]*PlanNode) (map[*PlanNode]bool, error) {
+	var nodes = make(map[*PlanNode]bool)
+	for _, col := range p.columns() {
+		tabName, fieldName, err := col.getTableField(c, plan.subqueries, plan.tables)
+		if err != nil {
+			return nil, err
+		}
+		node, err := fieldToOp(tabName, fieldName, tableMap)
+		if err != nil {
+			return nil, err
+		}
+		nodes[node] = true
+	}
+	return nodes, nil
+}
+
+// Return whether any column of the predicate is of one of the named tables.
+func (p *LogicalPredNode) refersTo(c *Catalog, plan *LogicalPlan, names map[string]bool) (bool, error) {
+	for _, col := range p.columns() {
+		tabName, _, err := col.getTableField(c, plan.subqueries, plan.tables)
+		if err != nil {
+			return false, err
+		}
+		if names[tabName] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Return the physical predicate for tuples with descriptor inputDesc.
+func (p *LogicalPredNode) generatePred(c *Catalog, inputDesc *TupleDesc, tableMap map[string]*PlanNode) (*PredExpr, error) {
+	if p.subquery != nil {
+		return nil, GoDBError{ParseError, "IN and EXISTS subqueries are only supported as terms of the WHERE clause of a SELECT"}
+	}
+	if p.predType == PredCompare {
+		left, _, err := p.left.generateExpr(c, inputDesc, tableMap)
+		if err != nil {
+			return nil, err
+		}
+		right, _, err := p.right.generateExpr(c, inputDesc, tableMap)
+		if err != nil {
+			return nil, err
+		}
+		c.typeParam(left, right.GetExprType().Ftype)
+		c.typeParam(right, left.GetExprType().Ftype)
+		return NewComparePred(left, p.predOp, right), nil
+	}
+	var args []*PredExpr
+	for _, arg := range p.args {
+		pred, err := arg.generatePred(c, inputDesc, tableMap)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, pred)
 	}
+	return &PredExpr{predType: p.predType, args: args}, nil
 }
 
 func parseFrom(c *Catalog, t sqlparser.TableExpr) ([]*LogicalTableNode, []*LogicalPlan, []*LogicalJoinNode, error) {
@@ -266,7 +626,29 @@ func parseFrom(c *Catalog, t sqlparser.TableExpr) ([]*LogicalTableNode, []*Logic
 		case sqlparser.SimpleTableExpr:
 			tableName := strings.ToLower(sqlparser.GetTableName(tableEx.Expr).CompliantName())
 			//fmt.Printf("got simple table, name %s\n", tableName)
+			cte, ok := c.ctes[tableName]
+			if !ok && c.refs != nil {
+				c.refs[tableName] = true
+			}
+			if v := c.findView(tableName); !ok && v != nil {
+				cte, ok = c.viewCTE(v), true
+			}
+			if ok {
+				alias := strings.ToLower(sqlparser.String(tableEx.As))
+				if alias == "" {
+					alias = tableName
+				}
+				subplan, err := cte.subplan(alias)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				return nil, []*LogicalPlan{subplan}, nil, nil
+			}
 			dbFile, err := c.GetTable(tableName)
+			if err != nil && tableName == "dual" {
+				// a SELECT without a FROM clause, which has no inputs
+				return nil, nil, nil, nil
+			}
 			if err != nil {
 				return nil, nil, nil, err
 			}
@@ -304,12 +686,25 @@ func parseFrom(c *Catalog, t sqlparser.TableExpr) ([]*LogicalTableNode, []*Logic
 		if err != nil {
 			return nil, nil, nil, err
 		}
-		if joinTable.Join != "join" {
+		joinType, ok := joinTypes[joinTable.Join]
+		if !ok {
 			return nil, nil, nil, GoDBError{ParseError, fmt.Sprintf("unsupported join type %s", joinTable.Join)}
 		}
 		tabList := append(leftTables, rightTables...)
 		subPlanList := append(leftSubplans, rightSubplans...)
-		_, joins, err := parseWhere(c, subPlanList, tabList, joinTable.Condition.On)
+		var (
+			filters []*LogicalFilterNode
+			joins   []*LogicalJoinNode
+			preds   []*LogicalPredNode
+		)
+		if joinTable.Condition.On != nil { // CROSS JOIN has none
+			filters, joins, preds, err = parseWhere(c, subPlanList, tabList, joinTable.Condition.On)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		sides := [2]string{fromOrder(leftTables, leftSubplans)[0], fromOrder(rightTables, rightSubplans)[0]}
+		joins, err = parseJoinCondition(c, subPlanList, tabList, fromNames(leftTables, leftSubplans), fromNames(rightTables, rightSubplans), sides, joinType, filters, joins, preds)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -319,6 +714,97 @@ func parseFrom(c *Catalog, t sqlparser.TableExpr) ([]*LogicalTableNode, []*Logic
 	return nil, nil, nil, GoDBError{ParseError, "unknown query type in parseFrom"}
 }
 
+// The joins of a FROM clause by their sqlparser names.  sqlparser does not
+// parse FULL OUTER JOIN, so Parse rewrites it to STRAIGHT_JOIN, which GoDB
+// does not otherwise support.
+var joinTypes = map[string]JoinType{
+	sqlparser.JoinStr:         InnerJoin,
+	sqlparser.LeftJoinStr:     LeftOuterJoin,
+	sqlparser.RightJoinStr:    RightOuterJoin,
+	sqlparser.StraightJoinStr: FullOuterJoin,
+}
+
+// Return the names of tables and subqueries that their columns are resolved
+// to by [LogicalSelectNode.getTableField].
+func fromNames(tables []*LogicalTableNode, subplans []*LogicalPlan) map[string]bool {
+	var names = make(map[string]bool)
+	for _, t := range tables {
+		names[t.tableName] = true
+		if t.alias != "" {
+			names[t.alias] = true
+		}
+	}
+	for _, p := range subplans {
+		names[p.alias] = true
+	}
+	return names
+}
+
+// Return the joins for the ON clause of a join of type joinType of the tables
+// in left with those in right, given the filters, joins and predicates it
+// parsed into;  sides names a table of each side.  The first equality of
+// columns of both sides is the join, with its left column from the left side;
+// for inner joins, the other equalities of columns stay joins, while for outer
+// joins they are part of the condition of the join, with the rest of the
+// clause.  If there is no such equality, the join is on the whole clause.
+func parseJoinCondition(c *Catalog, subqueries []*LogicalPlan, ts []*LogicalTableNode, left map[string]bool, right map[string]bool, sides [2]string, joinType JoinType, filters []*LogicalFilterNode, joins []*LogicalJoinNode, preds []*LogicalPredNode) ([]*LogicalJoinNode, error) {
+	var key *LogicalJoinNode
+	var cond []*LogicalPredNode
+	for _, f := range filters {
+		cond = append(cond, f.pred())
+	}
+	var rest []*LogicalJoinNode
+	for _, j := range joins {
+		lTable, _, err := j.left.getTableField(c, subqueries, ts)
+		if err != nil {
+			return nil, err
+		}
+		rTable, _, err := j.right.getTableField(c, subqueries, ts)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil && right[lTable] && left[rTable] {
+			j.left, j.right = j.right, j.left
+			lTable, rTable = rTable, lTable
+		}
+		if key == nil && left[lTable] && right[rTable] {
+			key = j
+		} else if joinType == InnerJoin {
+			rest = append(rest, j)
+		} else {
+			cond = append(cond, j.pred())
+		}
+	}
+	if key == nil {
+		key = &LogicalJoinNode{}
+	}
+	key.sides = sides
+	key.joinType = joinType
+	key.cond = append(cond, preds...)
+	preservesLeft, preservesRight := joinType.preserves()
+	for name := range left {
+		if preservesRight {
+			key.nullable = append(key.nullable, name)
+		}
+	}
+	for name := range right {
+		if preservesLeft {
+			key.nullable = append(key.nullable, name)
+		}
+	}
+	return append([]*LogicalJoinNode{key}, rest...), nil
+}
+
+// Return the filter as a predicate.
+func (f *LogicalFilterNode) pred() *LogicalPredNode {
+	return &LogicalPredNode{predType: PredCompare, left: &f.fieldExpr, right: &f.constExpr, predOp: f.predOp}
+}
+
+// Return the equality of the join as a predicate.
+func (j *LogicalJoinNode) pred() *LogicalPredNode {
+	return &LogicalPredNode{predType: PredCompare, left: j.left, right: j.right, predOp: j.predOp}
+}
+
 func isAgg(funcName string) bool {
 	aggs := []string{"count", "sum", "avg", "min", "max"}
 	for _, s := range aggs {
@@ -333,6 +819,9 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 	switch expr := expr.(type) {
 	case *sqlparser.FuncExpr:
 		funName := strings.ToLower(sqlparser.String(expr.Name))
+		if funName == windowFuncName {
+			return parseWindowFunc(c, expr, alias)
+		}
 		if isAgg(funName) {
 			if len(expr.Exprs) != 1 {
 				return nil, GoDBError{ParseError, fmt.Sprintf("expected one argument to aggregate %s in select list", sqlparser.String(expr.Name))}
@@ -396,6 +885,14 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 
 		return &field, nil
 	case *sqlparser.SQLVal:
+		if expr.Type == sqlparser.ValArg {
+			n, err := paramNumber(expr.Val)
+			if err != nil {
+				return nil, err
+			}
+			field := NewParamSelectNode(n, alias)
+			return &field, nil
+		}
 		str := sqlparser.String(expr)
 		if str[0] == '\'' {
 			str = str[1 : len(str)-1]
@@ -403,11 +900,77 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 		}
 		field := NewConstSelectNode(str, alias)
 		return &field, nil
+	case *sqlparser.NullVal:
+		field := NewNullSelectNode(alias)
+		return &field, nil
+	case *sqlparser.Subquery:
+		return newSubqueryValue(expr, alias)
+	case *sqlparser.CaseExpr:
+		return parseCase(c, expr, alias)
+	case *sqlparser.ConvertExpr:
+		ftype, ok := castTypes[strings.ToLower(expr.Type.Type)]
+		if !ok {
+			return nil, GoDBError{ParseError, fmt.Sprintf("can't cast to %s", expr.Type.Type)}
+		}
+		arg, err := parseExpr(c, expr.Expr, "")
+		if err != nil {
+			return nil, err
+		}
+		field := NewFuncSelectNode("cast", []*LogicalSelectNode{arg}, alias)
+		field.value = ftype
+		return &field, nil
+	case *sqlparser.ComparisonExpr, *sqlparser.AndExpr, *sqlparser.OrExpr, *sqlparser.NotExpr, *sqlparser.IsExpr, *sqlparser.RangeCond:
+		pred, err := parsePred(c, expr)
+		if err != nil {
+			return nil, err
+		}
+		field := NewFuncSelectNode(strings.ToLower(sqlparser.String(expr)), nil, alias)
+		field.preds = []*LogicalPredNode{pred}
+		return &field, nil
 	default:
 		return nil, GoDBError{ParseError, fmt.Sprintf("unsupported expression type %s in select list", reflect.TypeOf(expr))}
 	}
 
 }
+
+// The types CAST converts to, by the names sqlparser parses, see
+// [rewriteCasts].
+var castTypes = map[string]string{
+	"signed":   "int",
+	"unsigned": "int",
+	"char":     "string",
+	"nchar":    "string",
+}
+
+// Parse a CASE expression.  CASE x WHEN v ... is parsed as CASE WHEN x = v ...
+func parseCase(c *Catalog, expr *sqlparser.CaseExpr, alias string) (*LogicalSelectNode, error) {
+	field := NewFuncSelectNode("case", nil, alias)
+	for _, when := range expr.Whens {
+		cond := when.Cond
+		if expr.Expr != nil {
+			cond = &sqlparser.ComparisonExpr{Operator: sqlparser.EqualStr, Left: expr.Expr, Right: when.Cond}
+		}
+		pred, err := parsePred(c, cond)
+		if err != nil {
+			return nil, err
+		}
+		then, err := parseExpr(c, when.Val, "")
+		if err != nil {
+			return nil, err
+		}
+		field.preds = append(field.preds, pred)
+		field.args = append(field.args, then)
+	}
+	if expr.Else != nil {
+		elseExpr, err := parseExpr(c, expr.Else, "")
+		if err != nil {
+			return nil, err
+		}
+		field.args = append(field.args, elseExpr)
+	}
+	return &field, nil
+}
+
 func parseSelect(c *Catalog, stmt sqlparser.SelectExpr) (*LogicalSelectNode, error) {
 	star, ok := stmt.(*sqlparser.StarExpr)
 	if ok {
@@ -432,10 +995,20 @@ func extractAggs(s *LogicalSelectNode) []*LogicalSelectNode {
 		return []*LogicalSelectNode{s}
 	case ExprFunc:
 		var aggs []*LogicalSelectNode
-		for _, subs := range s.args {
+		for _, subs := range s.operands() {
 			aggs = append(aggs, extractAggs(subs)...)
 		}
 		return aggs
+	case ExprWindow:
+		// window functions are computed after aggregates, and can use them
+		var aggs []*LogicalSelectNode
+		for _, subs := range append(append([]*LogicalSelectNode{}, s.args...), s.window.partitionBy...) {
+			aggs = append(aggs, extractAggs(subs)...)
+		}
+		for _, oby := range s.window.orderBy {
+			aggs = append(aggs, extractAggs(oby.expr)...)
+		}
+		return aggs
 	}
 	return nil
 }
@@ -447,8 +1020,10 @@ func parseStatement(c *Catalog, s *sqlparser.Select) (*LogicalPlan, error) {
 		subplans []*LogicalPlan
 		joins    []*LogicalJoinNode
 		filters  []*LogicalFilterNode
+		preds    []*LogicalPredNode
 		aggs     []*LogicalSelectNode
 		selects  []*LogicalSelectNode
+		windows  []*LogicalSelectNode
 		groupBys []*GroupBy
 		orderBys []*OrderByNode
 	)
@@ -473,12 +1048,13 @@ func parseStatement(c *Catalog, s *sqlparser.Select) (*LogicalPlan, error) {
 					}
 		*/
 		//}
-		newFilters, newJoins, err := parseWhere(c, subplans, tables, where.Expr)
+		newFilters, newJoins, newPreds, err := parseWhere(c, subplans, tables, where.Expr)
 		if err != nil {
 			return nil, err
 		}
 		joins = append(joins, newJoins...)
 		filters = append(filters, newFilters...)
+		preds = append(preds, newPreds...)
 	}
 	//extract select list
 	for _, stmt := range s.SelectExprs {
@@ -488,6 +1064,7 @@ func parseStatement(c *Catalog, s *sqlparser.Select) (*LogicalPlan, error) {
 		}
 		selects = append(selects, sel)
 		aggs = append(aggs, extractAggs(sel)...)
+		windows = append(windows, extractWindows(sel)...)
 	}
 
 	for _, gby := range s.GroupBy {
@@ -507,6 +1084,17 @@ func parseStatement(c *Catalog, s *sqlparser.Select) (*LogicalPlan, error) {
 
 	}
 
+	var having *LogicalPredNode
+	if s.Having != nil {
+		var err error
+		having, err = parsePred(c, s.Having.Expr)
+		if err != nil {
+			return nil, err
+		}
+		// aggregates that are only in the HAVING clause are computed too
+		aggs = append(aggs, having.aggs()...)
+	}
+
 	lim := s.Limit
 	var limExpr *LogicalSelectNode
 	if lim != nil {
@@ -517,8 +1105,10 @@ func parseStatement(c *Catalog, s *sqlparser.Select) (*LogicalPlan, error) {
 		}
 	}
 
-	p := LogicalPlan{filters, joins, selects, aggs, tables, subplans, groupBys, orderBys, limExpr, s.Distinct != "", ""}
-
+	p := LogicalPlan{filters, joins, selects, aggs, tables, subplans, groupBys, orderBys, limExpr, s.Distinct != "", "", preds, having, nil, nil, false, false, nil, windows, nil}
+	if err := p.resolveSubqueries(c); err != nil {
+		return nil, err
+	}
 	return &p, nil
 }
 
@@ -576,7 +1166,7 @@ type PlanNode struct {
 
 func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, tableMap map[string]*PlanNode) (Expr, string, error) {
 	switch s.exprType {
-	case ExprAggr:
+	case ExprAggr, ExprWindow:
 		fallthrough
 	case ExprField:
 		var field FieldType
@@ -629,6 +1219,21 @@ func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, table
 		e := FieldExpr{field}
 		return &e, fieldName, nil
 	case ExprConst:
+		if s.null {
+			fieldName := "null"
+			if s.alias != "" {
+				fieldName = s.alias
+			}
+			return &ConstExpr{NullField{}, UnknownType}, fieldName, nil
+		}
+		if s.param != 0 {
+			fieldName := s.value
+			if s.alias != "" {
+				fieldName = s.alias
+			}
+			param, err := c.newParam(s.param)
+			return param, fieldName, err
+		}
 
 		var fval any
 		constType := StringType
@@ -651,12 +1256,21 @@ func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, table
 			fieldName = s.alias
 		}
 		exprs := make([]*Expr, len(s.args))
+		args := make([]Expr, len(s.args))
 		for i, lsn := range s.args {
 			newExpr, _, err := lsn.generateExpr(c, inputDesc, tableMap)
 			if err != nil {
 				return nil, "", err
 			}
 			exprs[i] = &newExpr
+			args[i] = newExpr
+		}
+		if s.preds != nil || condFuncs[*s.funcOp] {
+			e, err := s.generateCondExpr(c, args, inputDesc, tableMap)
+			if err != nil {
+				return nil, "", err
+			}
+			return e, fieldName, nil
 		}
 
 		fe := FuncExpr{*s.funcOp, exprs}
@@ -666,7 +1280,11 @@ func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, table
 
 }
 
-const JoinBufferSize int = 10000000
+// The maximum number of tuples a join buffers in memory:  the blocks of the
+// left input of a [NestedLoopJoin], and the hash tables of a [HashJoin], which
+// partitions its inputs on disk when both are larger.  Used by the plans of the
+// queries parsed after it is set.
+var JoinBufferSize int = 100000
 
 func exprToStr(e Expr) string {
 	switch ex := e.(type) {
@@ -684,6 +1302,29 @@ func exprToStr(e Expr) string {
 			argStr += fmt.Sprintf("%s,", exprToStr(*arg))
 		}
 		return fmt.Sprintf("%s(%s)", ex.op, argStr)
+	case *CaseExpr:
+		caseStr := "CASE"
+		for i, when := range ex.whens {
+			caseStr += fmt.Sprintf(" WHEN %s THEN %s", when, exprToStr(ex.thens[i]))
+		}
+		if ex.elseExpr != nil {
+			caseStr += " ELSE " + exprToStr(ex.elseExpr)
+		}
+		return caseStr + " END"
+	case *CoalesceExpr:
+		argStr := ""
+		for _, arg := range ex.args {
+			argStr += fmt.Sprintf("%s,", exprToStr(arg))
+		}
+		return fmt.Sprintf("coalesce(%s)", argStr)
+	case *CastExpr:
+		typeName := "int"
+		if ex.ftype == StringType {
+			typeName = "string"
+		}
+		return fmt.Sprintf("cast(%s as %s)", exprToStr(ex.expr), typeName)
+	case *BoolExpr:
+		return ex.pred.String()
 	default:
 		return fmt.Sprintf("%+v, ", e)
 	}
@@ -705,81 +1346,113 @@ func opToStr(op BoolOp) string {
 		return "<"
 	case OpLike:
 		return " LIKE "
-
+	case OpIsNull:
+		return "IS NULL"
+	case OpIsNotNull:
+		return "IS NOT NULL"
 	}
 	return "??"
 }
 
-// following is absolute grossness because we forgot to ask students
-// to expose heapfile name
-func GetUnexportedField(field reflect.Value) interface{} {
-	return reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem().Interface()
+func PrintPhysicalPlan(o Operator, indent string) {
+	for _, line := range planLines(o, indent) {
+		fmt.Println(line)
+	}
 }
 
-func getStrFromObj(obj any) string {
-	var v reflect.Value
-	t := reflect.TypeOf(obj)
-	if t.Kind() == reflect.Pointer {
-		v = reflect.ValueOf(obj).Elem()
-	} else {
-		v = reflect.ValueOf(obj)
+// Return the lines describing the plan rooted at o, one per operator, with
+// the inputs of each operator following it, indented by one more tab.
+// Operators instrumented by EXPLAIN ANALYZE are followed by what they did.
+func planLines(o Operator, indent string) []string {
+	var stats string
+	if a, ok := o.(*analyzedOp); ok {
+		stats = fmt.Sprintf(" (rows=%d time=%v pages=%d)", a.rows, a.elapsed, a.pages)
+		o = a.child
 	}
-	if reflect.TypeOf(v).Kind() != reflect.Struct {
-		return fmt.Sprintf("%v", obj)
+	var lines = []string{indent + describeOp(o) + stats}
+	for _, child := range planChildren(o) {
+		lines = append(lines, planLines(*child, indent+"\t")...)
 	}
-	for i := 0; i < v.NumField(); i++ {
-		v := GetUnexportedField(v.Field(i))
-		str, ok := v.(string)
-		if ok {
-			return str
-		}
-	}
-	return fmt.Sprintf("%v", obj)
+	return lines
 }
 
-func PrintPhysicalPlan(o Operator, indent string) {
+// Return a one line description of operator o, not including its inputs.
+func describeOp(o Operator) string {
 	switch op := o.(type) {
+	case *HashJoin:
+		var desc = fmt.Sprintf("Hash Join, %+v == %+v", exprToStr(op.leftField), exprToStr(op.rightField))
+		if op.joinType != InnerJoin {
+			desc = op.joinType.String() + " " + desc
+		}
+		if op.cond != nil {
+			desc += fmt.Sprintf(", %s", op.cond)
+		}
+		return desc
+	case *NestedLoopJoin:
+		var desc = "Nested Loop Join"
+		if op.joinType != InnerJoin {
+			desc = op.joinType.String() + " " + desc
+		}
+		if op.cond == nil {
+			return desc + ", cross product"
+		}
+		return fmt.Sprintf("%s, %s", desc, op.cond)
+	case *SortMergeJoin:
+		return fmt.Sprintf("Sort-Merge Join, %+v == %+v", exprToStr(op.leftField), exprToStr(op.rightField))
 	case *EqualityJoin[int64]:
-		fmt.Printf("%sJoin, %+v == %+v\n", indent, exprToStr(op.leftField), exprToStr(op.rightField))
-		indent = indent + "\t"
-		PrintPhysicalPlan(*op.left, indent)
-		PrintPhysicalPlan(*op.right, indent)
+		return fmt.Sprintf("Join, %+v == %+v", exprToStr(op.leftField), exprToStr(op.rightField))
 	case *EqualityJoin[string]:
-		fmt.Printf("%sJoin, %+v == %+v\n", indent, exprToStr(op.leftField), exprToStr(op.rightField))
-		indent = indent + "\t"
-		PrintPhysicalPlan(*op.left, indent)
-		PrintPhysicalPlan(*op.right, indent)
-
+		return fmt.Sprintf("Join, %+v == %+v", exprToStr(op.leftField), exprToStr(op.rightField))
 	case *Project:
 		selectStr := ""
 		for _, ex := range op.selectFields {
 			selectStr += exprToStr(ex) + ","
 		}
-		fmt.Printf("%sProject %+v -> %+v\n", indent, selectStr, op.outputNames)
-		indent = indent + "\t"
-		PrintPhysicalPlan(op.child, indent)
+		return fmt.Sprintf("Project %+v -> %+v", selectStr, op.outputNames)
 	case *Filter[int64]:
-		fmt.Printf("%sFilter %s %s %s\n", indent, exprToStr(op.left), opToStr(op.op), exprToStr(op.right))
-		indent = indent + "\t"
-		PrintPhysicalPlan(op.child, indent)
+		return fmt.Sprintf("Filter %s %s %s", exprToStr(op.left), opToStr(op.op), exprToStr(op.right))
 	case *Filter[string]:
-		fmt.Printf("%sFilter %s %s %s\n", indent, exprToStr(op.left), opToStr(op.op), exprToStr(op.right))
-		indent = indent + "\t"
-		PrintPhysicalPlan(op.child, indent)
+		return fmt.Sprintf("Filter %s %s %s", exprToStr(op.left), opToStr(op.op), exprToStr(op.right))
 	case *HeapFile:
-		fmt.Printf("%sHeap Scan %v\n", indent, getStrFromObj(op))
+		return fmt.Sprintf("Heap Scan %s", filepath.Base(op.file.Name()))
+	case *IndexScan:
+		return fmt.Sprintf("Index Scan %s, %s %s %s", filepath.Base(op.index.file.Name()), op.index.KeyField().Fname, opToStr(op.op), exprToStr(op.key))
 	case *OrderBy:
 		orderStr := ""
 		for _, ex := range op.orderBy {
 			orderStr += exprToStr(ex) + ","
 		}
-		fmt.Printf("%sOrder By %s\n", indent, orderStr)
-		indent = indent + "\t"
-		PrintPhysicalPlan(op.child, indent)
+		return fmt.Sprintf("Order By %s", orderStr)
 	case *LimitOp:
-		fmt.Printf("%sLimit %s\n", indent, exprToStr(op.limitTups))
-		indent = indent + "\t"
-		PrintPhysicalPlan(op.child, indent)
+		return fmt.Sprintf("Limit %s", exprToStr(op.limitTups))
+	case *SetOp:
+		if op.all {
+			return op.opType.String() + " All"
+		}
+		return op.opType.String()
+	case *ScalarSubquery:
+		return "Scalar Subquery"
+	case *RecursiveUnion:
+		if op.all {
+			return "Recursive Union All"
+		}
+		return "Recursive Union"
+	case *WorkTable:
+		return "Work Table"
+	case *DualOp:
+		return "Dual"
+	case *Window:
+		partStr, orderStr, funcStr := "", "", ""
+		for _, ex := range op.partitionBy {
+			partStr += exprToStr(ex) + ","
+		}
+		for _, ex := range op.orderBy {
+			orderStr += exprToStr(ex) + ","
+		}
+		for _, f := range op.funcs {
+			funcStr += f.name + ","
+		}
+		return fmt.Sprintf("Window %s Partition By %s Order By %s", funcStr, partStr, orderStr)
 	case *Aggregator:
 		gbyStr := ""
 		if len(op.groupByFields) > 0 {
@@ -795,28 +1468,191 @@ func PrintPhysicalPlan(o Operator, indent string) {
 			aggStr += fmt.Sprintf("%s(%s),", reflect.TypeOf(ex), ex.GetTupleDesc().HeaderString(false))
 		}
 
-		fmt.Printf("%sAggregate, %s %s\n", indent, aggStr, gbyStr)
-		indent = indent + "\t"
-		PrintPhysicalPlan(op.child, indent)
+		return fmt.Sprintf("Aggregate, %s %s", aggStr, gbyStr)
+	case *InsertOp:
+		return "Insert"
+	case *DeleteOp:
+		return "Delete"
+	case *UpdateOp:
+		return "Update"
+	case *ValueOp:
+		return fmt.Sprintf("Values, %d rows", len(op.exprs))
+	case *PredicateOp:
+		return fmt.Sprintf("Predicate %s", op.pred)
+	case *ExplainOp:
+		return "Explain"
 	default:
-		fmt.Printf("%sUnknown op, %s\n", indent, reflect.TypeOf(op))
+		return fmt.Sprintf("Unknown op, %s", reflect.TypeOf(op))
+	}
+}
+
+// Return an index scan that can replace applying filter f to op, or nil if
+// op is not a scan of a table with an index on the filtered column, or the
+// filter is not an equality or range predicate comparing that column to a
+// constant.
+func indexScanFor(op Operator, f *LogicalFilterNode, field Expr, key Expr) *IndexScan {
+	hf, ok := op.(*HeapFile)
+	if !ok || f.fieldExpr.exprType != ExprField || f.constExpr.exprType != ExprConst || !indexScanSupports(f.predOp) {
+		return nil
+	}
+	fieldExpr, ok := field.(*FieldExpr)
+	if !ok {
+		return nil
 	}
+	idx := hf.indexOn(fieldExpr.selectField.Fname)
+	if idx == nil {
+		return nil
+	}
+	scan, err := NewIndexScan(idx, f.predOp, key)
+	if err != nil {
+		return nil
+	}
+	return scan
+}
+
+// Apply the predicates that refer to a single input in tableMap to that input,
+// returning the other predicates.
+func applyPreds(c *Catalog, plan *LogicalPlan, preds []*LogicalPredNode, tableMap map[string]*PlanNode) ([]*LogicalPredNode, error) {
+	var rest []*LogicalPredNode
+	for _, p := range preds {
+		nodes, err := p.inputs(c, plan, tableMap)
+		if err != nil {
+			return nil, err
+		}
+		if len(nodes) != 1 {
+			rest = append(rest, p)
+			continue
+		}
+		for node := range nodes {
+			pred, err := p.generatePred(c, node.desc, tableMap)
+			if err != nil {
+				return nil, err
+			}
+			newNode := &PlanNode{NewPredicateOp(pred, node.op), node.desc}
+			for key, n := range tableMap {
+				if n == node {
+					tableMap[key] = newNode
+				}
+			}
+		}
+	}
+	return rest, nil
+}
+
+// Scans of a table return tuples qualified by the alias the table was last
+// given (see [TupleDesc.setTableAlias]), so when a statement uses a table more
+// than once, in joins with itself or in subqueries, the tuples of every use
+// but one are misqualified.  Project the inputs of such tables onto fields
+// qualified by their own name.
+func requalifySelfJoins(plan *LogicalPlan, tableMap map[string]*PlanNode) {
+	for _, t := range plan.tables {
+		if plan.tableUses[t.tableName] < 2 {
+			continue
+		}
+		name := t.tableName
+		if t.alias != "" {
+			name = t.alias
+		}
+		tableMap[name] = requalify(tableMap[name].op, name)
+	}
+}
+
+// Return the node projecting the tuples of op onto fields qualified by name.
+func requalify(op Operator, name string) *PlanNode {
+	desc := op.Descriptor().copy()
+	desc.setTableAlias(name)
+	var exprs []Expr
+	var names []string
+	for _, f := range desc.Fields {
+		exprs = append(exprs, &FieldExpr{f})
+		names = append(names, f.Fname)
+	}
+	proj, _ := NewProjectOp(exprs, names, false, op)
+	return &PlanNode{proj, desc}
+}
+
+// Count the uses of each table in the plan and the plans of its subqueries in
+// uses, which they all share as their tableUses.
+func (p *LogicalPlan) countTableUses(uses map[string]int) {
+	p.tableUses = uses
+	for _, t := range p.tables {
+		uses[t.tableName]++
+	}
+	for _, sub := range p.subqueries {
+		sub.countTableUses(uses)
+	}
+	for _, sj := range p.semiJoins {
+		sj.plan.countTableUses(uses)
+	}
+}
+
+// Return the names of tables and subqueries as keys of the tableMap of
+// [makePhysicalPlan], tables first, in the order of the FROM clause.
+func fromOrder(tables []*LogicalTableNode, subqueries []*LogicalPlan) []string {
+	var names []string
+	for _, t := range tables {
+		if t.alias != "" {
+			names = append(names, t.alias)
+		} else {
+			names = append(names, t.tableName)
+		}
+	}
+	for _, p := range subqueries {
+		names = append(names, p.alias)
+	}
+	return names
+}
+
+// Return a nested loop join of type joinType of node1 with node2 on the
+// conjunction of cond and, for inner joins, of the pending predicates on the
+// two inputs, and the rest of the pending predicates.
+func nestedLoopJoin(c *Catalog, plan *LogicalPlan, joinType JoinType, cond []*LogicalPredNode, node1 *PlanNode, node2 *PlanNode, pending []*LogicalPredNode, tableMap map[string]*PlanNode) (Operator, []*LogicalPredNode, error) {
+	var preds = append([]*LogicalPredNode{}, cond...)
+	var rest []*LogicalPredNode
+	for _, p := range pending {
+		nodes, err := p.inputs(c, plan, tableMap)
+		if err != nil {
+			return nil, nil, err
+		}
+		if joinType == InnerJoin && len(nodes) == 2 && nodes[node1] && nodes[node2] {
+			preds = append(preds, p)
+		} else {
+			rest = append(rest, p)
+		}
+	}
+	var pred *PredExpr
+	if len(preds) > 0 {
+		var err error
+		pred, err = (&LogicalPredNode{predType: PredAnd, args: preds}).generatePred(c, node1.desc.merge(node2.desc), tableMap)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	op, err := NewNestedLoopJoin(node1.op, node2.op, joinType, pred, JoinBufferSize)
+	return op, rest, err
 }
 
 func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 	//build mapping from table names / aliases to operators
 
+	if plan.physical != nil {
+		return plan.physical, nil
+	}
 	tableMap := make(map[string]*PlanNode)
+	if plan.tableUses == nil {
+		plan.countTableUses(make(map[string]int))
+	}
 
 	for _, p := range plan.subqueries {
 		subPhysP, err := makePhysicalPlan(c, p)
 		if err != nil {
 			return nil, err
 		}
-		var td *TupleDesc = subPhysP.Descriptor()
-		td.setTableAlias(p.alias)
-		//td = td.setTableAlias(p.alias)
-		tableMap[p.alias] = &PlanNode{subPhysP, td}
+		if p.scalar {
+			subPhysP = NewScalarSubquery(subPhysP)
+		}
+		//the tuples of the subquery are qualified by the tables it reads
+		tableMap[p.alias] = requalify(subPhysP, p.alias)
 	}
 	for _, t := range plan.tables {
 		name := t.tableName
@@ -826,15 +1662,41 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 		var td *TupleDesc = (*t.file).Descriptor()
 		td.setTableAlias(name)
 		//td = td.setTableAlias(name)
-		tableMap[name] = &PlanNode{*t.file, td}
+		// every use of a table shares its file, so its descriptor is copied
+		tableMap[name] = &PlanNode{*t.file, td.copy()}
 	}
 
-	//now apply each filter to appropriate table
+	//now apply each filter to appropriate table;  equality filters go first, so
+	//they are the ones turned into index scans if there is a choice
+	var filters []*LogicalFilterNode
+	for _, f := range plan.filters {
+		if f.predOp == OpEq {
+			filters = append(filters, f)
+		}
+	}
 	for _, f := range plan.filters {
+		if f.predOp != OpEq {
+			filters = append(filters, f)
+		}
+	}
+	//filters and predicates on tables an outer join pads with NULLs are
+	//applied after the joins
+	var nullable = make(map[string]bool)
+	for _, j := range plan.joins {
+		for _, name := range j.nullable {
+			nullable[name] = true
+		}
+	}
+	var deferred []*LogicalPredNode
+	for _, f := range filters {
 		tabName, fieldName, err := f.fieldExpr.getTableField(c, plan.subqueries, plan.tables)
 		if err != nil {
 			return nil, err
 		}
+		if nullable[tabName] {
+			deferred = append(deferred, f.pred())
+			continue
+		}
 		node, err := fieldToOp(tabName, fieldName, tableMap)
 		if err != nil {
 			return nil, err
@@ -852,6 +1714,12 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 		desc := *op.Descriptor()
 		desc.setTableAlias(tabName)
 
+		c.typeParam(rightExpr, leftExpr.GetExprType().Ftype)
+		if scan := indexScanFor(op, f, leftExpr, rightExpr); scan != nil {
+			tableMap[leftExpr.GetExprType().TableQualifier] = &PlanNode{scan, &desc}
+			continue
+		}
+
 		switch leftExpr.GetExprType().Ftype {
 		case IntType:
 			newOp, err := NewIntFilter(rightExpr, f.predOp, leftExpr, op)
@@ -867,95 +1735,203 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 			tableMap[leftExpr.GetExprType().TableQualifier] = &PlanNode{newOp, &desc}
 		}
 	}
-	//finally apply joins
+	//predicates on one input are applied to it;  the others are applied once
+	//the inputs they refer to are joined
+	var preds = plan.preds
 	for _, j := range plan.joins {
-		lTabName, lFieldName, err := j.left.getTableField(c, plan.subqueries, plan.tables)
-		if err != nil {
-			return nil, err
+		if j.joinType == InnerJoin {
+			preds = append(preds, j.cond...)
 		}
-
-		node1, err := fieldToOp(lTabName, lFieldName, tableMap)
+	}
+	var pending []*LogicalPredNode
+	for _, p := range preds {
+		refersToNullable, err := p.refersTo(c, plan, nullable)
 		if err != nil {
 			return nil, err
 		}
-
-		rTabName, rFieldName, err := j.right.getTableField(c, plan.subqueries, plan.tables)
-		if err != nil {
-			return nil, err
+		if refersToNullable {
+			deferred = append(deferred, p)
+		} else {
+			pending = append(pending, p)
 		}
+	}
+	pending, err := applyPreds(c, plan, pending, tableMap)
+	if err != nil {
+		return nil, err
+	}
+	requalifySelfJoins(plan, tableMap)
 
-		node2, err := fieldToOp(rTabName, rFieldName, tableMap)
-		if err != nil {
-			return nil, err
+	//finally apply joins, in the order estimated to be cheapest
+	joins, err := orderJoins(c, plan)
+	if err != nil {
+		return nil, err
+	}
+	var queryDesc *TupleDesc // columns of the joins in query order, if reordered
+	for i := range joins {
+		if joins[i] != plan.joins[i] {
+			queryDesc, err = joinedDesc(c, plan, plan.joins, tableMap)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	for _, j := range joins {
+		var node1, node2 *PlanNode
+		var leftExpr, rightExpr Expr
+		if j.left == nil {
+			node1, node2 = tableMap[j.sides[0]], tableMap[j.sides[1]]
+		} else {
+			lTabName, lFieldName, err := j.left.getTableField(c, plan.subqueries, plan.tables)
+			if err != nil {
+				return nil, err
+			}
+			node1, err = fieldToOp(lTabName, lFieldName, tableMap)
+			if err != nil {
+				return nil, err
+			}
+			rTabName, rFieldName, err := j.right.getTableField(c, plan.subqueries, plan.tables)
+			if err != nil {
+				return nil, err
+			}
+			node2, err = fieldToOp(rTabName, rFieldName, tableMap)
+			if err != nil {
+				return nil, err
+			}
+			leftExpr, _, err = j.left.generateExpr(c, node1.desc, tableMap)
+			if err != nil {
+				return nil, err
+			}
+			rightExpr, _, err = j.right.generateExpr(c, node2.desc, tableMap)
+			if err != nil {
+				return nil, err
+			}
 		}
-
-		/*desc1 := *op1.Descriptor()
-		desc1.setTableAlias(j.t1)
-		desc2 := *op2.Descriptor()
-		desc2.setTableAlias(j.t2)
-		*/
 		op1 := node1.op
 		op2 := node2.op
 
-		/*
-			leftField, _ := fieldNameToField(j.t1, j.f1, node1)
-			rightField, _ := fieldNameToField(j.t2, j.f2, node2)
-		*/
-		leftExpr, _, err := j.left.generateExpr(c, node1.desc, tableMap)
+		// inputs already in join order are merged, all others are hashed;  an
+		// equality of columns of inputs that are already joined is a predicate,
+		// and joins without an equality are nested loop joins
+		var newOp Operator
+		switch {
+		case node1 == node2 && j.joinType == InnerJoin && j.left == nil:
+			continue // its condition is among the pending predicates
+		case node1 == node2 && j.joinType == InnerJoin:
+			newOp = NewPredicateOp(NewComparePred(leftExpr, j.predOp, rightExpr), op1)
+		case node1 == node2:
+			return nil, GoDBError{ParseError, "both sides of an outer join refer to the same tables"}
+		case j.left == nil:
+			var cond []*LogicalPredNode // those of inner joins are pending
+			if j.joinType != InnerJoin {
+				cond = j.cond
+			}
+			newOp, pending, err = nestedLoopJoin(c, plan, j.joinType, cond, node1, node2, pending, tableMap)
+		case j.joinType != InnerJoin:
+			var cond *PredExpr
+			if len(j.cond) > 0 {
+				cond, err = (&LogicalPredNode{predType: PredAnd, args: j.cond}).generatePred(c, node1.desc.merge(node2.desc), tableMap)
+				if err != nil {
+					return nil, err
+				}
+			}
+			var hj *HashJoin
+			hj, err = NewOuterHashJoin(op1, leftExpr, op2, rightExpr, j.joinType, cond, JoinBufferSize)
+			if err == nil && j.pad != nil {
+				hj.rightPad = &Tuple{Desc: *op2.Descriptor(), Fields: j.pad}
+			}
+			newOp = hj
+		case sortedOn(op1, leftExpr) && sortedOn(op2, rightExpr):
+			newOp, err = NewSortMergeJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
+		default:
+			newOp, err = NewHashJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
+		}
 		if err != nil {
 			return nil, err
 		}
-		rightExpr, _, err := j.right.generateExpr(c, node2.desc, tableMap)
+		newNode := &PlanNode{newOp, newOp.Descriptor()}
+		for key, node := range tableMap {
+			if node == node1 || node == node2 {
+				tableMap[key] = newNode
+			}
+		}
+		pending, err = applyPreds(c, plan, pending, tableMap)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		var (
-			newOp Operator
-		)
-		switch leftExpr.GetExprType().Ftype {
-		case IntType:
-			newOp, err = NewIntJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
-		case StringType:
-			newOp, err = NewStringJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
-		}
+	//inputs no join connects are joined by cross products, in the order of
+	//the FROM clause
+	var curNode *PlanNode
+	for _, name := range fromOrder(plan.tables, plan.subqueries) {
+		node := tableMap[name]
+		if curNode == nil || node == curNode {
+			curNode = node
+			continue
+		}
+		var newOp Operator
+		newOp, pending, err = nestedLoopJoin(c, plan, InnerJoin, nil, curNode, node, pending, tableMap)
 		if err != nil {
 			return nil, err
 		}
 		newNode := &PlanNode{newOp, newOp.Descriptor()}
-		for key, node := range tableMap {
-			if node.op == op1 {
-				tableMap[key] = newNode
-			}
-			if node.op == op2 {
+		for key, n := range tableMap {
+			if n == curNode || n == node {
 				tableMap[key] = newNode
 			}
 		}
-		tableMap[lTabName] = newNode
-		tableMap[rTabName] = newNode
-		//&PlanNode{newOp, newOp.Descriptor()}
-
-	}
-
-	//check that all tables have the same op (all tables are joined)
-	first := true
-	var curOp Operator
-	for _, node := range tableMap {
-		if first {
-			curOp = node.op
-			first = false
-		} else {
-			if curOp != node.op {
-				return nil, GoDBError{ParseError, "not all tables are joined, cross products are not supported in GoDB"}
-			}
+		curNode = newNode
+		pending, err = applyPreds(c, plan, pending, tableMap)
+		if err != nil {
+			return nil, err
 		}
 	}
+	var curOp Operator = NewDualOp()
+	if curNode != nil {
+		curOp = curNode.op
+	}
 
 	topOp := curOp
+	for _, p := range append(deferred, pending...) { // pending are predicates on constants
+		pred, err := p.generatePred(c, topOp.Descriptor(), tableMap)
+		if err != nil {
+			return nil, err
+		}
+		topOp = NewPredicateOp(pred, topOp)
+	}
+	if queryDesc == nil && len(plan.markPreds) > 0 {
+		queryDesc = topOp.Descriptor() // without the marks
+	}
+	for _, sj := range plan.semiJoins {
+		topOp, err = makeSemiJoin(c, sj, topOp, tableMap)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range plan.markPreds {
+		pred, err := p.generatePred(c, topOp.Descriptor(), tableMap)
+		if err != nil {
+			return nil, err
+		}
+		topOp = NewPredicateOp(pred, topOp)
+	}
+	if queryDesc != nil {
+		var exprs []Expr
+		var names []string
+		for _, f := range queryDesc.Fields {
+			exprs = append(exprs, &FieldExpr{f})
+			names = append(names, f.Fname)
+		}
+		topOp, err = NewProjectOp(exprs, names, false, topOp)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	//var fieldList []FieldType
 	var fieldNames []string
-	hasAgg := len(plan.aggs) > 0
+	hasAgg := len(plan.aggs) > 0 || len(plan.groupByFields) > 0
 	selectAll := false
 
 	/*
@@ -984,10 +1960,7 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 				}
 			*/
 
-			var getter func(DBValue) any
 			if s.exprType == ExprAggr {
-				var as AggState
-
 				tabName, fieldName, err := s.args[0].getTableField(c, plan.subqueries, plan.tables)
 				if err != nil {
 					return nil, err
@@ -1001,35 +1974,8 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 					return nil, err
 				}
 
-				switch aggExpr.GetExprType().Ftype {
-				case IntType:
-					getter = intAggGetter
-				case StringType:
-					getter = stringAggGetter
-				}
-
-				switch *s.funcOp {
-				case "max":
-					if aggExpr.GetExprType().Ftype == StringType {
-						as = &MaxAggState[string]{}
-					} else {
-						as = &MaxAggState[int64]{}
-					}
-
-				case "min":
-					if aggExpr.GetExprType().Ftype == StringType {
-						as = &MinAggState[string]{}
-					} else {
-						as = &MinAggState[int64]{}
-					}
-				case "avg":
-					as = &AvgAggState[int64]{}
-				case "sum":
-					as = &SumAggState[int64]{}
-				case "count":
-					as = &CountAggState{}
-				default:
-					return nil, GoDBError{IllegalOperationError, fmt.Sprintf("unknown aggregate function %s", *s.funcOp)}
+				if *s.funcOp == "count" && s.args[0].field == "*" {
+					aggExpr = nil // count every tuple, even if all its fields are NULL
 				}
 				//make sure name has unique id
 				name := fmt.Sprintf("%s(%s.%s)%d", *s.funcOp, tabName, fieldName, aggCnt)
@@ -1037,7 +1983,10 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 				if s.alias != "" {
 					name = s.alias
 				}
-				as.Init(name, aggExpr, getter)
+				as, err := newAggState(*s.funcOp, name, aggExpr)
+				if err != nil {
+					return nil, err
+				}
 				aggs = append(aggs, as)
 				s.cachedField = &as.GetTupleDesc().Fields[0] //track aggregates by reference rather than name
 			}
@@ -1057,6 +2006,25 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 			topOp = NewGroupedAggregator(aggs, gbys, topOp)
 		}
 	}
+	if plan.having != nil {
+		for _, col := range plan.having.columns() {
+			if _, err := findFieldInTd(FieldType{col.field, col.table, UnknownType}, topOp.Descriptor()); err != nil {
+				return nil, GoDBError{ParseError, fmt.Sprintf("column %s in HAVING clause is not grouped or aggregated", col.field)}
+			}
+		}
+		pred, err := plan.having.generatePred(c, topOp.Descriptor(), tableMap)
+		if err != nil {
+			return nil, err
+		}
+		topOp = NewPredicateOp(pred, topOp)
+	}
+	if len(plan.windows) > 0 {
+		var err error
+		topOp, err = makeWindows(c, plan.windows, topOp, tableMap)
+		if err != nil {
+			return nil, err
+		}
+	}
 	exprList := make([]Expr, len(plan.selects))
 	for i, s := range plan.selects {
 		switch s.exprType {
@@ -1073,6 +2041,24 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 			fieldNames = append(fieldNames, field)
 		}
 	}
+	if selectAll {
+		//the values of subqueries are not columns of the FROM clause
+		var hidden = make(map[string]bool)
+		for _, p := range plan.subqueries {
+			for _, f := range p.getSubplanFields(c) {
+				hidden[f.Fname] = p.hidden
+			}
+		}
+		exprList, fieldNames = nil, nil
+		for _, f := range topOp.Descriptor().Fields {
+			if hidden[f.Fname] {
+				selectAll = false
+				continue
+			}
+			exprList = append(exprList, &FieldExpr{f})
+			fieldNames = append(fieldNames, f.Fname)
+		}
+	}
 	if !selectAll {
 		projOp, err := NewProjectOp(exprList, fieldNames, plan.distinct, topOp)
 		if err != nil {
@@ -1112,22 +2098,76 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (Operator, error) {
 	return topOp, nil
 }
 
-func parseInsert(c *Catalog, insStmt *sqlparser.Insert) (Operator, error) {
-	if insStmt.Columns != nil {
-		return nil, GoDBError{ParseError, "GoDB doesn't support inserts of incomplete tuples"}
+// Return the state of the aggregate funcOp of expr named name, where a nil
+// expr counts every tuple.
+func newAggState(funcOp string, name string, expr Expr) (AggState, error) {
+	var getter func(DBValue) any
+	var ftype = IntType
+	if expr != nil {
+		ftype = expr.GetExprType().Ftype
+	}
+	switch ftype {
+	case IntType:
+		getter = intAggGetter
+	case StringType:
+		getter = stringAggGetter
 	}
+
+	var as AggState
+	switch funcOp {
+	case "max":
+		if ftype == StringType {
+			as = &MaxAggState[string]{}
+		} else {
+			as = &MaxAggState[int64]{}
+		}
+	case "min":
+		if ftype == StringType {
+			as = &MinAggState[string]{}
+		} else {
+			as = &MinAggState[int64]{}
+		}
+	case "avg":
+		as = &AvgAggState[int64]{}
+	case "sum":
+		as = &SumAggState[int64]{}
+	case "count":
+		as = &CountAggState{}
+	default:
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("unknown aggregate function %s", funcOp)}
+	}
+	return as, as.Init(name, expr, getter)
+}
+
+func parseInsert(c *Catalog, insStmt *sqlparser.Insert) (Operator, error) {
 	tab := insStmt.Table.Name
 	file, err := c.GetTable(sqlparser.String(tab))
 	if err != nil {
 		return nil, err
 	}
+	fields := file.Descriptor().Fields
+	// the values are inserted into these fields, and the others are defaulted
+	columns, err := insertColumns(insStmt.Columns, file.Descriptor())
+	if err != nil {
+		return nil, err
+	}
+	defaults, err := c.columnDefaults(c.tableMap[sqlparser.String(tab)])
+	if err != nil {
+		return nil, err
+	}
 
 	switch stmt := insStmt.Rows.(type) {
 	case sqlparser.Values:
 		var exprAr []([]Expr)
 		for _, t := range stmt {
-			var tupAr []Expr
-			for _, e := range t {
+			if len(t) != len(columns) {
+				return nil, GoDBError{ParseError, fmt.Sprintf("expected %d values to insert, got %d", len(columns), len(t))}
+			}
+			tupAr := append([]Expr{}, defaults...)
+			for i, e := range t {
+				if _, ok := e.(*sqlparser.Default); ok {
+					continue
+				}
 				expr, err := parseExpr(c, e, "")
 				if err != nil {
 					return nil, err
@@ -1136,7 +2176,8 @@ func parseInsert(c *Catalog, insStmt *sqlparser.Insert) (Operator, error) {
 				if err != nil {
 					return nil, err
 				}
-				tupAr = append(tupAr, exprOp)
+				c.typeParam(exprOp, fields[columns[i]].Ftype)
+				tupAr[columns[i]] = exprOp
 			}
 			exprAr = append(exprAr, tupAr)
 		}
@@ -1153,6 +2194,25 @@ func parseInsert(c *Catalog, insStmt *sqlparser.Insert) (Operator, error) {
 		if err != nil {
 			return nil, err
 		}
+		if insStmt.Columns != nil {
+			// the i-th field of op is inserted into the field columns[i]
+			opFields := op.Descriptor().Fields
+			if len(opFields) != len(columns) {
+				return nil, GoDBError{ParseError, fmt.Sprintf("expected %d columns to insert, got %d", len(columns), len(opFields))}
+			}
+			exprs := append([]Expr{}, defaults...)
+			var names []string
+			for _, f := range fields {
+				names = append(names, f.Fname)
+			}
+			for i, field := range columns {
+				exprs[field] = &FieldExpr{opFields[i]}
+			}
+			op, err = NewProjectOp(exprs, names, false, op)
+			if err != nil {
+				return nil, err
+			}
+		}
 
 		insertOp := NewInsertOp(file, op)
 		return insertOp, nil
@@ -1160,32 +2220,37 @@ func parseInsert(c *Catalog, insStmt *sqlparser.Insert) (Operator, error) {
 	return nil, nil
 }
 
-func parseDelete(c *Catalog, delStmt *sqlparser.Delete) (Operator, error) {
-	if len(delStmt.TableExprs) > 1 {
-		return nil, GoDBError{ParseError, "godb does not supporting deleting from multiple tables"}
+// Return the table of a single table DELETE or UPDATE statement, and an
+// operator returning the tuples of the table that satisfy the WHERE clause.
+// verb names the statement in errors.
+func parseFilteredTable(c *Catalog, tableExprs sqlparser.TableExprs, where *sqlparser.Where, verb string) (DBFile, Operator, map[string]*PlanNode, error) {
+	multipleTablesErr := GoDBError{ParseError, fmt.Sprintf("godb does not supporting %s multiple tables", verb)}
+	if len(tableExprs) > 1 {
+		return nil, nil, nil, multipleTablesErr
 	}
-	tables, subplans, joins, err := parseFrom(c, delStmt.TableExprs[0])
+	tables, subplans, joins, err := parseFrom(c, tableExprs[0])
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 	if len(tables) > 1 {
-		return nil, GoDBError{ParseError, "godb does not supporting deleting from multiple tables"}
+		return nil, nil, nil, multipleTablesErr
 	}
 	if subplans != nil || joins != nil {
-		return nil, GoDBError{ParseError, "godb does not supporting deleting from multiple tables"}
+		return nil, nil, nil, multipleTablesErr
 	}
 
 	tableMap := make(map[string]*PlanNode)
 	tableMap[tables[0].tableName] = &PlanNode{*tables[0].file, (*tables[0].file).Descriptor()}
 
 	var filters []*LogicalFilterNode = make([]*LogicalFilterNode, 0)
-	if delStmt.Where != nil {
-		filters, joins, err = parseWhere(c, subplans, tables, delStmt.Where.Expr)
+	var preds []*LogicalPredNode
+	if where != nil {
+		filters, joins, preds, err = parseWhere(c, subplans, tables, where.Expr)
 		if err != nil {
-			return nil, err
+			return nil, nil, nil, err
 		}
 		if joins != nil {
-			return nil, GoDBError{ParseError, "godb does not supporting deleting from multiple tables"}
+			return nil, nil, nil, multipleTablesErr
 		}
 	}
 	var newOp Operator
@@ -1193,40 +2258,310 @@ func parseDelete(c *Catalog, delStmt *sqlparser.Delete) (Operator, error) {
 	for _, f := range filters {
 		tabName, fieldName, err := f.fieldExpr.getTableField(c, subplans, tables)
 		if err != nil {
-			return nil, err
+			return nil, nil, nil, err
 		}
 		node, err := fieldToOp(tabName, fieldName, tableMap)
 		if err != nil {
-			return nil, err
+			return nil, nil, nil, err
 		}
 		leftExpr, _, err := f.fieldExpr.generateExpr(c, node.desc, tableMap)
 		if err != nil {
-			return nil, err
+			return nil, nil, nil, err
 		}
 		rightExpr, _, err := f.constExpr.generateExpr(c, node.desc, tableMap)
 		if err != nil {
-			return nil, err
+			return nil, nil, nil, err
 		}
 
 		//op := node.op
 		//dbField, _ := fieldNameToField(f.table, f.field, &PlanNode{op, &desc})
+		c.typeParam(rightExpr, leftExpr.GetExprType().Ftype)
 
 		switch leftExpr.GetExprType().Ftype {
 		case IntType:
 			//newInt, _ := strconv.Atoi(f.constVal)
 			newOp, err = NewIntFilter(rightExpr, f.predOp, leftExpr, newOp)
 			if err != nil {
-				return nil, err
+				return nil, nil, nil, err
 			}
 		case StringType:
 			newOp, err = NewStringFilter(rightExpr, f.predOp, leftExpr, newOp)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+	for _, p := range preds {
+		pred, err := p.generatePred(c, newOp.Descriptor(), tableMap)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		newOp = NewPredicateOp(pred, newOp)
+	}
+	return *tables[0].file, newOp, tableMap, nil
+}
+
+func parseDelete(c *Catalog, delStmt *sqlparser.Delete) (Operator, error) {
+	file, op, _, err := parseFilteredTable(c, delStmt.TableExprs, delStmt.Where, "deleting from")
+	if err != nil {
+		return nil, err
+	}
+	return NewDeleteOp(file, op), nil
+}
+
+func parseUpdate(c *Catalog, updStmt *sqlparser.Update) (Operator, error) {
+	if updStmt.OrderBy != nil || updStmt.Limit != nil {
+		return nil, GoDBError{ParseError, "godb does not support ORDER BY or LIMIT in UPDATE"}
+	}
+	file, op, tableMap, err := parseFilteredTable(c, updStmt.TableExprs, updStmt.Where, "updating")
+	if err != nil {
+		return nil, err
+	}
+	desc := file.Descriptor()
+	fields := make([]FieldType, len(updStmt.Exprs))
+	exprs := make([]Expr, len(updStmt.Exprs))
+	for i, updExpr := range updStmt.Exprs {
+		colName := strings.ToLower(sqlparser.String(updExpr.Name.Name))
+		fieldNo, err := findFieldInTd(FieldType{colName, "", UnknownType}, desc)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = desc.Fields[fieldNo]
+		node, err := parseExpr(c, updExpr.Expr, "")
+		if err != nil {
+			return nil, err
+		}
+		expr, _, err := node.generateExpr(c, desc, tableMap)
+		if err != nil {
+			return nil, err
+		}
+		c.typeParam(expr, fields[i].Ftype)
+		// constants that look like numbers are ints, but may be assigned to string columns
+		if node.exprType == ExprConst && !node.null && node.param == 0 && fields[i].Ftype == StringType {
+			expr = &ConstExpr{StringField{node.value}, StringType}
+		}
+		exprs[i] = expr
+	}
+	return NewUpdateOp(file, fields, exprs, op)
+}
+
+// Return the operator for a query combining queries with set operations,
+// where ops are the set operations of the whole query, in order (see
+// [rewriteSetOps]), of which those of stmt are consumed.  INTERSECT binds
+// more tightly than UNION and EXCEPT, which apply from left to right.
+func parseUnion(c *Catalog, stmt sqlparser.SelectStatement, ops *[]SetOpType) (Operator, error) {
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select:
+		plan, err := parseStatement(c, stmt)
+		if err != nil {
+			return nil, err
+		}
+		return makePhysicalPlan(c, plan)
+	case *sqlparser.ParenSelect:
+		return parseUnion(c, stmt.Select, ops)
+	case *sqlparser.Union:
+		//the left operand of a union is a union too, unless parenthesized
+		var chain = []*sqlparser.Union{stmt}
+		for u, ok := stmt.Left.(*sqlparser.Union); ok; u, ok = u.Left.(*sqlparser.Union) {
+			chain = append([]*sqlparser.Union{u}, chain...)
+		}
+		first, err := parseUnion(c, chain[0].Left, ops)
+		if err != nil {
+			return nil, err
+		}
+		var operands = []Operator{first}
+		var opTypes []SetOpType
+		var alls []bool
+		for _, u := range chain {
+			if len(*ops) == 0 {
+				return nil, GoDBError{ParseError, "unsupported set operation"}
+			}
+			opTypes = append(opTypes, (*ops)[0])
+			alls = append(alls, u.Type == sqlparser.UnionAllStr)
+			*ops = (*ops)[1:]
+			right, err := parseUnion(c, u.Right, ops)
+			if err != nil {
+				return nil, err
+			}
+			operands = append(operands, right)
+		}
+		var terms = operands[:1]
+		var termOps []int // indexes in opTypes of the operations combining terms
+		for i, opType := range opTypes {
+			if opType != IntersectOp {
+				terms = append(terms, operands[i+1])
+				termOps = append(termOps, i)
+				continue
+			}
+			op, err := NewSetOp(terms[len(terms)-1], operands[i+1], IntersectOp, alls[i])
 			if err != nil {
 				return nil, err
 			}
+			terms[len(terms)-1] = op
 		}
+		var result = terms[0]
+		for j, i := range termOps {
+			op, err := NewSetOp(result, terms[j+1], opTypes[i], alls[i])
+			if err != nil {
+				return nil, err
+			}
+			result = op
+		}
+		return orderAndLimit(c, result, stmt.OrderBy, stmt.Limit)
 	}
-	return NewDeleteOp(*tables[0].file, newOp), nil
+	return nil, GoDBError{ParseError, "invalid query"}
+}
 
+// Return op with its tuples ordered by the columns of its result named in
+// orderBy, if any, and limited to limit, if set.
+func orderAndLimit(c *Catalog, op Operator, orderBy sqlparser.OrderBy, limit *sqlparser.Limit) (Operator, error) {
+	var tableMap = make(map[string]*PlanNode)
+	if len(orderBy) > 0 {
+		var exprs []Expr
+		var ascs []bool
+		for _, oby := range orderBy {
+			lsn, err := parseExpr(c, oby.Expr, "")
+			if err != nil {
+				return nil, err
+			}
+			expr, _, err := lsn.generateExpr(c, op.Descriptor(), tableMap)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, expr)
+			ascs = append(ascs, oby.Direction == sqlparser.AscScr)
+		}
+		var err error
+		op, err = NewOrderBy(exprs, op, ascs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if limit != nil {
+		lsn, err := parseExpr(c, limit.Rowcount, "")
+		if err != nil {
+			return nil, err
+		}
+		expr, _, err := lsn.generateExpr(c, op.Descriptor(), tableMap)
+		if err != nil {
+			return nil, err
+		}
+		op = NewLimitOp(expr, op)
+	}
+	return op, nil
+}
+
+// Rewrite the parts of query sqlparser does not parse, see [rewriteSetOps].
+func rewriteQuery(query string) (string, []SetOpType) {
+	return rewriteSetOps(rewriteWindows(rewriteCasts(rewriteUniqueKeys(fullJoinRegexp.ReplaceAllString(query, "straight_join")))))
+}
+
+// The set operations that are not UNION by their keywords.
+var setOpKeywords = map[string]SetOpType{
+	"intersect": IntersectOp,
+	"except":    ExceptOp,
+}
+
+// A token of a query, and its position in the query.
+type sqlToken struct {
+	typ        int
+	start, end int
+}
+
+// Return the text of the token in query, lowercased.
+func (t sqlToken) word(query string) string {
+	if t.start < 0 || t.end > len(query) {
+		return ""
+	}
+	return strings.ToLower(query[t.start:t.end])
+}
+
+// Return the tokens of query, up to the first one that can't be scanned.  The
+// positions are only exact for identifiers, keywords and punctuation.
+func scanTokens(query string) []sqlToken {
+	var tokens []sqlToken
+	tokenizer := sqlparser.NewStringTokenizer(query)
+	for {
+		typ, val := tokenizer.Scan()
+		if typ == 0 || typ == sqlparser.LEX_ERROR {
+			return tokens
+		}
+		end := tokenizer.Position - 1 // the tokenizer reads one character ahead
+		start := end - len(val)
+		if len(val) == 0 {
+			start = end - 1
+		}
+		tokens = append(tokens, sqlToken{typ, start, end})
+	}
+}
+
+// The names of types CAST converts to, by the names sqlparser parses.
+var castTypeNames = map[string]string{
+	"int":     "signed",
+	"integer": "signed",
+	"bigint":  "signed",
+	"string":  "char",
+	"varchar": "char",
+	"text":    "char",
+}
+
+// sqlparser only parses the MySQL names of the types of CAST(x AS type), so
+// rewrite the names of the types of the columns of tables to those.
+func rewriteCasts(query string) string {
+	tokens := scanTokens(query)
+	var names = make(map[int]string) // by the positions of the tokens to rewrite
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].word(query) != "cast" || tokens[i+1].typ != '(' {
+			continue
+		}
+		close := matchingParen(tokens, i+1, 1)
+		for j, depth := i+1, 0; j < close; j++ {
+			switch tokens[j].typ {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+			name, ok := castTypeNames[tokens[j+1].word(query)]
+			if depth == 1 && ok && tokens[j].word(query) == "as" {
+				names[j+1] = name
+			}
+		}
+	}
+	var rewritten strings.Builder
+	last := 0
+	for i, t := range tokens {
+		if name, ok := names[i]; ok {
+			rewritten.WriteString(query[last:t.start] + name)
+			last = t.end
+		}
+	}
+	rewritten.WriteString(query[last:])
+	return rewritten.String()
+}
+
+// sqlparser only parses UNION, so rewrite the INTERSECT and EXCEPT of query
+// to UNION, and return the set operations of the query in order.
+func rewriteSetOps(query string) (string, []SetOpType) {
+	var ops []SetOpType
+	var rewritten strings.Builder
+	var last = 0
+	for _, t := range scanTokens(query) {
+		if t.typ == sqlparser.UNION {
+			ops = append(ops, UnionOp)
+			continue
+		}
+		if t.typ != sqlparser.ID {
+			continue
+		}
+		if opType, ok := setOpKeywords[t.word(query)]; ok {
+			ops = append(ops, opType)
+			rewritten.WriteString(query[last:t.start] + "union")
+			last = t.end
+		}
+	}
+	rewritten.WriteString(query[last:])
+	return rewritten.String(), ops
 }
 
 type QueryType int
@@ -1238,17 +2573,60 @@ const (
 	AbortXactionType     QueryType = iota
 	CreateTableQueryType QueryType = iota
 	DropTableQueryType   QueryType = iota
+	CreateIndexQueryType QueryType = iota
+	DropIndexQueryType   QueryType = iota
+	AnalyzeQueryType     QueryType = iota
+	CreateViewQueryType  QueryType = iota
+	DropViewQueryType    QueryType = iota
+	AlterTableQueryType  QueryType = iota
 	UnknownQueryType     QueryType = iota
 )
 
-func processDDL(c *Catalog, ddl *sqlparser.DDL) (QueryType, error) {
+// sqlparser accepts CREATE INDEX and DROP INDEX, but drops the index name and
+// column, so these statements are matched before the query is handed to it.
+var (
+	createIndexRegexp = regexp.MustCompile(`(?i)^\s*create\s+index\s+(\w+)\s+on\s+(\w+)\s*\(\s*(\w+)\s*\)\s*;?\s*$`)
+	dropIndexRegexp   = regexp.MustCompile(`(?i)^\s*drop\s+index\s+(\w+)(\s+on\s+\w+)?\s*;?\s*$`)
+	analyzeRegexp     = regexp.MustCompile(`(?i)^\s*analyze\s+(table\s+)?(\w+)\s*;?\s*$`)
+	explainRegexp     = regexp.MustCompile(`(?is)^\s*explain\s+(analyze\s+)?(.*)$`)
+	fullJoinRegexp    = regexp.MustCompile(`(?i)\bfull\s+(outer\s+)?join\b`)
+)
+
+// Process query if it is a CREATE INDEX or DROP INDEX statement.  Returns false
+// if it is not.
+func processIndexDDL(c *Catalog, query string) (QueryType, bool, error) {
+	if m := createIndexRegexp.FindStringSubmatch(query); m != nil {
+		err := c.createIndex(strings.ToLower(m[1]), strings.ToLower(m[2]), strings.ToLower(m[3]))
+		if err != nil {
+			return UnknownQueryType, true, err
+		}
+		return CreateIndexQueryType, true, nil
+	}
+	if m := dropIndexRegexp.FindStringSubmatch(query); m != nil {
+		err := c.dropIndex(strings.ToLower(m[1]))
+		if err != nil {
+			return UnknownQueryType, true, err
+		}
+		return DropIndexQueryType, true, nil
+	}
+	return UnknownQueryType, false, nil
+}
+
+// Process a CREATE TABLE or DROP TABLE statement.  foreignKeys and defaults
+// are those of the table created, see [rewriteForeignKeys] and
+// [rewriteDefaults].
+func processDDL(c *Catalog, ddl *sqlparser.DDL, foreignKeys []*ForeignKey, defaults map[string]string) (QueryType, error) {
 	switch ddl.Action {
 	case "create":
+		if ddl.TableSpec == nil {
+			return UnknownQueryType, GoDBError{ParseError, "unsupported create statement"}
+		}
 		fields := make([]FieldType, len(ddl.TableSpec.Columns))
+		notNull := make([]bool, len(ddl.TableSpec.Columns))
 		tabName := sqlparser.String(ddl.NewName.Name)
 		t, _ := c.GetTable(tabName)
-		if t != nil {
-			return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("table %s already exists", tabName)}
+		if t != nil || c.findView(tabName) != nil {
+			return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("a table or view named %s already exists", tabName)}
 		}
 		for i, col := range ddl.TableSpec.Columns {
 			var colType DBType
@@ -1267,14 +2645,76 @@ func processDDL(c *Catalog, ddl *sqlparser.DDL) (QueryType, error) {
 
 			}
 			fields[i] = FieldType{colName, "", colType}
+			notNull[i] = bool(col.Type.NotNull)
+		}
+		var defaultExprs []string
+		if defaults != nil {
+			defaultExprs = make([]string, len(fields))
+			for i, f := range fields {
+				if defaults[f.Fname] == "" {
+					continue
+				}
+				if _, err := parseDefault(c, defaults[f.Fname], f.Ftype); err != nil {
+					return UnknownQueryType, err
+				}
+				defaultExprs[i] = defaults[f.Fname]
+			}
 		}
 
-		c.addTable(tabName, TupleDesc{fields})
+		desc := TupleDesc{fields}
+		keys, indexes, err := tableKeys(tabName, ddl.TableSpec, &desc, notNull)
+		if err != nil {
+			return UnknownQueryType, err
+		}
+		// check the names of the indexes first, so that they can all be created
+		var names []string
+		for _, key := range keys {
+			names = append(names, key.name)
+		}
+		for _, idx := range indexes {
+			names = append(names, idx.name)
+		}
+		for _, fk := range foreignKeys {
+			fk.table = tabName
+			err = c.checkForeignKey(fk, &desc, keys)
+			if err != nil {
+				return UnknownQueryType, err
+			}
+			names = append(names, fk.name)
+		}
+		seen := make(map[string]bool)
+		for _, name := range names {
+			if t, _ := c.findIndex(name); t != nil || seen[name] {
+				return UnknownQueryType, GoDBError{DuplicateTableError, fmt.Sprintf("an index named '%s' already exists", name)}
+			}
+			seen[name] = true
+		}
+		c.addTable(tabName, desc, notNull)
+		err = c.addKeys(tabName, keys)
+		for _, idx := range indexes {
+			if err == nil {
+				err = c.createIndex(idx.name, idx.table, idx.column)
+			}
+		}
+		for _, fk := range foreignKeys {
+			if err == nil {
+				err = c.createIndex(fk.name, tabName, fk.columns[0])
+			}
+		}
+		if err != nil {
+			c.dropTable(tabName)
+			return UnknownQueryType, err
+		}
+		c.tableMap[tabName].foreignKeys = foreignKeys
+		c.tableMap[tabName].defaults = defaultExprs
 		return CreateTableQueryType, nil
 
 	case "drop":
 		tabName := sqlparser.String(ddl.Table.Name)
-		err := c.dropTable(tabName)
+		err := c.dropDependents(tabName, false)
+		if err == nil {
+			err = c.dropTable(tabName)
+		}
 		if err != nil {
 			return UnknownQueryType, err
 		}
@@ -1285,11 +2725,58 @@ func processDDL(c *Catalog, ddl *sqlparser.DDL) (QueryType, error) {
 }
 
 func Parse(c *Catalog, query string) (QueryType, Operator, error) {
+	qtype, ok, err := processIndexDDL(c, query)
+	if ok {
+		return qtype, nil, err
+	}
+	qtype, ok, err = processViewDDL(c, query)
+	if ok {
+		return qtype, nil, err
+	}
+	qtype, ok, err = processAlterTable(c, query)
+	if ok {
+		return qtype, nil, err
+	}
+	// ANALYZE t collects the statistics used to order joins
+	if m := analyzeRegexp.FindStringSubmatch(query); m != nil {
+		err := c.analyze(strings.ToLower(m[2]))
+		if err != nil {
+			return UnknownQueryType, nil, err
+		}
+		return AnalyzeQueryType, nil, nil
+	}
+	// EXPLAIN [ANALYZE] q returns the plan of q
+	if m := explainRegexp.FindStringSubmatch(query); m != nil {
+		qtype, op, err := Parse(c, m[2])
+		if err != nil {
+			return UnknownQueryType, nil, err
+		}
+		if qtype != IteratorType {
+			return UnknownQueryType, nil, GoDBError{ParseError, "only queries that return tuples can be explained"}
+		}
+		return IteratorType, NewExplainOp(op, m[1] != "", c.bp), nil
+	}
+	c, query, err = parseWith(c, query)
+	if err != nil {
+		return UnknownQueryType, nil, err
+	}
+	query, foreignKeys, err := rewriteForeignKeys(query)
+	if err != nil {
+		return UnknownQueryType, nil, err
+	}
+	query, defaults := rewriteDefaults(c.rewriteDefaultValues(query))
+	query, setOps := rewriteQuery(query)
 	stmt, err := sqlparser.Parse(query)
 	if err != nil {
 		return UnknownQueryType, nil, err
 	}
 	switch stmt := stmt.(type) {
+	case *sqlparser.Union:
+		op, err := parseUnion(c, stmt, &setOps)
+		if err != nil {
+			return UnknownQueryType, nil, err
+		}
+		return IteratorType, op, nil
 	case *sqlparser.Select:
 		plan, err := parseStatement(c, stmt)
 		if err != nil {
@@ -1314,6 +2801,12 @@ func Parse(c *Catalog, query string) (QueryType, Operator, error) {
 			return UnknownQueryType, nil, err
 		}
 		return IteratorType, op, nil
+	case *sqlparser.Update:
+		op, err := parseUpdate(c, stmt)
+		if err != nil {
+			return UnknownQueryType, nil, err
+		}
+		return IteratorType, op, nil
 	case *sqlparser.Begin:
 		return BeginXactionType, nil, nil
 	case *sqlparser.Commit:
@@ -1321,7 +2814,7 @@ func Parse(c *Catalog, query string) (QueryType, Operator, error) {
 	case *sqlparser.Rollback:
 		return AbortXactionType, nil, nil
 	case *sqlparser.DDL:
-		qtype, err := processDDL(c, stmt)
+		qtype, err := processDDL(c, stmt, foreignKeys, defaults)
 		if err != nil {
 			return UnknownQueryType, nil, err
 		} else {
@@ -0,0 +1,165 @@
+package godb
+
+import "fmt"
+
+type PredType int
+
+const (
+	PredCompare PredType = iota
+	PredAnd     PredType = iota
+	PredOr      PredType = iota
+	PredNot     PredType = iota
+)
+
+// PredExpr is a boolean expression over tuples:  a comparison of two
+// expressions, or the AND, OR or NOT of other predicates.
+type PredExpr struct {
+	predType    PredType
+	left, right Expr // operands of comparisons;  right is ignored by IS [NOT] NULL
+	op          BoolOp
+	args        []*PredExpr // operands of AND, OR and NOT
+}
+
+func NewComparePred(left Expr, op BoolOp, right Expr) *PredExpr {
+	return &PredExpr{PredCompare, left, right, op, nil}
+}
+
+func NewAndPred(args ...*PredExpr) *PredExpr {
+	return &PredExpr{predType: PredAnd, args: args}
+}
+
+func NewOrPred(args ...*PredExpr) *PredExpr {
+	return &PredExpr{predType: PredOr, args: args}
+}
+
+func NewNotPred(arg *PredExpr) *PredExpr {
+	return &PredExpr{predType: PredNot, args: []*PredExpr{arg}}
+}
+
+// Evaluate the predicate on t in SQL's three valued logic:  comparisons with
+// NULL are unknown, AND is false if any of its operands is false, OR is true
+// if any of its operands is true, and NOT of unknown is unknown.
+func (p *PredExpr) eval(t *Tuple) (triBool, error) {
+	switch p.predType {
+	case PredCompare:
+		return p.evalCompare(t)
+	case PredNot:
+		v, err := p.args[0].eval(t)
+		if err != nil || v == triUnknown {
+			return triUnknown, err
+		}
+		return toTriBool(v == triFalse), nil
+	}
+	// AND and OR stop at the first operand that decides the result
+	var decisive = triFalse
+	if p.predType == PredOr {
+		decisive = triTrue
+	}
+	var res = toTriBool(p.predType == PredAnd)
+	for _, arg := range p.args {
+		v, err := arg.eval(t)
+		if err != nil {
+			return triUnknown, err
+		}
+		if v == decisive {
+			return v, nil
+		}
+		if v == triUnknown {
+			res = triUnknown
+		}
+	}
+	return res, nil
+}
+
+func (p *PredExpr) evalCompare(t *Tuple) (triBool, error) {
+	lv, err := p.left.EvalExpr(t)
+	if err != nil {
+		return triUnknown, err
+	}
+	if p.op == OpIsNull || p.op == OpIsNotNull {
+		return evalNullablePred(lv, nil, p.op, intFilterGetter), nil
+	}
+	rv, err := p.right.EvalExpr(t)
+	if err != nil {
+		return triUnknown, err
+	}
+	if isNull(lv) || isNull(rv) {
+		return triUnknown, nil
+	}
+	switch lv.(type) {
+	case IntField:
+		if _, ok := rv.(IntField); ok {
+			return evalNullablePred(lv, rv, p.op, intFilterGetter), nil
+		}
+	case StringField:
+		if _, ok := rv.(StringField); ok {
+			return evalNullablePred(lv, rv, p.op, stringFilterGetter), nil
+		}
+	}
+	return triUnknown, GoDBError{TypeMismatchError, fmt.Sprintf("can't compare %v and %v", lv, rv)}
+}
+
+func (p *PredExpr) String() string {
+	switch p.predType {
+	case PredCompare:
+		if p.op == OpIsNull || p.op == OpIsNotNull {
+			return fmt.Sprintf("%s %s", exprToStr(p.left), opToStr(p.op))
+		}
+		return fmt.Sprintf("%s %s %s", exprToStr(p.left), opToStr(p.op), exprToStr(p.right))
+	case PredNot:
+		return fmt.Sprintf("NOT %s", p.args[0])
+	}
+	var sep = " AND "
+	if p.predType == PredOr {
+		sep = " OR "
+	}
+	var str = "("
+	for i, arg := range p.args {
+		if i > 0 {
+			str += sep
+		}
+		str += arg.String()
+	}
+	return str + ")"
+}
+
+// PredicateOp returns the tuples of its child for which a predicate is true.
+// Unlike [Filter], which compares an expression with a constant, it evaluates
+// any [PredExpr].
+type PredicateOp struct {
+	pred  *PredExpr
+	child Operator
+}
+
+func NewPredicateOp(pred *PredExpr, child Operator) *PredicateOp {
+	return &PredicateOp{pred, child}
+}
+
+// Return a TupleDescriptor for this predicate, that of its child.
+func (p *PredicateOp) Descriptor() *TupleDesc {
+	return p.child.Descriptor()
+}
+
+// Predicate operator implementation.  Tuples for which the predicate is false
+// or unknown are skipped.
+func (p *PredicateOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	childIter, err := p.child.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	return func() (*Tuple, error) {
+		for {
+			t, err := childIter()
+			if err != nil || t == nil {
+				return nil, err
+			}
+			v, err := p.pred.eval(t)
+			if err != nil {
+				return nil, err
+			}
+			if v == triTrue {
+				return t, nil
+			}
+		}
+	}, nil
+}
@@ -0,0 +1,84 @@
+package godb
+
+import (
+	"testing"
+)
+
+func TestPredExprThreeValued(t *testing.T) {
+	td := TupleDesc{Fields: []FieldType{{"a", "", IntType}, {"b", "", IntType}}}
+	tup := &Tuple{td, []DBValue{IntField{1}, NullField{}}, nil}
+	a := &FieldExpr{td.Fields[0]}
+	b := &FieldExpr{td.Fields[1]}
+	one := &ConstExpr{IntField{1}, IntType}
+
+	aIsOne := NewComparePred(a, OpEq, one)
+	aIsNotOne := NewComparePred(a, OpNeq, one)
+	bIsOne := NewComparePred(b, OpEq, one)
+	var preds = []struct {
+		pred *PredExpr
+		res  triBool
+	}{
+		{aIsOne, triTrue},
+		{bIsOne, triUnknown},
+		{NewNotPred(bIsOne), triUnknown},
+		{NewAndPred(aIsOne, bIsOne), triUnknown},
+		{NewAndPred(aIsNotOne, bIsOne), triFalse},
+		{NewOrPred(aIsOne, bIsOne), triTrue},
+		{NewOrPred(aIsNotOne, bIsOne), triUnknown},
+		{NewComparePred(b, OpIsNull, nil), triTrue},
+		{NewComparePred(a, OpLe, b), triUnknown},
+	}
+	for _, p := range preds {
+		res, err := p.pred.eval(tup)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if res != p.res {
+			t.Errorf("expected %v for %s, got %v", p.res, p.pred, res)
+		}
+	}
+
+	_, err := NewComparePred(a, OpEq, &ConstExpr{StringField{"x"}, StringType}).eval(tup)
+	if err == nil {
+		t.Errorf("expected an error comparing an int with a string")
+	}
+}
+
+func TestWherePredicates(t *testing.T) {
+	c, _ := makeIndexTestCatalog(t, 20)
+	runTestQuery(t, c, "insert into t values (null, null)")
+	var queries = map[string]int{
+		"select name from t where age = 1 or age = 2":                                               4,
+		"select name from t where not (age < 8)":                                                    4,
+		"select name from t where not (age = 1)":                                                    18,
+		"select name from t where (age = 1 or name = 'sam2') and age < 5":                           3,
+		"select name from t where age + 1 = age * 2":                                                2,
+		"select name from t where 3 > age":                                                          6,
+		"select name from t where age is null or age = 0":                                           3,
+		"select t1.name from t t1, t t2 where t1.age = t2.age and t1.name < t2.name":                10,
+		"select t1.name from t t1, t t2 where t1.age = t2.age and (t1.age = 1 or t2.name = 'sam3')": 6,
+	}
+	for q, cnt := range queries {
+		if res := runTestQuery(t, c, q); len(res) != cnt {
+			t.Errorf("expected %d results for %s, got %d", cnt, q, len(res))
+		}
+	}
+
+	// equalities of columns of two tables are still joins
+	_, plan, err := Parse(c, "select t1.name from t t1, t t2 where t1.age = t2.age and (t1.age = 1 or t2.age = 2)")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	pred, ok := planInput(plan).(*PredicateOp)
+	if !ok {
+		t.Fatalf("expected a predicate on the join")
+	}
+	if _, ok := pred.child.(*HashJoin); !ok {
+		t.Errorf("expected a hash join")
+	}
+
+	runTestQuery(t, c, "delete from t where age = 1 or age = 2")
+	if res := runTestQuery(t, c, "select name from t"); len(res) != 17 {
+		t.Errorf("expected 17 tuples after delete, got %d", len(res))
+	}
+}
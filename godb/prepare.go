@@ -0,0 +1,245 @@
+package godb
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// The constants of the placeholders of the query being parsed, by their
+// numbers, see [Prepare].
+type paramExprs map[int][]*ConstExpr
+
+// A placeholder of a prepared statement:  a constant its argument number n is
+// bound to, converted to ftype, unless it is UnknownType.
+type paramRef struct {
+	n     int
+	expr  *ConstExpr
+	ftype DBType
+}
+
+// A PreparedStatement is a query planned once and executed with different
+// values of its placeholders.  Its plan is reused by every execution, so
+// executions must not run concurrently, and it must be prepared again after
+// the tables it reads change.
+type PreparedStatement struct {
+	plan    Operator
+	params  []paramRef
+	nparams int
+}
+
+// The statements that can be prepared, those that return tuples.
+var preparableRegexp = regexp.MustCompile(`(?is)^\s*\(*\s*(select|insert|update|delete|with|explain)\b`)
+
+// Plan query, whose placeholders are either ? or $1, $2, ..., numbered by their
+// position or explicitly.  Their values are given when it is executed, see
+// [PreparedStatement.Bind].
+func Prepare(c *Catalog, query string) (*PreparedStatement, error) {
+	if !preparableRegexp.MatchString(query) {
+		return nil, GoDBError{ParseError, "only queries and INSERT, UPDATE and DELETE statements can be prepared"}
+	}
+	scoped := *c
+	scoped.params = make(paramExprs)
+	qtype, plan, err := Parse(&scoped, rewritePlaceholders(query))
+	if err != nil {
+		return nil, err
+	}
+	if qtype != IteratorType {
+		return nil, GoDBError{ParseError, "only queries and INSERT, UPDATE and DELETE statements can be prepared"}
+	}
+	p := &PreparedStatement{plan: plan}
+	for n, exprs := range scoped.params {
+		for _, e := range exprs {
+			p.params = append(p.params, paramRef{n, e, e.constType})
+		}
+		if n > p.nparams {
+			p.nparams = n
+		}
+	}
+	sort.Slice(p.params, func(i, j int) bool { return p.params[i].n < p.params[j].n })
+	return p, nil
+}
+
+// Return the number of arguments the statement is executed with.
+func (p *PreparedStatement) NumParams() int {
+	return p.nparams
+}
+
+// Return the plan of the statement with its placeholders bound to args, the
+// first to $1 and so on.  Arguments are ints, strings or nil, for NULL, and
+// are converted to the types of the columns their placeholders are compared
+// to or assigned to.
+func (p *PreparedStatement) Bind(args ...any) (Operator, error) {
+	if len(args) != p.nparams {
+		return nil, GoDBError{ParseError, fmt.Sprintf("expected %d arguments, got %d", p.nparams, len(args))}
+	}
+	for _, param := range p.params {
+		v, err := paramValue(args[param.n-1], param.ftype)
+		if err != nil {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("argument $%d: %s", param.n, err.Error())}
+		}
+		param.expr.val = v
+		param.expr.constType = param.ftype
+		if param.ftype == UnknownType {
+			param.expr.constType = valueType(v)
+		}
+	}
+	return p.plan, nil
+}
+
+// Return arg as a value of type ftype, or of its own type if ftype is
+// UnknownType.
+func paramValue(arg any, ftype DBType) (DBValue, error) {
+	switch arg := arg.(type) {
+	case nil:
+		return NullField{}, nil
+	case NullField:
+		return arg, nil
+	case IntField:
+		return paramValue(arg.Value, ftype)
+	case StringField:
+		return paramValue(arg.Value, ftype)
+	case int:
+		return paramValue(int64(arg), ftype)
+	case int64:
+		if ftype == StringType {
+			return StringField{strconv.FormatInt(arg, 10)}, nil
+		}
+		return IntField{arg}, nil
+	case string:
+		if ftype == IntType {
+			n, err := strconv.ParseInt(strings.TrimSpace(arg), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("'%s' is not an integer", arg)
+			}
+			return IntField{n}, nil
+		}
+		return StringField{arg}, nil
+	}
+	return nil, fmt.Errorf("unsupported value %v of type %T", arg, arg)
+}
+
+// Return the type of v, UnknownType for NULL.
+func valueType(v DBValue) DBType {
+	switch v.(type) {
+	case IntField:
+		return IntType
+	case StringField:
+		return StringType
+	}
+	return UnknownType
+}
+
+// Return the constant a reference to placeholder n is planned as.
+func (c *Catalog) newParam(n int) (*ConstExpr, error) {
+	if c.params == nil {
+		return nil, GoDBError{ParseError, "placeholders are only supported in prepared statements"}
+	}
+	e := &ConstExpr{NullField{}, UnknownType}
+	c.params[n] = append(c.params[n], e)
+	return e, nil
+}
+
+// If e is a placeholder of the query being parsed, give it type ftype, that of
+// the column it is compared to or assigned to.
+func (c *Catalog) typeParam(e Expr, ftype DBType) {
+	for _, exprs := range c.params {
+		for _, p := range exprs {
+			if p == e {
+				p.constType = ftype
+				return
+			}
+		}
+	}
+}
+
+// sqlparser numbers ? placeholders from 1 in each query it parses, including
+// the queries of WITH clauses, which are parsed separately, and does not parse
+// $1, $2, ..., so rewrite both to the :v1, :v2, ... it parses ? as.
+func rewritePlaceholders(query string) string {
+	var (
+		rewritten strings.Builder
+		quote     byte
+		next      = 1 // the number of the next ?
+	)
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && i+1 < len(query) {
+				rewritten.WriteByte(ch)
+				i++
+				ch = query[i]
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '?':
+			fmt.Fprintf(&rewritten, ":v%d", next)
+			next++
+			continue
+		case ch == '$' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9':
+			rewritten.WriteString(":v")
+			continue
+		}
+		rewritten.WriteByte(ch)
+	}
+	return rewritten.String()
+}
+
+// Return the number of a placeholder sqlparser parsed as val.
+func paramNumber(val []byte) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(string(val), ":v"))
+	if err != nil || n < 1 {
+		return 0, GoDBError{ParseError, fmt.Sprintf("unsupported placeholder %s, expected ? or $1, $2, ...", val)}
+	}
+	return n, nil
+}
+
+// Parse list, a comma separated list of constants, such as the arguments of an
+// EXECUTE statement, into the arguments of [PreparedStatement.Bind].
+func ParseArgs(list string) ([]any, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	stmt, err := sqlparser.Parse("select " + list)
+	if err != nil {
+		return nil, err
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok || sel.Where != nil || sel.GroupBy != nil || sel.Limit != nil {
+		return nil, GoDBError{ParseError, "arguments must be constants"}
+	}
+	var args []any
+	for _, e := range sel.SelectExprs {
+		aliased, ok := e.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, GoDBError{ParseError, "arguments must be constants"}
+		}
+		switch v := aliased.Expr.(type) {
+		case *sqlparser.NullVal:
+			args = append(args, nil)
+		case *sqlparser.SQLVal:
+			switch v.Type {
+			case sqlparser.IntVal:
+				n, err := strconv.ParseInt(string(v.Val), 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, n)
+			case sqlparser.StrVal:
+				args = append(args, string(v.Val))
+			default:
+				return nil, GoDBError{ParseError, fmt.Sprintf("unsupported argument %s", sqlparser.String(v))}
+			}
+		default:
+			return nil, GoDBError{ParseError, "arguments must be constants"}
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,134 @@
+package godb
+
+import (
+	"sort"
+	"testing"
+)
+
+// Return the results of the prepared statement p executed with args.
+func runPrepared(t *testing.T, c *Catalog, p *PreparedStatement, args ...any) []*Tuple {
+	plan, err := p.Bind(args...)
+	if err != nil {
+		t.Fatalf("failed to bind %v, %s", args, err.Error())
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	defer c.bp.CommitTransaction(tid)
+	iter, err := plan.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var res []*Tuple
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf("failed to run with %v, %s", args, err.Error())
+		}
+		if tup == nil {
+			return res
+		}
+		res = append(res, tup)
+	}
+}
+
+func TestPreparedQuery(t *testing.T) {
+	c := makeOuterJoinTestCatalog(t)
+	if _, _, err := Parse(c, "create table people (name varchar(20), n int)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	var queries = []struct {
+		query    string
+		args     [][]any
+		expected [][]int64 // the sorted first column of the results of each args
+	}{
+		{"select x from a where x = ?", [][]any{{2}, {int64(3)}, {"1"}, {nil}}, [][]int64{{2, 2}, {3}, {1}, nil}},
+		{"select x from a where x > $2 and x < $1", [][]any{{3, 1}, {10, 0}}, [][]int64{{2, 2}, {1, 2, 2, 3}}},
+		{"select a.x from a join b on a.x = b.x where b.x <= ? and a.x >= ?", [][]any{{3, 3}}, [][]int64{{3, 3}}},
+		{"select x + ? from a where x = ?", [][]any{{10, 1}}, [][]int64{{11}}},
+		{"with t as (select x from a where x > ?) select x from t where x < ?", [][]any{{1, 3}}, [][]int64{{2, 2}}},
+		{"select x from a where x in (select x from b where x > ?)", [][]any{{2}}, [][]int64{{3}}},
+		{"select x from a where x = ? or y = ?", [][]any{{1, 5}, {7, 1}}, [][]int64{{1}, {-1, 1, 2, 2, 3}}},
+		{"select x from a where x = ? or y = ?", [][]any{{"3", "7"}}, [][]int64{{3}}},
+		{"select x from a where not (? = x or x > ?)", [][]any{{"1", "2"}}, [][]int64{{2, 2}}},
+	}
+	for _, q := range queries {
+		p, err := Prepare(c, q.query)
+		if err != nil {
+			t.Fatalf("failed to prepare %s, %s", q.query, err.Error())
+		}
+		for i, args := range q.args {
+			var res []int64
+			for _, tup := range runPrepared(t, c, p, args...) {
+				if isNull(tup.Fields[0]) {
+					res = append(res, -1)
+					continue
+				}
+				res = append(res, tup.Fields[0].(IntField).Value)
+			}
+			sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+			if len(res) != len(q.expected[i]) {
+				t.Errorf("%s with %v: expected %v, got %v", q.query, args, q.expected[i], res)
+				continue
+			}
+			for j := range res {
+				if res[j] != q.expected[i][j] {
+					t.Errorf("%s with %v: expected %v, got %v", q.query, args, q.expected[i], res)
+					break
+				}
+			}
+		}
+	}
+
+	// placeholders take the types of the columns they are assigned to
+	insert, err := Prepare(c, "insert into people values (?, ?)")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	runPrepared(t, c, insert, "alice", 1)
+	runPrepared(t, c, insert, 42, "2")
+	update, err := Prepare(c, "update people set name = $1 where n = $2")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	runPrepared(t, c, update, 7, 1)
+	sel, err := Prepare(c, "select n from people where name = ?")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, s := range []any{"7", 42} {
+		if res := runPrepared(t, c, sel, s); len(res) != 1 {
+			t.Errorf("expected one name %v, got %v", s, res)
+		}
+	}
+	if _, err := sel.Bind(); err == nil {
+		t.Errorf("expected an error binding too few arguments")
+	}
+	if _, err := insert.Bind("bob", "two"); err == nil {
+		t.Errorf("expected an error binding a string that is not an integer to an int column")
+	}
+
+	for _, q := range []string{
+		"select x from a where x = ?",
+	} {
+		if _, _, err := Parse(c, q); err == nil {
+			t.Errorf("%s: expected an error outside a prepared statement", q)
+		}
+	}
+	for _, q := range []string{
+		"create table t (x int)",
+		"select x from a where x = :name",
+		"select x from a where x = $0",
+	} {
+		if _, err := Prepare(c, q); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+	if _, err := c.GetTable("t"); err == nil {
+		t.Errorf("preparing a statement must not change the catalog")
+	}
+
+	args, err := ParseArgs("1, -2, 'it''s, here', null")
+	if err != nil || len(args) != 4 || args[0] != int64(1) || args[1] != int64(-2) || args[2] != "it's, here" || args[3] != nil {
+		t.Errorf("unexpected arguments %v, %v", args, err)
+	}
+}
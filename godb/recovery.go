@@ -0,0 +1,439 @@
+package godb
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Logging and recovery for the BufferPool.  HeapFile calls logPageImage and
+// logTupleChange around every change it makes to a page; the BufferPool keeps
+// the LSN of the last record of each transaction so records of a transaction
+// form a chain through their prevLSN fields, which is what both rollback and
+// crash recovery walk to undo a transaction.
+//
+// The first change to a page after the log was last truncated is preceded by
+// a full image of the page.  Redo restores the image unconditionally, so a page
+// that was torn by a crash in the middle of being written is repaired before
+// any record that depends on its contents is replayed.
+//
+// BTreeFile calls logIndexPage before a transaction first changes an index
+// page, and rollback restores the logged image.  As strict two phase locking
+// keeps other transactions from changing the page until the transaction ends,
+// this undoes exactly its changes.  Index pages are not recovered after a
+// crash;  the catalog rebuilds the indexes instead (see [NewCatalogFromFile]).
+
+func tidValue(tid TransactionID) int64 {
+	if tid == nil {
+		return -1
+	}
+	return int64(*tid)
+}
+
+func (bp *BufferPool) appendLog(tid TransactionID, rec *logRecord) (LSN, error) {
+	rec.tid = tidValue(tid)
+	rec.prevLSN = bp.lastLSN[tid]
+	lsn, err := bp.log.append(rec)
+	if err != nil {
+		return 0, err
+	}
+	bp.lastLSN[tid] = lsn
+	return lsn, nil
+}
+
+// Log a full image of the page if it hasn't been logged since the log was
+// truncated.  Must be called before the page is changed.
+func (bp *BufferPool) logPageImage(tid TransactionID, hp *heapPage) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if bp.log == nil {
+		return nil
+	}
+	key := hp.file.pageKey(hp.pageId)
+	if bp.imaged[key] {
+		return nil
+	}
+	buf, err := hp.toBuffer()
+	if err != nil {
+		return err
+	}
+	bp.walFiles[hp.file.file.Name()] = hp.file
+	lsn, err := bp.appendLog(tid, &logRecord{kind: logPageImageRecord, file: hp.file.file.Name(), pageNo: hp.pageId, data: buf.Bytes()})
+	if err != nil {
+		return err
+	}
+	bp.imaged[key] = true
+	hp.lsn = lsn
+	return nil
+}
+
+// Log the image of an index page, unless tid already has since the log was
+// truncated.  Must be called before tid changes the page.
+func (bp *BufferPool) logIndexPage(tid TransactionID, p *btreePage) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if bp.log == nil {
+		return nil
+	}
+	key := p.file.pageKey(p.pageNo)
+	if imaged, ok := bp.indexImaged[key]; ok && imaged == tid {
+		return nil
+	}
+	buf, err := p.toBuffer()
+	if err != nil {
+		return err
+	}
+	bp.indexFiles[p.file.file.Name()] = p.file
+	lsn, err := bp.appendLog(tid, &logRecord{kind: logIndexPageRecord, file: p.file.file.Name(), pageNo: p.pageNo, data: buf.Bytes()})
+	if err != nil {
+		return err
+	}
+	bp.indexImaged[key] = tid
+	p.lsn = lsn
+	return nil
+}
+
+// Replace the cached index page a logIndexPageRecord was written for with
+// the image in the record.  Called with bp.mu held.
+func (bp *BufferPool) restoreIndexPage(rec *logRecord) error {
+	f := bp.indexFiles[rec.file]
+	if f == nil {
+		return nil
+	}
+	p := newBTreePage(btreeLeafPage, rec.pageNo, f)
+	err := p.initFromBuffer(bytes.NewBuffer(rec.data))
+	if err != nil {
+		return err
+	}
+	pg, err := bp.loadPage(f, rec.pageNo)
+	if err != nil {
+		return err
+	}
+	p.lsn = rec.lsn
+	p.setDirty(true)
+	*pg = p
+	bp.replacer.touch(bp.corr[f.pageKey(rec.pageNo)])
+	return nil
+}
+
+// Log the insertion (kind logInsertRecord) or deletion (kind logDeleteRecord)
+// of t at rid, which has already been applied to hp.
+func (bp *BufferPool) logTupleChange(tid TransactionID, kind logRecordType, hp *heapPage, rid Rid, t *Tuple) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if bp.log == nil {
+		return nil
+	}
+	var buf = new(bytes.Buffer)
+	err := t.writeTo(buf)
+	if err != nil {
+		return err
+	}
+	lsn, err := bp.appendLog(tid, &logRecord{kind: kind, file: hp.file.file.Name(), pageNo: rid.PageNo, slot: rid.SlotNo, data: buf.Bytes()})
+	if err != nil {
+		return err
+	}
+	hp.lsn = lsn
+	return nil
+}
+
+// Write the commit record of tid and force the log.  Called with bp.mu held.
+func (bp *BufferPool) logCommit(tid TransactionID) error {
+	if _, ok := bp.lastLSN[tid]; !ok {
+		// read only transaction, nothing to make durable
+		return nil
+	}
+	lsn, err := bp.appendLog(tid, &logRecord{kind: logCommitRecord})
+	if err != nil {
+		return err
+	}
+	err = bp.log.force(lsn)
+	if err != nil {
+		return err
+	}
+	_, err = bp.appendLog(tid, &logRecord{kind: logEndRecord})
+	delete(bp.lastLSN, tid)
+	return err
+}
+
+// Undo the changes of tid using its log records.  Called with bp.mu held and
+// while tid still holds its page locks.
+func (bp *BufferPool) rollback(tid TransactionID) error {
+	lastLSN, ok := bp.lastLSN[tid]
+	if !ok {
+		return nil
+	}
+	lastLSN, err := bp.log.append(&logRecord{kind: logAbortRecord, tid: tidValue(tid), prevLSN: lastLSN})
+	if err != nil {
+		return err
+	}
+	lastLSN, err = bp.undo(tidValue(tid), lastLSN, lastLSN)
+	if err != nil {
+		return err
+	}
+	_, err = bp.log.append(&logRecord{kind: logEndRecord, tid: tidValue(tid), prevLSN: lastLSN})
+	delete(bp.lastLSN, tid)
+	return err
+}
+
+// Undo the record at lsn and the records before it in its transaction's chain,
+// writing a CLR for every undone change.  lastLSN is the last record the
+// transaction wrote.  Returns the LSN of the last CLR written.
+func (bp *BufferPool) undo(tid int64, lsn LSN, lastLSN LSN) (LSN, error) {
+	for lsn != 0 {
+		rec, err := bp.log.readRecord(lsn)
+		if err != nil {
+			return 0, err
+		}
+		switch rec.kind {
+		case logInsertRecord, logDeleteRecord:
+			lastLSN, err = bp.undoOne(tid, rec, lastLSN)
+			if err != nil {
+				return 0, err
+			}
+			lsn = rec.prevLSN
+		case logIndexPageRecord:
+			err = bp.restoreIndexPage(rec)
+			if err != nil {
+				return 0, err
+			}
+			lsn = rec.prevLSN
+		case logCLRRecord:
+			lsn = rec.undoNext
+		default:
+			lsn = rec.prevLSN
+		}
+	}
+	return lastLSN, nil
+}
+
+// Return the frame holding the page a log record refers to, extending the heap
+// file if the page was allocated after the file was last written.  Called with
+// bp.mu held.
+func (bp *BufferPool) logRecordPage(rec *logRecord) (*heapPage, error) {
+	hf := bp.walFiles[rec.file]
+	if hf == nil {
+		return nil, nil
+	}
+	if rec.kind == logPageImageRecord {
+		// don't read the old page, which may be torn
+		hp := emptyHeapPage(hf.desc, rec.pageNo, hf)
+		err := hp.initFromBuffer(bytes.NewBuffer(rec.data))
+		if err != nil {
+			return nil, err
+		}
+		fid, ok := bp.corr[hf.pageKey(rec.pageNo)]
+		if !ok {
+			if bp.freeList.Len() > 0 {
+				backElement := bp.freeList.Back()
+				fid = backElement.Value.(int)
+				bp.freeList.Remove(backElement)
+			} else {
+				fid, err = bp.evictFrame()
+				if err != nil {
+					return nil, err
+				}
+			}
+			bp.changeCorrespond(hf, rec.pageNo, fid)
+		}
+		bp.pages[fid] = hp
+		bp.replacer.touch(fid)
+		return hp, nil
+	}
+	if rec.pageNo >= hf.NumPages() {
+		hf.AllocPage(rec.pageNo)
+	}
+	pg, err := bp.loadPage(hf, rec.pageNo)
+	if err != nil {
+		return nil, err
+	}
+	bp.replacer.touch(bp.corr[hf.pageKey(rec.pageNo)])
+	return (*pg).(*heapPage), nil
+}
+
+// Apply the change described by a page record to its page, and set the page
+// LSN to the LSN of the record.  Called with bp.mu held.
+func (bp *BufferPool) applyLogRecord(rec *logRecord) error {
+	hp, err := bp.logRecordPage(rec)
+	if err != nil || hp == nil {
+		return err
+	}
+	action := rec.kind
+	if action == logCLRRecord {
+		action = rec.action
+	}
+	rid := Rid{rec.pageNo, rec.slot}
+	switch action {
+	case logInsertRecord:
+		t, err := readTupleFrom(bytes.NewBuffer(rec.data), hp.desc)
+		if err != nil {
+			return err
+		}
+		if hp.slotUsed(rec.slot) {
+			hp.deleteTuple(rid)
+		}
+		_, err = hp.insertTupleAt(rec.slot, t)
+		if err != nil {
+			return err
+		}
+	case logDeleteRecord:
+		if hp.slotUsed(rec.slot) {
+			hp.deleteTuple(rid)
+		}
+	case logPageImageRecord:
+	default:
+		return GoDBError{MalformedDataError, fmt.Sprintf("log record %d can't be applied to a page", rec.lsn)}
+	}
+	hp.lsn = rec.lsn
+	hp.setDirty(true)
+	return nil
+}
+
+// Attach the log lf to the buffer pool and recover from it, switching the
+// buffer pool to STEAL/NO FORCE.  files maps the names of the heap files
+// changes may have been logged for to the heap files themselves.
+//
+// Recovery has three passes over the log.  Analysis finds the transactions
+// that neither committed nor finished aborting (the losers).  Redo repeats
+// history by applying every page record whose LSN is newer than the LSN of
+// its page.  Undo rolls back the losers, newest record first, writing CLRs so
+// that a crash during recovery doesn't undo anything twice.  Afterwards all
+// pages are written back and the log is truncated.
+func (bp *BufferPool) recoverFromLog(lf *LogFile, files map[string]*HeapFile) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	if bp.log != nil {
+		err := bp.checkpoint()
+		if err != nil {
+			return err
+		}
+	}
+	bp.log = lf
+	for name, hf := range files {
+		bp.walFiles[name] = hf
+	}
+
+	// analysis
+	losers := make(map[int64]LSN)
+	iter := lf.iterator()
+	for {
+		rec, err := iter()
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			break
+		}
+		switch rec.kind {
+		case logCommitRecord, logEndRecord:
+			delete(losers, rec.tid)
+		default:
+			losers[rec.tid] = rec.lsn
+		}
+	}
+
+	// redo
+	iter = lf.iterator()
+	for {
+		rec, err := iter()
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			break
+		}
+		if !rec.isPageRecord() || bp.walFiles[rec.file] == nil {
+			continue
+		}
+		if rec.kind != logPageImageRecord {
+			hp, err := bp.logRecordPage(rec)
+			if err != nil {
+				return err
+			}
+			if hp.lsn >= rec.lsn {
+				continue
+			}
+		}
+		err = bp.applyLogRecord(rec)
+		if err != nil {
+			return err
+		}
+	}
+
+	// undo, always continuing with the loser whose next record is the newest
+	toUndo := make(map[int64]LSN)
+	lastLSN := make(map[int64]LSN)
+	for tid, lsn := range losers {
+		toUndo[tid] = lsn
+		lastLSN[tid] = lsn
+	}
+	for len(toUndo) > 0 {
+		var tid int64
+		var lsn LSN = -1
+		for t, l := range toUndo {
+			if l > lsn {
+				tid, lsn = t, l
+			}
+		}
+		rec, err := lf.readRecord(lsn)
+		if err != nil {
+			return err
+		}
+		next := rec.prevLSN
+		if rec.kind == logCLRRecord {
+			next = rec.undoNext
+		} else if rec.kind == logInsertRecord || rec.kind == logDeleteRecord {
+			// undo just this record, the rest of the chain is handled by the loop
+			lastLSN[tid], err = bp.undoOne(tid, rec, lastLSN[tid])
+			if err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			_, err = lf.append(&logRecord{kind: logEndRecord, tid: tid, prevLSN: lastLSN[tid]})
+			if err != nil {
+				return err
+			}
+			delete(toUndo, tid)
+		} else {
+			toUndo[tid] = next
+		}
+	}
+
+	return bp.checkpoint()
+}
+
+// Undo a single insert or delete record, returning the LSN of its CLR.
+func (bp *BufferPool) undoOne(tid int64, rec *logRecord, lastLSN LSN) (LSN, error) {
+	clr := &logRecord{kind: logCLRRecord, tid: tid, prevLSN: lastLSN, file: rec.file,
+		pageNo: rec.pageNo, slot: rec.slot, data: rec.data, undoNext: rec.prevLSN}
+	clr.action = logInsertRecord
+	if rec.kind == logInsertRecord {
+		clr.action = logDeleteRecord
+	}
+	lsn, err := bp.log.append(clr)
+	if err != nil {
+		return 0, err
+	}
+	return lsn, bp.applyLogRecord(clr)
+}
+
+// Write every dirty page back to disk and truncate the log.  Only valid when
+// no transaction is running.  Called with bp.mu held.
+func (bp *BufferPool) checkpoint() error {
+	for i := 0; i < len(bp.pages); i++ {
+		if bp.pages[i] != nil && bp.pages[i].isDirty() {
+			err := bp.flushFrame(i)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err := bp.log.truncate()
+	if err != nil {
+		return err
+	}
+	bp.imaged = make(map[any]bool)
+	bp.indexImaged = make(map[any]TransactionID)
+	return nil
+}
@@ -0,0 +1,294 @@
+package godb
+
+import (
+	"os"
+	"testing"
+)
+
+// Create a database with a single table t (name string, age int) in a fresh
+// directory, returning the directory.
+func makeRecoveryTestDir(t *testing.T) string {
+	dir := t.TempDir()
+	err := os.WriteFile(dir+"/catalog.txt", []byte("t (name string, age int)\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write catalog, %s", err.Error())
+	}
+	return dir
+}
+
+// Open the database in dir with a new buffer pool, as after a restart.
+func openRecoveryTestDb(t *testing.T, dir string, numPages int) (*BufferPool, *HeapFile) {
+	bp := NewBufferPool(numPages)
+	c, err := NewCatalogFromFile("catalog.txt", bp, dir)
+	if err != nil {
+		t.Fatalf("failed to load catalog, %s", err.Error())
+	}
+	hf, err := c.GetTable("t")
+	if err != nil {
+		t.Fatalf("failed to get table, %s", err.Error())
+	}
+	return bp, hf.(*HeapFile)
+}
+
+func countTuples(t *testing.T, bp *BufferPool, hf *HeapFile) int {
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	iter, err := hf.Iterator(tid)
+	if err != nil {
+		t.Fatalf("failed to get iterator, %s", err.Error())
+	}
+	cnt := 0
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf("iterator failed, %s", err.Error())
+		}
+		if tup == nil {
+			break
+		}
+		cnt++
+	}
+	bp.CommitTransaction(tid)
+	return cnt
+}
+
+func insertTestTuples(t *testing.T, hf *HeapFile, tid TransactionID, n int) {
+	for i := 0; i < n; i++ {
+		tup := Tuple{*hf.Descriptor(), []DBValue{StringField{"sam"}, IntField{int64(i)}}, nil}
+		err := hf.insertTuple(&tup, tid)
+		if err != nil {
+			t.Fatalf("insert failed, %s", err.Error())
+		}
+	}
+}
+
+func TestRecoverCommitted(t *testing.T) {
+	dir := makeRecoveryTestDir(t)
+	bp, hf := openRecoveryTestDb(t, dir, 10)
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	insertTestTuples(t, hf, tid, 250)
+	bp.CommitTransaction(tid)
+
+	// NO FORCE, so nothing has been written to the table yet
+	stat, _ := os.Stat(dir + "/t.dat")
+	hp := newHeapPage(hf.Descriptor(), 0, hf)
+	if stat.Size() != 0 && hp.numUsedSlots != 0 {
+		t.Errorf("expected commit not to write pages to the table")
+	}
+
+	// crash, and restart
+	bp, hf = openRecoveryTestDb(t, dir, 10)
+	if cnt := countTuples(t, bp, hf); cnt != 250 {
+		t.Errorf("expected 250 tuples after recovery, got %d", cnt)
+	}
+}
+
+func TestRecoverUncommittedStolenPages(t *testing.T) {
+	dir := makeRecoveryTestDir(t)
+	bp, hf := openRecoveryTestDb(t, dir, 3)
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	insertTestTuples(t, hf, tid, 50)
+	bp.CommitTransaction(tid)
+
+	// more pages than fit in the buffer pool, so some are stolen
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	insertTestTuples(t, hf, tid, 1000)
+
+	bp, hf = openRecoveryTestDb(t, dir, 3)
+	if cnt := countTuples(t, bp, hf); cnt != 50 {
+		t.Errorf("expected 50 tuples after recovery, got %d", cnt)
+	}
+
+	// recovering a second time is a no-op
+	bp, hf = openRecoveryTestDb(t, dir, 3)
+	if cnt := countTuples(t, bp, hf); cnt != 50 {
+		t.Errorf("expected 50 tuples after second recovery, got %d", cnt)
+	}
+}
+
+func TestAbortWithLog(t *testing.T) {
+	dir := makeRecoveryTestDir(t)
+	bp, hf := openRecoveryTestDb(t, dir, 3)
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	insertTestTuples(t, hf, tid, 300)
+	bp.CommitTransaction(tid)
+
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	dop := NewDeleteOp(hf, hf)
+	iter, err := dop.Iterator(tid)
+	if err != nil {
+		t.Fatalf("delete failed, %s", err.Error())
+	}
+	tup, err := iter()
+	if err != nil || tup.Fields[0].(IntField).Value != 300 {
+		t.Fatalf("expected 300 deletes")
+	}
+	insertTestTuples(t, hf, tid, 10)
+	bp.AbortTransaction(tid)
+
+	if cnt := countTuples(t, bp, hf); cnt != 300 {
+		t.Errorf("expected 300 tuples after abort, got %d", cnt)
+	}
+
+	bp, hf = openRecoveryTestDb(t, dir, 3)
+	if cnt := countTuples(t, bp, hf); cnt != 300 {
+		t.Errorf("expected 300 tuples after recovery, got %d", cnt)
+	}
+}
+
+func TestRecoverTornPage(t *testing.T) {
+	dir := makeRecoveryTestDir(t)
+	bp, hf := openRecoveryTestDb(t, dir, 10)
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	insertTestTuples(t, hf, tid, 20)
+	bp.CommitTransaction(tid)
+	bp.FlushAllPages()
+
+	// pretend the crash happened while the first page was being written
+	f, err := os.OpenFile(dir+"/t.dat", os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatalf("failed to open table, %s", err.Error())
+	}
+	f.WriteAt(make([]byte, PageSize/2), int64(PageSize/2))
+	f.WriteAt([]byte{1, 2, 3, 4, 5, 6, 7, 8}, 0)
+	f.Close()
+
+	bp, hf = openRecoveryTestDb(t, dir, 10)
+	if cnt := countTuples(t, bp, hf); cnt != 20 {
+		t.Errorf("expected 20 tuples after recovery, got %d", cnt)
+	}
+}
+
+func TestLogFileTornTail(t *testing.T) {
+	name := t.TempDir() + "/test.log"
+	lf, err := NewLogFile(name)
+	if err != nil {
+		t.Fatalf("failed to create log, %s", err.Error())
+	}
+	rec := &logRecord{kind: logInsertRecord, tid: 1, file: "t.dat", pageNo: 2, slot: 3, data: []byte("tuple")}
+	lsn, err := lf.append(rec)
+	if err != nil {
+		t.Fatalf("append failed, %s", err.Error())
+	}
+	_, err = lf.append(&logRecord{kind: logCommitRecord, tid: 1, prevLSN: lsn})
+	if err != nil {
+		t.Fatalf("append failed, %s", err.Error())
+	}
+	// chop off the end of the commit record
+	lf.file.Truncate(int64(lf.end) - 3)
+
+	lf, err = NewLogFile(name)
+	if err != nil {
+		t.Fatalf("failed to reopen log, %s", err.Error())
+	}
+	iter := lf.iterator()
+	got, err := iter()
+	if err != nil || got == nil {
+		t.Fatalf("expected to read the first record")
+	}
+	if got.lsn != lsn || got.file != "t.dat" || got.pageNo != 2 || got.slot != 3 || string(got.data) != "tuple" {
+		t.Errorf("record changed by the log, got %+v", got)
+	}
+	got, err = iter()
+	if err != nil || got != nil {
+		t.Errorf("expected the torn commit record to be dropped")
+	}
+}
+
+func TestRecoverNulls(t *testing.T) {
+	dir := makeRecoveryTestDir(t)
+	bp, hf := openRecoveryTestDb(t, dir, 10)
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	tup := Tuple{*hf.Descriptor(), []DBValue{StringField{"sam"}, NullField{}}, nil}
+	if err := hf.insertTuple(&tup, tid); err != nil {
+		t.Fatalf(err.Error())
+	}
+	bp.CommitTransaction(tid)
+
+	// crash, and restart
+	bp, hf = openRecoveryTestDb(t, dir, 10)
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	iter, _ := hf.Iterator(tid)
+	res, err := iter()
+	if err != nil || res == nil {
+		t.Fatalf("expected a tuple after recovery")
+	}
+	if !isNull(res.Fields[1]) {
+		t.Errorf("expected NULL age after recovery, got %v", res.Fields[1])
+	}
+	bp.CommitTransaction(tid)
+}
+
+func TestRecoverAfterDropTable(t *testing.T) {
+	dir := makeRecoveryTestDir(t)
+	c, err := NewCatalogFromFile("catalog.txt", NewBufferPool(10), dir)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i := 0; i < 10; i++ {
+		runTestQuery(t, c, "insert into t values ('sam', 1)")
+	}
+
+	// the records of the old table must not be replayed on the new one
+	for _, q := range []string{"drop table t", "create table t (a int, b int, c int)"} {
+		if _, _, err := Parse(c, q); err != nil {
+			t.Fatalf("%s: %s", q, err.Error())
+		}
+	}
+	runTestQuery(t, c, "insert into t values (1, 2, 3)")
+	if err := c.SaveToFile("catalog.txt", dir); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// crash, and restart
+	c, err = NewCatalogFromFile("catalog.txt", NewBufferPool(10), dir)
+	if err != nil {
+		t.Fatalf("failed to recover, %s", err.Error())
+	}
+	if res := runTestQuery(t, c, "select a, b, c from t"); len(res) != 1 || res[0].Fields[2].(IntField).Value != 3 {
+		t.Errorf("expected the tuple of the new table after recovery, got %v", res)
+	}
+}
+
+func TestCheckpointLogSize(t *testing.T) {
+	dir := makeRecoveryTestDir(t)
+	bp, hf := openRecoveryTestDb(t, dir, 10)
+	defer func(size int64) { CheckpointLogSize = size }(CheckpointLogSize)
+	CheckpointLogSize = 1000
+
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	insertTestTuples(t, hf, tid, 100)
+	if err := bp.CommitTransaction(tid); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !bp.log.isEmpty() {
+		t.Errorf("expected the log to be checkpointed once it grew past CheckpointLogSize")
+	}
+	if cnt := countTuples(t, bp, hf); cnt != 100 {
+		t.Errorf("expected 100 tuples after the checkpoint, got %d", cnt)
+	}
+
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	if err := bp.Checkpoint(); err == nil {
+		t.Errorf("expected an error checkpointing while a transaction is running")
+	}
+	bp.CommitTransaction(tid)
+	if err := bp.Checkpoint(); err != nil {
+		t.Errorf(err.Error())
+	}
+}
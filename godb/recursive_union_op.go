@@ -0,0 +1,115 @@
+package godb
+
+import "fmt"
+
+// The most times a [RecursiveUnion] evaluates its recursive term, so queries
+// whose recursion does not end return an error instead of running forever.
+const MaxRecursion = 10000
+
+// WorkTable returns the tuples the recursive term of a [RecursiveUnion] reads:
+// those its previous evaluation returned.
+type WorkTable struct {
+	desc   *TupleDesc
+	tuples []*Tuple
+}
+
+func NewWorkTable(desc *TupleDesc) *WorkTable {
+	return &WorkTable{desc.copy(), nil}
+}
+
+// Return a TupleDescriptor for this work table.
+func (w *WorkTable) Descriptor() *TupleDesc {
+	return w.desc
+}
+
+// Work table operator implementation.  Returns the tuples the work table had
+// when the iterator was created.
+func (w *WorkTable) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	tuples := w.tuples
+	next := 0
+	return func() (*Tuple, error) {
+		if next >= len(tuples) {
+			return nil, nil
+		}
+		next++
+		return &Tuple{Desc: *w.desc, Fields: tuples[next-1].Fields}, nil
+	}, nil
+}
+
+// RecursiveUnion evaluates a recursive query:  it returns the tuples of its
+// anchor, and then those of its recursive term, which reads the tuples
+// returned last through work, until that returns no new tuples.  Unless all is
+// set, tuples already returned are discarded.
+type RecursiveUnion struct {
+	anchor, recursive Operator
+	work              *WorkTable
+	all               bool
+	desc              *TupleDesc
+}
+
+// Constructor for a recursive union, whose fields are named as those of
+// anchor.  Returns an error if anchor and recursive do not return the same
+// number of fields, with the same types.
+func NewRecursiveUnion(anchor Operator, recursive Operator, work *WorkTable, all bool) (*RecursiveUnion, error) {
+	union, err := NewSetOp(anchor, recursive, UnionOp, all)
+	if err != nil {
+		return nil, err
+	}
+	desc := union.Descriptor()
+	for i := range desc.Fields {
+		desc.Fields[i].TableQualifier = ""
+	}
+	return &RecursiveUnion{anchor, recursive, work, all, desc}, nil
+}
+
+// Return a TupleDescriptor for this recursive union.
+func (r *RecursiveUnion) Descriptor() *TupleDesc {
+	return r.desc
+}
+
+// Recursive union operator implementation.  The tuples of each evaluation of
+// the recursive term are returned as they are produced, and become the tuples
+// of the work table once it is done.
+func (r *RecursiveUnion) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	iter, err := r.anchor.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		seen      = make(map[any]bool)
+		returned  []*Tuple // by the current evaluation
+		evaluated int      // times the recursive term was
+	)
+	return func() (*Tuple, error) {
+		for {
+			t, err := iter()
+			if err != nil {
+				return nil, err
+			}
+			if t == nil {
+				if len(returned) == 0 {
+					return nil, nil
+				}
+				if evaluated++; evaluated > MaxRecursion {
+					return nil, GoDBError{IllegalOperationError, fmt.Sprintf("recursive query did not end after %d iterations", MaxRecursion)}
+				}
+				r.work.tuples, returned = returned, nil
+				iter, err = r.recursive.Iterator(tid)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if !r.all {
+				key := setOpKey(t)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+			}
+			t = &Tuple{Desc: *r.desc, Fields: t.Fields}
+			returned = append(returned, t)
+			return t, nil
+		}
+	}, nil
+}
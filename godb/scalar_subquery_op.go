@@ -0,0 +1,50 @@
+package godb
+
+// ScalarSubquery returns the single tuple of a subquery used as a value.  If
+// the subquery returns no tuples, its value is NULL, and it is an error for it
+// to return more than one.
+type ScalarSubquery struct {
+	child Operator
+}
+
+func NewScalarSubquery(child Operator) *ScalarSubquery {
+	return &ScalarSubquery{child}
+}
+
+// Return a TupleDescriptor for this subquery, that of its child.
+func (s *ScalarSubquery) Descriptor() *TupleDesc {
+	return s.child.Descriptor()
+}
+
+// Scalar subquery implementation.  The child is read when the iterator is
+// created, so that a subquery returning several tuples fails the query before
+// any tuple is returned.
+func (s *ScalarSubquery) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	iter, err := s.child.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	t, err := iter()
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		t = nullTuple(s.child.Descriptor())
+	} else {
+		extra, err := iter()
+		if err != nil {
+			return nil, err
+		}
+		if extra != nil {
+			return nil, GoDBError{IllegalOperationError, "subquery used as a value returned more than one row"}
+		}
+	}
+	done := false
+	return func() (*Tuple, error) {
+		if done {
+			return nil, nil
+		}
+		done = true
+		return t, nil
+	}, nil
+}
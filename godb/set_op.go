@@ -0,0 +1,139 @@
+package godb
+
+// The set operations combining the results of two queries.
+type SetOpType int
+
+const (
+	UnionOp     SetOpType = iota
+	IntersectOp SetOpType = iota
+	ExceptOp    SetOpType = iota
+)
+
+func (s SetOpType) String() string {
+	switch s {
+	case IntersectOp:
+		return "Intersect"
+	case ExceptOp:
+		return "Except"
+	}
+	return "Union"
+}
+
+// SetOp returns the union, intersection or difference of the tuples of its
+// inputs.  Unless all is set, duplicate tuples are returned once;  otherwise
+// a tuple is returned as many times as it is in the union, in both inputs, or
+// more times in the left input than in the right one, respectively.  Tuples
+// are compared by their fields, NULLs being equal to each other, using a hash
+// table of the tuples of the right input, or of the tuples returned so far.
+type SetOp struct {
+	left, right Operator
+	opType      SetOpType
+	all         bool
+	desc        *TupleDesc
+}
+
+// Constructor for a set operation.  Returns an error if its inputs do not
+// have the same number of fields, with the same types.
+func NewSetOp(left Operator, right Operator, opType SetOpType, all bool) (*SetOp, error) {
+	leftDesc, rightDesc := left.Descriptor(), right.Descriptor()
+	if len(leftDesc.Fields) != len(rightDesc.Fields) {
+		return nil, GoDBError{TypeMismatchError, "queries of a set operation must return the same number of columns"}
+	}
+	desc := leftDesc.copy()
+	for i, f := range rightDesc.Fields {
+		switch {
+		case desc.Fields[i].Ftype == UnknownType: // NULL
+			desc.Fields[i].Ftype = f.Ftype
+		case f.Ftype != UnknownType && f.Ftype != desc.Fields[i].Ftype:
+			return nil, GoDBError{TypeMismatchError, "can't combine columns of different types"}
+		}
+	}
+	return &SetOp{left, right, opType, all, desc}, nil
+}
+
+// Return a TupleDescriptor for this set operation, the fields of the left
+// input.
+func (s *SetOp) Descriptor() *TupleDesc {
+	return s.desc
+}
+
+// Return the key tuples are compared with.
+func setOpKey(t *Tuple) any {
+	return (&Tuple{Fields: t.Fields}).tupleKey()
+}
+
+// Set operation implementation.  Intersections and differences first read
+// the right input into the hash table, and then scan the left input once.
+func (s *SetOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	leftIter, err := s.left.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		counts    = make(map[any]int) // of the tuples of the right input
+		seen      = make(map[any]bool)
+		rightIter func() (*Tuple, error)
+	)
+	if s.opType == UnionOp {
+		rightIter, err = s.right.Iterator(tid)
+	} else {
+		err = countKeys(s.right, tid, counts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() (*Tuple, error) {
+		for {
+			t, err := leftIter()
+			if err != nil {
+				return nil, err
+			}
+			if t == nil && rightIter != nil {
+				leftIter, rightIter = rightIter, nil
+				continue
+			}
+			if t == nil {
+				return nil, nil
+			}
+			key := setOpKey(t)
+			if !s.all && seen[key] {
+				continue
+			}
+			switch s.opType {
+			case IntersectOp:
+				if counts[key] == 0 {
+					continue
+				}
+				if s.all {
+					counts[key]--
+				}
+			case ExceptOp:
+				if counts[key] > 0 {
+					if s.all {
+						counts[key]--
+					}
+					continue
+				}
+			}
+			if !s.all {
+				seen[key] = true
+			}
+			return &Tuple{Desc: *s.desc, Fields: t.Fields}, nil
+		}
+	}, nil
+}
+
+// Count the tuples of op by their keys in counts.
+func countKeys(op Operator, tid TransactionID, counts map[any]int) error {
+	iter, err := op.Iterator(tid)
+	if err != nil {
+		return err
+	}
+	for {
+		t, err := iter()
+		if err != nil || t == nil {
+			return err
+		}
+		counts[setOpKey(t)]++
+	}
+}
@@ -0,0 +1,49 @@
+package godb
+
+import (
+	"testing"
+)
+
+func TestSetOpQuery(t *testing.T) {
+	c := makeOuterJoinTestCatalog(t)
+	var queries = []struct {
+		query string
+		rows  int
+	}{
+		{"select x from a union select x from b", 5},
+		{"select x from a union distinct select x from b", 5},
+		{"select x from a union all select x from b", 10},
+		{"select x from a intersect select x from b", 3},
+		{"select x from a intersect select x from a", 4},
+		{"select x from a intersect all select x from a", 5},
+		{"select x from a except select x from b", 1},
+		{"select x from a except all select x from b", 2},
+		{"select x from b except all select x from a", 2},
+		{"select x from a union select y from b union all select y from a", 9},
+		// INTERSECT is applied first
+		{"select x from a except select x from a intersect select x from b", 1},
+		{"(select x from a except select x from a) intersect select x from b", 0},
+		{"select x from a where 'except' = 'except' intersect select x from b", 3},
+		{"select x from a union select x from b limit 2", 2},
+	}
+	for _, q := range queries {
+		if res := runTestQuery(t, c, q.query); len(res) != q.rows {
+			t.Errorf("%s: expected %d results, got %d", q.query, q.rows, len(res))
+		}
+	}
+
+	res := runTestQuery(t, c, "select x from a where x is not null union select x from b where x is not null order by x desc limit 2")
+	if len(res) != 2 || res[0].Fields[0].(IntField).Value != 4 || res[1].Fields[0].(IntField).Value != 3 {
+		t.Errorf("expected 4 and 3, got %v", res)
+	}
+	if _, _, err := Parse(c, "select x from a union select x, y from b"); err == nil {
+		t.Errorf("expected an error for queries returning different numbers of columns")
+	}
+	_, plan, err := Parse(c, "select x from a except all select x from b")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if op, ok := plan.(*SetOp); !ok || op.opType != ExceptOp || !op.all {
+		t.Errorf("expected an EXCEPT ALL, got %s", describeOp(plan))
+	}
+}
@@ -0,0 +1,179 @@
+package godb
+
+// SortMergeJoin is an equality join that merges its inputs in the order of
+// their join values.  Inputs that are not already in that order (see
+// [sortedOn]) are sorted with [externalSort], keeping at most maxBufferSize
+// tuples in memory.  The tuples of the right input that share a join value are
+// buffered while they are joined with the matching left tuples.
+type SortMergeJoin struct {
+	// Expressions that when applied to tuples from the left or right operators,
+	// respectively, return the value of the left or right side of the join
+	leftField, rightField Expr
+
+	left, right Operator
+
+	// The maximum number of tuples each sort keeps in memory
+	maxBufferSize int
+}
+
+// Constructor for a sort-merge join of int or string expressions.  Returns an
+// error if the left and right expressions have different types.
+func NewSortMergeJoin(left Operator, leftField Expr, right Operator, rightField Expr, maxBufferSize int) (*SortMergeJoin, error) {
+	if leftField.GetExprType().Ftype != rightField.GetExprType().Ftype {
+		return nil, GoDBError{TypeMismatchError, "can't join fields of different types"}
+	}
+	switch leftField.GetExprType().Ftype {
+	case IntType, StringType:
+	default:
+		return nil, GoDBError{TypeMismatchError, "unknown type"}
+	}
+	if maxBufferSize < 1 {
+		return nil, GoDBError{IllegalOperationError, "join buffer must hold at least one tuple"}
+	}
+	return &SortMergeJoin{leftField, rightField, left, right, maxBufferSize}, nil
+}
+
+// Return a TupleDescriptor for this join, the fields of the left input
+// followed by those of the right input.
+func (j *SortMergeJoin) Descriptor() *TupleDesc {
+	return j.left.Descriptor().merge(j.right.Descriptor())
+}
+
+// Return true if the tuples of op are returned in ascending order of field.
+// This is the case for sorts and int index scans on field (string keys are
+// truncated in indexes, see [indexKey]), filters and predicates of such inputs,
+// sort-merge joins on field, and such operators instrumented by EXPLAIN
+// ANALYZE.
+func sortedOn(op Operator, field Expr) bool {
+	switch op := op.(type) {
+	case *OrderBy:
+		return len(op.orderBy) > 0 && op.ascending[0] && sameField(op.orderBy[0], field, op.Descriptor())
+	case *IndexScan:
+		return indexOrderedOn(op.index, field)
+	case *BTreeFile:
+		return indexOrderedOn(op, field)
+	case *Filter[int64]:
+		return sortedOn(op.child, field)
+	case *Filter[string]:
+		return sortedOn(op.child, field)
+	case *PredicateOp:
+		return sortedOn(op.child, field)
+	case *SortMergeJoin:
+		return sameField(op.leftField, field, op.Descriptor()) || sameField(op.rightField, field, op.Descriptor())
+	case *analyzedOp:
+		return sortedOn(op.child, field)
+	}
+	return false
+}
+
+func indexOrderedOn(index *BTreeFile, field Expr) bool {
+	f, ok := field.(*FieldExpr)
+	if !ok || index.keyType != IntType {
+		return false
+	}
+	fieldNo, err := findFieldInTd(f.selectField, index.Descriptor())
+	return err == nil && fieldNo == index.keyField
+}
+
+// Return true if e1 and e2 are the same field of desc.
+func sameField(e1 Expr, e2 Expr, desc *TupleDesc) bool {
+	f1, ok1 := e1.(*FieldExpr)
+	f2, ok2 := e2.(*FieldExpr)
+	if !ok1 || !ok2 {
+		return false
+	}
+	i1, err1 := findFieldInTd(f1.selectField, desc)
+	i2, err2 := findFieldInTd(f2.selectField, desc)
+	return err1 == nil && err2 == nil && i1 == i2
+}
+
+// Return an iterator over the tuples of op in ascending order of field.
+func (j *SortMergeJoin) sortedIterator(tid TransactionID, op Operator, field Expr) (func() (*Tuple, error), error) {
+	iter, err := op.Iterator(tid)
+	if err != nil || sortedOn(op, field) {
+		return iter, err
+	}
+	return externalSort(tid, iter, func(a, b *Tuple) bool {
+		return tupleLess(a, b, []Expr{field}, []bool{true})
+	}, j.maxBufferSize)
+}
+
+// Return the next tuple of iter whose field is not NULL, and its field.
+func nextNonNull(iter func() (*Tuple, error), field Expr) (*Tuple, DBValue, error) {
+	for {
+		t, err := iter()
+		if err != nil || t == nil {
+			return nil, nil, err
+		}
+		v, err := field.EvalExpr(t)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !isNull(v) {
+			return t, v, nil
+		}
+	}
+}
+
+// Join operator implementation.  Returns the joined tuples in ascending order
+// of the join values;  NULLs never match.
+func (j *SortMergeJoin) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	leftIter, err := j.sortedIterator(tid, j.left, j.leftField)
+	if err != nil {
+		return nil, err
+	}
+	rightIter, err := j.sortedIterator(tid, j.right, j.rightField)
+	if err != nil {
+		return nil, err
+	}
+	left, lv, err := nextNonNull(leftIter, j.leftField)
+	if err != nil {
+		return nil, err
+	}
+	right, rv, err := nextNonNull(rightIter, j.rightField)
+	if err != nil {
+		return nil, err
+	}
+
+	// the right tuples with join value groupKey, joined with left in turn
+	var group []*Tuple
+	var groupKey DBValue
+	var next = 0
+	return func() (*Tuple, error) {
+		for {
+			if group != nil {
+				if next < len(group) {
+					next++
+					return joinTuples(left, group[next-1]), nil
+				}
+				left, lv, err = nextNonNull(leftIter, j.leftField)
+				if err != nil {
+					return nil, err
+				}
+				next = 0
+				if left != nil && compareDBValue(lv, groupKey) == 0 {
+					continue
+				}
+				group = nil
+			}
+			if left == nil || right == nil {
+				return nil, nil
+			}
+			switch c := compareDBValue(lv, rv); {
+			case c < 0:
+				left, lv, err = nextNonNull(leftIter, j.leftField)
+			case c > 0:
+				right, rv, err = nextNonNull(rightIter, j.rightField)
+			default:
+				groupKey = rv
+				for right != nil && err == nil && compareDBValue(rv, groupKey) == 0 {
+					group = append(group, right)
+					right, rv, err = nextNonNull(rightIter, j.rightField)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}, nil
+}
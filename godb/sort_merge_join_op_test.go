@@ -0,0 +1,82 @@
+package godb
+
+import (
+	"os"
+	"testing"
+)
+
+const SortMergeJoinTestFile string = "SortMergeJoinTestFile.dat"
+
+func TestSortMergeJoin(t *testing.T) {
+	td, _, _, hf, bp, tid := makeTestVars()
+	os.Remove(SortMergeJoinTestFile)
+	hf2, _ := NewHeapFile(SortMergeJoinTestFile, &td, bp)
+	// ages 0-9 twice on the left, 5-14 three times on the right, plus NULLs
+	for i := 0; i < 20; i++ {
+		hf.insertTuple(&Tuple{td, []DBValue{StringField{"l"}, IntField{int64(i % 10)}}, nil}, tid)
+	}
+	for i := 0; i < 30; i++ {
+		hf2.insertTuple(&Tuple{td, []DBValue{StringField{"r"}, IntField{int64(5 + i%10)}}, nil}, tid)
+	}
+	hf.insertTuple(&Tuple{td, []DBValue{StringField{"l"}, NullField{}}, nil}, tid)
+	hf2.insertTuple(&Tuple{td, []DBValue{StringField{"r"}, NullField{}}, nil}, tid)
+
+	ageField := FieldExpr{td.Fields[1]}
+	// a buffer of 3 tuples makes both sorts spill
+	join, err := NewSortMergeJoin(hf, &ageField, hf2, &ageField, 3)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	iter, err := join.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cnt := 0
+	var last int64 = -1
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if tup == nil {
+			break
+		}
+		age := tup.Fields[1].(IntField).Value
+		if age != tup.Fields[3].(IntField).Value || age < last {
+			t.Fatalf("unexpected join result %s", tup.ToString())
+		}
+		last = age
+		cnt++
+	}
+	// ages 5-9 match, each twice on the left and three times on the right
+	if cnt != 5*2*3 {
+		t.Errorf("expected %d results, got %d", 5*2*3, cnt)
+	}
+}
+
+func TestSortMergeJoinQuery(t *testing.T) {
+	c, _ := makeIndexTestCatalog(t, 20)
+	if _, _, err := Parse(c, "create table u (name varchar(20), age int)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	runTestQuery(t, c, "insert into u values ('joe', 3)")
+	runTestQuery(t, c, "insert into u values ('ann', 3)")
+	runTestQuery(t, c, "insert into u values ('bob', 4)")
+	for _, q := range []string{"CREATE INDEX t_age ON t(age)", "CREATE INDEX u_age ON u(age)"} {
+		if _, _, err := Parse(c, q); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	// both inputs are index scans in age order
+	q := "select t.name, u.name from t, u where t.age >= 0 and u.age >= 0 and t.age = u.age"
+	_, plan, err := Parse(c, q)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := planInput(plan).(*SortMergeJoin); !ok {
+		t.Errorf("expected a sort-merge join")
+	}
+	if res := runTestQuery(t, c, q); len(res) != 6 {
+		t.Errorf("expected 6 results, got %d", len(res))
+	}
+}
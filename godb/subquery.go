@@ -0,0 +1,514 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// A subquery of an expression or a predicate, parsed once the query it is in
+// is, since it may refer to the columns of that query.
+type LogicalSubqueryNode struct {
+	stmt     *sqlparser.Select
+	name     string // unique, the alias of its plan
+	resolved bool
+}
+
+// A semi or anti join of the rest of the query with a subquery, for a term of
+// its WHERE clause x IN (subquery), EXISTS (subquery) or their negation.  IN
+// and EXISTS elsewhere in the WHERE clause are mark joins instead, whose mark
+// replaces them in their predicate.
+type LogicalSemiJoinNode struct {
+	anti  bool
+	mark  bool
+	in    *LogicalSelectNode // x, or nil for EXISTS
+	value *LogicalSelectNode // what x is compared with, if not the column of plan
+	plan  *LogicalPlan
+	cond  []*LogicalPredNode // the correlation of the subquery with the query
+}
+
+var subqueryCount atomic.Int64
+
+func newSubqueryNode(sq *sqlparser.Subquery) (*LogicalSubqueryNode, error) {
+	stmt, ok := sq.Select.(*sqlparser.Select)
+	if !ok {
+		return nil, GoDBError{ParseError, fmt.Sprintf("unsupported subquery %s", sqlparser.String(sq))}
+	}
+	return &LogicalSubqueryNode{stmt: stmt, name: fmt.Sprintf("$subquery%d", subqueryCount.Add(1))}, nil
+}
+
+// Return the field that is the value of a subquery, named by alias or by the
+// expression the subquery selects.
+func newSubqueryValue(sq *sqlparser.Subquery, alias string) (*LogicalSelectNode, error) {
+	node, err := newSubqueryNode(sq)
+	if err != nil {
+		return nil, err
+	}
+	if expr, ok := node.stmt.SelectExprs[0].(*sqlparser.AliasedExpr); ok && alias == "" {
+		alias = strings.ToLower(sqlparser.String(expr.As))
+		if alias == "" {
+			alias = strings.ToLower(sqlparser.String(expr.Expr))
+		}
+	}
+	field := NewFieldSelectNode(node.name, node.name, alias)
+	field.subquery = node
+	return &field, nil
+}
+
+// Parse x IN (...) or x NOT IN (...) into a predicate.
+func parseIn(c *Catalog, expr *sqlparser.ComparisonExpr) (*LogicalPredNode, error) {
+	switch right := expr.Right.(type) {
+	case sqlparser.ValTuple:
+		return parsePred(c, inListToExpr(expr, right))
+	case *sqlparser.Subquery:
+		left, err := parseExpr(c, expr.Left, "")
+		if err != nil {
+			return nil, err
+		}
+		sq, err := newSubqueryNode(right)
+		if err != nil {
+			return nil, err
+		}
+		pred := &LogicalPredNode{predType: PredCompare, left: left, predOp: OpEq, subquery: sq}
+		if expr.Operator == sqlparser.NotInStr {
+			pred = &LogicalPredNode{predType: PredNot, args: []*LogicalPredNode{pred}}
+		}
+		return pred, nil
+	}
+	return nil, GoDBError{ParseError, fmt.Sprintf("unsupported where expression %s", sqlparser.String(expr))}
+}
+
+// Return the comparisons x IN (a, b, ...) stands for, x = a OR x = b ..., or
+// their negation for NOT IN.
+func inListToExpr(expr *sqlparser.ComparisonExpr, list sqlparser.ValTuple) sqlparser.Expr {
+	var or sqlparser.Expr
+	for _, v := range list {
+		var eq sqlparser.Expr = &sqlparser.ComparisonExpr{Operator: sqlparser.EqualStr, Left: expr.Left, Right: v}
+		if or == nil {
+			or = eq
+		} else {
+			or = &sqlparser.OrExpr{Left: or, Right: eq}
+		}
+	}
+	if expr.Operator == sqlparser.NotInStr {
+		return &sqlparser.NotExpr{Expr: or}
+	}
+	return or
+}
+
+// Return the terms of the conjunction expr.
+func conjuncts(expr sqlparser.Expr) []sqlparser.Expr {
+	switch expr := expr.(type) {
+	case *sqlparser.AndExpr:
+		return append(conjuncts(expr.Left), conjuncts(expr.Right)...)
+	case *sqlparser.ParenExpr:
+		return conjuncts(expr.Expr)
+	}
+	return []sqlparser.Expr{expr}
+}
+
+// Return the name in the FROM clause of the plan of the table or subquery
+// that col is a column of, or "" if it is not a column of any of them.
+func (p *LogicalPlan) columnScope(c *Catalog, col *LogicalSelectNode) (string, error) {
+	names := fromOrder(p.tables, p.subqueries)
+	if col.table != "" {
+		for _, name := range names {
+			if name == col.table {
+				return name, nil
+			}
+		}
+		return "", nil
+	}
+	tabName, err := checkNameInTablesOrSubqueries("", col.field, c, p.subqueries, p.tables)
+	if err != nil {
+		return "", err
+	}
+	for _, t := range p.tables {
+		if t.tableName == tabName && t.alias != "" {
+			return t.alias, nil
+		}
+	}
+	return tabName, nil
+}
+
+// Parse a subquery of the query with plan outer.  The terms of its WHERE
+// clause that refer to columns of the outer query are returned apart, with
+// all their columns qualified, as the correlation of the subquery.
+func parseSubquery(c *Catalog, stmt *sqlparser.Select, outer *LogicalPlan) (*LogicalPlan, []*LogicalPredNode, error) {
+	var inner = *stmt
+	inner.Where = nil
+	plan, err := parseStatement(c, &inner)
+	if err != nil || stmt.Where == nil {
+		return plan, nil, err
+	}
+	var corr []*LogicalPredNode
+	for _, term := range conjuncts(stmt.Where.Expr) {
+		pred, err := parsePred(c, term)
+		if err != nil {
+			return nil, nil, err
+		}
+		var names []string
+		correlated := false
+		for _, col := range pred.columns() {
+			name, err := plan.columnScope(c, col)
+			if err == nil && name == "" && col.subquery == nil {
+				name, err = outer.columnScope(c, col)
+				correlated = correlated || name != ""
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			names = append(names, name)
+		}
+		if !correlated {
+			filters, joins, preds, err := parseWhere(c, plan.subqueries, plan.tables, term)
+			if err != nil {
+				return nil, nil, err
+			}
+			plan.filters = append(plan.filters, filters...)
+			plan.joins = append(plan.joins, joins...)
+			plan.preds = append(plan.preds, preds...)
+			continue
+		}
+		for i, col := range pred.columns() {
+			col.table = names[i]
+		}
+		corr = append(corr, pred)
+	}
+	return plan, corr, plan.resolveSubqueries(c)
+}
+
+// Return the subqueries used as values in the expression.
+func (lsn *LogicalSelectNode) subqueryValues() []*LogicalSelectNode {
+	if lsn.subquery != nil {
+		return []*LogicalSelectNode{lsn}
+	}
+	var values []*LogicalSelectNode
+	for _, arg := range lsn.args {
+		values = append(values, arg.subqueryValues()...)
+	}
+	return values
+}
+
+// Return the subqueries used as values in the predicate, and whether it has
+// IN or EXISTS subqueries.
+func (p *LogicalPredNode) subqueryValues() ([]*LogicalSelectNode, bool) {
+	var values []*LogicalSelectNode
+	hasSubquery := p.subquery != nil
+	for _, lsn := range []*LogicalSelectNode{p.left, p.right} {
+		if lsn != nil {
+			values = append(values, lsn.subqueryValues()...)
+		}
+	}
+	for _, arg := range p.args {
+		argValues, argHasSubquery := arg.subqueryValues()
+		values = append(values, argValues...)
+		hasSubquery = hasSubquery || argHasSubquery
+	}
+	return values, hasSubquery
+}
+
+// Plan the subqueries of the query that are not yet.  IN and EXISTS
+// subqueries that are terms of the WHERE clause become semi joins, those under
+// an OR or NOT of the WHERE clause become mark joins, and subqueries used as
+// values become hidden inputs of the query.
+func (p *LogicalPlan) resolveSubqueries(c *Catalog) error {
+	var preds []*LogicalPredNode
+	for _, pred := range p.preds {
+		sj, err := p.semiJoin(c, pred)
+		if err != nil {
+			return err
+		}
+		if sj != nil {
+			p.semiJoins = append(p.semiJoins, sj)
+			continue
+		}
+		if _, hasSubquery := pred.subqueryValues(); hasSubquery {
+			if err := p.markJoins(c, pred); err != nil {
+				return err
+			}
+			p.markPreds = append(p.markPreds, pred)
+			continue
+		}
+		preds = append(preds, pred)
+	}
+	p.preds = preds
+
+	var values []*LogicalSelectNode
+	for _, s := range p.selects {
+		values = append(values, s.subqueryValues()...)
+	}
+	for _, f := range p.filters {
+		values = append(values, f.fieldExpr.subqueryValues()...)
+		values = append(values, f.constExpr.subqueryValues()...)
+	}
+	var others = append(append([]*LogicalPredNode{}, p.preds...), p.markPreds...)
+	for _, j := range p.joins {
+		if j.left != nil {
+			others = append(others, j.pred())
+		}
+		others = append(others, j.cond...)
+	}
+	for _, sj := range p.semiJoins {
+		if sj.in != nil {
+			values = append(values, sj.in.subqueryValues()...)
+		}
+	}
+	for _, pred := range others {
+		predValues, hasSubquery := pred.subqueryValues()
+		if hasSubquery {
+			return GoDBError{ParseError, "IN and EXISTS subqueries are only supported in WHERE clauses"}
+		}
+		values = append(values, predValues...)
+	}
+	if p.having != nil {
+		if values, hasSubquery := p.having.subqueryValues(); len(values) > 0 || hasSubquery {
+			return GoDBError{ParseError, "subqueries are not supported in HAVING clauses"}
+		}
+	}
+	for _, v := range values {
+		if v.subquery.resolved {
+			continue
+		}
+		v.subquery.resolved = true
+		if err := p.resolveValue(c, v.subquery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the semi join for a term of the WHERE clause, or nil if it is not
+// IN or EXISTS or their negation.
+func (p *LogicalPlan) semiJoin(c *Catalog, pred *LogicalPredNode) (*LogicalSemiJoinNode, error) {
+	anti := false
+	if pred.predType == PredNot && pred.args[0].subquery != nil {
+		anti, pred = true, pred.args[0]
+	}
+	if pred.subquery == nil {
+		return nil, nil
+	}
+	sub, corr, err := parseSubquery(c, pred.subquery.stmt, p)
+	if err != nil {
+		return nil, err
+	}
+	sub.alias = pred.subquery.name
+	sj := &LogicalSemiJoinNode{anti: anti, in: pred.left, plan: sub, cond: corr}
+	if sj.in != nil && (len(sub.selects) != 1 || sub.selects[0].exprType == ExprStar) {
+		return nil, GoDBError{ParseError, "subquery of IN must select one column"}
+	}
+	if len(corr) > 0 {
+		if len(sub.aggs) > 0 || len(sub.groupByFields) > 0 || sub.limit != nil {
+			return nil, GoDBError{ParseError, "correlated IN and EXISTS subqueries cannot aggregate or limit their results"}
+		}
+		// the correlation refers to any column of the subquery
+		if sj.in != nil {
+			sj.value = sub.selects[0]
+		}
+		star := NewStarSelectNode("")
+		sub.selects = []*LogicalSelectNode{&star}
+	}
+	return sj, nil
+}
+
+// Replace the IN and EXISTS subqueries in pred by comparisons of the marks of
+// mark joins with 1, which are unknown if the mark is NULL.
+func (p *LogicalPlan) markJoins(c *Catalog, pred *LogicalPredNode) error {
+	for _, arg := range pred.args {
+		if err := p.markJoins(c, arg); err != nil {
+			return err
+		}
+	}
+	if pred.subquery == nil {
+		return nil
+	}
+	sj, err := p.semiJoin(c, pred)
+	if err != nil {
+		return err
+	}
+	sj.mark = true
+	p.semiJoins = append(p.semiJoins, sj)
+	mark := NewFieldSelectNode(sj.plan.alias, "mark", "")
+	one := NewConstSelectNode("1", "")
+	*pred = LogicalPredNode{predType: PredCompare, left: &mark, predOp: OpEq, right: &one}
+	return nil
+}
+
+// Make the subquery used as a value an input of the query.  If it is not
+// correlated, it is a scalar subquery joined with the rest of the query.
+// Otherwise it must compute an aggregate, and correlate by equalities of its
+// columns with those of the query:  it is then grouped by its columns, and
+// left outer joined with the query on the equalities.
+func (p *LogicalPlan) resolveValue(c *Catalog, sq *LogicalSubqueryNode) error {
+	sub, corr, err := parseSubquery(c, sq.stmt, p)
+	if err != nil {
+		return err
+	}
+	if len(sub.selects) != 1 || sub.selects[0].exprType == ExprStar {
+		return GoDBError{ParseError, "subquery used as a value must select one column"}
+	}
+	sel := sub.selects[0]
+	sel.alias = sq.name
+	sub.alias = sq.name
+	sub.hidden = true
+	p.subqueries = append(p.subqueries, sub)
+	if len(corr) == 0 {
+		sub.scalar = true
+		return nil
+	}
+
+	if sel.exprType != ExprAggr || len(sub.groupByFields) > 0 || sub.limit != nil || sub.distinct {
+		return GoDBError{ParseError, "correlated subquery used as a value must compute a single aggregate"}
+	}
+	innerNames := make(map[string]bool)
+	for _, name := range fromOrder(sub.tables, sub.subqueries) {
+		innerNames[name] = true
+	}
+	var join *LogicalJoinNode
+	var errNotEquality = GoDBError{ParseError, "correlated subquery used as a value must only compare its columns with those of the query for equality"}
+	for i, pred := range corr {
+		if pred.predType != PredCompare || pred.predOp != OpEq || pred.left.exprType != ExprField || pred.right.exprType != ExprField {
+			return errNotEquality
+		}
+		inner, outer := pred.left, pred.right
+		if !innerNames[inner.table] {
+			inner, outer = outer, inner
+		}
+		if !innerNames[inner.table] || innerNames[outer.table] {
+			return errNotEquality
+		}
+		key := *inner
+		key.alias = fmt.Sprintf("%s_%d", sq.name, i)
+		sub.groupByFields = append(sub.groupByFields, &GroupBy{inner})
+		sub.selects = append(sub.selects, &key)
+		right := NewFieldSelectNode(sq.name, key.alias, "")
+		if join == nil {
+			join = &LogicalJoinNode{left: outer, right: &right, predOp: OpEq, joinType: LeftOuterJoin, nullable: []string{sq.name}, sides: [2]string{outer.table, sq.name}}
+		} else {
+			join.cond = append(join.cond, &LogicalPredNode{predType: PredCompare, left: outer, right: &right, predOp: OpEq})
+		}
+	}
+	if *sel.funcOp == "count" { // of no tuples is 0, not NULL
+		join.pad = []DBValue{IntField{0}}
+		for range corr {
+			join.pad = append(join.pad, NullField{})
+		}
+	}
+	// equalities with the value are predicates on the result of the join
+	var joins []*LogicalJoinNode
+	for _, j := range p.joins {
+		if j.left != nil && (j.left.subquery == sq || j.right.subquery == sq) {
+			p.preds = append(p.preds, j.pred())
+		} else {
+			joins = append(joins, j)
+		}
+	}
+	p.joins = append(joins, join)
+	return nil
+}
+
+// Return the equality of a column of each side of a semi join, among
+// the terms of its condition, as expressions on the left and right inputs.
+func semiJoinKey(c *Catalog, p *LogicalPredNode, leftDesc *TupleDesc, rightDesc *TupleDesc, tableMap map[string]*PlanNode) (Expr, Expr, bool) {
+	if p.predType != PredCompare || p.predOp != OpEq || p.left.exprType != ExprField || p.right.exprType != ExprField {
+		return nil, nil, false
+	}
+	for _, sides := range [][2]*LogicalSelectNode{{p.left, p.right}, {p.right, p.left}} {
+		if !descHasField(leftDesc, sides[0]) || !descHasField(rightDesc, sides[1]) {
+			continue
+		}
+		left, _, err := sides[0].generateExpr(c, leftDesc, tableMap)
+		if err != nil {
+			continue
+		}
+		right, _, err := sides[1].generateExpr(c, rightDesc, map[string]*PlanNode{})
+		if err != nil || left.GetExprType().Ftype != right.GetExprType().Ftype {
+			continue
+		}
+		return left, right, true
+	}
+	return nil, nil, false
+}
+
+// Return whether desc has the field of the column col, qualified as col is.
+func descHasField(desc *TupleDesc, col *LogicalSelectNode) bool {
+	for _, f := range desc.Fields {
+		if f.Fname == col.field && f.TableQualifier == col.table {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the semi or anti join of op, the rest of the query, with the
+// subquery of sj.  IN joins on the value of the subquery, and EXISTS on the
+// first equality of its correlation, if any, with hash joins;  NOT IN, which
+// is not true if either side is NULL, and EXISTS on other conditions use
+// nested loop joins, as do mark joins.
+func makeSemiJoin(c *Catalog, sj *LogicalSemiJoinNode, op Operator, tableMap map[string]*PlanNode) (Operator, error) {
+	sub, err := makePhysicalPlan(c, sj.plan)
+	if err != nil {
+		return nil, err
+	}
+	if len(sj.cond) == 0 {
+		// the subquery may read a table of the query without an alias, so
+		// its columns are qualified by the name of the subquery instead
+		sub = requalify(sub, sj.plan.alias).op
+	}
+	leftDesc, rightDesc := op.Descriptor(), sub.Descriptor()
+	joinType := SemiJoin
+	if sj.anti {
+		joinType = AntiJoin
+	}
+	cond := sj.cond
+	var leftExpr, rightExpr Expr
+	if sj.in != nil {
+		leftExpr, _, err = sj.in.generateExpr(c, leftDesc, tableMap)
+		if err != nil {
+			return nil, err
+		}
+		rightExpr = &FieldExpr{rightDesc.Fields[0]}
+		if sj.value != nil {
+			rightExpr, _, err = sj.value.generateExpr(c, rightDesc, map[string]*PlanNode{})
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else if !sj.mark {
+		for i, p := range cond {
+			if l, r, ok := semiJoinKey(c, p, leftDesc, rightDesc, tableMap); ok {
+				leftExpr, rightExpr = l, r
+				cond = append(append([]*LogicalPredNode{}, cond[:i]...), cond[i+1:]...)
+				break
+			}
+		}
+	}
+	var pred *PredExpr
+	if len(cond) > 0 {
+		pred, err = (&LogicalPredNode{predType: PredAnd, args: cond}).generatePred(c, leftDesc.merge(rightDesc), tableMap)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if sj.mark {
+		var mark *PredExpr
+		if sj.in != nil {
+			mark = NewComparePred(leftExpr, OpEq, rightExpr)
+		}
+		return NewMarkJoin(op, sub, pred, mark, FieldType{"mark", sj.plan.alias, IntType}, JoinBufferSize)
+	}
+	if sj.anti && sj.in != nil {
+		null := &ConstExpr{NullField{}, UnknownType}
+		matches := NewOrPred(NewComparePred(leftExpr, OpEq, rightExpr), NewComparePred(leftExpr, OpIsNull, null), NewComparePred(rightExpr, OpIsNull, null))
+		if pred != nil {
+			matches = NewAndPred(matches, pred)
+		}
+		return NewNestedLoopJoin(op, sub, joinType, matches, JoinBufferSize)
+	}
+	if leftExpr != nil {
+		return NewOuterHashJoin(op, leftExpr, sub, rightExpr, joinType, pred, JoinBufferSize)
+	}
+	return NewNestedLoopJoin(op, sub, joinType, pred, JoinBufferSize)
+}
@@ -0,0 +1,98 @@
+package godb
+
+import (
+	"testing"
+)
+
+func TestSubqueries(t *testing.T) {
+	c := makeOuterJoinTestCatalog(t)
+	var queries = []struct {
+		query string
+		rows  int
+	}{
+		{"select * from a where x in (1, 3)", 2},
+		{"select * from a where x not in (1, 3)", 2},
+		{"select * from a where x in (select x from b)", 3},
+		{"select * from a where x in (select x from b) and y = 1", 3},
+		// b.x has a NULL, so x NOT IN is never true
+		{"select * from a where x not in (select x from b)", 0},
+		{"select * from a where x not in (select x from b where x is not null)", 1},
+		{"select * from a where x in (select x from b where b.y = a.y + 1)", 3},
+		{"select * from a where exists (select * from b where b.x = a.x)", 3},
+		{"select * from a where not exists (select * from b where b.x = a.x)", 2},
+		{"select * from a where exists (select * from b where b.x > a.x)", 4},
+		{"select * from a where exists (select * from b where x > 3)", 5},
+		{"select * from a where not exists (select * from b where x > 4)", 5},
+		{"select * from a where exists (select * from a a2 where a2.x = a.x + 1)", 3},
+		{"select * from a a1 where a1.x in (select a2.x from a a2 where a2.y = a1.y)", 4},
+		{"select * from a where x = (select min(x) from b)", 2},
+		{"select * from a where x < (select max(x) from b)", 4},
+		{"select * from a where (select count(*) from b where b.x = a.x) = 2", 1},
+		{"select * from a where y < (select max(y) from b where b.x = a.x)", 3},
+		// under OR and NOT, IN and EXISTS may be unknown like any comparison
+		{"select * from a where x = 1 or x in (select x from b)", 4},
+		{"select * from a where x = 1 or x not in (select x from b)", 1},
+		{"select * from a where not (x in (select x from b where x is not null))", 1},
+		{"select * from a where y = 2 or x in (select x from b where b.y = a.y + 1)", 3},
+		{"select * from a where x = 1 or exists (select * from b where b.x = a.x + 2)", 3},
+		{"select * from a where x = 3 or not exists (select * from b where b.x = a.x)", 3},
+		{"select * from a where not (x in (select x from b) and y = 1)", 0},
+		// the subquery reads the table of the query without an alias
+		{"select * from a where x = 1 or x in (select x from a where x = 2)", 3},
+		{"select * from a where not (x in (select x from a where x > 1))", 1},
+		{"select * from a where x = 3 or not (x in (select x from a where x > 1))", 2},
+	}
+	for _, q := range queries {
+		if res := runTestQuery(t, c, q.query); len(res) != q.rows {
+			t.Errorf("%s: expected %d results, got %d", q.query, q.rows, len(res))
+		}
+	}
+
+	res := runTestQuery(t, c, "select x, (select count(*) from b where b.x = a.x) as n, (select max(x) from b) from a")
+	var expected = map[int64]int64{1: 0, 2: 1, 3: 2}
+	if len(res) != 5 {
+		t.Fatalf("expected 5 results, got %d", len(res))
+	}
+	for _, tup := range res {
+		if len(tup.Fields) != 3 || tup.Desc.Fields[1].Fname != "n" {
+			t.Fatalf("wrong columns %v", tup.Desc)
+		}
+		n := tup.Fields[1].(IntField).Value
+		if max := tup.Fields[2].(IntField).Value; max != 4 {
+			t.Errorf("expected max(x) = 4, got %d", max)
+		}
+		if isNull(tup.Fields[0]) {
+			if n != 0 {
+				t.Errorf("expected a count of 0 for NULL, got %d", n)
+			}
+		} else if x := tup.Fields[0].(IntField).Value; n != expected[x] {
+			t.Errorf("expected a count of %d for %d, got %d", expected[x], x, n)
+		}
+	}
+	if res := runTestQuery(t, c, "select * from a where x = (select min(x) from b)"); len(res[0].Fields) != 2 {
+		t.Errorf("expected the value of the subquery not to be selected, got %v", res[0].Desc)
+	}
+	if res := runTestQuery(t, c, "select * from a where x = 1 or x in (select x from b)"); len(res[0].Fields) != 2 {
+		t.Errorf("expected the mark of the subquery not to be selected, got %v", res[0].Desc)
+	}
+
+	for _, q := range []string{
+		"select * from a where x in (select x, y from b)",
+		"select * from a where x = (select max(x) from b where b.x > a.x)",
+		"select * from a where x = (select max(x) from b where b.x = a.x + 1)",
+	} {
+		if _, _, err := Parse(c, q); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+	_, plan, err := Parse(c, "select * from a where x = (select x from b)")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid := NewTID()
+	c.bp.BeginTransaction(tid)
+	defer c.bp.CommitTransaction(tid)
+	if _, err := plan.Iterator(tid); err == nil {
+		t.Errorf("expected an error for a subquery used as a value returning several rows")
+	}
+}
@@ -0,0 +1,278 @@
+package godb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TableStats are the statistics of a table collected by ANALYZE (see
+// [Catalog.analyze]), used to estimate the cardinality of filters and joins
+// when ordering joins (see [orderJoins]).  They are not maintained by inserts
+// and deletes, so they are only as recent as the last ANALYZE.
+type TableStats struct {
+	numTuples int
+	columns   []*ColumnStats // one per field of the table
+}
+
+// ColumnStats are the statistics of one column of a table.
+type ColumnStats struct {
+	distinct int // number of distinct non-NULL values
+	nulls    int
+	hist     *histogram // nil if every value of the column is NULL
+}
+
+// An equi-width histogram of the non-NULL values of a column.  Strings are
+// mapped to ints by [histValue].
+type histogram struct {
+	min, max int64
+	buckets  []int
+	count    int // number of values in the histogram
+}
+
+// Maximum number of buckets of a histogram
+const histogramBuckets int = 64
+
+// Default selectivity of predicates that statistics say nothing about
+const defaultSelectivity float64 = 0.1
+
+// Return the value of v in a histogram.  Strings are mapped to their first 7
+// bytes, which preserves their order up to strings with the same prefix.
+func histValue(v DBValue) int64 {
+	switch v := v.(type) {
+	case IntField:
+		return v.Value
+	case StringField:
+		var res int64
+		for i := 0; i < 7; i++ {
+			res <<= 8
+			if i < len(v.Value) {
+				res |= int64(v.Value[i])
+			}
+		}
+		return res
+	}
+	return 0
+}
+
+func newHistogram(min int64, max int64) *histogram {
+	var numBuckets int64 = int64(histogramBuckets)
+	if max-min+1 < numBuckets {
+		numBuckets = max - min + 1
+	}
+	return &histogram{min: min, max: max, buckets: make([]int, numBuckets)}
+}
+
+// Width of the buckets of the histogram;  every bucket covers the same range.
+func (h *histogram) width() float64 {
+	return (float64(h.max) - float64(h.min) + 1) / float64(len(h.buckets))
+}
+
+func (h *histogram) bucket(v int64) int {
+	b := int((float64(v) - float64(h.min)) / h.width())
+	if b >= len(h.buckets) {
+		b = len(h.buckets) - 1
+	}
+	return b
+}
+
+func (h *histogram) add(v int64) {
+	h.buckets[h.bucket(v)]++
+	h.count++
+}
+
+// Return the estimated fraction of the values of the histogram that are less
+// than v.
+func (h *histogram) fractionBelow(v int64) float64 {
+	if v <= h.min || h.count == 0 {
+		return 0
+	}
+	if v > h.max {
+		return 1
+	}
+	var b = h.bucket(v)
+	var below = 0
+	for i := 0; i < b; i++ {
+		below += h.buckets[i]
+	}
+	bucketStart := float64(h.min) + float64(b)*h.width()
+	part := (float64(v) - bucketStart) / h.width() * float64(h.buckets[b])
+	return (float64(below) + part) / float64(h.count)
+}
+
+// Return the estimated fraction of the tuples of the table whose value in
+// this column satisfies "value op v".
+func (s *TableStats) selectivity(field int, op BoolOp, v DBValue) float64 {
+	if s.numTuples == 0 {
+		return 0
+	}
+	var col = s.columns[field]
+	var nullFraction = float64(col.nulls) / float64(s.numTuples)
+	switch op {
+	case OpIsNull:
+		return nullFraction
+	case OpIsNotNull:
+		return 1 - nullFraction
+	}
+	if isNull(v) || col.hist == nil {
+		return 0
+	}
+	var h = col.hist
+	var x = histValue(v)
+	var eq float64
+	if x >= h.min && x <= h.max {
+		eq = 1 / float64(col.distinct)
+	}
+	var sel float64
+	switch op {
+	case OpEq:
+		sel = eq
+	case OpNeq:
+		sel = 1 - eq
+	case OpLt:
+		sel = h.fractionBelow(x)
+	case OpLe:
+		sel = h.fractionBelow(x) + eq
+	case OpGt:
+		sel = 1 - h.fractionBelow(x) - eq
+	case OpGe:
+		sel = 1 - h.fractionBelow(x)
+	default:
+		sel = defaultSelectivity
+	}
+	if sel < 0 {
+		sel = 0
+	} else if sel > 1 {
+		sel = 1
+	}
+	return sel * (1 - nullFraction)
+}
+
+// Collect the statistics of the tuples returned by the iterators returned by
+// newIter, which must return the same tuples every time.  The tuples are read
+// twice, first to find the range of every column and then to fill the
+// histograms.
+func collectStats(desc *TupleDesc, newIter func() (func() (*Tuple, error), error)) (*TableStats, error) {
+	var stats = &TableStats{}
+	var numFields = len(desc.Fields)
+	var distinct = make([]map[DBValue]bool, numFields)
+	var mins = make([]int64, numFields)
+	var maxs = make([]int64, numFields)
+	for i := range desc.Fields {
+		distinct[i] = make(map[DBValue]bool)
+		stats.columns = append(stats.columns, &ColumnStats{})
+	}
+	err := forEachTuple(newIter, func(t *Tuple) {
+		stats.numTuples++
+		for i, v := range t.Fields {
+			if isNull(v) {
+				stats.columns[i].nulls++
+				continue
+			}
+			x := histValue(v)
+			if len(distinct[i]) == 0 || x < mins[i] {
+				mins[i] = x
+			}
+			if len(distinct[i]) == 0 || x > maxs[i] {
+				maxs[i] = x
+			}
+			distinct[i][v] = true
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, col := range stats.columns {
+		col.distinct = len(distinct[i])
+		if col.distinct > 0 {
+			col.hist = newHistogram(mins[i], maxs[i])
+		}
+	}
+	err = forEachTuple(newIter, func(t *Tuple) {
+		for i, v := range t.Fields {
+			if !isNull(v) {
+				stats.columns[i].hist.add(histValue(v))
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func forEachTuple(newIter func() (func() (*Tuple, error), error), f func(*Tuple)) error {
+	iter, err := newIter()
+	if err != nil {
+		return err
+	}
+	for {
+		t, err := iter()
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return nil
+		}
+		f(t)
+	}
+}
+
+// Return the statistics as they are stored in the catalog (see
+// [parseCatalogFile]):  the number of tuples, followed by, for every column,
+// the name of the column, the number of distinct values and NULLs, and the
+// minimum, maximum and buckets of its histogram, if it has one.
+func (s *TableStats) String(desc *TupleDesc) string {
+	var cols = []string{strconv.Itoa(s.numTuples)}
+	for i, col := range s.columns {
+		str := fmt.Sprintf("%s %d %d", desc.Fields[i].Fname, col.distinct, col.nulls)
+		if col.hist != nil {
+			str += fmt.Sprintf(" %d %d", col.hist.min, col.hist.max)
+			for _, b := range col.hist.buckets {
+				str += " " + strconv.Itoa(b)
+			}
+		}
+		cols = append(cols, str)
+	}
+	return strings.Join(cols, ", ")
+}
+
+// Parse statistics written by [TableStats.String] for a table with the
+// specified TupleDesc.
+func parseTableStats(str string, desc *TupleDesc) (*TableStats, error) {
+	var cols = strings.Split(str, ",")
+	if len(cols) != len(desc.Fields)+1 {
+		return nil, GoDBError{ParseError, fmt.Sprintf("expected statistics of %d columns (%s)", len(desc.Fields), str)}
+	}
+	numTuples, err := strconv.Atoi(strings.TrimSpace(cols[0]))
+	if err != nil {
+		return nil, GoDBError{ParseError, fmt.Sprintf("malformed tuple count in statistics (%s)", str)}
+	}
+	var stats = &TableStats{numTuples: numTuples}
+	for i, colStr := range cols[1:] {
+		words := strings.Fields(colStr)
+		if len(words) < 3 || words[0] != desc.Fields[i].Fname || len(words) == 4 {
+			return nil, GoDBError{ParseError, fmt.Sprintf("malformed column statistics (%s)", colStr)}
+		}
+		nums := make([]int64, len(words)-1)
+		for j, w := range words[1:] {
+			nums[j], err = strconv.ParseInt(w, 10, 64)
+			if err != nil {
+				return nil, GoDBError{ParseError, fmt.Sprintf("malformed column statistics (%s)", colStr)}
+			}
+		}
+		col := &ColumnStats{distinct: int(nums[0]), nulls: int(nums[1])}
+		if len(nums) > 2 {
+			col.hist = newHistogram(nums[2], nums[3])
+			if len(nums)-4 != len(col.hist.buckets) {
+				return nil, GoDBError{ParseError, fmt.Sprintf("wrong number of histogram buckets (%s)", colStr)}
+			}
+			for j, b := range nums[4:] {
+				col.hist.buckets[j] = int(b)
+				col.hist.count += int(b)
+			}
+		}
+		stats.columns = append(stats.columns, col)
+	}
+	return stats, nil
+}
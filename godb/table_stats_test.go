@@ -0,0 +1,111 @@
+package godb
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	c, dir := makeIndexTestCatalog(t, 100)
+	runTestQuery(t, c, "insert into t values (null, null)")
+	qType, _, err := Parse(c, "analyze t")
+	if err != nil || qType != AnalyzeQueryType {
+		t.Fatalf("failed to analyze, %v", err)
+	}
+	stats := c.tableMap["t"].stats
+	if stats == nil || stats.numTuples != 101 {
+		t.Fatalf("expected statistics of 101 tuples, got %v", stats)
+	}
+	if stats.columns[0].distinct != 100 || stats.columns[1].distinct != 10 || stats.columns[1].nulls != 1 {
+		t.Errorf("wrong column statistics")
+	}
+	var estimates = []struct {
+		op  BoolOp
+		v   DBValue
+		sel float64
+	}{
+		{OpEq, IntField{3}, 0.1},
+		{OpEq, IntField{30}, 0},
+		{OpLt, IntField{3}, 0.3},
+		{OpGe, IntField{7}, 0.3},
+		{OpNeq, IntField{3}, 0.9},
+		{OpIsNull, NullField{}, 0.01},
+	}
+	for _, e := range estimates {
+		sel := stats.selectivity(1, e.op, e.v)
+		if math.Abs(sel-e.sel) > 0.02 {
+			t.Errorf("expected selectivity %f for %v %v, got %f", e.sel, e.op, e.v, sel)
+		}
+	}
+
+	// statistics are saved with the catalog
+	err = c.SaveToFile("catalog.txt", dir)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	c2, err := NewCatalogFromFile("catalog.txt", NewBufferPool(50), dir)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	stats2 := c2.tableMap["t"].stats
+	if stats2 == nil || stats2.String(&c2.tableMap["t"].desc) != stats.String(&c.tableMap["t"].desc) {
+		t.Errorf("statistics not saved with catalog")
+	}
+}
+
+func TestJoinOrder(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(dir+"/catalog.txt", []byte("a (x int)\nb (x int)\ns (x int)\n"), 0644)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	c, err := NewCatalogFromFile("catalog.txt", NewBufferPool(50), dir)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i := 0; i < 40; i++ {
+		runTestQuery(t, c, fmt.Sprintf("insert into a values (%d)", i%2))
+		runTestQuery(t, c, fmt.Sprintf("insert into b values (%d)", i%2))
+	}
+	runTestQuery(t, c, "insert into s values (0)")
+
+	// a is joined with b first unless the tables are analyzed, which shows
+	// that joining b with s first is cheaper
+	query := "select * from a, b, s where a.x = b.x and b.x = s.x"
+	for _, analyzed := range []bool{false, true} {
+		if analyzed {
+			for _, table := range []string{"a", "b", "s"} {
+				if _, _, err := Parse(c, "analyze "+table); err != nil {
+					t.Fatalf(err.Error())
+				}
+			}
+		}
+		_, plan, err := Parse(c, query)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		join, ok := planInput(plan).(*HashJoin)
+		if !ok {
+			t.Fatalf("expected a hash join")
+		}
+		inner := join.left
+		if _, ok := inner.(*HashJoin); !ok {
+			inner = join.right
+		}
+		joinsA := false
+		for _, f := range inner.Descriptor().Fields {
+			joinsA = joinsA || f.TableQualifier == "a"
+		}
+		if joinsA == analyzed {
+			t.Errorf("expected a joined first: %t", !analyzed)
+		}
+		if !plan.Descriptor().equals(&TupleDesc{[]FieldType{{"x", "a", IntType}, {"x", "b", IntType}, {"x", "s", IntType}}}) {
+			t.Errorf("wrong columns %v", plan.Descriptor())
+		}
+		if res := runTestQuery(t, c, query); len(res) != 400 {
+			t.Errorf("expected 400 results, got %d", len(res))
+		}
+	}
+}
@@ -162,7 +162,7 @@ func transactionTestSetUpVarLen(t *testing.T, tupCnt int, pgCnt int) (*BufferPoo
 }
 
 func transactionTestSetUp(t *testing.T) (*BufferPool, *HeapFile, TransactionID, TransactionID, Tuple) {
-	bp, hf, tid1, tid2, t1, _ := transactionTestSetUpVarLen(t, 300, 3)
+	bp, hf, tid1, tid2, t1, _ := transactionTestSetUpVarLen(t, 400, 3)
 	return bp, hf, tid1, tid2, t1
 }
 
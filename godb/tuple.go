@@ -7,6 +7,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
+	"math"
 	"strings"
 
 	"github.com/mitchellh/hashstructure/v2"
@@ -42,6 +44,9 @@ type TupleDesc struct {
 	Fields []FieldType
 }
 
+// Nominal size of a tuple, counting strings as StringLength bytes.  Tuples are
+// stored as variable length records (see [Tuple.writeTo]), so this is only an
+// estimate.
 func (d *TupleDesc) Size() int {
 	var size int = 0
 	for _, f := range d.Fields {
@@ -149,6 +154,15 @@ type StringField struct {
 	Value string
 }
 
+// NULL field value.  A NULL can be stored in a field of any type.
+type NullField struct {
+}
+
+func isNull(v DBValue) bool {
+	_, ok := v.(NullField)
+	return ok
+}
+
 // Tuple represents the contents of a tuple read from a database
 // It includes the tuple descriptor, and the value of the fields
 type Tuple struct {
@@ -165,44 +179,63 @@ type Rid struct {
 	SlotNo int
 }
 
-// Serialize the contents of the tuple into a byte array Since all tuples are of
-// fixed size, this method should simply write the fields in sequential order
-// into the supplied buffer.
-//
-// See the function [binary.Write].  Objects should be serialized in little
-// endian oder.
-//
-// Strings can be converted to byte arrays by casting to []byte. Note that all
-// strings need to be padded to StringLength bytes (set in types.go). For
-// example if StringLength is set to 5, the string 'mit' should be written as
-// 'm', 'i', 't', 0, 0
-//
-// May return an error if the buffer has insufficient capacity to store the
-// tuple.
+// Pad (or truncate) s to exactly n bytes.  Used for fixed size string keys
+// (see [btreePage]).
 func makeFixedBytes(s string, n int) []byte {
 	var fixed = make([]byte, n)
-	for i := 0; i < len(s); i++ {
-		if i < len(s) {
-			fixed[i] = s[i]
-		} else {
-			fixed[i] = 0
-		}
-	}
+	copy(fixed, s)
 	return fixed
 }
 
+// Serialize the contents of the tuple into a variable length record:  a
+// bitmap with one bit per field, set if the field is NULL, followed by the
+// non-NULL fields in sequential order.  Ints are written as 8 bytes, strings
+// as their length (a uint16) followed by their bytes, so strings keep their
+// real length.
+//
+// See the function [binary.Write].  Objects should be serialized in little
+// endian oder.
+//
+// May return an error if a string is too long to be stored.
 func (t *Tuple) writeTo(b *bytes.Buffer) error {
-	var err error
+	var nulls = make([]byte, nullBitmapSize(len(t.Fields)))
+	for i, f := range t.Fields {
+		if isNull(f) {
+			nulls[i/8] |= 1 << (i % 8)
+		}
+	}
+	b.Write(nulls)
 	for i := 0; i < len(t.Fields); i++ {
 		switch f := t.Fields[i].(type) {
 		case IntField:
-			err = binary.Write(b, binary.LittleEndian, f.Value)
+			binary.Write(b, binary.LittleEndian, f.Value)
+		case StringField:
+			if len(f.Value) > math.MaxUint16 {
+				return GoDBError{MalformedDataError, fmt.Sprintf("string of %d bytes is too long", len(f.Value))}
+			}
+			binary.Write(b, binary.LittleEndian, uint16(len(f.Value)))
+			b.WriteString(f.Value)
+		}
+	}
+	return nil
+}
+
+// Return the number of bytes [Tuple.writeTo] writes for the tuple.
+func (t *Tuple) recordSize() int {
+	var size = nullBitmapSize(len(t.Fields))
+	for _, f := range t.Fields {
+		switch f := f.(type) {
+		case IntField:
+			size += 8
 		case StringField:
-			fixed := makeFixedBytes(f.Value, StringLength)
-			b.Write(fixed)
+			size += 2 + len(f.Value)
 		}
 	}
-	return err
+	return size
+}
+
+func nullBitmapSize(numFields int) int {
+	return (numFields + 7) / 8
 }
 
 func (t *Tuple) Print() {
@@ -212,6 +245,8 @@ func (t *Tuple) Print() {
 			fmt.Printf(" %d ", f.Value)
 		case StringField:
 			fmt.Printf(" %s ", f.Value)
+		case NullField:
+			fmt.Printf(" NULL ")
 		}
 	}
 }
@@ -224,24 +259,15 @@ func (t *Tuple) ToString() string {
 			fmt.Fprintf(&b, " %d ", f.Value)
 		case StringField:
 			fmt.Fprintf(&b, " %s ", f.Value)
+		case NullField:
+			fmt.Fprintf(&b, " NULL ")
 		}
 	}
 	return b.String()
 }
 
-// Read the contents of a tuple with the specified [TupleDesc] from the
-// specified buffer, returning a Tuple.
-//
-// See [binary.Read]. Objects should be deserialized in little endian oder.
-//
-// All strings are stored as StringLength byte objects.
-//
-// Strings with length < StringLength will be padded with zeros, and these
-// trailing zeros should be removed from the strings.  A []byte can be cast
-// directly to string.
-//
-// May return an error if the buffer has insufficent data to deserialize the
-// tuple.
+// Read a fixed size string of len bytes, written by [makeFixedBytes].
+// Trailing zeros are removed from the string.
 func readString(b *bytes.Buffer, len int) (string, error) {
 	var s string
 	var data = make([]byte, len)
@@ -259,10 +285,26 @@ func readString(b *bytes.Buffer, len int) (string, error) {
 	return s, nil
 }
 
+// Read the contents of a tuple with the specified [TupleDesc], written by
+// [Tuple.writeTo], from the specified buffer, returning a Tuple.
+//
+// See [binary.Read]. Objects should be deserialized in little endian oder.
+//
+// May return an error if the buffer has insufficent data to deserialize the
+// tuple.
 func readTupleFrom(b *bytes.Buffer, desc *TupleDesc) (*Tuple, error) {
 	var tp Tuple = Tuple{}
 	tp.Desc = *desc.copy()
+	var nulls = make([]byte, nullBitmapSize(len(desc.Fields)))
+	_, err := io.ReadFull(b, nulls)
+	if err != nil {
+		return nil, err
+	}
 	for i := 0; i < len(desc.Fields); i++ {
+		if nulls[i/8]&(1<<(i%8)) != 0 {
+			tp.Fields = append(tp.Fields, NullField{})
+			continue
+		}
 		switch desc.Fields[i].Ftype {
 		case IntType:
 			var num int64
@@ -272,15 +314,20 @@ func readTupleFrom(b *bytes.Buffer, desc *TupleDesc) (*Tuple, error) {
 			}
 			tp.Fields = append(tp.Fields, IntField{Value: num})
 		case StringType:
-			str, err := readString(b, StringLength)
+			var n uint16
+			err := binary.Read(b, binary.LittleEndian, &n)
 			if err != nil {
 				return nil, err
 			}
-			tp.Fields = append(tp.Fields, StringField{Value: str})
+			var data = make([]byte, n)
+			_, err = io.ReadFull(b, data)
+			if err != nil {
+				return nil, err
+			}
+			tp.Fields = append(tp.Fields, StringField{Value: string(data)})
 		}
 	}
-	return &tp, nil //replace me
-
+	return &tp, nil
 }
 
 // Compare two tuples for equality.  Equality means that the TupleDescs are equal
@@ -309,11 +356,25 @@ func (t1 *Tuple) equals(t2 *Tuple) bool {
 			if f1.Value != f2.Value {
 				return false
 			}
+		case NullField:
+			if !isNull(t2.Fields[i]) {
+				return false
+			}
 		}
 	}
 	return true
 }
 
+// Return a tuple with descriptor desc whose fields are all NULL, which outer
+// joins use in place of a missing match.
+func nullTuple(desc *TupleDesc) *Tuple {
+	var fields = make([]DBValue, len(desc.Fields))
+	for i := range fields {
+		fields[i] = NullField{}
+	}
+	return &Tuple{*desc.copy(), fields, nil}
+}
+
 // Merge two tuples together, producing a new tuple with the fields of t2 appended to t1.
 func joinTuples(t1 *Tuple, t2 *Tuple) *Tuple {
 	if t1 == nil || t2 == nil {
@@ -365,6 +426,17 @@ func (t *Tuple) compareField(t2 *Tuple, field Expr) (orderByState, error) {
 		return OrderedEqual, err
 	}
 
+	if isNull(v1) || isNull(v2) {
+		// NULLs sort first
+		switch c := compareDBValue(v1, v2); {
+		case c < 0:
+			return OrderedLessThan, nil
+		case c > 0:
+			return OrderedGreaterThan, nil
+		}
+		return OrderedEqual, nil
+	}
+
 	switch v1.(type) {
 	case IntField:
 		if v1.(IntField).Value < v2.(IntField).Value {
@@ -387,6 +459,34 @@ func (t *Tuple) compareField(t2 *Tuple, field Expr) (orderByState, error) {
 	}
 }
 
+// Compare two field values of the same type, returning a negative number, zero
+// or a positive number if v1 is smaller than, equal to or greater than v2.
+// NULLs are equal to each other and sort before all other values.
+func compareDBValue(v1 DBValue, v2 DBValue) int {
+	if isNull(v1) || isNull(v2) {
+		if !isNull(v1) {
+			return 1
+		}
+		if !isNull(v2) {
+			return -1
+		}
+		return 0
+	}
+	switch v1 := v1.(type) {
+	case IntField:
+		v2 := v2.(IntField)
+		if v1.Value < v2.Value {
+			return -1
+		}
+		if v1.Value > v2.Value {
+			return 1
+		}
+	case StringField:
+		return strings.Compare(v1.Value, v2.(StringField).Value)
+	}
+	return 0
+}
+
 // Project out the supplied fields from the tuple. Should return a new Tuple
 // with just the fields named in fields.
 //
@@ -463,6 +563,8 @@ func (t *Tuple) PrettyPrintString(aligned bool) string {
 			str = fmt.Sprintf("%d", f.Value)
 		case StringField:
 			str = f.Value
+		case NullField:
+			str = "NULL"
 		}
 		if aligned {
 			outstr = fmt.Sprintf("%s %s", outstr, fmtCol(str, len(t.Fields)))
@@ -24,6 +24,9 @@ const (
 	IllegalOperationError   GoDBErrorCode = iota
 	DeadlockError           GoDBErrorCode = iota
 	IllegalTransactionError GoDBErrorCode = iota
+	DuplicateKeyError       GoDBErrorCode = iota
+	ForeignKeyError         GoDBErrorCode = iota
+	LogError                GoDBErrorCode = iota
 )
 
 type GoDBError struct {
@@ -46,12 +49,12 @@ type Page interface {
 	isDirty() bool
 	setDirty(dirty bool)
 	getFile() *DBFile
+	getPageNo() int
 }
 
 func PageKey(p Page) any {
 	file := p.getFile()
-	hp := p.(*heapPage)
-	return (*file).pageKey(hp.pageId)
+	return (*file).pageKey(p.getPageNo())
 }
 
 type DBFile interface {
@@ -81,17 +84,74 @@ const (
 	OpEq   BoolOp = iota
 	OpNeq  BoolOp = iota
 	OpLike BoolOp = iota
+	// unary, the constant of a filter testing for NULL is ignored
+	OpIsNull    BoolOp = iota
+	OpIsNotNull BoolOp = iota
 )
 
 var BoolOpMap = map[string]BoolOp{
-	">":    OpGt,
-	"<":    OpLt,
-	"<=":   OpLe,
-	">=":   OpGe,
-	"=":    OpEq,
-	"<>":   OpNeq,
-	"!=":   OpNeq,
-	"like": OpLike,
+	">":           OpGt,
+	"<":           OpLt,
+	"<=":          OpLe,
+	">=":          OpGe,
+	"=":           OpEq,
+	"<>":          OpNeq,
+	"!=":          OpNeq,
+	"like":        OpLike,
+	"is null":     OpIsNull,
+	"is not null": OpIsNotNull,
+}
+
+// Which inputs of a join return their unmatched tuples, padded with NULLs for
+// the fields of the other input.  Semi and anti joins return only the tuples
+// of their left input that match, or do not match, respectively.  Mark joins
+// return every tuple of their left input once, with a field that tells whether
+// it matched (see [NewMarkJoin]).
+type JoinType int
+
+const (
+	InnerJoin      JoinType = iota
+	LeftOuterJoin  JoinType = iota
+	RightOuterJoin JoinType = iota
+	FullOuterJoin  JoinType = iota
+	SemiJoin       JoinType = iota
+	AntiJoin       JoinType = iota
+	MarkJoin       JoinType = iota
+)
+
+// Return whether the unmatched tuples of the left and right inputs of a join
+// are returned.
+func (j JoinType) preserves() (left bool, right bool) {
+	return j == LeftOuterJoin || j == FullOuterJoin, j == RightOuterJoin || j == FullOuterJoin
+}
+
+// Return whether the join returns only tuples of its left input.
+func (j JoinType) filtersLeft() bool {
+	return j == SemiJoin || j == AntiJoin
+}
+
+// Return whether the join returns each tuple of its left input at most once,
+// so it is done with a tuple once it matched.
+func (j JoinType) returnsLeftOnce() bool {
+	return j.filtersLeft() || j == MarkJoin
+}
+
+func (j JoinType) String() string {
+	switch j {
+	case SemiJoin:
+		return "Semi"
+	case AntiJoin:
+		return "Anti"
+	case MarkJoin:
+		return "Mark"
+	case LeftOuterJoin:
+		return "Left Outer"
+	case RightOuterJoin:
+		return "Right Outer"
+	case FullOuterJoin:
+		return "Full Outer"
+	}
+	return "Inner"
 }
 
 func evalPred[T constraints.Ordered](i1 T, i2 T, op BoolOp) bool {
@@ -125,3 +185,34 @@ func evalPred[T constraints.Ordered](i1 T, i2 T, op BoolOp) bool {
 	return false
 
 }
+
+// Truth value in SQL's three valued logic.  Comparisons with NULL are unknown.
+type triBool int
+
+const (
+	triFalse   triBool = iota
+	triTrue    triBool = iota
+	triUnknown triBool = iota
+)
+
+func toTriBool(b bool) triBool {
+	if b {
+		return triTrue
+	}
+	return triFalse
+}
+
+// Evaluate "v1 op v2" where either value may be NULL, using getter to extract
+// the values to compare with evalPred.
+func evalNullablePred[T constraints.Ordered](v1 DBValue, v2 DBValue, op BoolOp, getter func(DBValue) T) triBool {
+	switch op {
+	case OpIsNull:
+		return toTriBool(isNull(v1))
+	case OpIsNotNull:
+		return toTriBool(!isNull(v1))
+	}
+	if isNull(v1) || isNull(v2) {
+		return triUnknown
+	}
+	return toTriBool(evalPred(getter(v1), getter(v2), op))
+}
@@ -0,0 +1,105 @@
+package godb
+
+import "fmt"
+
+type UpdateOp struct {
+	updateFile DBFile
+	fields     []int // indexes of the fields to set in the tuples of updateFile
+	exprs      []Expr
+	child      Operator
+}
+
+// Files that can replace a tuple in place, keeping its Rid.  Tuples of other
+// files are updated by deleting the old and inserting the new tuple.
+type tupleUpdater interface {
+	updateTuple(old *Tuple, t *Tuple, tid TransactionID) error
+}
+
+// Construtor.  The update operator sets the named fields of the records in the
+// child Operator to the values of the corresponding expressions, which are
+// evaluated on the records before they are updated, and writes the records
+// back to the specified DBFile.
+func NewUpdateOp(updateFile DBFile, fields []FieldType, exprs []Expr, child Operator) (*UpdateOp, error) {
+	if len(fields) != len(exprs) {
+		return nil, GoDBError{IllegalOperationError, "update needs one expression per field"}
+	}
+	var res = UpdateOp{updateFile: updateFile, exprs: exprs, child: child}
+	for i, f := range fields {
+		fieldNo, err := findFieldInTd(FieldType{f.Fname, f.TableQualifier, UnknownType}, updateFile.Descriptor())
+		if err != nil {
+			return nil, err
+		}
+		fieldType := updateFile.Descriptor().Fields[fieldNo].Ftype
+		if exprType := exprs[i].GetExprType().Ftype; exprType != fieldType && !isNullConst(exprs[i]) {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot set %s of type %s to a value of type %s", f.Fname, typeNames[fieldType], typeNames[exprType])}
+		}
+		res.fields = append(res.fields, fieldNo)
+	}
+	return &res, nil
+}
+
+// The update TupleDesc is a one column descriptor with an integer field named "count"
+func (u *UpdateOp) Descriptor() *TupleDesc {
+	var f FieldType = FieldType{"count", "", IntType}
+	var desc TupleDesc = TupleDesc{[]FieldType{f}}
+	return &desc
+}
+
+// Return an iterator function that updates all of the tuples from the child
+// iterator and then returns a one-field tuple with a "count" field indicating
+// the number of tuples that were updated.
+//
+// All tuples are read from the child before the first one is updated, so
+// updated tuples are never seen (and updated again) by the child.
+func (u *UpdateOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	iter, err := u.child.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	var tuples []*Tuple
+	for {
+		tup, err := iter()
+		if err != nil {
+			return nil, err
+		}
+		if tup == nil {
+			break
+		}
+		tuples = append(tuples, tup)
+	}
+
+	for _, old := range tuples {
+		var t = Tuple{*u.updateFile.Descriptor(), make([]DBValue, len(old.Fields)), old.Rid}
+		copy(t.Fields, old.Fields)
+		for i, fieldNo := range u.fields {
+			v, err := u.exprs[i].EvalExpr(old)
+			if err != nil {
+				return nil, err
+			}
+			t.Fields[fieldNo] = v
+		}
+
+		if updater, ok := u.updateFile.(tupleUpdater); ok {
+			err = updater.updateTuple(old, &t, tid)
+		} else {
+			err = u.updateFile.deleteTuple(old, tid)
+			if err == nil {
+				t.Rid = nil
+				err = u.updateFile.insertTuple(&t, tid)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	called := false
+	return func() (*Tuple, error) {
+		if called {
+			return nil, nil
+		}
+		called = true
+		var t Tuple = Tuple{*u.Descriptor(), []DBValue{IntField{int64(len(tuples))}}, nil}
+		return &t, nil
+	}, nil
+}
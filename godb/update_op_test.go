@@ -0,0 +1,156 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	_, t1, t2, hf, bp, tid := makeTestVars()
+	hf.insertTuple(&t1, tid)
+	hf.insertTuple(&t2, tid)
+	bp.CommitTransaction(tid)
+	var f FieldType = FieldType{"age", "", IntType}
+	filt, err := NewIntFilter(&ConstExpr{IntField{25}, IntType}, OpGt, &FieldExpr{f}, hf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var ageExpr Expr = &FieldExpr{f}
+	var oneExpr Expr = &ConstExpr{IntField{1}, IntType}
+	uop, err := NewUpdateOp(hf, []FieldType{f}, []Expr{&FuncExpr{"+", []*Expr{&ageExpr, &oneExpr}}}, filt)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	iter, err := uop.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tup, err := iter()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if tup == nil {
+		t.Fatalf("update did not return tuple")
+	}
+	intField, ok := tup.Fields[0].(IntField)
+	if !ok || len(tup.Fields) != 1 || intField.Value != 1 {
+		t.Errorf("invalid output tuple")
+	}
+	bp.CommitTransaction(tid)
+
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	iter, _ = hf.Iterator(tid)
+	var ages []int64
+	for tup, _ := iter(); tup != nil; tup, _ = iter() {
+		ages = append(ages, tup.Fields[1].(IntField).Value)
+	}
+	if len(ages) != 2 || ages[0] != 25 || ages[1] != 1000 {
+		t.Errorf("unexpected ages after update: %v", ages)
+	}
+	bp.CommitTransaction(tid)
+
+	_, err = NewUpdateOp(hf, []FieldType{f}, []Expr{&ConstExpr{StringField{"x"}, StringType}}, filt)
+	if err == nil {
+		t.Errorf("expected type mismatch error")
+	}
+}
+
+func TestUpdateQuery(t *testing.T) {
+	c, _ := makeIndexTestCatalog(t, 50)
+	if _, _, err := Parse(c, "create index t_age on t (age)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// every tuple matches its own update, so it must only be updated once
+	res := runTestQuery(t, c, "update t set age = age + 10 where age >= 5")
+	if len(res) != 1 || res[0].Fields[0].(IntField).Value != 25 {
+		t.Fatalf("expected 25 updated tuples")
+	}
+	if res := runTestQuery(t, c, "select name from t where age >= 15"); len(res) != 25 {
+		t.Errorf("expected 25 results, got %d", len(res))
+	}
+	if res := runTestQuery(t, c, "select name from t where age = 5"); len(res) != 0 {
+		t.Errorf("old key still in the index")
+	}
+
+	runTestQuery(t, c, "update t set name = 'joe', age = 3 where name = 'sam1'")
+	res = runTestQuery(t, c, "select name from t where age = 3")
+	if len(res) != 6 {
+		t.Errorf("expected 6 results, got %d", len(res))
+	}
+	found := false
+	for _, tup := range res {
+		found = found || tup.Fields[0].(StringField).Value == "joe"
+	}
+	if !found {
+		t.Errorf("updated name not found")
+	}
+
+	if _, _, err := Parse(c, "update t set age = 'old'"); err == nil {
+		t.Errorf("expected error for setting an int column to a string")
+	}
+	if _, _, err := Parse(c, "update t set x = 1"); err == nil {
+		t.Errorf("expected error for unknown column")
+	}
+}
+
+func TestUpdateAbortWithLog(t *testing.T) {
+	dir := makeRecoveryTestDir(t)
+	bp, hf := openRecoveryTestDb(t, dir, 20)
+	tid := NewTID()
+	bp.BeginTransaction(tid)
+	insertTestTuples(t, hf, tid, 100)
+	bp.CommitTransaction(tid)
+
+	var f = hf.Descriptor().Fields[1]
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	uop, err := NewUpdateOp(hf, []FieldType{f}, []Expr{&ConstExpr{IntField{-1}, IntType}}, hf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	iter, err := uop.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err = iter(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	bp.AbortTransaction(tid)
+
+	tid = NewTID()
+	bp.BeginTransaction(tid)
+	iter, _ = hf.Iterator(tid)
+	cnt := 0
+	for tup, _ := iter(); tup != nil; tup, _ = iter() {
+		if tup.Fields[1].(IntField).Value == -1 {
+			t.Fatalf("update not rolled back")
+		}
+		cnt++
+	}
+	if cnt != 100 {
+		t.Errorf("expected 100 tuples, got %d", cnt)
+	}
+	bp.CommitTransaction(tid)
+}
+
+// A tuple that no longer fits on its page after an update moves to another page.
+func TestUpdateLongString(t *testing.T) {
+	c, _ := makeIndexTestCatalog(t, 0)
+	long := strings.Repeat("z", 1500)
+	for i := 0; i < 3; i++ {
+		runTestQuery(t, c, fmt.Sprintf("insert into t values ('%s', %d)", long, i))
+	}
+	runTestQuery(t, c, fmt.Sprintf("update t set name = '%s' where age = 1", long+long))
+	res := runTestQuery(t, c, "select name, age from t where age = 1")
+	if len(res) != 1 || res[0].Fields[0].(StringField).Value != long+long {
+		t.Fatalf("expected the updated tuple")
+	}
+	if res := runTestQuery(t, c, "select name from t"); len(res) != 3 {
+		t.Errorf("expected 3 tuples after update, got %d", len(res))
+	}
+}
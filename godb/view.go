@@ -0,0 +1,173 @@
+package godb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// View is a named query, stored in the catalog, that is planned as a subquery
+// wherever it is referenced.
+type View struct {
+	name    string
+	columns []string // if nil, the columns are named by its query
+	query   string
+}
+
+// sqlparser parses CREATE VIEW but drops its query, and does not parse
+// CASCADE or RESTRICT, so these statements are matched before the query is
+// handed to it.  Views are stored in the catalog file as
+//
+//	view name [(column, ...)] as query
+var (
+	createViewRegexp = regexp.MustCompile(`(?is)^\s*create\s+view\s+(\w+)\s*(?:\(([^)]*)\))?\s*as\s+(.*?)\s*;?\s*$`)
+	viewEntryRegexp  = regexp.MustCompile(`(?is)^view\s+(\w+)\s*(?:\(([^)]*)\))?\s*as\s+(.*?)\s*$`)
+	dropViewRegexp   = regexp.MustCompile(`(?i)^\s*drop\s+(view|table)\s+(if\s+exists\s+)?(\w+)(\s+cascade|\s+restrict)?\s*;?\s*$`)
+)
+
+// Return the view described by m, a match of [createViewRegexp] or
+// [viewEntryRegexp].
+func newView(m []string) *View {
+	v := &View{name: strings.ToLower(m[1]), query: strings.Join(strings.Fields(m[3]), " ")}
+	if strings.TrimSpace(m[2]) != "" {
+		for _, col := range strings.Split(m[2], ",") {
+			v.columns = append(v.columns, strings.ToLower(strings.TrimSpace(col)))
+		}
+	}
+	return v
+}
+
+// Return the catalog file entry of v.
+func (v *View) String() string {
+	s := "view " + v.name
+	if v.columns != nil {
+		s += " (" + strings.Join(v.columns, ", ") + ")"
+	}
+	return s + " as " + v.query
+}
+
+// Process query if it is a CREATE VIEW, DROP VIEW, or DROP TABLE statement.
+// Returns false if it is not.
+func processViewDDL(c *Catalog, query string) (QueryType, bool, error) {
+	if m := createViewRegexp.FindStringSubmatch(query); m != nil {
+		err := c.createView(newView(m))
+		if err != nil {
+			return UnknownQueryType, true, err
+		}
+		return CreateViewQueryType, true, nil
+	}
+	m := dropViewRegexp.FindStringSubmatch(query)
+	if m == nil {
+		return UnknownQueryType, false, nil
+	}
+	name, view := strings.ToLower(m[3]), strings.EqualFold(m[1], "view")
+	qtype := DropTableQueryType
+	if view {
+		qtype = DropViewQueryType
+	}
+	if view && c.findView(name) == nil || !view && c.tableMap[name] == nil {
+		if m[2] != "" {
+			return qtype, true, nil
+		}
+		return UnknownQueryType, true, GoDBError{NoSuchTableError, fmt.Sprintf("couldn't find %s '%s' to drop", strings.ToLower(m[1]), name)}
+	}
+	err := c.dropDependents(name, strings.EqualFold(strings.TrimSpace(m[4]), "cascade"))
+	if err == nil && view {
+		err = c.dropView(name)
+	} else if err == nil {
+		err = c.dropTable(name)
+	}
+	if err != nil {
+		return UnknownQueryType, true, err
+	}
+	return qtype, true, nil
+}
+
+// Return the view with the given name, or nil if there is none.
+func (c *Catalog) findView(named string) *View {
+	for _, v := range c.views {
+		if v.name == named {
+			return v
+		}
+	}
+	return nil
+}
+
+// Add a view to the catalog.  Its query is planned, to check that it is valid.
+func (c *Catalog) createView(v *View) error {
+	if c.tableMap[v.name] != nil || c.findView(v.name) != nil {
+		return GoDBError{DuplicateTableError, fmt.Sprintf("a table or view named '%s' already exists", v.name)}
+	}
+	if _, err := c.viewCTE(v).subplan(v.name); err != nil {
+		return err
+	}
+	c.views = append(c.views, v)
+	return nil
+}
+
+func (c *Catalog) dropView(named string) error {
+	for i, v := range c.views {
+		if v.name == named {
+			c.views = append(c.views[:i], c.views[i+1:]...)
+			return nil
+		}
+	}
+	return GoDBError{NoSuchTableError, fmt.Sprintf("couldn't find view '%s' to drop", named)}
+}
+
+// Return the common table expression a reference to v is planned as.  Its
+// query is parsed without the expressions of the query referencing it.
+func (c *Catalog) viewCTE(v *View) *LogicalCTENode {
+	scope := *c
+	scope.ctes = nil
+	return &LogicalCTENode{name: v.name, columns: v.columns, query: v.query, scope: &scope}
+}
+
+// Return the views that read the table or view named, directly or through
+// other views.
+func (c *Catalog) dependents(named string) []*View {
+	var deps []*View
+	for _, v := range c.views {
+		scope := *c
+		scope.refs = make(map[string]bool)
+		scope.viewCTE(v).subplan(v.name)
+		if v.name != named && scope.refs[named] {
+			deps = append(deps, v)
+		}
+	}
+	return deps
+}
+
+// Drop the views that read the table or view named, and the foreign keys of
+// other tables that reference it, if cascade is set, or else return an error
+// if there are any.
+func (c *Catalog) dropDependents(named string, cascade bool) error {
+	deps := c.dependents(named)
+	var fks []*ForeignKey
+	for _, fk := range c.referencing(named) {
+		if fk.table != named {
+			fks = append(fks, fk)
+		}
+	}
+	if (len(deps) > 0 || len(fks) > 0) && !cascade {
+		var names []string
+		for _, v := range deps {
+			names = append(names, "view "+v.name)
+		}
+		for _, fk := range fks {
+			names = append(names, "foreign key "+fk.name)
+		}
+		return GoDBError{IllegalOperationError, fmt.Sprintf("can't drop '%s', %s depend on it (use CASCADE to drop them too)", named, strings.Join(names, ", "))}
+	}
+	for _, v := range deps {
+		if err := c.dropView(v.name); err != nil {
+			return err
+		}
+	}
+	for _, fk := range fks {
+		if err := c.dropForeignKey(fk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package godb
+
+import (
+	"testing"
+)
+
+func TestViewQuery(t *testing.T) {
+	c := makeOuterJoinTestCatalog(t)
+	for _, q := range []string{
+		"create view big as select x from a where x > 1",
+		"create view small (n) as select x from big where x < 3",
+		"CREATE VIEW later AS WITH t AS (SELECT x FROM b) SELECT x FROM t WHERE x > 3;",
+	} {
+		if _, _, err := Parse(c, q); err != nil {
+			t.Fatalf("failed to create view %s, %s", q, err.Error())
+		}
+	}
+	var queries = []struct {
+		query    string
+		expected []int64 // the sorted first column of the results, -1 for NULL
+	}{
+		{"select x from big", []int64{2, 2, 3}},
+		{"select n from small", []int64{2, 2}},
+		{"select big.x from big join b on big.x = b.x", []int64{2, 2, 3, 3}},
+		{"select v.x from big v where v.x = 3", []int64{3}},
+		{"select x from later", []int64{4}},
+		{"with big as (select x from b) select x from big where x > 3", []int64{4}},
+		{"select x from a where x in (select n from small)", []int64{2, 2}},
+	}
+	check := func(c *Catalog) {
+		for _, q := range queries {
+			res := firstColumn(t, c, q.query)
+			if len(res) != len(q.expected) {
+				t.Errorf("%s: expected %v, got %v", q.query, q.expected, res)
+				continue
+			}
+			for i := range res {
+				if res[i] != q.expected[i] {
+					t.Errorf("%s: expected %v, got %v", q.query, q.expected, res)
+					break
+				}
+			}
+		}
+	}
+	check(c)
+
+	// views are stored in the catalog file
+	err := c.SaveToFile("catalog.txt", c.rootPath)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	c, err = NewCatalogFromFile("catalog.txt", c.bp, c.rootPath)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	check(c)
+
+	for _, q := range []string{
+		"create view a as select x from b",
+		"create table big (x int)",
+		"create view bad as select nosuch from a",
+		"create view bad (p, q) as select x from a",
+		"create view bad as select x from bad",
+		"drop table a",
+		"drop table a restrict",
+		"drop view big",
+		"drop view nosuch",
+		"drop view a",
+	} {
+		if _, _, err := Parse(c, q); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+	if c.findView("bad") != nil || c.findView("big") == nil {
+		t.Errorf("expected failed statements to leave the views unchanged")
+	}
+
+	for _, q := range []string{"drop view big cascade", "drop table b cascade", "drop view if exists nosuch"} {
+		if _, _, err := Parse(c, q); err != nil {
+			t.Errorf("%s: %s", q, err.Error())
+		}
+	}
+	if len(c.views) != 0 {
+		t.Errorf("expected CASCADE to drop all the views, got %v", c.views)
+	}
+	if _, _, err := Parse(c, "drop table a"); err != nil {
+		t.Errorf("expected a table without views to be dropped, got %s", err.Error())
+	}
+}
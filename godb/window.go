@@ -0,0 +1,351 @@
+package godb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// The OVER clause of a window function.
+type LogicalWindowNode struct {
+	spec        string // its text, which functions over the same window share
+	partitionBy []*LogicalSelectNode
+	orderBy     []*OrderByNode
+	frame       WindowFrame
+}
+
+// The function sqlparser parses window functions as, see [rewriteWindows].
+const windowFuncName = "__window"
+
+var specEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// sqlparser does not parse OVER clauses, so rewrite each window function
+// f(args) OVER (spec) of query to __window(f(args), 'spec'), which parseExpr
+// parses back into the window function.
+func rewriteWindows(query string) string {
+	tokens := scanTokens(query)
+	var rewritten strings.Builder
+	last := 0
+	for i := 1; i+1 < len(tokens); i++ {
+		if tokens[i].word(query) != "over" || tokens[i-1].typ != ')' || tokens[i+1].typ != '(' {
+			continue
+		}
+		open, close := matchingParen(tokens, i-1, -1), matchingParen(tokens, i+1, 1)
+		if open < 1 || close < 0 || tokens[open-1].start < last {
+			continue
+		}
+		start := tokens[open-1].start
+		spec := query[tokens[i+1].end:tokens[close].start]
+		rewritten.WriteString(query[last:start] + windowFuncName + "(" + query[start:tokens[i-1].end] + ", '" + specEscaper.Replace(spec) + "')")
+		last = tokens[close].end
+		i = close
+	}
+	rewritten.WriteString(query[last:])
+	return rewritten.String()
+}
+
+// Return the position of the parenthesis matching the one at position i of
+// tokens, searching forward if dir is 1 and backward if it is -1, or -1 if
+// there is none.
+func matchingParen(tokens []sqlToken, i int, dir int) int {
+	depth := 0
+	for ; i >= 0 && i < len(tokens); i += dir {
+		switch tokens[i].typ {
+		case '(':
+			depth += dir
+		case ')':
+			depth -= dir
+		}
+		if depth == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// Parse the placeholder __window(f(args), 'spec') of a window function.
+func parseWindowFunc(c *Catalog, expr *sqlparser.FuncExpr, alias string) (*LogicalSelectNode, error) {
+	var call *sqlparser.FuncExpr
+	var spec *sqlparser.SQLVal
+	if len(expr.Exprs) == 2 {
+		callExpr, _ := expr.Exprs[0].(*sqlparser.AliasedExpr)
+		specExpr, _ := expr.Exprs[1].(*sqlparser.AliasedExpr)
+		if callExpr != nil && specExpr != nil {
+			call, _ = callExpr.Expr.(*sqlparser.FuncExpr)
+			spec, _ = specExpr.Expr.(*sqlparser.SQLVal)
+		}
+	}
+	if call == nil || spec == nil {
+		return nil, GoDBError{ParseError, "OVER must follow a function call"}
+	}
+	window, err := parseWindowSpec(c, string(spec.Val))
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ToLower(sqlparser.String(call.Name))
+	var args []*LogicalSelectNode
+	switch name {
+	case "row_number", "rank", "dense_rank":
+		if len(call.Exprs) != 0 {
+			return nil, GoDBError{ParseError, fmt.Sprintf("%s takes no arguments", name)}
+		}
+	case "lag", "lead":
+		if len(call.Exprs) < 1 || len(call.Exprs) > 3 {
+			return nil, GoDBError{ParseError, fmt.Sprintf("%s takes one to three arguments", name)}
+		}
+		for _, e := range call.Exprs {
+			arg, err := parseSelect(c, e)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+	default:
+		if !isAgg(name) {
+			return nil, GoDBError{ParseError, fmt.Sprintf("unsupported window function %s", name)}
+		}
+		agg, err := parseExpr(c, call, "")
+		if err != nil {
+			return nil, err
+		}
+		args = agg.args
+	}
+	node := LogicalSelectNode{exprType: ExprWindow, funcOp: &name, args: args, alias: alias, window: window}
+	return &node, nil
+}
+
+// Parse the text of an OVER clause:  [PARTITION BY exprs] [ORDER BY exprs]
+// [ROWS frame].
+func parseWindowSpec(c *Catalog, spec string) (*LogicalWindowNode, error) {
+	window := &LogicalWindowNode{spec: spec}
+	// the clauses start with these keywords, outside of parentheses
+	var clauses = make(map[string]string)
+	var keyword string
+	var start, depth int
+	for _, t := range scanTokens(spec) {
+		switch t.typ {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		word := t.word(spec)
+		if depth > 0 || (word != "partition" && word != "order" && word != "rows" && word != "range") {
+			continue
+		}
+		if keyword != "" {
+			clauses[keyword] = spec[start:t.start]
+		}
+		keyword, start = word, t.start
+	}
+	if keyword != "" {
+		clauses[keyword] = spec[start:]
+	}
+	if _, ok := clauses["range"]; ok {
+		return nil, GoDBError{ParseError, "only ROWS frames are supported"}
+	}
+
+	//the expressions are parsed as those of GROUP BY and ORDER BY clauses
+	var exprs = "select 1 from t"
+	if partition, ok := clauses["partition"]; ok {
+		exprs += " group " + strings.TrimSpace(partition)[len("partition"):]
+	}
+	exprs += " " + clauses["order"]
+	stmt, err := sqlparser.Parse(exprs)
+	if err != nil {
+		return nil, GoDBError{ParseError, fmt.Sprintf("invalid window %s", spec)}
+	}
+	sel := stmt.(*sqlparser.Select)
+	for _, gby := range sel.GroupBy {
+		expr, err := parseExpr(c, gby, "")
+		if err != nil {
+			return nil, err
+		}
+		window.partitionBy = append(window.partitionBy, expr)
+	}
+	for _, oby := range sel.OrderBy {
+		expr, err := parseExpr(c, oby.Expr, "")
+		if err != nil {
+			return nil, err
+		}
+		window.orderBy = append(window.orderBy, &OrderByNode{expr, oby.Direction == sqlparser.AscScr})
+	}
+
+	rows, ok := clauses["rows"]
+	switch {
+	case ok:
+		window.frame, err = parseFrame(strings.Fields(strings.ToLower(rows))[1:])
+		if err != nil {
+			return nil, err
+		}
+	case len(window.orderBy) > 0:
+		window.frame = WindowFrame{UnboundedPreceding, 0, true}
+	default:
+		window.frame = WindowFrame{UnboundedPreceding, UnboundedFollowing, false}
+	}
+	return window, nil
+}
+
+// Parse the words of a ROWS frame after ROWS:  BETWEEN bound AND bound, or a
+// bound that starts a frame ending at the current row.
+func parseFrame(words []string) (WindowFrame, error) {
+	var errFrame = GoDBError{ParseError, fmt.Sprintf("invalid frame ROWS %s", strings.Join(words, " "))}
+	var bounds [][]string
+	switch {
+	case len(words) > 0 && words[0] == "between":
+		for i := range words {
+			if words[i] == "and" {
+				bounds = [][]string{words[1:i], words[i+1:]}
+			}
+		}
+	case len(words) == 2:
+		bounds = [][]string{words, {"current", "row"}}
+	}
+	if len(bounds) != 2 {
+		return WindowFrame{}, errFrame
+	}
+	var offsets [2]int
+	for i, bound := range bounds {
+		if len(bound) != 2 {
+			return WindowFrame{}, errFrame
+		}
+		switch {
+		case bound[0] == "current" && bound[1] == "row":
+			offsets[i] = 0
+		case bound[0] == "unbounded" && bound[1] == "preceding":
+			offsets[i] = UnboundedPreceding
+		case bound[0] == "unbounded" && bound[1] == "following":
+			offsets[i] = UnboundedFollowing
+		default:
+			n, err := strconv.Atoi(bound[0])
+			if err != nil || n < 0 || (bound[1] != "preceding" && bound[1] != "following") {
+				return WindowFrame{}, errFrame
+			}
+			offsets[i] = n
+			if bound[1] == "preceding" {
+				offsets[i] = -n
+			}
+		}
+	}
+	return WindowFrame{offsets[0], offsets[1], false}, nil
+}
+
+// Return the window functions of the expression.
+func extractWindows(s *LogicalSelectNode) []*LogicalSelectNode {
+	switch s.exprType {
+	case ExprWindow:
+		return []*LogicalSelectNode{s}
+	case ExprFunc:
+		var windows []*LogicalSelectNode
+		for _, arg := range s.args {
+			windows = append(windows, extractWindows(arg)...)
+		}
+		return windows
+	}
+	return nil
+}
+
+// Return op with the values of the window functions appended, computed by a
+// [Window] for each distinct OVER clause.
+func makeWindows(c *Catalog, windows []*LogicalSelectNode, op Operator, tableMap map[string]*PlanNode) (Operator, error) {
+	var specs []string
+	var bySpec = make(map[string][]*LogicalSelectNode)
+	for _, w := range windows {
+		if _, ok := bySpec[w.window.spec]; !ok {
+			specs = append(specs, w.window.spec)
+		}
+		bySpec[w.window.spec] = append(bySpec[w.window.spec], w)
+	}
+	var funcCnt int
+	for _, spec := range specs {
+		desc := op.Descriptor()
+		window := bySpec[spec][0].window
+		var partitionBy, orderBy []Expr
+		var ascs []bool
+		for _, lsn := range window.partitionBy {
+			expr, _, err := lsn.generateExpr(c, desc, tableMap)
+			if err != nil {
+				return nil, err
+			}
+			partitionBy = append(partitionBy, expr)
+		}
+		for _, oby := range window.orderBy {
+			expr, _, err := oby.expr.generateExpr(c, desc, tableMap)
+			if err != nil {
+				return nil, err
+			}
+			orderBy = append(orderBy, expr)
+			ascs = append(ascs, oby.ascending)
+		}
+		var funcs []*WindowFunc
+		for _, w := range bySpec[spec] {
+			//make sure name has unique id
+			f, err := makeWindowFunc(c, w, fmt.Sprintf("%s()%d", *w.funcOp, funcCnt), desc, tableMap)
+			if err != nil {
+				return nil, err
+			}
+			funcCnt++
+			funcs = append(funcs, f)
+		}
+		newOp, err := NewWindow(op, partitionBy, orderBy, ascs, funcs)
+		if err != nil {
+			return nil, err
+		}
+		fields := newOp.Descriptor().Fields[len(desc.Fields):]
+		for i, w := range bySpec[spec] {
+			w.cachedField = &fields[i] //track window functions by reference rather than name
+		}
+		op = newOp
+	}
+	return op, nil
+}
+
+// Return the window function for w, named name, of tuples with descriptor
+// desc.
+func makeWindowFunc(c *Catalog, w *LogicalSelectNode, name string, desc *TupleDesc, tableMap map[string]*PlanNode) (*WindowFunc, error) {
+	f := &WindowFunc{name: name, ftype: IntType, frame: w.window.frame}
+	var args []Expr
+	for _, arg := range w.args {
+		if arg.field == "*" { // of COUNT(*)
+			continue
+		}
+		expr, _, err := arg.generateExpr(c, desc, tableMap)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, expr)
+	}
+	switch *w.funcOp {
+	case "row_number":
+		f.funcType = RowNumberFunc
+	case "rank":
+		f.funcType = RankFunc
+	case "dense_rank":
+		f.funcType = DenseRankFunc
+	case "lag", "lead":
+		f.funcType = LagFunc
+		if *w.funcOp == "lead" {
+			f.funcType = LeadFunc
+		}
+		if len(args) < 2 {
+			args = append(args, &ConstExpr{IntField{1}, IntType})
+		}
+		if len(args) < 3 {
+			args = append(args, &ConstExpr{NullField{}, UnknownType})
+		}
+		f.args, f.ftype = args, args[0].GetExprType().Ftype
+	default:
+		var expr Expr
+		if len(args) > 0 {
+			expr = args[0]
+		}
+		agg, err := newAggState(*w.funcOp, name, expr)
+		if err != nil {
+			return nil, err
+		}
+		f.funcType, f.agg, f.ftype = FrameAggFunc, agg, agg.GetTupleDesc().Fields[0].Ftype
+	}
+	return f, nil
+}
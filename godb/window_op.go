@@ -0,0 +1,264 @@
+package godb
+
+import "math"
+
+// The functions a [Window] computes for each tuple.
+type WindowFuncType int
+
+const (
+	RowNumberFunc WindowFuncType = iota
+	RankFunc      WindowFuncType = iota
+	DenseRankFunc WindowFuncType = iota
+	LagFunc       WindowFuncType = iota
+	LeadFunc      WindowFuncType = iota
+	FrameAggFunc  WindowFuncType = iota // an aggregate of the tuples of the frame
+)
+
+// Frame boundaries unbounded by the partition.
+const (
+	UnboundedPreceding int = math.MinInt
+	UnboundedFollowing int = math.MaxInt
+)
+
+// The tuples of its partition a window aggregate is computed over:  those from
+// start to end positions after the current tuple, negative offsets being
+// before it.  If toPeers is set, the frame also extends to the last tuple with
+// the same ORDER BY values as the current one, as the default frame of SQL
+// does.
+type WindowFrame struct {
+	start, end int
+	toPeers    bool
+}
+
+// A window function.  LAG and LEAD have as args the expression they return
+// the value of for the tuple offset before or after the current one, the
+// offset, and the value if there is no such tuple.  Frame aggregates use agg,
+// initialized with the expression to aggregate.
+type WindowFunc struct {
+	funcType WindowFuncType
+	name     string
+	ftype    DBType
+	args     []Expr
+	agg      AggState
+	frame    WindowFrame
+}
+
+// Window computes window functions over the partitions of its input, the
+// tuples with the same values of partitionBy, ordered by orderBy.  Each tuple
+// of its input is returned with the values of the functions appended.  The
+// input is sorted by its partition and order, then each partition is read
+// into memory.
+type Window struct {
+	child       Operator
+	partitionBy []Expr
+	orderBy     []Expr
+	funcs       []*WindowFunc
+}
+
+// Constructor for a window over child.  ascending tells for each expression
+// of orderBy if it is sorted in ascending order.
+func NewWindow(child Operator, partitionBy []Expr, orderBy []Expr, ascending []bool, funcs []*WindowFunc) (*Window, error) {
+	keys := append(append([]Expr{}, partitionBy...), orderBy...)
+	if len(keys) > 0 {
+		var asc []bool
+		for range partitionBy {
+			asc = append(asc, true)
+		}
+		sorted, err := NewOrderBy(keys, child, append(asc, ascending...))
+		if err != nil {
+			return nil, err
+		}
+		child = sorted
+	}
+	return &Window{child, partitionBy, orderBy, funcs}, nil
+}
+
+// Return a TupleDescriptor for this window, the fields of its input followed
+// by those of its functions.
+func (w *Window) Descriptor() *TupleDesc {
+	desc := w.child.Descriptor().copy()
+	for _, f := range w.funcs {
+		desc.Fields = append(desc.Fields, FieldType{f.name, "", f.ftype})
+	}
+	return desc
+}
+
+// Return whether t1 and t2 have the same values of exprs.
+func sameValues(t1 *Tuple, t2 *Tuple, exprs []Expr) (bool, error) {
+	for _, e := range exprs {
+		v1, err := e.EvalExpr(t1)
+		if err != nil {
+			return false, err
+		}
+		v2, err := e.EvalExpr(t2)
+		if err != nil {
+			return false, err
+		}
+		if compareDBValue(v1, v2) != 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Window operator implementation.  The values of the functions are computed
+// for a whole partition before its first tuple is returned.
+func (w *Window) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	iter, err := w.child.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	desc := w.Descriptor()
+	var (
+		partition []*Tuple
+		values    [][]DBValue // by function, of the tuples of partition
+		next      int         // position in partition of the next tuple to return
+		lookahead *Tuple      // the first tuple of the next partition
+		done      bool
+	)
+	return func() (*Tuple, error) {
+		for next >= len(partition) {
+			if done {
+				return nil, nil
+			}
+			partition, next = nil, 0
+			if lookahead != nil {
+				partition = append(partition, lookahead)
+				lookahead = nil
+			}
+			for {
+				t, err := iter()
+				if err != nil {
+					return nil, err
+				}
+				if t == nil {
+					done = true
+					break
+				}
+				if len(partition) > 0 {
+					same, err := sameValues(partition[0], t, w.partitionBy)
+					if err != nil {
+						return nil, err
+					}
+					if !same {
+						lookahead = t
+						break
+					}
+				}
+				partition = append(partition, t)
+			}
+			values = make([][]DBValue, len(w.funcs))
+			for i, f := range w.funcs {
+				values[i], err = w.evalFunc(f, partition)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		t := partition[next]
+		fields := append([]DBValue{}, t.Fields...)
+		for i := range w.funcs {
+			fields = append(fields, values[i][next])
+		}
+		next++
+		return &Tuple{Desc: *desc, Fields: fields, Rid: t.Rid}, nil
+	}, nil
+}
+
+// Return the value of f for each tuple of partition.
+func (w *Window) evalFunc(f *WindowFunc, partition []*Tuple) ([]DBValue, error) {
+	// lastPeer[i] is the position of the last tuple with the ORDER BY values
+	// of the i-th one
+	lastPeer := make([]int, len(partition))
+	for i := len(partition) - 1; i >= 0; i-- {
+		lastPeer[i] = i
+		if i+1 < len(partition) {
+			same, err := sameValues(partition[i], partition[i+1], w.orderBy)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				lastPeer[i] = lastPeer[i+1]
+			}
+		}
+	}
+	values := make([]DBValue, len(partition))
+	switch f.funcType {
+	case RowNumberFunc, RankFunc, DenseRankFunc:
+		rank, denseRank := 0, 0
+		for i := range partition {
+			if i == 0 || lastPeer[i-1] != lastPeer[i] {
+				rank, denseRank = i+1, denseRank+1
+			}
+			switch f.funcType {
+			case RowNumberFunc:
+				values[i] = IntField{int64(i + 1)}
+			case RankFunc:
+				values[i] = IntField{int64(rank)}
+			default:
+				values[i] = IntField{int64(denseRank)}
+			}
+		}
+	case LagFunc, LeadFunc:
+		for i, t := range partition {
+			offset, err := f.args[1].EvalExpr(t)
+			if err != nil {
+				return nil, err
+			}
+			n, ok := offset.(IntField)
+			if !ok {
+				return nil, GoDBError{TypeMismatchError, "offset of LAG and LEAD must be an integer"}
+			}
+			j := i + int(n.Value)
+			if f.funcType == LagFunc {
+				j = i - int(n.Value)
+			}
+			if j >= 0 && j < len(partition) {
+				values[i], err = f.args[0].EvalExpr(partition[j])
+			} else {
+				values[i], err = f.args[2].EvalExpr(t)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	case FrameAggFunc:
+		// frames starting at the start of the partition only grow, so their
+		// aggregates are computed incrementally
+		var running AggState
+		added := 0
+		for i := range partition {
+			start, end := frameBound(i, f.frame.start, 0, len(partition)), frameBound(i, f.frame.end, -1, len(partition)-1)
+			if f.frame.toPeers && lastPeer[i] > end {
+				end = lastPeer[i]
+			}
+			state := running
+			if f.frame.start != UnboundedPreceding || state == nil {
+				state, added = f.agg.Copy(), start
+			}
+			for ; added <= end; added++ {
+				state.AddTuple(partition[added])
+			}
+			if f.frame.start == UnboundedPreceding {
+				running = state
+			}
+			values[i] = state.Finalize().Fields[0]
+		}
+	}
+	return values, nil
+}
+
+// Return the position offset from i, clamped to [lo, hi].
+func frameBound(i int, offset int, lo int, hi int) int {
+	switch {
+	case offset == UnboundedPreceding:
+		return lo
+	case offset == UnboundedFollowing:
+		return hi
+	case i+offset < lo:
+		return lo
+	case i+offset > hi:
+		return hi
+	}
+	return i + offset
+}
@@ -0,0 +1,65 @@
+package godb
+
+import (
+	"testing"
+)
+
+func TestWindowQuery(t *testing.T) {
+	c := makeOuterJoinTestCatalog(t)
+	// a.x is NULL, 1, 2, 2, 3;  NULLs sort first
+	var queries = []struct {
+		query    string
+		expected []any // the window function of each row, nil for NULL
+	}{
+		{"select x, row_number() over (order by x) as v from a order by v", []any{1, 2, 3, 4, 5}},
+		{"select x, rank() over (order by x) as v from a order by v", []any{1, 2, 3, 3, 5}},
+		{"select x, dense_rank() over (order by x) as v from a order by v", []any{1, 2, 3, 3, 4}},
+		{"select x, rank() over (order by x desc) as v from a order by v", []any{1, 2, 2, 4, 5}},
+		{"select x, row_number() over (partition by x order by y) as v from a order by x, v", []any{1, 1, 1, 2, 1}},
+		{"select x, count(*) over (partition by x) as v from a order by x", []any{1, 1, 2, 2, 1}},
+		{"select x, count(x) over () as v from a order by x", []any{4, 4, 4, 4, 4}},
+		{"select x, sum(x) over (order by x) as v from a order by x", []any{nil, 1, 5, 5, 8}},
+		{"select x, sum(x) over (order by x rows between unbounded preceding and current row) as v from a order by v", []any{nil, 1, 3, 5, 8}},
+		{"select x, sum(x) over (order by x rows between 1 preceding and 1 following) as v from a order by x, v", []any{1, 3, 5, 7, 5}},
+		{"select x, max(x) over (order by x rows 2 preceding) as v from a order by x, v", []any{nil, 1, 2, 2, 3}},
+		{"select x, lag(x) over (order by x) as v from a order by x, v", []any{nil, nil, 1, 2, 2}},
+		{"select x, lead(x, 1, 0) over (order by x) as v from a order by x, v", []any{1, 2, 2, 3, 0}},
+		{"select x, lead(x, 2) over (order by x) as v from a order by x, v", []any{2, 2, nil, 3, nil}},
+	}
+	for _, q := range queries {
+		res := runTestQuery(t, c, q.query)
+		if len(res) != len(q.expected) {
+			t.Errorf("%s: expected %d results, got %d", q.query, len(q.expected), len(res))
+			continue
+		}
+		for i, tup := range res {
+			v := tup.Fields[1]
+			if q.expected[i] == nil {
+				if !isNull(v) {
+					t.Errorf("%s: expected NULL in row %d, got %v", q.query, i, v)
+				}
+			} else if f, ok := v.(IntField); !ok || f.Value != int64(q.expected[i].(int)) {
+				t.Errorf("%s: expected %v in row %d, got %v", q.query, q.expected[i], i, v)
+			}
+		}
+	}
+
+	res := runTestQuery(t, c, "select x, count(*) as n, rank() over (order by count(*) desc) from a group by x order by n desc")
+	if len(res) != 4 || res[0].Fields[1].(IntField).Value != 2 || res[0].Fields[2].(IntField).Value != 1 || res[1].Fields[2].(IntField).Value != 2 {
+		t.Errorf("expected the group of 2 to be ranked first, got %v", res)
+	}
+	if res := runTestQuery(t, c, "select x, avg(x) over (partition by y) as avg from a"); len(res) != 5 || res[0].Desc.Fields[1].Fname != "avg" {
+		t.Errorf("expected 5 results with column avg, got %v", res)
+	}
+
+	for _, q := range []string{
+		"select x, rank(x) over (order by x) from a",
+		"select x, lower(x) over (order by x) from a",
+		"select x, sum(x) over (order by x range between 1 preceding and current row) from a",
+		"select x, sum(x) over (order by x rows between 1 above and current row) from a",
+	} {
+		if _, _, err := Parse(c, q); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+}
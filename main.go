@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"regexp"
 	"runtime/pprof"
 	"strconv"
 	"strings"
@@ -29,7 +30,34 @@ Available shell commands:
 	\d : List tables and fields in the current database
 	\f : List available functions for use in queries
 	\a : Toggle aligned vs csv output
-	\l table path/to/file [sep] [hasHeader]: Append csv file to end of table.  Default to sep = ',', hasHeader = 'true'`
+	\l table path/to/file [sep] [hasHeader]: Append csv file to end of table.  Default to sep = ',', hasHeader = 'true'
+
+Queries with ? or $1, $2, ... placeholders can be prepared and executed with their values:
+	PREPARE name FROM 'query';
+	EXECUTE name USING value, ...;`
+
+// PREPARE name FROM 'query' and EXECUTE name [USING values] are processed by
+// the shell, see godb.Prepare.
+var prepareRegexp = regexp.MustCompile(`(?is)^prepare\s+(\w+)\s+from\s+'((?:[^']|'')*)'$`)
+var executeRegexp = regexp.MustCompile(`(?is)^execute\s+(\w+)(?:\s+using\s+(.*))?$`)
+
+// Return the plan of the prepared statement name with its placeholders bound
+// to args, a comma separated list of constants.
+func execute(prepared map[string]*godb.PreparedStatement, name string, args string) (godb.QueryType, godb.Operator, error) {
+	p, ok := prepared[strings.ToLower(name)]
+	if !ok {
+		return godb.UnknownQueryType, nil, fmt.Errorf("no prepared statement named %s", name)
+	}
+	values, err := godb.ParseArgs(args)
+	if err != nil {
+		return godb.UnknownQueryType, nil, err
+	}
+	plan, err := p.Bind(values...)
+	if err != nil {
+		return godb.UnknownQueryType, nil, err
+	}
+	return godb.IteratorType, plan, nil
+}
 
 /*func printCatalog(fname string) {
 	f, err := os.Open(fname)
@@ -49,6 +77,18 @@ Available shell commands:
 	f.Close()
 }*/
 
+// Commit tid, or abort it if it can't be committed, returning whether it
+// committed.
+func commit(bp *godb.BufferPool, tid godb.TransactionID) bool {
+	err := bp.CommitTransaction(tid)
+	if err == nil {
+		return true
+	}
+	fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+	bp.AbortTransaction(tid)
+	return false
+}
+
 func printCatalog(c *godb.Catalog) {
 	s := c.CatalogString()
 	fmt.Printf("\033[34m%s\n\033[0m", s)
@@ -105,6 +145,7 @@ func main() {
 	var autocommit bool = true
 	var tid godb.TransactionID
 	aligned := true
+	prepared := make(map[string]*godb.PreparedStatement)
 	for {
 
 		//text := "SELECT l_orderkey, sum(l_extendedprice * (1 - l_discount)) as revenue, o_orderdate, o_shippriority FROM customer, orders, lineitem WHERE c_mktsegment = 'BUILDING' AND c_custkey = o_custkey AND l_orderkey = o_orderkey GROUP BY l_orderkey, o_orderdate, o_shippriority ORDER BY revenue desc, o_orderdate LIMIT 20"
@@ -141,6 +182,7 @@ func main() {
 						fmt.Printf("failed load catalog, %s\n", err.Error())
 						continue
 					}
+					prepared = make(map[string]*godb.PreparedStatement)
 					fmt.Printf("Loaded %s/%s\n", catPath, catName)
 					//	printCatalog(catPath + "/" + catName)
 					printCatalog(c)
@@ -206,14 +248,28 @@ func main() {
 		}
 		query = strings.TrimSpace(query + " " + text[0:len(text)-1])
 
-		explain := false
-		if strings.HasPrefix(strings.ToLower(query), "explain") {
-			queryParts := strings.Split(query, " ")
-			query = strings.Join(queryParts[1:], " ")
-			explain = true
+		// plans are printed as a tree rather than as a table
+		explain := strings.HasPrefix(strings.ToLower(query), "explain")
+
+		if m := prepareRegexp.FindStringSubmatch(query); m != nil {
+			query = ""
+			p, err := godb.Prepare(c, strings.ReplaceAll(m[2], "''", "'"))
+			if err != nil {
+				fmt.Printf("\033[31;1mInvalid query (%s)\033[0m\n", err.Error())
+				continue
+			}
+			prepared[strings.ToLower(m[1])] = p
+			fmt.Printf("\033[32;1mPREPARE\033[0m\n\n")
+			continue
 		}
 
-		queryType, plan, err := godb.Parse(c, query)
+		var queryType godb.QueryType
+		var plan godb.Operator
+		if m := executeRegexp.FindStringSubmatch(query); m != nil {
+			queryType, plan, err = execute(prepared, m[1], m[2])
+		} else {
+			queryType, plan, err = godb.Parse(c, query)
+		}
 		//fmt.Println(query)
 		query = ""
 		nresults := 0
@@ -246,12 +302,6 @@ func main() {
 
 		switch queryType {
 		case godb.IteratorType:
-			if explain {
-				fmt.Printf("\033[32m")
-				godb.PrintPhysicalPlan(plan, "")
-				fmt.Printf("\033[0m\n")
-				break
-			}
 			if autocommit {
 				tid = godb.NewTID()
 				bp.BeginTransaction(tid)
@@ -261,10 +311,15 @@ func main() {
 			iter, err := plan.Iterator(tid)
 			if err != nil {
 				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+				if autocommit {
+					bp.AbortTransaction(tid)
+				}
 				continue
 			}
 
-			fmt.Printf("\033[32;4m%s\033[0m\n", plan.Descriptor().HeaderString(aligned))
+			if !explain {
+				fmt.Printf("\033[32;4m%s\033[0m\n", plan.Descriptor().HeaderString(aligned))
+			}
 
 			for {
 				tup, err := iter()
@@ -274,6 +329,8 @@ func main() {
 				}
 				if tup == nil {
 					break
+				} else if explain {
+					fmt.Printf("\033[32m%s\033[0m\n", tup.PrettyPrintString(false))
 				} else {
 					fmt.Printf("\033[32m%s\033[0m\n", tup.PrettyPrintString(aligned))
 				}
@@ -286,10 +343,10 @@ func main() {
 
 				}
 			}
+		outer:
 			if autocommit {
-				bp.CommitTransaction(tid)
+				commit(bp, tid)
 			}
-		outer:
 			fmt.Printf("\033[32;1m(%d results)\033[0m\n", nresults)
 			duration := time.Since(start)
 			fmt.Printf("\033[32;1m%v\033[0m\n\n", duration)
@@ -307,7 +364,11 @@ func main() {
 			if autocommit {
 				fmt.Printf("\033[31;1m%s\033[0m\n", "Cannot abort transaction unless in transaction")
 			} else {
-				bp.AbortTransaction(tid)
+				err := bp.AbortTransaction(tid)
+				if err != nil {
+					fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+					continue
+				}
 				autocommit = true
 				fmt.Printf("\033[32;1mABORT\033[0m\n\n")
 			}
@@ -316,9 +377,10 @@ func main() {
 			if autocommit {
 				fmt.Printf("\033[31;1m%s\033[0m\n", "Cannot commit transaction unless in transaction")
 			} else {
-				bp.CommitTransaction(tid)
 				autocommit = true
-				fmt.Printf("\033[32;1mCOMMIT\033[0m\n\n")
+				if commit(bp, tid) {
+					fmt.Printf("\033[32;1mCOMMIT\033[0m\n\n")
+				}
 			}
 		case godb.CreateTableQueryType:
 			fmt.Printf("\033[32;1mCREATE\033[0m\n\n")
@@ -332,7 +394,52 @@ func main() {
 			if err != nil {
 				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
 			}
+		case godb.CreateIndexQueryType:
+			fmt.Printf("\033[32;1mCREATE INDEX\033[0m\n\n")
+			err := c.SaveToFile(catName, catPath)
+			if err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+			}
+		case godb.DropIndexQueryType:
+			fmt.Printf("\033[32;1mDROP INDEX\033[0m\n\n")
+			err := c.SaveToFile(catName, catPath)
+			if err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+			}
+		case godb.CreateViewQueryType:
+			fmt.Printf("\033[32;1mCREATE VIEW\033[0m\n\n")
+			err := c.SaveToFile(catName, catPath)
+			if err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+			}
+		case godb.DropViewQueryType:
+			fmt.Printf("\033[32;1mDROP VIEW\033[0m\n\n")
+			err := c.SaveToFile(catName, catPath)
+			if err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+			}
+		case godb.AlterTableQueryType:
+			fmt.Printf("\033[32;1mALTER TABLE\033[0m\n\n")
+			err := c.SaveToFile(catName, catPath)
+			if err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+			}
+		case godb.AnalyzeQueryType:
+			fmt.Printf("\033[32;1mANALYZE\033[0m\n\n")
+			err := c.SaveToFile(catName, catPath)
+			if err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+			}
 		}
 
 	}
+
+	// checkpoint, so that the database is opened again without recovery
+	if !autocommit {
+		bp.AbortTransaction(tid)
+	}
+	err = bp.Checkpoint()
+	if err != nil {
+		fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+	}
 }